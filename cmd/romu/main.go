@@ -1,28 +1,89 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/retronian/romu/internal/config"
 	"github.com/retronian/romu/internal/covers"
 	"github.com/retronian/romu/internal/dat"
 	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/enrich"
+	"github.com/retronian/romu/internal/fsutil"
 	"github.com/retronian/romu/internal/gamedb"
+	"github.com/retronian/romu/internal/igdb"
+	"github.com/retronian/romu/internal/logging"
+	"github.com/retronian/romu/internal/platform"
 	"github.com/retronian/romu/internal/scanner"
+	"github.com/retronian/romu/internal/screenscraper"
 	"github.com/retronian/romu/internal/server"
 )
 
+// version is set at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// cfg is config.toml with environment overrides, loaded at startup
+var cfg = &config.Config{}
+
+// Exit codes, so scripts can tell a partial run from a failed one
+const (
+	exitOK      = 0 // everything succeeded
+	exitUsage   = 1 // bad arguments, flags or configuration
+	exitPartial = 2 // finished, but some items failed or nothing was found
+	exitFatal   = 3 // database, I/O or network error; nothing was done
+)
+
 func main() {
+	args, level, logFormat, err := parseGlobalFlags(os.Args)
+	if err == nil {
+		err = logging.Setup(level, logFormat)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	os.Args = args
 	if len(os.Args) < 2 {
 		usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	// Help and version work even when config.toml is broken
+	switch os.Args[1] {
+	case "version", "--version":
+		cmdVersion()
+		return
+	case "help", "--help", "-h":
+		usage()
+		return
 	}
 
+	cfg, err = config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	scanner.ApplyConfig(cfg)
+
 	switch os.Args[1] {
 	case "scan":
 		cmdScan()
@@ -30,29 +91,213 @@ func main() {
 		cmdList()
 	case "search":
 		cmdSearch()
+	case "info":
+		cmdInfo()
 	case "stats":
 		cmdStats()
+	case "top":
+		cmdTop()
 	case "server":
 		cmdServer()
 	case "import-dat":
 		cmdImportDAT()
 	case "import-gamelist":
 		cmdImportGameList()
+	case "import-playlist":
+		cmdImportPlaylist()
 	case "export-gamelist":
 		cmdExportGameList()
+	case "export-playlist":
+		cmdExportPlaylist()
+	case "export-pegasus":
+		cmdExportPegasus()
+	case "export-launchbox":
+		cmdExportLaunchBox()
 	case "enrich":
 		cmdEnrich()
 	case "fetch-covers":
 		cmdFetchCovers()
 	case "match":
 		cmdMatch()
-	case "help", "--help", "-h":
-		usage()
+	case "match-all":
+		cmdMatchAll()
+	case "unmatched":
+		cmdUnmatched()
+	case "missing-ja":
+		cmdMissingJA()
+	case "merge-games":
+		cmdMergeGames()
+	case "reassign":
+		cmdReassign()
+	case "tag":
+		cmdTag()
+	case "tags":
+		cmdTags()
+	case "maintenance":
+		cmdMaintenance()
+	case "backup":
+		cmdBackup()
+	case "doctor":
+		cmdDoctor()
+	case "checkhashes":
+		cmdCheckHashes()
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
 		usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+}
+
+// parseGlobalFlags removes --quiet/-q, --verbose/-v and --log-format from
+// the front of args, up to the command name or "--", and returns the output
+// level and log format. Arguments from the command on are the command's.
+func parseGlobalFlags(args []string) (rest []string, level logging.Level, format string, err error) {
+	quiet, verbose := false, false
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "--quiet" || a == "-q":
+			quiet = true
+		case a == "--verbose" || a == "-v":
+			verbose = true
+		case a == "--log-format":
+			if i+1 >= len(args) {
+				return nil, 0, "", errors.New("--log-format needs a value (text or json)")
+			}
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(a, "--log-format="):
+			format = strings.TrimPrefix(a, "--log-format=")
+		case a == "--":
+			i++
+			break flags
+		default:
+			break flags
+		}
+	}
+	rest = append([]string{args[0]}, args[i:]...)
+	switch {
+	case quiet && verbose:
+		return nil, 0, "", errors.New("--quiet and --verbose can't be used together")
+	case quiet:
+		level = logging.LevelQuiet
+	case verbose:
+		level = logging.LevelVerbose
+	}
+	return rest, level, format, nil
+}
+
+// flagOutput is where flag sets print errors and usage
+var flagOutput io.Writer = os.Stderr
+
+// errUsage marks argument errors whose message and usage were printed
+var errUsage = errors.New("usage error")
+
+// newFlags returns the flag set of a subcommand. On a bad flag it prints
+// the usage lines, e.g. "romu tag <path> <tag>", and the flags.
+func newFlags(name string, usage ...string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(flagOutput)
+	flags.Usage = func() {
+		for i, u := range usage {
+			if i == 0 {
+				fmt.Fprintf(flags.Output(), "usage: %s\n", u)
+			} else {
+				fmt.Fprintf(flags.Output(), "       %s\n", u)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses a subcommand's arguments and returns the positional
+// ones, of which there must be between min and max (max < 0: any number).
+// Errors other than flag.ErrHelp wrap errUsage.
+func parseArgs(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	pos, err := parseInterspersed(flags, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if len(pos) < min || max >= 0 && len(pos) > max {
+		flags.Usage()
+		return nil, errUsage
+	}
+	return pos, nil
+}
+
+// parseInterspersed is flags.Parse, except that flags may also follow the
+// positional arguments, as in "romu scan /roms --rehash". Everything after
+// "--" is positional.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return pos, nil
+		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(pos, rest...), nil
+		}
+		pos = append(pos, rest[0])
+		args = rest[1:]
+	}
+}
+
+// platformVar defines a --platform flag storing its value in p. Folder
+// names such as "snes" are taken for their platform code, which is what the
+// database stores; anything else that isn't a code is rejected with a
+// suggestion, as it would match no ROMs.
+func platformVar(flags *flag.FlagSet, p *string, usage string) {
+	platformFlag(flags, "platform", p, usage)
+}
+
+// platformFlag is platformVar for a flag of another name, such as --from
+func platformFlag(flags *flag.FlagSet, name string, p *string, usage string) {
+	flags.Func(name, usage+" (a `code` such as SFC)", func(s string) error {
+		if s != "" {
+			var err error
+			if s, err = normalizePlatform(s); err != nil {
+				return err
+			}
+		}
+		*p = s
+		return nil
+	})
+}
+
+// normalizePlatform returns the platform code s stands for, as platformVar
+// takes it, or an error suggesting one
+func normalizePlatform(s string) (string, error) {
+	s = platform.Normalize(s)
+	return s, platform.Check(s)
+}
+
+// checkArgs exits if parsing arguments failed: with exitOK after -h, else
+// with exitUsage, printing err unless parseArgs already did
+func checkArgs(err error) {
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(exitOK)
+	case !errors.Is(err, errUsage):
+		fmt.Fprintln(flagOutput, err)
 	}
+	os.Exit(exitUsage)
+}
+
+// interruptContext returns a context cancelled by Ctrl-C, so a long
+// database transaction is rolled back rather than left to the signal
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
 }
 
 func usage() {
@@ -60,55 +305,171 @@ func usage() {
 
 Usage:
   romu scan <path>              Scan a ROM directory recursively
+                                [--rehash] to re-hash unchanged files
+                                [--detect-moves] to relink renamed files
+                                [--detect-content] identify ROMs outside
+                                platform folders by their headers
+                                [--keep-unknown] record unidentified files
+                                as platform UNKNOWN instead of skipping
   romu list                     List registered ROMs
+                                [--since 24h|DATE] only ROMs added since
+                                [--platform XX] to filter by platform
+                                [--limit N] [--offset N] to page through
+                                [--names] show platform names, not codes
   romu search <query>           Search ROMs by title/filename
                                 [--platform XX] to filter by platform
+                                [--sort title_ja] order by Japanese title
+  romu info <path-or-query>     Show everything known about one ROM
   romu stats                    Show collection statistics
+                                [--names] show platform names, not codes
+  romu top                      List the largest ROMs
+                                [--platform XX] [--limit N] (default: 20)
   romu server                   Start web UI server
                                 [--port XXXX] (default: 8080)
+                                [--host ADDR] (default: 127.0.0.1; 0.0.0.0
+                                for every interface)
+                                [--read-only] browse without changing the DB
+                                [--auth-token T] [--basic-auth U:P] protect
+                                /api/, and the UI too with [--protect-ui]
+                                [--stats-cache-ttl 5s] reuse stats between
+                                writes for this long (0 disables)
   romu import-dat <dat-file>    Import a No-Intro DAT file
                                 [--platform XX] to override auto-detection
+                                [--dir DIR] to import every .dat/.xml in DIR
+                                [--include-nodump] keep never-dumped ROMs
   romu import-gamelist <dir>    Import all gamelist.xml from ROM directory
+  romu import-playlist <lpl>    Match ROMs to games named in a RetroArch
+                                playlist, by CRC32 or file name
+                                [--platform XX] if the playlist's or its
+                                items' db_name doesn't tell
   romu export-gamelist <dir>    Export gamelist.xml per platform
                                 [--platform XX] to export single platform
-                                ZIP files use ./zipname.zip as path
+                                ZIP/7z entries use ./archive.zip as path
                                 Empty metadata fields are omitted
+                                Media is copied to <dir>/<XX>/media/,
+                                named after each ROM
+                                [--media-root DIR] to copy it to DIR/<XX>/
+                                [--absolute-paths] for absolute ROM and
+                                media paths instead of ./ ones
+  romu export-playlist <dir>    Export a RetroArch .lpl playlist per platform
+                                [--platform XX] to export single platform
+  romu export-pegasus <dir>     Export metadata.pegasus.txt per platform
+                                [--platform XX] to export single platform
+  romu export-launchbox <dir>   Export a LaunchBox platform XML per platform
+                                [--platform XX] to export single platform
   romu enrich                   Apply gamedb metadata to matched games
                                 [--platform XX] to filter by platform
+                                [--gamedb-dir DIR] extra gamedb JSON files
+                                (default: $ROMU_GAMEDB, gamedb_dir in
+                                config.toml or ~/.romu/gamedb)
+                                [--dry-run] show changes without writing
+                                [--overwrite] replace existing metadata,
+                                clearing fields the source leaves empty
+                                [--source LIST] sources to try in order,
+                                of gamedb, screenscraper, igdb (default:
+                                gamedb; online ones need SCREENSCRAPER_* or
+                                IGDB_* env), e.g. gamedb,screenscraper
   romu fetch-covers             Download cover art from libretro-thumbnails
                                 [--platform XX] [--output-dir DIR] [--force]
-  romu match                    Match ROMs to games by hash
-  romu help                     Show this help`)
+                                [--source-dir DIR] copy from a local clone
+                                [--allow-network] download if missing there
+                                [--retry-missing] retry covers found missing
+                                by earlier runs (kept in .manifest.json)
+                                [--workers N] parallel downloads (default: 4)
+                                [--base-url URL] libretro-thumbnails mirror
+                                (default: $ROMU_THUMBNAILS_URL, base_url
+                                in config.toml or GitHub)
+  romu match [dat-file]         Match ROMs to games by hash using imported DATs
+                                [--platform XX] to filter by platform
+                                [--include-nodump] match on nodump ROMs'
+                                placeholder hashes too
+  romu match-all                Match all ROMs against every imported DAT
+                                [--platform XX] to filter by platform
+  romu unmatched                List ROMs not matched to a game yet
+                                [--platform XX] to filter by platform
+  romu missing-ja               List matched games without a Japanese title
+                                or description, with counts per platform
+                                [--platform XX] to filter by platform
+  romu merge-games              List games that share an English or
+                                Japanese title, e.g. one from a DAT and one
+                                from a gamelist.xml
+                                [--platform XX] to filter by platform
+                                [--apply] to merge them
+  romu merge-games <keep-id> <merge-id>
+                                Merge one game into another
+  romu reassign <path-or-query> <XX>
+                                Move matching ROMs to another platform,
+                                unlinking games of the old platform
+  romu reassign --to XX         Move ROMs in bulk, selected by
+                                [--from XX] current platform and/or
+                                [--path-prefix DIR] location
+  romu tag <path> <tag>         Add a ROM (or every ROM in an archive) to a tag
+                                [--remove] to remove it instead
+  romu tags [tag]               List tags, or the ROMs carrying a tag
+  romu maintenance              Check integrity and compact the database
+                                [--prune-games] delete games without ROMs
+                                or cover art
+  romu backup <dest.db>         Copy the database to a new file, also while
+                                the server or a scan is using it
+  romu doctor                   Report missing files, empty hashes and other
+                                anomalies (exits 2 on critical issues)
+  romu checkhashes              Re-hash ROMs on disk and report any whose
+                                hashes changed (exits 2 on mismatches)
+                                [--platform XX] to filter by platform
+  romu version                  Show the version, Go version and the
+                                number of built-in gamedb titles
+  romu help                     Show this help
+
+Global options, given before the command:
+  --quiet, -q                   Only print summaries and errors
+  --verbose, -v                 Also log skipped files and why, HTTP
+                                requests and database timings
+  --log-format text|json        Format of the log on stderr (default: text)
+
+Exit codes:
+  0  success
+  1  usage error: bad arguments, flags or config.toml
+  2  partial failure: some files or platforms failed, nothing was
+     matched or found, or doctor/checkhashes found problems
+  3  fatal error: database, I/O or network failure`)
 }
 
-func cmdSearch() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: romu search <query> [--platform XX]")
-		os.Exit(1)
+// parseSearchArgs parses "romu search" arguments into a filter
+func parseSearchArgs(args []string) (db.SearchFilter, error) {
+	var filter db.SearchFilter
+	flags := newFlags("search", "romu search <query> [--platform XX] [--sort title_ja]")
+	platformVar(flags, &filter.Platform, "only ROMs of this platform")
+	flags.StringVar(&filter.Sort, "sort", "", "order by "+db.SortTitleJA+" instead of file name")
+	pos, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return filter, err
 	}
-	query := os.Args[2]
-	platform := ""
-	for i := 3; i < len(os.Args)-1; i++ {
-		if os.Args[i] == "--platform" {
-			platform = os.Args[i+1]
-		}
+	filter.Query = pos[0]
+	if filter.Sort != "" && filter.Sort != db.SortTitleJA {
+		return filter, fmt.Errorf("invalid --sort: %s (only %s is supported)", filter.Sort, db.SortTitleJA)
 	}
+	return filter, nil
+}
+
+func cmdSearch() {
+	filter, err := parseSearchArgs(os.Args[2:])
+	checkArgs(err)
 
-	database, err := db.Open()
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
-	files, total, err := database.SearchRoms(query, platform, 1, 100)
+	files, total, err := database.SearchRoms(filter, 1, 100)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "search error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 
 	if len(files) == 0 {
-		fmt.Printf("No results for %q\n", query)
+		fmt.Printf("No results for %q\n", filter.Query)
 		return
 	}
 
@@ -127,95 +488,393 @@ func cmdSearch() {
 	fmt.Printf("\nFound: %d ROMs\n", total)
 }
 
+// findRoms resolves a command argument to ROMs: a path to a ROM (or archive)
+// on disk, else a search query. It returns up to 50 ROMs and the total found.
+func findRoms(database *db.DB, arg string) ([]db.RomFile, int, error) {
+	if _, err := os.Stat(arg); err == nil {
+		abs, _ := filepath.Abs(arg)
+		locs, err := database.FindRomFilesByPath(abs)
+		if err != nil {
+			return nil, 0, err
+		}
+		var files []db.RomFile
+		for _, l := range locs {
+			f, err := database.GetRomFile(l.ID)
+			if err != nil {
+				return nil, 0, err
+			}
+			files = append(files, *f)
+		}
+		if len(files) > 0 {
+			return files, len(files), nil
+		}
+	}
+	return database.SearchRoms(db.SearchFilter{Query: arg}, 1, 50)
+}
+
+func cmdInfo() {
+	flags := newFlags("info", "romu info <path-or-query>")
+	args, err := parseArgs(flags, os.Args[2:], 1, 1)
+	checkArgs(err)
+	arg := args[0]
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	files, _, err := findRoms(database, arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	switch {
+	case len(files) == 0:
+		fmt.Printf("No ROM matches %q\n", arg)
+		os.Exit(exitPartial)
+	case len(files) > 1:
+		fmt.Printf("%d ROMs match %q; narrow the query or pass a path:\n\n", len(files), arg)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PLATFORM\tFILENAME\tPATH")
+		for _, f := range files {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Platform, f.Filename, f.Path)
+		}
+		w.Flush()
+		os.Exit(exitUsage)
+	}
+
+	f := files[0]
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	field := func(name, value string) {
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(w, "%s:\t%s\n", name, value)
+	}
+	field("File", f.Filename)
+	field("Path", f.Path)
+	field("Platform", f.Platform)
+	field("Size", strconv.FormatInt(f.Size, 10))
+	field("CRC32", f.HashCRC32)
+	field("MD5", f.HashMD5)
+	field("SHA1", f.HashSHA1)
+	if f.UserRating > 0 {
+		field("My rating", strings.Repeat("★", f.UserRating))
+	}
+	if f.Favorite {
+		field("Favorite", "yes")
+	}
+
+	if f.GameID == nil {
+		w.Flush()
+		fmt.Println("\nNot matched to a game. Run 'romu match' or 'romu enrich' first.")
+		return
+	}
+	g, err := database.GetGame(*f.GameID)
+	if err != nil {
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	field("Title", g.TitleEN)
+	field("Title (JA)", g.TitleJA)
+	field("Developer", g.Developer)
+	field("Publisher", g.Publisher)
+	field("Release date", g.ReleaseDate)
+	field("Genre", g.Genre)
+	field("Players", g.Players)
+	field("Rating", g.Rating)
+	if len(g.Covers) == 0 {
+		field("Cover", "")
+	}
+	for _, c := range g.Covers {
+		field("Cover ("+c.ImageType+")", c.FilePath)
+	}
+	w.Flush()
+	if g.DescJA != "" {
+		fmt.Printf("\n%s\n", g.DescJA)
+	}
+}
+
 func cmdStats() {
-	database, err := db.Open()
+	flags := newFlags("stats", "romu stats [--names]")
+	names := flags.Bool("names", false, "show platform names, not codes")
+	_, err := parseArgs(flags, os.Args[2:], 0, 0)
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
 	stats, err := database.GetStats()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "stats error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "PLATFORM\tTOTAL\tMATCHED\tUNMATCHED\tTITLE_EN\tTITLE_JA")
+	fmt.Fprintln(w, "PLATFORM\tTOTAL\tMATCHED\tUNMATCHED\tTITLE_EN\tTITLE_JA\tSIZE")
 	for _, p := range stats.Platforms {
-		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n", p.Platform, p.Total, p.Matched, p.Unmatched, p.HasTitleEN, p.HasTitleJA)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", platformLabel(p.Platform, *names), p.Total, p.Matched, p.Unmatched, p.HasTitleEN, p.HasTitleJA, humanSize(p.TotalBytes))
 	}
-	fmt.Fprintf(w, "---\t---\t---\t---\t---\t---\n")
-	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\t\t\n", stats.Total, stats.Matched, stats.Unmatched)
+	fmt.Fprintf(w, "---\t---\t---\t---\t---\t---\t---\n")
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t%d\t\t\t%s\n", stats.Total, stats.Matched, stats.Unmatched, humanSize(stats.TotalBytes))
 	w.Flush()
 }
 
-func cmdServer() {
-	port := 8080
-	for i := 2; i < len(os.Args)-1; i++ {
-		if os.Args[i] == "--port" {
-			p, err := strconv.Atoi(os.Args[i+1])
-			if err == nil {
-				port = p
-			}
+// parseTopArgs parses "romu top" arguments
+func parseTopArgs(args []string) (platform string, limit int, err error) {
+	flags := newFlags("top", "romu top [--platform XX] [--limit N]")
+	platformVar(flags, &platform, "only ROMs of this platform")
+	flags.IntVar(&limit, "limit", 20, "number of ROMs to list")
+	if _, err := parseArgs(flags, args, 0, 0); err != nil {
+		return "", 0, err
+	}
+	if limit <= 0 {
+		return "", 0, fmt.Errorf("invalid --limit: %d", limit)
+	}
+	return platform, limit, nil
+}
+
+func cmdTop() {
+	platform, limit, err := parseTopArgs(os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	files, err := database.TopBySize(platform, limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "top error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if len(files) == 0 {
+		fmt.Println("No ROMs found.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tPLATFORM\tFILENAME\tGAME")
+	for _, f := range files {
+		game := "-"
+		if f.TitleJA != nil {
+			game = *f.TitleJA
+		} else if f.TitleEN != nil {
+			game = *f.TitleEN
 		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", humanSize(f.Size), f.Platform, f.Filename, game)
+	}
+	w.Flush()
+}
+
+// platformLabel returns the platform column for a table: the code, or with
+// --names the display name
+func platformLabel(code string, names bool) string {
+	if names {
+		return platform.DisplayName(code)
+	}
+	return code
+}
+
+// humanSize formats a byte count with binary units, e.g. "12.3 GB"
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// serverArgs are the arguments of "romu server"
+type serverArgs struct {
+	port       int
+	host       string
+	readOnly   bool
+	auth       server.Auth
+	statsCache time.Duration
+}
+
+// parseServerArgs parses "romu server" arguments
+func parseServerArgs(args []string) (serverArgs, error) {
+	var a serverArgs
+	flags := newFlags("server", "romu server [--port XXXX] [--host ADDR] [--read-only] [--auth-token TOKEN] [--basic-auth USER:PASS] [--protect-ui] [--stats-cache-ttl 5s]")
+	flags.IntVar(&a.port, "port", 8080, "port to listen on")
+	flags.StringVar(&a.host, "host", "127.0.0.1", "address to listen on; 0.0.0.0 for every interface")
+	flags.BoolVar(&a.readOnly, "read-only", false, "open the database read-only and refuse changes")
+	flags.StringVar(&a.auth.Token, "auth-token", "", "require this token on /api/ as a Bearer header or ?token=")
+	flags.StringVar(&a.auth.BasicAuth, "basic-auth", "", "require this user:pass on /api/")
+	flags.BoolVar(&a.auth.ProtectUI, "protect-ui", false, "require auth for the UI and covers too")
+	flags.DurationVar(&a.statsCache, "stats-cache-ttl", 5*time.Second, "reuse /api/stats results for this long between writes (0 disables)")
+	if _, err := parseArgs(flags, args, 0, 0); err != nil {
+		return serverArgs{}, err
+	}
+	if a.port <= 0 || a.port > 65535 {
+		return serverArgs{}, fmt.Errorf("invalid --port: %d", a.port)
+	}
+	if user, _, ok := strings.Cut(a.auth.BasicAuth, ":"); a.auth.BasicAuth != "" && (!ok || user == "") {
+		return serverArgs{}, fmt.Errorf("invalid --basic-auth: want user:pass")
+	}
+	if a.auth.ProtectUI && a.auth.Token == "" && a.auth.BasicAuth == "" {
+		return serverArgs{}, fmt.Errorf("--protect-ui needs --auth-token or --basic-auth")
 	}
+	if a.statsCache < 0 {
+		return serverArgs{}, fmt.Errorf("invalid --stats-cache-ttl: %s", a.statsCache)
+	}
+	return a, nil
+}
+
+func cmdServer() {
+	a, err := parseServerArgs(os.Args[2:])
+	checkArgs(err)
 
-	database, err := db.Open()
+	var database *db.DB
+	if a.readOnly {
+		database, err = db.OpenReadOnlyPath(cfg.DBPath)
+	} else {
+		database, err = db.OpenPath(cfg.DBPath)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
-	srv := server.New(database, port)
+	loadGameDB(cfg.GameDBDir)
+	srv := server.New(database, a.port)
+	srv.Host = a.host
+	srv.Auth = a.auth
+	srv.StatsCacheTTL = a.statsCache
+	srv.Covers = covers.Options{OutputDir: cfg.Covers.Dir, BaseURL: cfg.Covers.BaseURL}
 	if err := srv.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "server error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 }
 
 func cmdScan() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: romu scan <path>")
-		os.Exit(1)
-	}
-	path := os.Args[2]
-
-	database, err := db.Open()
+	opts := scanner.ScanOptions{Progress: printScanEvent}
+	flags := newFlags("scan", "romu scan <path> [--rehash] [--detect-moves] [--detect-content] [--keep-unknown]")
+	flags.BoolVar(&opts.Rehash, "rehash", false, "re-hash unchanged files")
+	flags.BoolVar(&opts.DetectMoves, "detect-moves", false, "relink renamed files")
+	flags.BoolVar(&opts.DetectContent, "detect-content", false, "identify ROMs outside platform folders by their headers")
+	flags.BoolVar(&opts.KeepUnknown, "keep-unknown", false, "record unidentified files as platform UNKNOWN")
+	args, err := parseArgs(flags, os.Args[2:], 1, 1)
+	checkArgs(err)
+	path := args[0]
+
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
 	fmt.Printf("Scanning %s ...\n", path)
-	result, err := scanner.Scan(path, database)
+	result, err := scanner.ScanWithOptions(path, database, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "scan error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 
-	fmt.Printf("\nDone! Scanned: %d, Added: %d, Skipped: %d, Errors: %d\n",
-		result.Scanned, result.Added, result.Skipped, result.Errors)
+	fmt.Printf("\nDone! Scanned: %d, Added: %d, Unchanged: %d, Moved: %d, Skipped: %d, Errors: %d\n",
+		result.Scanned, result.Added, result.Unchanged, result.Moved, result.Skipped, result.Errors)
+	if result.Errors > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// printScanEvent reports per-file scan progress on the terminal. Errors
+// and skips are logged by the scanner.
+func printScanEvent(ev scanner.ScanEvent) {
+	if logging.Quiet() {
+		return
+	}
+	switch ev.Action {
+	case scanner.ActionAdded:
+		fmt.Printf("  [%s] %s (CRC32: %s)\n", ev.Platform, ev.Name, ev.CRC32)
+	case scanner.ActionMoved:
+		fmt.Printf("  [%s] %s moved from %s\n", ev.Platform, ev.Name, ev.OldPath)
+	}
+}
+
+// listArgs are the arguments of "romu list"
+type listArgs struct {
+	filter        db.ListFilter
+	limit, offset int
+	names         bool
+}
+
+// parseListArgs parses "romu list" arguments, resolving --since against now
+func parseListArgs(args []string, now time.Time) (listArgs, error) {
+	var a listArgs
+	var since string
+	flags := newFlags("list", "romu list [--since 24h|DATE] [--platform XX] [--limit N] [--offset N] [--names]")
+	platformVar(flags, &a.filter.Platform, "only ROMs of this platform")
+	flags.StringVar(&since, "since", "", "only ROMs added within a duration (24h) or since a date (2006-01-02)")
+	flags.IntVar(&a.limit, "limit", -1, "list at most N ROMs (default: all)")
+	flags.IntVar(&a.offset, "offset", 0, "skip the first N ROMs")
+	flags.BoolVar(&a.names, "names", false, "show platform names, not codes")
+	if _, err := parseArgs(flags, args, 0, 0); err != nil {
+		return a, err
+	}
+	if a.limit < -1 {
+		return a, fmt.Errorf("invalid --limit: %d", a.limit)
+	}
+	if a.offset < 0 {
+		return a, fmt.Errorf("invalid --offset: %d", a.offset)
+	}
+	if since != "" {
+		t, err := parseSince(since, now)
+		if err != nil {
+			return a, fmt.Errorf("invalid --since: %w", err)
+		}
+		a.filter.Since = t
+	}
+	return a, nil
 }
 
 func cmdList() {
-	database, err := db.Open()
+	a, err := parseListArgs(os.Args[2:], time.Now())
+	checkArgs(err)
+	filter, limit, offset, names := a.filter, a.limit, a.offset, a.names
+
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
-	files, err := database.ListRomFiles()
+	files, total, err := database.ListRomFilesPaged(filter, limit, offset)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "list error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 
 	if len(files) == 0 {
+		if total > 0 {
+			fmt.Printf("No ROMs past offset %d (total: %d).\n", offset, total)
+			return
+		}
+		if filter != (db.ListFilter{}) {
+			fmt.Println("No ROMs match the given filters.")
+			return
+		}
 		fmt.Println("No ROMs registered. Run 'romu scan <path>' first.")
 		return
 	}
@@ -229,29 +888,57 @@ func cmdList() {
 		} else if f.TitleEN != nil {
 			game = *f.TitleEN
 		}
-		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", f.Platform, f.Filename, f.Size, f.HashCRC32, game)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", platformLabel(f.Platform, names), f.Filename, f.Size, f.HashCRC32, game)
 	}
 	w.Flush()
-	fmt.Printf("\nTotal: %d ROMs\n", len(files))
+	if len(files) < total {
+		fmt.Printf("\nShowing %d of %d ROMs (offset %d)\n", len(files), total, offset)
+		return
+	}
+	if filter.Platform != "" {
+		fmt.Printf("\nTotal: %d %s ROMs\n", total, filter.Platform)
+		return
+	}
+	fmt.Printf("\nTotal: %d ROMs\n", total)
+}
+
+// parseSince parses a --since value: a duration before now such as "24h",
+// or a local date ("2006-01-02", "2006-01-02 15:04") or RFC 3339 time
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration (24h) nor a date (2006-01-02)", s)
 }
 
 func cmdImportGameList() {
-	if len(os.Args) < 3 {
+	flags := newFlags("import-gamelist", "romu import-gamelist <roms-dir>")
+	args, err := parseArgs(flags, os.Args[2:], 0, -1)
+	checkArgs(err)
+	if len(args) != 1 {
 		fmt.Fprintln(os.Stderr, "usage: romu import-gamelist <roms-dir>")
 		fmt.Fprintln(os.Stderr, "  Scans for gamelist.xml in platform subdirectories")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
-	romsDir := os.Args[2]
+	romsDir := args[0]
 
-	database, err := db.Open()
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
 	// Walk romsDir for gamelist.xml files
-	totalCreated, totalMatched := 0, 0
+	totalCreated, totalMatched, failed := 0, 0, 0
 	err = filepath.Walk(romsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() || info.Name() != "gamelist.xml" {
 			return nil
@@ -268,6 +955,7 @@ func cmdImportGameList() {
 		entries, err := dat.ParseGameList(path)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "  error %s: %v\n", path, err)
+			failed++
 			return nil
 		}
 
@@ -284,12 +972,16 @@ func cmdImportGameList() {
 				Genre:       e.Genre,
 				Players:     e.Players,
 				Rating:      e.Rating,
+				Image:       e.Image,
+				Thumbnail:   e.Thumbnail,
+				Marquee:     e.Marquee,
 			}
 		}
 
 		created, matched, err := database.MatchByGameList(dbEntries, platform)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "  error %s: %v\n", path, err)
+			failed++
 			return nil
 		}
 
@@ -300,161 +992,284 @@ func cmdImportGameList() {
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "walk error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 
 	fmt.Printf("\nTotal: %d games created, %d ROMs matched\n", totalCreated, totalMatched)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d gamelist(s) failed\n", failed)
+		os.Exit(exitPartial)
+	}
 }
 
-func cmdEnrich() {
-	platform := ""
-	showSkipped := false
-	for i := 2; i < len(os.Args); i++ {
-		if os.Args[i] == "--platform" && i+1 < len(os.Args) {
-			platform = os.Args[i+1]
-			i++
+// parseImportPlaylistArgs parses "romu import-playlist" arguments
+func parseImportPlaylistArgs(args []string) (file, platform string, err error) {
+	flags := newFlags("import-playlist", "romu import-playlist <playlist.lpl> [--platform XX]")
+	platformVar(flags, &platform, "platform of every item, overriding their db_name")
+	pos, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return "", "", err
+	}
+	return pos[0], platform, nil
+}
+
+func cmdImportPlaylist() {
+	file, only, err := parseImportPlaylistArgs(os.Args[2:])
+	checkArgs(err)
+
+	items, err := dat.ParsePlaylist(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	// Items name their platform's database, as does a platform playlist's
+	// file name; a collection such as Favorites mixes platforms
+	byPlatform := map[string][]db.PlaylistItem{}
+	unknown := 0
+	for _, item := range items {
+		code := only
+		if code == "" {
+			code = platform.FromRetroArchDB(item.DBName)
+		}
+		if code == "" {
+			code = platform.FromRetroArchDB(filepath.Base(file))
 		}
-		if os.Args[i] == "--show-skipped" {
-			showSkipped = true
+		if code == "" {
+			unknown++
+			continue
 		}
+		byPlatform[code] = append(byPlatform[code], item)
+	}
+	if unknown > 0 {
+		fmt.Fprintf(os.Stderr, "  skipped %d items of unknown platform (use --platform)\n", unknown)
 	}
 
-	database, err := db.Open()
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
-	roms, noMatch, err := database.GetEnrichableRoms(platform)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	codes := slices.Sorted(maps.Keys(byPlatform))
+	totalCreated, totalMatched := 0, 0
+	for _, code := range codes {
+		created, matched, err := database.MatchByPlaylist(byPlatform[code], code)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", code, err)
+			os.Exit(exitFatal)
+		}
+		fmt.Printf("  [%s] %d items: %d games created, %d ROMs matched\n", code, len(byPlatform[code]), created, matched)
+		totalCreated += created
+		totalMatched += matched
 	}
 
-	if noMatch > 0 {
-		fmt.Printf("Note: %d ROM(s) have no game match. Run 'romu match' with DAT files first.\n\n", noMatch)
+	fmt.Printf("\nTotal: %d games created, %d ROMs matched\n", totalCreated, totalMatched)
+	if totalMatched == 0 {
+		os.Exit(exitPartial)
 	}
+}
 
-	enriched, skipped := 0, 0
-	// platform -> list of skipped titles
-	skippedByPlatform := make(map[string][]string)
-	for _, r := range roms {
-		entry := gamedb.Lookup(r.Platform, r.TitleEN)
-		if entry == nil {
-			skipped++
-			skippedByPlatform[r.Platform] = append(skippedByPlatform[r.Platform], r.TitleEN)
-			continue
-		}
-		err := database.UpdateGameMetadata(r.GameID, entry.TitleJA, entry.DescJA, entry.Developer, entry.Publisher, entry.ReleaseDate, entry.Genre, entry.Players)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "  error updating game %d: %v\n", r.GameID, err)
-			continue
-		}
-		enriched++
+func cmdEnrich() {
+	var platform, source, gamedbDir string
+	var showSkipped, dryRun, overwrite bool
+	flags := newFlags("enrich", "romu enrich [--platform XX] [--source LIST] [--gamedb-dir DIR] [--dry-run] [--overwrite] [--show-skipped]")
+	platformVar(flags, &platform, "only games of this platform")
+	flags.StringVar(&source, "source", "gamedb", "comma-separated sources to try in order: gamedb, screenscraper, igdb")
+	flags.StringVar(&gamedbDir, "gamedb-dir", cfg.GameDBDir, "directory of extra gamedb JSON files")
+	flags.BoolVar(&dryRun, "dry-run", false, "show changes without writing")
+	flags.BoolVar(&overwrite, "overwrite", false, "replace existing metadata")
+	flags.BoolVar(&showSkipped, "show-skipped", false, "list titles no source knew")
+	_, err := parseArgs(flags, os.Args[2:], 0, 0)
+	checkArgs(err)
+
+	enricher, err := newEnricher(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitUsage)
 	}
+	loadGameDB(gamedbDir)
 
-	// Also try to enrich unmatched ROMs by filename
-	unmatchedRoms, err := database.GetUnmatchedRoms(platform)
-	filenameEnriched := 0
-	filenameSkipped := 0
-	if err == nil {
-		for _, ur := range unmatchedRoms {
-			// Extract title from filename (may be "archive.zip/romname.ext")
-			title := ur.Filename
-			if idx := strings.LastIndex(title, "/"); idx >= 0 {
-				title = title[idx+1:]
-			}
-			// Strip ROM extension
-			for _, ext := range []string{".zip", ".7z", ".nes", ".sfc", ".smc", ".gb", ".gbc", ".gba", ".md", ".bin", ".pce", ".ws", ".wsc", ".n64", ".z64", ".v64", ".nds"} {
-				title = strings.TrimSuffix(title, ext)
-			}
-			// Also try the zip name (before /) as fallback
-			zipTitle := ur.Filename
-			if idx := strings.Index(zipTitle, "/"); idx >= 0 {
-				zipTitle = zipTitle[:idx]
-			}
-			zipTitle = strings.TrimSuffix(zipTitle, ".zip")
-			zipTitle = strings.TrimSuffix(zipTitle, ".7z")
-			entry := gamedb.Lookup(ur.Platform, title)
-			lookupTitle := title
-			if entry == nil {
-				entry = gamedb.Lookup(ur.Platform, zipTitle)
-				lookupTitle = zipTitle
-			}
-			if entry == nil {
-				filenameSkipped++
-				skippedByPlatform[ur.Platform] = append(skippedByPlatform[ur.Platform], title)
-				continue
-			}
-			err := database.CreateGameAndLink(ur.ID, lookupTitle, ur.Platform, entry.TitleJA, entry.DescJA, entry.Developer, entry.Publisher, entry.ReleaseDate, entry.Genre, entry.Players)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "  error creating game for %s: %v\n", title, err)
-				continue
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	quotaWarned := false
+	res, err := enrich.Run(database, enrich.Options{
+		Platform:       platform,
+		Source:         enricher,
+		JapaneseTitles: usesGameDB(source),
+		DryRun:         dryRun,
+		Overwrite:      overwrite,
+		Warn: func(err error) {
+			if errors.Is(err, screenscraper.ErrQuotaExceeded) {
+				if quotaWarned {
+					return
+				}
+				quotaWarned = true
 			}
-			filenameEnriched++
-		}
+			fmt.Fprintf(os.Stderr, "  warning: %v\n", err)
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
 	}
 
-	fmt.Printf("Enriched %d games (%d skipped - no gamedb entry)\n", enriched, skipped)
-	if filenameEnriched > 0 || filenameSkipped > 0 {
-		fmt.Printf("Enriched %d unmatched ROMs by filename (%d skipped)\n", filenameEnriched, filenameSkipped)
+	if res.NoMatch > 0 {
+		fmt.Printf("Note: %d ROM(s) have no game match. Run 'romu match' with DAT files first.\n\n", res.NoMatch)
+	}
+	for _, change := range res.Planned {
+		fmt.Printf("  would %s\n", change)
+	}
+	if dryRun {
+		fmt.Printf("\nDry run: nothing was written.\n")
+	}
+	fmt.Printf("Enriched %d games (%d skipped - no %s entry)\n", res.Enriched, res.Skipped, enricher.Name())
+	if res.FilenameEnriched > 0 || res.FilenameSkipped > 0 {
+		fmt.Printf("Enriched %d unmatched ROMs by filename (%d skipped)\n", res.FilenameEnriched, res.FilenameSkipped)
+	}
+	if res.JapaneseEnriched > 0 {
+		fmt.Printf("Added English titles to %d Japanese-titled games\n", res.JapaneseEnriched)
 	}
 
-	if showSkipped && (skipped > 0 || filenameSkipped > 0) {
+	if showSkipped && (res.Skipped > 0 || res.FilenameSkipped > 0) {
 		fmt.Printf("\n--- Skipped titles by platform ---\n")
 		// Sort platforms for consistent output
-		platforms := make([]string, 0, len(skippedByPlatform))
-		for p := range skippedByPlatform {
+		platforms := make([]string, 0, len(res.SkippedTitles))
+		for p := range res.SkippedTitles {
 			platforms = append(platforms, p)
 		}
 		sort.Strings(platforms)
 		for _, p := range platforms {
-			titles := skippedByPlatform[p]
+			titles := res.SkippedTitles[p]
 			fmt.Printf("\n[%s] (%d skipped)\n", p, len(titles))
-			sort.Strings(titles)
 			for _, t := range titles {
 				fmt.Printf("  - %s\n", t)
 			}
 		}
 	}
+	if res.Failed > 0 {
+		os.Exit(exitPartial)
+	}
 }
 
-func cmdExportGameList() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: romu export-gamelist <output-dir> [--platform XX]")
-		os.Exit(1)
+// loadGameDB overlays the gamedb JSON files in dir, default ~/.romu/gamedb
+func loadGameDB(dir string) {
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".romu", "gamedb")
 	}
-	outDir := os.Args[2]
-	platform := ""
-	for i := 3; i < len(os.Args)-1; i++ {
-		if os.Args[i] == "--platform" {
-			platform = os.Args[i+1]
+	if err := gamedb.LoadFrom(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+}
+
+// usesGameDB reports whether a --source list includes the gamedb
+func usesGameDB(source string) bool {
+	return slices.ContainsFunc(strings.Split(source, ","), func(name string) bool {
+		return strings.TrimSpace(name) == "gamedb"
+	})
+}
+
+// newEnricher builds the metadata source chain for a comma-separated
+// --source list, tried in the given order
+func newEnricher(source string) (enrich.Enricher, error) {
+	var chain enrich.Chain
+	for _, name := range strings.Split(source, ",") {
+		var e enrich.Enricher
+		var err error
+		switch strings.TrimSpace(name) {
+		case "gamedb":
+			e = enrich.GameDB{}
+		case "screenscraper":
+			e, err = screenscraper.NewFromEnv()
+		case "igdb":
+			e, err = igdb.NewFromEnv()
+		default:
+			err = fmt.Errorf("unknown source: %s (want gamedb, screenscraper or igdb)", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, e)
+	}
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+// exportGameListArgs are the arguments of "romu export-gamelist"
+type exportGameListArgs struct {
+	outDir    string
+	platform  string
+	mediaRoot string
+	absolute  bool
+}
+
+// parseExportGameListArgs parses "romu export-gamelist" arguments
+func parseExportGameListArgs(args []string) (exportGameListArgs, error) {
+	var a exportGameListArgs
+	flags := newFlags("export-gamelist", "romu export-gamelist <output-dir> [--platform XX] [--media-root DIR] [--absolute-paths]")
+	platformVar(flags, &a.platform, "export only this platform")
+	flags.StringVar(&a.mediaRoot, "media-root", "", "copy media to DIR/<XX>/ instead of <output-dir>/<XX>/media/")
+	flags.BoolVar(&a.absolute, "absolute-paths", false, "write absolute ROM and media paths instead of ./ ones")
+	pos, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return exportGameListArgs{}, err
+	}
+	a.outDir = pos[0]
+	if a.mediaRoot != "" {
+		if info, err := os.Stat(a.mediaRoot); err != nil || !info.IsDir() {
+			return exportGameListArgs{}, fmt.Errorf("invalid --media-root: %s is not a directory", a.mediaRoot)
 		}
 	}
+	return a, nil
+}
+
+func cmdExportGameList() {
+	a, err := parseExportGameListArgs(os.Args[2:])
+	checkArgs(err)
 
-	database, err := db.Open()
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
 	var platforms []string
-	if platform != "" {
-		platforms = []string{platform}
+	if a.platform != "" {
+		platforms = []string{a.platform}
 	} else {
 		platforms, err = database.GetPlatforms()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitFatal)
 		}
 	}
 
+	// Media and absolute paths are worked out from absolute directories
+	outDir, err := filepath.Abs(a.outDir)
+	if err == nil && a.mediaRoot != "" {
+		a.mediaRoot, err = filepath.Abs(a.mediaRoot)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	failed, mediaFailed := 0, 0
 	for _, p := range platforms {
 		entries, err := database.ExportGameList(p)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
 			continue
 		}
 		if len(entries) == 0 {
@@ -462,164 +1277,1394 @@ func cmdExportGameList() {
 		}
 
 		dir := filepath.Join(outDir, p)
-		os.MkdirAll(dir, 0755)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
 		outPath := filepath.Join(dir, "gamelist.xml")
+		x := gameListExport{dir: dir, mediaDir: filepath.Join(dir, "media"), absolute: a.absolute}
+		if a.mediaRoot != "" {
+			x.mediaDir = filepath.Join(a.mediaRoot, p)
+		}
 
-		f, err := os.Create(outPath)
+		games := make([]dat.GameListGame, len(entries))
+		for i, e := range entries {
+			image := e.Image
+			if image == "" {
+				image = e.Boxart
+			}
+			media := func(kind, src string) string {
+				ref, err := x.media(kind, e.Path, src)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "  error [%s]: copy media: %v\n", p, err)
+					mediaFailed++
+				}
+				return ref
+			}
+			romPath := e.Path
+			if a.absolute {
+				romPath = e.File
+			}
+			games[i] = dat.GameListGame{
+				Path:        romPath,
+				Name:        e.Name,
+				Desc:        e.Desc,
+				ReleaseDate: e.ReleaseDate,
+				Developer:   e.Developer,
+				Publisher:   e.Publisher,
+				Genre:       e.Genre,
+				Players:     e.Players,
+				Rating:      e.Rating,
+				Image:       media("images", image),
+				Thumbnail:   media("thumbnails", e.Thumbnail),
+				Marquee:     media("marquees", e.Marquee),
+			}
+		}
+		data, err := dat.MarshalGameList(games)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "  error creating %s: %v\n", outPath, err)
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
+		if err := fsutil.WriteFileAtomic(outPath, data); err != nil {
+			fmt.Fprintf(os.Stderr, "  error writing %s: %v\n", outPath, err)
+			failed++
 			continue
 		}
-		f.WriteString("<?xml version=\"1.0\"?>\n<gameList>\n")
-		for _, e := range entries {
-			f.WriteString("  <game>\n")
-			writeXMLField(f, "path", e.Path)
-			writeXMLField(f, "name", e.Name)
-			writeXMLField(f, "desc", e.Desc)
-			writeXMLField(f, "releasedate", e.ReleaseDate)
-			writeXMLField(f, "developer", e.Developer)
-			writeXMLField(f, "publisher", e.Publisher)
-			writeXMLField(f, "genre", e.Genre)
-			writeXMLField(f, "players", e.Players)
-			writeXMLField(f, "rating", e.Rating)
-			f.WriteString("  </game>\n")
-		}
-		f.WriteString("</gameList>\n")
-		f.Close()
 
 		fmt.Printf("  [%s] %d games → %s\n", p, len(entries), outPath)
 	}
+	if mediaFailed > 0 {
+		fmt.Fprintf(os.Stderr, "%d media files could not be copied\n", mediaFailed)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d platform(s) failed\n", failed)
+	}
+	if failed+mediaFailed > 0 {
+		os.Exit(exitPartial)
+	}
 }
 
-func cmdImportDAT() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: romu import-dat <dat-file> [--platform XX]")
-		os.Exit(1)
+// parseExportPlaylistArgs parses "romu export-playlist" arguments
+func parseExportPlaylistArgs(args []string) (outDir, platform string, err error) {
+	flags := newFlags("export-playlist", "romu export-playlist <output-dir> [--platform XX]")
+	platformVar(flags, &platform, "export only this platform")
+	pos, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return "", "", err
+	}
+	return pos[0], platform, nil
+}
+
+func cmdExportPlaylist() {
+	outDir, platform, err := parseExportPlaylistArgs(os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	var platforms []string
+	if platform != "" {
+		platforms = []string{platform}
+	} else {
+		platforms, err = database.GetPlatforms()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
 	}
-	datPath := os.Args[2]
-	platform := ""
-	for i := 3; i < len(os.Args)-1; i++ {
-		if os.Args[i] == "--platform" {
-			platform = os.Args[i+1]
+	failed := 0
+	for _, p := range platforms {
+		items, err := database.ExportPlaylist(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		// RetroArch finds a playlist's thumbnails by its file name
+		outPath := filepath.Join(outDir, items[0].DBName)
+		data, err := dat.MarshalPlaylist(items)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
 		}
+		if err := fsutil.WriteFileAtomic(outPath, data); err != nil {
+			fmt.Fprintf(os.Stderr, "  error writing %s: %v\n", outPath, err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("  [%s] %d games → %s\n", p, len(items), outPath)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d platform(s) failed\n", failed)
+		os.Exit(exitPartial)
 	}
+}
 
-	roms, headerName, err := dat.ParseDAT(datPath, platform)
+// parseExportPegasusArgs parses "romu export-pegasus" arguments
+func parseExportPegasusArgs(args []string) (outDir, platform string, err error) {
+	flags := newFlags("export-pegasus", "romu export-pegasus <output-dir> [--platform XX]")
+	platformVar(flags, &platform, "export only this platform")
+	pos, err := parseArgs(flags, args, 1, 1)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse error: %v\n", err)
-		os.Exit(1)
+		return "", "", err
 	}
+	return pos[0], platform, nil
+}
 
-	database, err := db.Open()
+func cmdExportPegasus() {
+	outDir, only, err := parseExportPegasusArgs(os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
-	count, err := database.ImportDATGames(roms)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "import error: %v\n", err)
-		os.Exit(1)
+	var platforms []string
+	if only != "" {
+		platforms = []string{only}
+	} else {
+		platforms, err = database.GetPlatforms()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFatal)
+		}
 	}
 
-	fmt.Printf("Imported DAT: %s\n", headerName)
-	fmt.Printf("Games added: %d (from %d ROM entries)\n", count, len(roms))
+	failed := 0
+	for _, p := range platforms {
+		entries, err := database.ExportGameList(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		games := pegasusGames(entries)
+		dir := filepath.Join(outDir, p)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
+		outPath := filepath.Join(dir, "metadata.pegasus.txt")
+		data := dat.MarshalPegasus(platform.DisplayName(p), strings.ToLower(p), games)
+		if err := fsutil.WriteFileAtomic(outPath, data); err != nil {
+			fmt.Fprintf(os.Stderr, "  error writing %s: %v\n", outPath, err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("  [%s] %d games → %s\n", p, len(games), outPath)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d platform(s) failed\n", failed)
+		os.Exit(exitPartial)
+	}
 }
 
-func cmdMatch() {
-	// For matching, we need DAT files to have been imported first.
-	// We re-read all DAT info from the games table and match by hash.
-	// However, since we don't store ROM hashes in games table,
-	// we need a different approach: store DAT ROM info separately or
-	// re-parse DAT files. For simplicity, we'll ask user to provide DAT files again.
+// pegasusGames turns gamelist export entries into Pegasus games. ROMs of
+// the same game become one game with several files, and a file, such as
+// an archive holding several ROMs, is only listed once.
+func pegasusGames(entries []db.ExportGameListEntry) []dat.PegasusGame {
+	var games []dat.PegasusGame
+	byTitle := map[string]int{}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		file := strings.TrimPrefix(e.Path, "./")
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+		if i, ok := byTitle[e.Name]; ok {
+			games[i].Files = append(games[i].Files, file)
+			continue
+		}
+		byTitle[e.Name] = len(games)
+		games = append(games, dat.PegasusGame{
+			Title:       e.Name,
+			Files:       []string{file},
+			Developer:   e.Developer,
+			Publisher:   e.Publisher,
+			Genre:       e.Genre,
+			Players:     e.Players,
+			ReleaseDate: e.ReleaseDate,
+			Rating:      e.Rating,
+			Desc:        e.Desc,
+		})
+	}
+	return games
+}
 
-	fmt.Println("Matching ROMs to games by hash...")
-	fmt.Println("Note: You need to provide DAT files for matching.")
-	fmt.Println()
+// parseExportLaunchBoxArgs parses "romu export-launchbox" arguments
+func parseExportLaunchBoxArgs(args []string) (outDir, platform string, err error) {
+	flags := newFlags("export-launchbox", "romu export-launchbox <output-dir> [--platform XX]")
+	platformVar(flags, &platform, "export only this platform")
+	pos, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return "", "", err
+	}
+	return pos[0], platform, nil
+}
+
+func cmdExportLaunchBox() {
+	outDir, only, err := parseExportLaunchBoxArgs(os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	var platforms []string
+	if only != "" {
+		platforms = []string{only}
+	} else {
+		platforms, err = database.GetPlatforms()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	failed := 0
+	for _, p := range platforms {
+		entries, err := database.ExportGameList(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		name := platform.DisplayName(p)
+		if info, ok := platform.Get(p); ok && info.LaunchBox != "" {
+			name = info.LaunchBox
+		}
+		games := launchBoxGames(name, entries)
+		outPath := filepath.Join(outDir, name+".xml")
+		data, err := dat.MarshalLaunchBox(games)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
+		if err := fsutil.WriteFileAtomic(outPath, data); err != nil {
+			fmt.Fprintf(os.Stderr, "  error writing %s: %v\n", outPath, err)
+			failed++
+			continue
+		}
 
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: romu match <dat-file> [--platform XX]")
-		fmt.Fprintln(os.Stderr, "  Provide the same DAT file(s) used with import-dat")
-		os.Exit(1)
+		fmt.Printf("  [%s] %d games → %s\n", p, len(games), outPath)
 	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d platform(s) failed\n", failed)
+		os.Exit(exitPartial)
+	}
+}
 
-	datPath := os.Args[2]
-	platform := ""
-	for i := 3; i < len(os.Args)-1; i++ {
-		if os.Args[i] == "--platform" {
-			platform = os.Args[i+1]
+// launchBoxGames turns gamelist export entries into LaunchBox games on the
+// named platform, listing a file, such as an archive holding several ROMs,
+// only once
+func launchBoxGames(platformName string, entries []db.ExportGameListEntry) []dat.LaunchBoxGame {
+	var games []dat.LaunchBoxGame
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if seen[e.File] {
+			continue
 		}
+		seen[e.File] = true
+		games = append(games, dat.LaunchBoxGame{
+			Title:           e.Name,
+			ApplicationPath: e.File,
+			Platform:        platformName,
+			ReleaseDate:     e.ReleaseDate,
+			Developer:       e.Developer,
+			Publisher:       e.Publisher,
+			Genre:           e.Genre,
+			Notes:           e.Desc,
+		})
 	}
+	return games
+}
 
-	roms, _, err := dat.ParseDAT(datPath, platform)
+// importDATArgs are the arguments of "romu import-dat"
+type importDATArgs struct {
+	datPath, dir  string // one of them is set
+	platform      string
+	includeNoDump bool
+}
+
+// parseImportDATArgs parses "romu import-dat" arguments: one of a DAT
+// file or --dir
+func parseImportDATArgs(args []string) (importDATArgs, error) {
+	var a importDATArgs
+	flags := newFlags("import-dat", "romu import-dat <dat-file> [--platform XX] [--include-nodump]", "romu import-dat --dir <dir> [--platform XX] [--include-nodump]")
+	platformVar(flags, &a.platform, "platform code, overriding detection from the DAT header")
+	flags.StringVar(&a.dir, "dir", "", "import every .dat and .xml file in this directory")
+	flags.BoolVar(&a.includeNoDump, "include-nodump", false, "keep ROMs the DAT marks as never dumped")
+	pos, err := parseArgs(flags, args, 0, 1)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse error: %v\n", err)
-		os.Exit(1)
+		return importDATArgs{}, err
+	}
+	if (len(pos) == 0) == (a.dir == "") {
+		flags.Usage()
+		return importDATArgs{}, errUsage
+	}
+	if len(pos) == 1 {
+		a.datPath = pos[0]
+	}
+	return a, nil
+}
+
+func cmdImportDAT() {
+	a, err := parseImportDATArgs(os.Args[2:])
+	checkArgs(err)
+
+	if a.dir != "" {
+		importDATDir(a.dir, a.platform, a.includeNoDump)
+		return
 	}
 
-	database, err := db.Open()
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
-	matched, err := database.MatchROMs(roms)
+	ctx, stop := interruptContext()
+	defer stop()
+	var progress datProgress
+	im, err := importDAT(ctx, database, a.datPath, a.platform, a.includeNoDump, &progress)
+	progress.end()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "match error: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "import error: %v\n", err)
+		os.Exit(exitFatal)
 	}
 
-	fmt.Printf("Matched %d ROM(s) to games.\n", matched)
+	fmt.Printf("Imported DAT: %s\n", im.header)
+	if len(im.platforms) > 1 {
+		fmt.Printf("Platforms: %s\n", strings.Join(im.platforms, ", "))
+	}
+	fmt.Printf("Games added: %d (from %d ROM entries)\n", im.games, im.roms)
+	printNoDumps(im.noDumps)
 }
 
-func cmdFetchCovers() {
-	platform := ""
-	outputDir := ""
-	force := false
-	for i := 2; i < len(os.Args); i++ {
-		switch os.Args[i] {
-		case "--platform":
-			if i+1 < len(os.Args) {
-				platform = os.Args[i+1]
-				i++
+// datImport is what importDAT did
+type datImport struct {
+	header    string
+	games     int      // games added
+	roms      int      // ROM entries imported
+	noDumps   int      // nodump ROM entries left out
+	platforms []string // of the ROMs imported, as several concatenated DATs have more than one
+}
+
+// importDAT imports the DAT at path as it reads it, so that a big one never
+// has to fit in memory, leaving out ROMs never dumped unless includeNoDump.
+// Nothing is imported if it fails. How far it got is shown on progress, if
+// set.
+func importDAT(ctx context.Context, database *db.DB, path, platform string, includeNoDump bool, progress *datProgress) (datImport, error) {
+	var im datImport
+	var reading, importing func(int)
+	if progress != nil {
+		reading, importing = progress.reading, progress.importing
+	}
+	games, err := database.ImportDATGamesFunc(ctx, func(add func(db.DATRom) error) error {
+		var err error
+		im.header, err = dat.StreamDAT(path, platform, reading, func(r db.DATRom) error {
+			if r.Status == db.StatusNoDump && !includeNoDump {
+				im.noDumps++
+				return nil
 			}
-		case "--output-dir":
-			if i+1 < len(os.Args) {
-				outputDir = os.Args[i+1]
-				i++
+			if !slices.Contains(im.platforms, r.Platform) {
+				im.platforms = append(im.platforms, r.Platform)
 			}
-		case "--force":
-			force = true
-		}
+			im.roms++
+			return add(r)
+		})
+		return err
+	}, importing)
+	if err != nil {
+		return datImport{}, err
+	}
+	im.games = games
+	return im, nil
+}
+
+// datProgress shows how far reading and importing a DAT got on one
+// terminal line, as big ones take minutes. Both go on at once, a game at a
+// time.
+type datProgress struct {
+	games, roms int  // read and imported so far
+	shown       bool // a line is shown and not yet ended
+}
+
+func (p *datProgress) reading(games int) {
+	p.games = games
+	p.show()
+}
+
+func (p *datProgress) importing(roms int) {
+	p.roms = roms
+	p.show()
+}
+
+func (p *datProgress) show() {
+	if logging.Quiet() {
+		return
+	}
+	fmt.Printf("\rImporting DAT: %d games read, %d ROM entries imported    ", p.games, p.roms)
+	p.shown = true
+}
+
+// end moves past the progress line, if one is shown
+func (p *datProgress) end() {
+	if p.shown {
+		fmt.Println()
+		p.shown = false
+	}
+}
+
+// dumpedRoms returns roms without those never dumped, unless include, and
+// how many it left out
+func dumpedRoms(roms []db.DATRom, include bool) ([]db.DATRom, int) {
+	if include {
+		return roms, 0
 	}
+	return db.DumpedRoms(roms)
+}
+
+func printNoDumps(n int) {
+	if n > 0 {
+		fmt.Printf("Skipped %d nodump ROM entries (use --include-nodump to keep them)\n", n)
+	}
+}
 
-	database, err := db.Open()
+// importDATDir imports every *.dat and *.xml file under dir. Files that fail
+// to import (e.g. unknown platform) are reported and skipped.
+func importDATDir(dir, platform string, includeNoDump bool) {
+	database, err := db.OpenPath(cfg.DBPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitFatal)
 	}
 	defer database.Close()
 
-	if err := covers.FetchCovers(database, platform, outputDir, force); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	ctx, stop := interruptContext()
+	defer stop()
+	type platformCount struct{ files, games, roms int }
+	counts := make(map[string]*platformCount)
+	imported, failed, noDumps := 0, 0, 0
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".dat" && ext != ".xml" {
+			return nil
+		}
+
+		im, err := importDAT(ctx, database, path, platform, includeNoDump, nil)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  warning: skip %s: %v\n", path, err)
+			failed++
+			return nil
+		}
+		noDumps += im.noDumps
+
+		p := platform
+		if len(im.platforms) > 0 {
+			p = strings.Join(im.platforms, ", ")
+		}
+		fmt.Printf("  [%s] %s: %d games added (from %d ROM entries)\n", p, im.header, im.games, im.roms)
+		if counts[p] == nil {
+			counts[p] = &platformCount{}
+		}
+		counts[p].files++
+		counts[p].games += im.games
+		counts[p].roms += im.roms
+		imported++
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "walk error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	platforms := make([]string, 0, len(counts))
+	for p := range counts {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORM\tDATS\tGAMES ADDED\tROM ENTRIES")
+	for _, p := range platforms {
+		c := counts[p]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", p, c.files, c.games, c.roms)
+	}
+	w.Flush()
+	fmt.Printf("\nImported %d DAT file(s), %d skipped\n", imported, failed)
+	printNoDumps(noDumps)
+	if failed > 0 {
+		os.Exit(exitPartial)
 	}
 }
 
-func writeXMLField(f *os.File, tag, value string) {
-	if value == "" {
-		return
+// matchArgs are the arguments of "romu match"
+type matchArgs struct {
+	datPath       string // empty to match against imported DATs
+	platform      string
+	includeNoDump bool
+}
+
+// parseMatchArgs parses "romu match" arguments: an optional DAT file and
+// flags
+func parseMatchArgs(args []string) (matchArgs, error) {
+	var a matchArgs
+	flags := newFlags("match", "romu match [dat-file] [--platform XX] [--include-nodump]")
+	platformVar(flags, &a.platform, "only ROMs of this platform")
+	flags.BoolVar(&a.includeNoDump, "include-nodump", false, "match ROMs the DAT marks as never dumped too")
+	pos, err := parseArgs(flags, args, 0, 1)
+	if err != nil {
+		return matchArgs{}, err
+	}
+	if len(pos) == 1 {
+		a.datPath = pos[0]
+	}
+	return a, nil
+}
+
+func cmdMatch() {
+	// Hashes from imported DATs are stored in the database, so matching
+	// normally needs no arguments. A DAT file can still be given to match
+	// against it directly without importing it.
+	a, err := parseMatchArgs(os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	var roms []db.DATRom
+	var noDumps int
+	if a.datPath != "" {
+		roms, _, err = dat.ParseDAT(a.datPath, a.platform)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "parse error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+	} else {
+		roms, err = database.GetDATRoms(a.platform)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		if len(roms) == 0 {
+			fmt.Fprintln(os.Stderr, "No DAT entries in the database. Run 'romu import-dat <dat-file>' first.")
+			os.Exit(exitPartial)
+		}
+	}
+	roms, noDumps = dumpedRoms(roms, a.includeNoDump)
+	if a.includeNoDump {
+		// MatchROMs skips nodump ROMs; take their hashes as real instead
+		for i := range roms {
+			if roms[i].Status == db.StatusNoDump {
+				roms[i].Status = ""
+			}
+		}
+	}
+
+	fmt.Println("Matching ROMs to games by hash...")
+	ctx, stop := interruptContext()
+	defer stop()
+	matched, err := database.MatchROMsContext(ctx, roms)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "match error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	fmt.Printf("Matched %d ROM(s) to games.\n", matched)
+	printNoDumps(noDumps)
+	if matched == 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// parsePlatformArgs parses the arguments of a command whose only flag is
+// --platform
+func parsePlatformArgs(name string, args []string) (platform string, err error) {
+	flags := newFlags(name, "romu "+name+" [--platform XX]")
+	platformVar(flags, &platform, "only ROMs of this platform")
+	_, err = parseArgs(flags, args, 0, 0)
+	return platform, err
+}
+
+func cmdUnmatched() {
+	platform, err := parsePlatformArgs("unmatched", os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	roms, err := database.GetUnmatchedRoms(platform)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if len(roms) == 0 {
+		fmt.Println("All ROMs are matched.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORM\tFILENAME")
+	for _, r := range roms {
+		fmt.Fprintf(w, "%s\t%s\n", r.Platform, r.Filename)
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %d unmatched ROMs\n", len(roms))
+}
+
+func cmdMatchAll() {
+	platform, err := parsePlatformArgs("match-all", os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	var platforms []string
+	if platform != "" {
+		platforms = []string{platform}
+	} else {
+		platforms, err = database.GetPlatforms()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	total, failed := 0, 0
+	for _, p := range platforms {
+		matched, err := database.MatchAllStoredContext(ctx, p)
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "interrupted; [%s] and later platforms were not matched\n", p)
+			os.Exit(exitFatal)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  error [%s]: %v\n", p, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  [%s] %d ROM(s) matched\n", p, matched)
+		total += matched
+	}
+	fmt.Printf("\nMatched %d ROM(s) to games.\n", total)
+	if total == 0 || failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+func cmdMissingJA() {
+	platform, err := parsePlatformArgs("missing-ja", os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	games, err := database.GamesMissingJA(platform)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if len(games) == 0 {
+		fmt.Println("Every matched game has a Japanese title and description.")
+		return
+	}
+
+	type platformCount struct{ games, noTitle int }
+	counts := map[string]*platformCount{}
+	var platforms []string
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORM\tTITLE\tMISSING")
+	for _, g := range games {
+		var missing []string
+		if g.TitleJA == "" {
+			missing = append(missing, "title_ja")
+		}
+		if g.DescJA == "" {
+			missing = append(missing, "description_ja")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", g.Platform, g.TitleEN, strings.Join(missing, ", "))
+		c := counts[g.Platform]
+		if c == nil {
+			c = &platformCount{}
+			counts[g.Platform] = c
+			platforms = append(platforms, g.Platform)
+		}
+		c.games++
+		if g.TitleJA == "" {
+			c.noTitle++
+		}
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, p := range platforms {
+		fmt.Fprintf(w, "  [%s]\t%d games\t(%d without title_ja)\n", p, counts[p].games, counts[p].noTitle)
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %d games missing Japanese text\n", len(games))
+}
+
+// mergeGamesArgs are the arguments of "romu merge-games"
+type mergeGamesArgs struct {
+	platform    string
+	apply       bool
+	keep, merge int64 // set if given explicitly
+}
+
+// parseMergeGamesArgs parses "romu merge-games" arguments: flags, or two
+// game IDs
+func parseMergeGamesArgs(args []string) (mergeGamesArgs, error) {
+	var a mergeGamesArgs
+	flags := newFlags("merge-games", "romu merge-games [--platform XX] [--apply]", "romu merge-games <keep-id> <merge-id>")
+	platformVar(flags, &a.platform, "only games of this platform")
+	flags.BoolVar(&a.apply, "apply", false, "merge the duplicates instead of listing them")
+	pos, err := parseArgs(flags, args, 0, 2)
+	if err != nil {
+		return a, err
+	}
+	switch {
+	case len(pos) == 0:
+		return a, nil
+	case len(pos) == 1 || a.platform != "" || a.apply:
+		flags.Usage()
+		return a, errUsage
+	}
+	ids := []*int64{&a.keep, &a.merge}
+	for i, s := range pos {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			return a, fmt.Errorf("invalid game ID: %s", s)
+		}
+		*ids[i] = id
+	}
+	return a, nil
+}
+
+func cmdMergeGames() {
+	a, err := parseMergeGamesArgs(os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	if a.keep != 0 {
+		if err := database.MergeGames(a.keep, a.merge); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			if errors.Is(err, db.ErrNotFound) {
+				os.Exit(exitPartial)
+			}
+			os.Exit(exitFatal)
+		}
+		fmt.Printf("Merged game %d into %d.\n", a.merge, a.keep)
+		return
+	}
+
+	dups, err := database.FindDuplicateGames(a.platform)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if len(dups) == 0 {
+		fmt.Println("No duplicate games found.")
+		return
+	}
+
+	merged, failed := 0, 0
+	for _, d := range dups {
+		keep := d.Games[0]
+		fmt.Printf("  [%s] %s: keeping %d %s\n", d.Platform, d.Title, keep.ID, gameTitle(keep))
+		for _, g := range d.Games[1:] {
+			if !a.apply {
+				fmt.Printf("    would merge %d %s\n", g.ID, gameTitle(g))
+				continue
+			}
+			if err := database.MergeGames(keep.ID, g.ID); err != nil {
+				fmt.Fprintf(os.Stderr, "    error: %v\n", err)
+				failed++
+				continue
+			}
+			fmt.Printf("    merged %d %s\n", g.ID, gameTitle(g))
+			merged++
+		}
+	}
+	if !a.apply {
+		fmt.Printf("\n%d set(s) of duplicate games found. Run with --apply to merge them.\n", len(dups))
+		return
+	}
+	fmt.Printf("\nMerged %d game(s).\n", merged)
+	if failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// gameTitle returns a game's English title, or its Japanese one
+func gameTitle(g db.Game) string {
+	if g.TitleEN != "" {
+		return g.TitleEN
+	}
+	return g.TitleJA
+}
+
+func cmdFetchCovers() {
+	opts := covers.Options{
+		OutputDir: cfg.Covers.Dir,
+		Workers:   cfg.Covers.Workers,
+		BaseURL:   cfg.Covers.BaseURL,
+	}
+	flags := newFlags("fetch-covers", "romu fetch-covers [--platform XX] [--output-dir DIR] [--force] [--source-dir DIR] [--allow-network] [--retry-missing] [--workers N] [--base-url URL]")
+	platformVar(flags, &opts.Platform, "only covers of this platform")
+	flags.StringVar(&opts.OutputDir, "output-dir", opts.OutputDir, "where covers are saved (default ~/.romu/covers)")
+	flags.BoolVar(&opts.Force, "force", false, "download covers that already exist again")
+	flags.StringVar(&opts.SourceDir, "source-dir", "", "copy covers from a local libretro-thumbnails clone")
+	flags.BoolVar(&opts.AllowNetwork, "allow-network", false, "with --source-dir, download covers missing there")
+	flags.BoolVar(&opts.RetryMissing, "retry-missing", false, "retry covers found missing by earlier runs")
+	flags.IntVar(&opts.Workers, "workers", opts.Workers, "parallel downloads (default 4)")
+	flags.StringVar(&opts.BaseURL, "base-url", opts.BaseURL, "libretro-thumbnails mirror (default GitHub)")
+	_, err := parseArgs(flags, os.Args[2:], 0, 0)
+	checkArgs(err)
+	if opts.Workers < 0 {
+		fmt.Fprintf(os.Stderr, "invalid --workers: %d\n", opts.Workers)
+		os.Exit(exitUsage)
+	}
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	if err := covers.FetchCoversWithOptions(database, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// reassignArgs are the arguments of "romu reassign"
+type reassignArgs struct {
+	filter db.ReassignFilter
+	query  string // a path or search, unless moving by filter
+	to     string
+}
+
+// parseReassignArgs parses "romu reassign" arguments: a path or query and a
+// platform, or --to with at least one filter
+func parseReassignArgs(args []string) (reassignArgs, error) {
+	var a reassignArgs
+	flags := newFlags("reassign", "romu reassign <path-or-query> <platform>", "romu reassign [--from XX] [--path-prefix DIR] --to XX")
+	platformFlag(flags, "from", &a.filter.Platform, "move ROMs currently of this platform")
+	flags.StringVar(&a.filter.PathPrefix, "path-prefix", "", "move ROMs under this directory")
+	platformFlag(flags, "to", &a.to, "platform to move the ROMs to")
+	pos, err := parseArgs(flags, args, 0, 2)
+	if err != nil {
+		return a, err
+	}
+	bulk := a.to != "" || a.filter != (db.ReassignFilter{})
+	if bulk && (a.to == "" || a.filter == (db.ReassignFilter{}) || len(pos) > 0) || !bulk && len(pos) != 2 {
+		flags.Usage()
+		return a, errUsage
+	}
+	if !bulk {
+		a.query = pos[0]
+		a.to, err = normalizePlatform(pos[1])
+	}
+	return a, err
+}
+
+func cmdReassign() {
+	a, err := parseReassignArgs(os.Args[2:])
+	checkArgs(err)
+	filter, platform := a.filter, a.to
+	if filter.PathPrefix != "" {
+		abs, err := filepath.Abs(filter.PathPrefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		filter.PathPrefix = abs
+	}
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	if filter != (db.ReassignFilter{}) {
+		n, err := database.BulkReassignPlatform(filter, platform)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		fmt.Printf("Reassigned %d ROMs to %s\n", n, platform)
+		return
+	}
+	arg := a.query
+
+	files, total, err := findRoms(database, arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No ROM matches %q\n", arg)
+		os.Exit(exitPartial)
+	}
+	if total > len(files) {
+		fmt.Fprintf(os.Stderr, "%d ROMs match %q; narrow the query or pass a path\n", total, arg)
+		os.Exit(exitUsage)
+	}
+
+	changed, unlinked := 0, 0
+	for _, f := range files {
+		if f.Platform == platform {
+			continue
+		}
+		if err := database.ReassignPlatform(f.ID, platform); err != nil {
+			fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		changed++
+		note := ""
+		if f.GameID != nil {
+			if g, err := database.GetGame(*f.GameID); err == nil && g.Platform != platform {
+				unlinked++
+				note = " (game link cleared)"
+			}
+		}
+		fmt.Printf("  %s: %s → %s%s\n", f.Path, f.Platform, platform, note)
+	}
+	fmt.Printf("Reassigned %d of %d ROMs (%d game links cleared)\n", changed, len(files), unlinked)
+}
+
+func cmdTag() {
+	var remove bool
+	flags := newFlags("tag", "romu tag <path> <tag> [--remove]")
+	flags.BoolVar(&remove, "remove", false, "remove the tag instead of adding it")
+	args, err := parseArgs(flags, os.Args[2:], 2, 2)
+	checkArgs(err)
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	tag := args[1]
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	roms, err := database.FindRomFilesByPath(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	if len(roms) == 0 {
+		fmt.Fprintf(os.Stderr, "No ROM registered at %s\n", path)
+		os.Exit(exitPartial)
+	}
+
+	for _, r := range roms {
+		if remove {
+			err = database.RemoveTag(r.ID, tag)
+		} else {
+			err = database.AddTag(r.ID, tag)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if remove {
+		fmt.Printf("Removed %d ROM(s) from %q\n", len(roms), tag)
+	} else {
+		fmt.Printf("Tagged %d ROM(s) as %q\n", len(roms), tag)
+	}
+}
+
+func cmdTags() {
+	flags := newFlags("tags", "romu tags [tag]")
+	args, err := parseArgs(flags, os.Args[2:], 0, 1)
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	if len(args) == 0 {
+		tags, err := database.ListTags()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		if len(tags) == 0 {
+			fmt.Println("No tags. Add one with 'romu tag <path> <tag>'.")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TAG\tROMS")
+		for _, t := range tags {
+			fmt.Fprintf(w, "%s\t%d\n", t.Name, t.Count)
+		}
+		w.Flush()
+		return
+	}
+
+	files, err := database.ListByTag(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORM\tFILENAME\tTITLE")
+	for _, f := range files {
+		title := "-"
+		if f.TitleJA != nil {
+			title = *f.TitleJA
+		} else if f.TitleEN != nil {
+			title = *f.TitleEN
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Platform, f.Filename, title)
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %d ROMs\n", len(files))
+}
+
+func cmdDoctor() {
+	flags := newFlags("doctor", "romu doctor")
+	_, err := parseArgs(flags, os.Args[2:], 0, 0)
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	report, err := database.Diagnose(scanner.KnownPlatforms())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	sections := []struct {
+		level string
+		name  string
+		issue db.DiagnoseIssue
+	}{
+		{"CRITICAL", "ROMs with empty hashes", report.EmptyHashes},
+		{"CRITICAL", "ROMs missing on disk", report.MissingFiles},
+		{"WARNING", "Games without ROMs", report.OrphanGames},
+		{"WARNING", "Duplicate SHA1 groups", report.DuplicateSHA1},
+		{"WARNING", "Unknown platforms", report.UnknownPlatforms},
+	}
+	problems := 0
+	for _, s := range sections {
+		if s.issue.Count == 0 {
+			continue
+		}
+		problems++
+		fmt.Printf("[%s] %s: %d\n", s.level, s.name, s.issue.Count)
+		for _, sample := range s.issue.Samples {
+			fmt.Printf("  - %s\n", sample)
+		}
+		if more := s.issue.Count - len(s.issue.Samples); more > 0 {
+			fmt.Printf("  ... and %d more\n", more)
+		}
+	}
+	if problems == 0 {
+		fmt.Println("No problems found.")
+	}
+	if report.Critical() {
+		os.Exit(exitPartial)
+	}
+}
+
+func cmdCheckHashes() {
+	platform, err := parsePlatformArgs("checkhashes", os.Args[2:])
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	files, err := database.ListRomFilesByPlatform(platform)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	ok, mismatched, missing, failed := 0, 0, 0, 0
+	for _, f := range files {
+		h, err := scanner.HashPath(f.Path, f.Platform)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing++
+			fmt.Printf("  missing  %s\n", f.Path)
+			continue
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("  error    %s: %v\n", f.Path, err)
+			continue
+		}
+
+		var diffs []string
+		for _, c := range []struct{ name, stored, now string }{
+			{"CRC32", f.HashCRC32, h.CRC32},
+			{"MD5", f.HashMD5, h.MD5},
+			{"SHA1", f.HashSHA1, h.SHA1},
+		} {
+			if c.stored != "" && !strings.EqualFold(c.stored, c.now) {
+				diffs = append(diffs, fmt.Sprintf("%s %s → %s", c.name, c.stored, c.now))
+			}
+		}
+		if len(diffs) > 0 {
+			mismatched++
+			fmt.Printf("  MISMATCH %s: %s\n", f.Path, strings.Join(diffs, ", "))
+			continue
+		}
+		ok++
+	}
+
+	fmt.Printf("\nChecked %d ROMs: %d ok, %d mismatched, %d missing, %d errors\n", len(files), ok, mismatched, missing, failed)
+	if mismatched+missing+failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// buildVersion returns version, or for a "go install pkg@version" build
+// without -ldflags, the module version recorded in the binary
+func buildVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return version
+}
+
+func cmdVersion() {
+	fmt.Printf("romu %s (%s %s/%s)\n", buildVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	counts := gamedb.Counts()
+	platforms := make([]string, 0, len(counts))
+	total := 0
+	for p, n := range counts {
+		platforms = append(platforms, p)
+		total += n
+	}
+	sort.Strings(platforms)
+	fmt.Printf("\nBuilt-in gamedb: %d titles\n", total)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, p := range platforms {
+		fmt.Fprintf(w, "  %s\t%d\n", p, counts[p])
+	}
+	w.Flush()
+}
+
+func cmdMaintenance() {
+	flags := newFlags("maintenance", "romu maintenance [--prune-games]")
+	pruneGames := flags.Bool("prune-games", false, "delete games without ROMs or cover art")
+	_, err := parseArgs(flags, os.Args[2:], 0, 0)
+	checkArgs(err)
+
+	database, err := db.OpenPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	fmt.Println("Checking integrity...")
+	if err := database.Integrity(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	if *pruneGames {
+		fmt.Println("Pruning games without ROMs or cover art...")
+		n, err := database.PruneOrphanGames()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+		fmt.Printf("  %d games deleted\n", n)
+	}
+
+	before, _ := database.FileSize()
+	fmt.Println("Compacting database...")
+	if err := database.Vacuum(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	after, _ := database.FileSize()
+
+	fmt.Printf("Done! %s: %d bytes → %d bytes\n", database.Path(), before, after)
+}
+
+func cmdBackup() {
+	flags := newFlags("backup", "romu backup <dest.db>")
+	args, err := parseArgs(flags, os.Args[2:], 1, 1)
+	checkArgs(err)
+
+	// Read-only, so the backup is of the database as it is, schema and all
+	database, err := db.OpenReadOnlyPath(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	defer database.Close()
+
+	if err := database.BackupTo(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	var size int64
+	if info, err := os.Stat(args[0]); err == nil {
+		size = info.Size()
+	}
+	fmt.Printf("Backed up %s → %s (%d bytes)\n", database.Path(), args[0], size)
+}
+
+// gameListExport says where export-gamelist puts one platform's media and
+// how its gamelist.xml refers to them
+type gameListExport struct {
+	dir      string // the gamelist's directory, absolute
+	mediaDir string // media are copied to mediaDir/<kind>/, absolute
+	absolute bool   // refer to media by absolute path
+}
+
+// media returns the gamelist path for a media file of the ROM at the
+// gamelist path rom, copying it to mediaDir/<kind>/ under the ROM's name
+// unless it's already under dir or mediaDir. It returns "" if the file is
+// missing so the tag is omitted, and an error if copying it failed.
+func (x gameListExport) media(kind, rom, src string) (string, error) {
+	if src == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(src); err != nil {
+		return "", nil
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	if within(x.dir, src) || within(x.mediaDir, src) {
+		return x.ref(src), nil
+	}
+
+	// Media of different ROMs often share a name, such as cover.png
+	name := strings.TrimSuffix(path.Base(rom), path.Ext(rom))
+	dst := filepath.Join(x.mediaDir, kind, name+filepath.Ext(src))
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	if old, err := os.ReadFile(dst); err != nil || !bytes.Equal(old, data) {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+		if err := fsutil.WriteFileAtomic(dst, data); err != nil {
+			return "", err
+		}
+	}
+	return x.ref(dst), nil
+}
+
+// ref returns how the gamelist refers to the file at the absolute path p:
+// as "./" relative to dir if it's under dir and paths aren't absolute
+func (x gameListExport) ref(p string) string {
+	if !x.absolute && within(x.dir, p) {
+		rel, _ := filepath.Rel(x.dir, p)
+		return "./" + filepath.ToSlash(rel)
 	}
-	escaped := xmlEscape(value)
-	fmt.Fprintf(f, "    <%s>%s</%s>\n", tag, escaped, tag)
+	return filepath.ToSlash(p)
 }
 
-func xmlEscape(s string) string {
-	s = strings.ReplaceAll(s, "&", "&amp;")
-	s = strings.ReplaceAll(s, "<", "&lt;")
-	s = strings.ReplaceAll(s, ">", "&gt;")
-	s = strings.ReplaceAll(s, "\"", "&quot;")
-	return s
+// within reports whether the path p is under dir
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && !strings.HasPrefix(rel, "..")
 }
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/logging"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	tests := []struct {
+		args   []string
+		rest   []string
+		level  logging.Level
+		format string
+	}{
+		{[]string{"romu", "-v", "scan", "/roms", "--rehash"}, []string{"romu", "scan", "/roms", "--rehash"}, logging.LevelVerbose, ""},
+		{[]string{"romu", "--quiet", "fetch-covers"}, []string{"romu", "fetch-covers"}, logging.LevelQuiet, ""},
+		{[]string{"romu", "--log-format", "json", "server"}, []string{"romu", "server"}, logging.LevelNormal, "json"},
+		{[]string{"romu", "--log-format=json", "server", "--port", "9000"}, []string{"romu", "server", "--port", "9000"}, logging.LevelNormal, "json"},
+		// Arguments of the command are left alone, such as a ROM named -v
+		{[]string{"romu", "-q", "info", "-v"}, []string{"romu", "info", "-v"}, logging.LevelQuiet, ""},
+		{[]string{"romu", "tag", "--", "-q", "favorites"}, []string{"romu", "tag", "--", "-q", "favorites"}, logging.LevelNormal, ""},
+		{[]string{"romu", "-v", "--", "scan"}, []string{"romu", "scan"}, logging.LevelVerbose, ""},
+		{[]string{"romu"}, []string{"romu"}, logging.LevelNormal, ""},
+	}
+	for _, tt := range tests {
+		rest, level, format, err := parseGlobalFlags(tt.args)
+		if err != nil || !slices.Equal(rest, tt.rest) || level != tt.level || format != tt.format {
+			t.Errorf("parseGlobalFlags(%q) = %q, %d, %q, %v", tt.args, rest, level, format, err)
+		}
+	}
+
+	for _, args := range [][]string{{"romu", "-q", "-v", "scan"}, {"romu", "--log-format"}} {
+		if _, _, _, err := parseGlobalFlags(args); err == nil {
+			t.Errorf("parseGlobalFlags(%q): expected an error", args)
+		}
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		args     []string
+		pos      []string
+		platform string
+		force    bool
+	}{
+		{[]string{"/roms", "--force"}, []string{"/roms"}, "", true},
+		{[]string{"--platform", "SFC", "a", "b"}, []string{"a", "b"}, "SFC", false},
+		{[]string{"a", "--platform=GB", "b", "--force"}, []string{"a", "b"}, "GB", true},
+		{[]string{"a", "--", "--force"}, []string{"a", "--force"}, "", false},
+		{nil, nil, "", false},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		platform := flags.String("platform", "", "")
+		force := flags.Bool("force", false, "")
+		pos, err := parseInterspersed(flags, tt.args)
+		if err != nil || !slices.Equal(pos, tt.pos) || *platform != tt.platform || *force != tt.force {
+			t.Errorf("parseInterspersed(%q) = %q, platform %q, force %v, %v", tt.args, pos, *platform, *force, err)
+		}
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.Int("limit", 0, "")
+	if _, err := parseInterspersed(flags, []string{"a", "--limit", "x"}); err == nil {
+		t.Error("expected an error for a bad --limit")
+	}
+}
+
+func TestParseCommandArgs(t *testing.T) {
+	flagOutput = io.Discard
+	defer func() { flagOutput = os.Stderr }()
+
+	// Flags at the end, and in = form, used to be dropped
+	f, err := parseSearchArgs([]string{"mario", "--platform", "SFC"})
+	if err != nil || f.Query != "mario" || f.Platform != "SFC" {
+		t.Errorf("search: %+v, %v", f, err)
+	}
+	f, err = parseSearchArgs([]string{"--sort=title_ja", "mario"})
+	if err != nil || f.Query != "mario" || f.Sort != "title_ja" {
+		t.Errorf("search --sort=: %+v, %v", f, err)
+	}
+
+	platform, limit, err := parseTopArgs([]string{"--limit", "5", "--platform", "GB"})
+	if err != nil || platform != "GB" || limit != 5 {
+		t.Errorf("top: %q, %d, %v", platform, limit, err)
+	}
+	if _, limit, err = parseTopArgs(nil); err != nil || limit != 20 {
+		t.Errorf("top default limit: %d, %v", limit, err)
+	}
+
+	if srv, err := parseServerArgs([]string{"--port", "9000", "--read-only", "--host", "0.0.0.0"}); err != nil || srv.port != 9000 || !srv.readOnly || srv.host != "0.0.0.0" {
+		t.Errorf("server: %+v, %v", srv, err)
+	}
+	if srv, err := parseServerArgs(nil); err != nil || srv.port != 8080 || srv.host != "127.0.0.1" || srv.readOnly || srv.statsCache != 5*time.Second {
+		t.Errorf("server defaults: %+v, %v", srv, err)
+	}
+	if srv, err := parseServerArgs([]string{"--basic-auth", "me:pa:ss", "--protect-ui"}); err != nil || srv.auth.BasicAuth != "me:pa:ss" || !srv.auth.ProtectUI {
+		t.Errorf("server auth: %+v, %v", srv, err)
+	}
+	if srv, err := parseServerArgs([]string{"--stats-cache-ttl=0"}); err != nil || srv.statsCache != 0 {
+		t.Errorf("server stats cache: %+v, %v", srv, err)
+	}
+
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	l, err := parseListArgs([]string{"--names", "--since", "24h", "--offset", "10", "--platform", "FC"}, now)
+	if err != nil || !l.names || l.offset != 10 || l.limit != -1 || l.filter.Platform != "FC" || !l.filter.Since.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("list: %+v, %v", l, err)
+	}
+
+	e, err := parseExportGameListArgs([]string{"/out", "--platform", "MD"})
+	if err != nil || e != (exportGameListArgs{outDir: "/out", platform: "MD"}) {
+		t.Errorf("export-gamelist: %+v, %v", e, err)
+	}
+	media := t.TempDir()
+	e, err = parseExportGameListArgs([]string{"/out", "--media-root", media, "--absolute-paths"})
+	if err != nil || e != (exportGameListArgs{outDir: "/out", mediaRoot: media, absolute: true}) {
+		t.Errorf("export-gamelist --media-root: %+v, %v", e, err)
+	}
+
+	outDir, platform, err := parseExportPlaylistArgs([]string{"--platform=GB", "/out"})
+	if err != nil || outDir != "/out" || platform != "GB" {
+		t.Errorf("export-playlist: %q, %q, %v", outDir, platform, err)
+	}
+
+	outDir, platform, err = parseExportPegasusArgs([]string{"/out", "--platform", "GB"})
+	if err != nil || outDir != "/out" || platform != "GB" {
+		t.Errorf("export-pegasus: %q, %q, %v", outDir, platform, err)
+	}
+
+	outDir, platform, err = parseExportLaunchBoxArgs([]string{"/out"})
+	if err != nil || outDir != "/out" || platform != "" {
+		t.Errorf("export-launchbox: %q, %q, %v", outDir, platform, err)
+	}
+
+	file, platform, err := parseImportPlaylistArgs([]string{"Favorites.lpl", "--platform", "GB"})
+	if err != nil || file != "Favorites.lpl" || platform != "GB" {
+		t.Errorf("import-playlist: %q, %q, %v", file, platform, err)
+	}
+
+	if i, err := parseImportDATArgs([]string{"nes.dat", "--platform", "FC"}); err != nil || i != (importDATArgs{datPath: "nes.dat", platform: "FC"}) {
+		t.Errorf("import-dat: %+v, %v", i, err)
+	}
+	if i, err := parseImportDATArgs([]string{"--dir", "/dats", "--include-nodump"}); err != nil || i != (importDATArgs{dir: "/dats", includeNoDump: true}) {
+		t.Errorf("import-dat --dir: %+v, %v", i, err)
+	}
+
+	if m, err := parseMatchArgs([]string{"--platform", "GBA"}); err != nil || m != (matchArgs{platform: "GBA"}) {
+		t.Errorf("match: %+v, %v", m, err)
+	}
+	if m, err := parseMatchArgs([]string{"gba.dat", "--platform=GBA", "--include-nodump"}); err != nil || m != (matchArgs{datPath: "gba.dat", platform: "GBA", includeNoDump: true}) {
+		t.Errorf("match with DAT: %+v, %v", m, err)
+	}
+
+	if m, err := parseMergeGamesArgs([]string{"--apply", "--platform", "FC"}); err != nil || m != (mergeGamesArgs{platform: "FC", apply: true}) {
+		t.Errorf("merge-games: %+v, %v", m, err)
+	}
+	if m, err := parseMergeGamesArgs([]string{"3", "7"}); err != nil || m != (mergeGamesArgs{keep: 3, merge: 7}) {
+		t.Errorf("merge-games with IDs: %+v, %v", m, err)
+	}
+
+	if r, err := parseReassignArgs([]string{"--from", "UNKNOWN", "--to", "MSX"}); err != nil || r != (reassignArgs{filter: db.ReassignFilter{Platform: "UNKNOWN"}, to: "MSX"}) {
+		t.Errorf("reassign --from: %+v, %v", r, err)
+	}
+	if r, err := parseReassignArgs([]string{"/roms/misc/game.rom", "MSX"}); err != nil || r != (reassignArgs{query: "/roms/misc/game.rom", to: "MSX"}) {
+		t.Errorf("reassign path: %+v, %v", r, err)
+	}
+
+	for _, name := range []string{"unmatched", "match-all", "checkhashes", "missing-ja"} {
+		if platform, err := parsePlatformArgs(name, []string{"--platform", "PCE"}); err != nil || platform != "PCE" {
+			t.Errorf("%s: %q, %v", name, platform, err)
+		}
+	}
+}
+
+func TestParseCommandArgsErrors(t *testing.T) {
+	flagOutput = io.Discard
+	defer func() { flagOutput = os.Stderr }()
+
+	for name, err := range map[string]error{
+		"search without query":   second(parseSearchArgs(nil)),
+		"search bad sort":        second(parseSearchArgs([]string{"x", "--sort", "size"})),
+		"search two queries":     second(parseSearchArgs([]string{"a", "b"})),
+		"top zero limit":         third(parseTopArgs([]string{"--limit", "0"})),
+		"top bad limit":          third(parseTopArgs([]string{"--limit", "x"})),
+		"server bad port":        second(parseServerArgs([]string{"--port", "x"})),
+		"server missing port":    second(parseServerArgs([]string{"--port"})),
+		"server bad basic auth":  second(parseServerArgs([]string{"--basic-auth", "me"})),
+		"server protect no auth": second(parseServerArgs([]string{"--protect-ui"})),
+		"server negative ttl":    second(parseServerArgs([]string{"--stats-cache-ttl", "-1s"})),
+		"list bad since":         second(parseListArgs([]string{"--since", "yesterday"}, time.Now())),
+		"list negative offset":   second(parseListArgs([]string{"--offset", "-1"}, time.Now())),
+		"export without dir":     second(parseExportGameListArgs([]string{"--platform", "FC"})),
+		"playlist without dir":   third(parseExportPlaylistArgs(nil)),
+		"pegasus two dirs":       third(parseExportPegasusArgs([]string{"/a", "/b"})),
+		"playlist import none":   third(parseImportPlaylistArgs(nil)),
+		"launchbox bad flag":     third(parseExportLaunchBoxArgs([]string{"/out", "--media-root", "/m"})),
+		"export no media root":   second(parseExportGameListArgs([]string{"/out", "--media-root", "/no/such/dir"})),
+		"import-dat file & dir":  second(parseImportDATArgs([]string{"a.dat", "--dir", "/dats"})),
+		"import-dat nothing":     second(parseImportDATArgs(nil)),
+		"match two DATs":         second(parseMatchArgs([]string{"a.dat", "b.dat"})),
+		"merge-games one ID":     second(parseMergeGamesArgs([]string{"3"})),
+		"merge-games bad ID":     second(parseMergeGamesArgs([]string{"3", "x"})),
+		"merge-games IDs & flag": second(parseMergeGamesArgs([]string{"3", "4", "--apply"})),
+		"unmatched unknown flag": second(parsePlatformArgs("unmatched", []string{"--force"})),
+		"list display name":      second(parseListArgs([]string{"--platform", "Game Boy"}, time.Now())),
+		"match unknown platform": second(parseMatchArgs([]string{"--platform", "XYZ"})),
+		"reassign no filter":     second(parseReassignArgs([]string{"--to", "MSX"})),
+		"reassign unknown from":  second(parseReassignArgs([]string{"--from", "XYZ", "--to", "MSX"})),
+		"reassign path & flag":   second(parseReassignArgs([]string{"a.rom", "MSX", "--to", "MSX"})),
+	} {
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := parseSearchArgs([]string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("search -h: %v, want flag.ErrHelp", err)
+	}
+	if _, err := parsePlatformArgs("match-all", []string{"--platform", "super famicom / snes"}); err == nil || !strings.Contains(err.Error(), "did you mean SFC?") {
+		t.Errorf("--platform super famicom / snes: %v, want a suggestion", err)
+	}
+	if _, err := parseReassignArgs([]string{"a.rom", "Game Boy"}); err == nil || !strings.Contains(err.Error(), "did you mean GB?") {
+		t.Errorf("reassign to Game Boy: %v, want a suggestion", err)
+	}
+	if p, err := parsePlatformArgs("unmatched", []string{"--platform", "UNKNOWN"}); err != nil || p != "UNKNOWN" {
+		t.Errorf("--platform UNKNOWN: %q, %v", p, err)
+	}
+}
+
+func second[A any](_ A, err error) error        { return err }
+func third[A, B any](_ A, _ B, err error) error { return err }
+
+func TestPlatformFolderAlias(t *testing.T) {
+	flagOutput = io.Discard
+	defer func() { flagOutput = os.Stderr }()
+
+	f, _ := parseSearchArgs([]string{"sonic", "--platform", "genesis"})
+	e, _ := parseExportGameListArgs([]string{"/out", "--platform", "genesis"})
+	importDAT, _ := parseImportDATArgs([]string{"a.dat", "--platform", "genesis"})
+	match, _ := parseMatchArgs([]string{"--platform", "genesis"})
+	matchAll, _ := parsePlatformArgs("match-all", []string{"--platform", "Genesis"})
+	reassign, _ := parseReassignArgs([]string{"--from", "genesis", "--to", "MD"})
+	reassignTo, _ := parseReassignArgs([]string{"--from", "SFC", "--to", "genesis"})
+	reassignPath, _ := parseReassignArgs([]string{"/roms/misc/sonic.bin", "genesis"})
+	for name, got := range map[string]string{
+		"search":          f.Platform,
+		"export-gamelist": e.platform,
+		"import-dat":      importDAT.platform,
+		"match":           match.platform,
+		"match-all":       matchAll,
+		"reassign --from": reassign.filter.Platform,
+		"reassign --to":   reassignTo.to,
+		"reassign path":   reassignPath.to,
+	} {
+		if got != "MD" {
+			t.Errorf("%s --platform genesis: %q, want MD", name, got)
+		}
+	}
+
+	// genesis filters the same rows as MD
+	database, err := db.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	database.UpsertRomFilesBatch([]db.RomFileInput{
+		{Path: "/roms/md/sonic.md", Filename: "sonic.md", Size: 1, Platform: "MD"},
+		{Path: "/roms/sfc/sonic.sfc", Filename: "sonic.sfc", Size: 1, Platform: "SFC"},
+	})
+	code, _ := parseSearchArgs([]string{"sonic", "--platform", "MD"})
+	a, _, _ := database.SearchRoms(f, 1, 10)
+	c, _, _ := database.SearchRoms(code, 1, 10)
+	if len(a) != 1 || len(c) != 1 || a[0].ID != c[0].ID {
+		t.Errorf("--platform genesis found %+v, --platform MD %+v", a, c)
+	}
+}
+
+func TestGameListExportMedia(t *testing.T) {
+	tmp := t.TempDir()
+	src := filepath.Join(tmp, "covers", "game.png")
+	os.MkdirAll(filepath.Dir(src), 0755)
+	os.WriteFile(src, []byte("png"), 0644)
+	dir := filepath.Join(tmp, "out", "FC")
+	root := filepath.Join(tmp, "media", "FC")
+
+	tests := []struct {
+		x    gameListExport
+		want string
+		copy string
+	}{
+		{gameListExport{dir: dir, mediaDir: filepath.Join(dir, "media")}, "./media/images/Game (Japan).png", filepath.Join(dir, "media", "images", "Game (Japan).png")},
+		{gameListExport{dir: dir, mediaDir: filepath.Join(dir, "media"), absolute: true}, filepath.ToSlash(filepath.Join(dir, "media", "images", "Game (Japan).png")), ""},
+		{gameListExport{dir: dir, mediaDir: root}, filepath.ToSlash(filepath.Join(root, "images", "Game (Japan).png")), filepath.Join(root, "images", "Game (Japan).png")},
+	}
+	for _, tt := range tests {
+		if got, err := tt.x.media("images", "./Game (Japan).nes", src); err != nil || got != tt.want {
+			t.Errorf("%+v: got %q, %v, want %q", tt.x, got, err, tt.want)
+		}
+		if tt.copy != "" {
+			if _, err := os.Stat(tt.copy); err != nil {
+				t.Errorf("%+v: not copied: %v", tt.x, err)
+			}
+		}
+	}
+
+	// Media already under the media root are used where they are
+	x := gameListExport{dir: dir, mediaDir: root, absolute: true}
+	if got, _ := x.media("thumbnails", "./Game (Japan).nes", filepath.Join(root, "images", "Game (Japan).png")); got != filepath.ToSlash(filepath.Join(root, "images", "Game (Japan).png")) {
+		t.Errorf("got %q", got)
+	}
+	if got, err := x.media("images", "./Game (Japan).nes", filepath.Join(tmp, "missing.png")); got != "" || err != nil {
+		t.Errorf("missing media: got %q, %v", got, err)
+	}
+
+	// Covers of different ROMs sharing a name and size each get their own copy
+	for _, g := range []string{"a", "b"} {
+		p := filepath.Join(tmp, "covers", g, "cover.png")
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte("png "+g), 0644)
+		if _, err := x.media("images", "./"+g+".nes", p); err != nil {
+			t.Fatalf("copy %s: %v", p, err)
+		}
+	}
+	for _, g := range []string{"a", "b"} {
+		if data, err := os.ReadFile(filepath.Join(root, "images", g+".png")); err != nil || string(data) != "png "+g {
+			t.Errorf("cover of %s.nes: %q, %v", g, data, err)
+		}
+	}
+
+	// A copy that fails is reported
+	blocked := gameListExport{dir: dir, mediaDir: filepath.Join(tmp, "covers", "game.png")}
+	if got, err := blocked.media("images", "./Game (Japan).nes", filepath.Join(tmp, "covers", "a", "cover.png")); err == nil {
+		t.Errorf("expected an error copying under a file, got %q", got)
+	}
+}
+
+func TestPegasusGames(t *testing.T) {
+	games := pegasusGames([]db.ExportGameListEntry{
+		{Path: "./Pack.zip", Name: "Pack"},
+		{Path: "./Pack.zip", Name: "Other ROM in the pack"},
+		{Path: "./Game (Japan).nes", Name: "Game", Developer: "Dev"},
+		{Path: "./Game (USA).nes", Name: "Game"},
+	})
+	if len(games) != 2 {
+		t.Fatalf("got %+v", games)
+	}
+	if games[0].Title != "Pack" || !slices.Equal(games[0].Files, []string{"Pack.zip"}) {
+		t.Errorf("archive: %+v", games[0])
+	}
+	if games[1].Developer != "Dev" || !slices.Equal(games[1].Files, []string{"Game (Japan).nes", "Game (USA).nes"}) {
+		t.Errorf("game with two ROMs: %+v", games[1])
+	}
+}
+
+func TestLaunchBoxGames(t *testing.T) {
+	games := launchBoxGames("Nintendo Entertainment System", []db.ExportGameListEntry{
+		{File: "/roms/fc/Pack.zip", Name: "Pack", ReleaseDate: "19850913T000000"},
+		{File: "/roms/fc/Pack.zip", Name: "Other ROM in the pack"},
+		{File: "/roms/fc/game.nes", Name: "Game", Desc: "About"},
+	})
+	if len(games) != 2 {
+		t.Fatalf("got %+v", games)
+	}
+	if games[0].Title != "Pack" || games[0].ReleaseDate != "19850913T000000" || games[0].Platform != "Nintendo Entertainment System" {
+		t.Errorf("archive: %+v", games[0])
+	}
+	if games[1].ApplicationPath != "/roms/fc/game.nes" || games[1].Notes != "About" {
+		t.Errorf("game: %+v", games[1])
+	}
+}
@@ -2,4 +2,8 @@ module github.com/retronian/romu
 
 go 1.25.7
 
-require github.com/mattn/go-sqlite3 v1.14.33 // indirect
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.33
+	golang.org/x/text v0.40.0
+)
@@ -0,0 +1,140 @@
+// Package config reads the optional ~/.romu/config.toml and the ROMU_*
+// environment variables that override it.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the contents of config.toml. A missing file is an empty Config,
+// and empty settings mean romu's defaults.
+type Config struct {
+	// DBPath is the SQLite database, default ~/.romu/romu.db ($ROMU_DB)
+	DBPath string `toml:"db_path"`
+	// GameDBDir holds gamedb overrides, default ~/.romu/gamedb ($ROMU_GAMEDB)
+	GameDBDir string `toml:"gamedb_dir"`
+	Covers    Covers `toml:"covers"`
+
+	// Platforms adds to the built-in platform tables, keyed by platform code:
+	//
+	//	[platforms.A26]
+	//	name = "Atari 2600"
+	//	folders = ["atari2600", "a26"]
+	//	extensions = [".a26"]
+	Platforms map[string]PlatformConfig `toml:"platforms"`
+}
+
+// Covers configures fetch-covers and the server's cover endpoint
+type Covers struct {
+	// Dir is where covers are saved, default ~/.romu/covers ($ROMU_COVERS_DIR)
+	Dir string `toml:"dir"`
+	// BaseURL serves libretro-thumbnails repositories, default GitHub
+	// ($ROMU_THUMBNAILS_URL)
+	BaseURL string `toml:"base_url"`
+	// Workers is the number of parallel downloads, default 4
+	// ($ROMU_COVER_WORKERS)
+	Workers int `toml:"workers"`
+}
+
+// PlatformConfig extends or, with Replace, overrides one platform's folder
+// names and ROM extensions
+type PlatformConfig struct {
+	Name       string   `toml:"name"`
+	Folders    []string `toml:"folders"`
+	Extensions []string `toml:"extensions"`
+	// Replace makes Folders and Extensions, where given, replace the
+	// built-in ones instead of adding to them
+	Replace bool `toml:"replace"`
+}
+
+// Path returns the location of config.toml
+func Path() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".romu", "config.toml")
+}
+
+// Load reads config.toml from Path and applies environment overrides
+func Load() (*Config, error) {
+	c, err := LoadFile(Path())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	for _, p := range []*string{&c.DBPath, &c.GameDBDir, &c.Covers.Dir} {
+		*p = expandHome(*p)
+	}
+	return c, nil
+}
+
+// expandHome replaces a leading ~/ with the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, rest)
+	}
+	return path
+}
+
+func (c *Config) applyEnv() error {
+	for env, field := range map[string]*string{
+		"ROMU_DB":             &c.DBPath,
+		"ROMU_GAMEDB":         &c.GameDBDir,
+		"ROMU_COVERS_DIR":     &c.Covers.Dir,
+		"ROMU_THUMBNAILS_URL": &c.Covers.BaseURL,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	if v := os.Getenv("ROMU_COVER_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid ROMU_COVER_WORKERS: %s", v)
+		}
+		c.Covers.Workers = n
+	}
+	return nil
+}
+
+// LoadFile reads a config file. Platform codes are upper-cased, folder
+// names lower-cased and extensions lower-cased with a leading dot.
+func LoadFile(path string) (*Config, error) {
+	c := &Config{}
+	if _, err := toml.DecodeFile(path, c); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if c.Covers.Workers < 0 {
+		return nil, fmt.Errorf("config %s: covers.workers must be positive", path)
+	}
+
+	platforms := make(map[string]PlatformConfig, len(c.Platforms))
+	for code, p := range c.Platforms {
+		code = strings.ToUpper(code)
+		for i, f := range p.Folders {
+			p.Folders[i] = strings.ToLower(f)
+		}
+		for i, ext := range p.Extensions {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			p.Extensions[i] = ext
+		}
+		platforms[code] = p
+	}
+	c.Platforms = platforms
+	return c, nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(path, []byte(`
+[platforms.a26]
+name = "Atari 2600"
+folders = ["Atari2600"]
+extensions = [".A26", "bin"]
+
+[platforms.PS1]
+extensions = [".chd"]
+replace = true
+`), 0644)
+
+	c, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	a26, ok := c.Platforms["A26"]
+	if !ok {
+		t.Fatalf("expected platform codes upper-cased, got %+v", c.Platforms)
+	}
+	if a26.Name != "Atari 2600" || !slices.Equal(a26.Folders, []string{"atari2600"}) ||
+		!slices.Equal(a26.Extensions, []string{".a26", ".bin"}) {
+		t.Errorf("unexpected A26 config: %+v", a26)
+	}
+	if ps1 := c.Platforms["PS1"]; !ps1.Replace || !slices.Equal(ps1.Extensions, []string{".chd"}) {
+		t.Errorf("unexpected PS1 config: %+v", ps1)
+	}
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	c, err := LoadFile(filepath.Join(t.TempDir(), "config.toml"))
+	if err != nil || len(c.Platforms) != 0 {
+		t.Errorf("expected an empty config, got %+v (%v)", c, err)
+	}
+}
+
+func TestLoadFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	os.WriteFile(path, []byte("[platforms.A26\n"), 0644)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected an error for invalid TOML")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+	os.MkdirAll(filepath.Join(tmp, ".romu"), 0755)
+	os.WriteFile(Path(), []byte(`
+db_path = "~/data/romu.db"
+gamedb_dir = "/srv/gamedb"
+
+[covers]
+dir = "/srv/covers"
+workers = 2
+`), 0644)
+	t.Setenv("ROMU_GAMEDB", "/env/gamedb")
+	t.Setenv("ROMU_COVER_WORKERS", "8")
+
+	c, err := Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if c.DBPath != filepath.Join(tmp, "data", "romu.db") {
+		t.Errorf("expected ~ expanded in db_path, got %q", c.DBPath)
+	}
+	if c.GameDBDir != "/env/gamedb" || c.Covers.Workers != 8 {
+		t.Errorf("expected env to override the file, got %+v", c)
+	}
+	if c.Covers.Dir != "/srv/covers" || c.Covers.BaseURL != "" {
+		t.Errorf("unexpected covers config: %+v", c.Covers)
+	}
+
+	t.Setenv("ROMU_COVER_WORKERS", "many")
+	if _, err := Load(); err == nil {
+		t.Error("expected an error for invalid ROMU_COVER_WORKERS")
+	}
+}
@@ -1,40 +1,109 @@
 package covers
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/fsutil"
+	"github.com/retronian/romu/internal/logging"
+	"github.com/retronian/romu/internal/platform"
 )
 
-var LibretroSystems = map[string]string{
-	"FC":     "Nintendo_-_Nintendo_Entertainment_System",
-	"SFC":    "Nintendo_-_Super_Nintendo_Entertainment_System",
-	"GB":     "Nintendo_-_Game_Boy",
-	"GBC":    "Nintendo_-_Game_Boy_Color",
-	"GBA":    "Nintendo_-_Game_Boy_Advance",
-	"MD":     "Sega_-_Mega_Drive_-_Genesis",
-	"N64":    "Nintendo_-_Nintendo_64",
-	"NDS":    "Nintendo_-_Nintendo_DS",
-	"PCE":    "NEC_-_PC_Engine_-_TurboGrafx_16",
-	"GG":     "Sega_-_Game_Gear",
-	"SMS":    "Sega_-_Master_System_-_Mark_III",
-	"WS":     "Bandai_-_WonderSwan",
-	"WSC":    "Bandai_-_WonderSwan_Color",
-	"NGP":    "SNK_-_Neo_Geo_Pocket",
-	"NEOGEO": "SNK_-_Neo_Geo_Pocket",
+// BoxartType is the cover_arts image type of libretro Named_Boxarts images
+const BoxartType = "boxart"
+
+// LibretroSystems maps platform codes to libretro-thumbnails repository names
+var LibretroSystems = platform.LibretroSystems()
+
+// defaultThumbnailsURL is where libretro-thumbnails repositories are served from
+// unless Options.BaseURL says otherwise
+const defaultThumbnailsURL = "https://raw.githubusercontent.com/libretro-thumbnails"
+
+// ErrNoCover is returned by FetchCoverForGame when libretro-thumbnails has
+// no cover for the game
+var ErrNoCover = errors.New("no cover found")
+
+// Store is the part of *db.DB that fetching covers reads and writes
+type Store interface {
+	GetPlatforms() ([]string, error)
+	GetEnrichableRoms(platform string) ([]db.EnrichableRom, int, error)
+	GetGamesWithoutCovers(platform, imageType string) ([]db.EnrichableRom, error)
+	GetGame(id int64) (*db.Game, error)
+	SetCoverArt(gameID int64, imageType, filePath string) error
+	ReadOnly() bool
 }
 
-func FetchCovers(database *db.DB, platform, outputDir string, force bool) error {
-	home, _ := os.UserHomeDir()
+// Options configures FetchCoversWithOptions
+type Options struct {
+	Platform  string // only this platform; empty means all
+	OutputDir string // default ~/.romu/covers
+	Force     bool   // re-fetch games that already have a cover
+	// SourceDir is a local libretro-thumbnails clone to copy covers from.
+	// When set, covers missing there are only downloaded if AllowNetwork.
+	SourceDir    string
+	AllowNetwork bool
+	// RetryMissing re-requests covers an earlier run found don't exist
+	RetryMissing bool
+	Workers      int // parallel downloads, default 4
+	// BaseURL serves libretro-thumbnails repositories as
+	// <BaseURL>/<repo>/master/Named_Boxarts/..., e.g. a mirror or caching
+	// proxy. Default raw.githubusercontent.com.
+	BaseURL string
+}
+
+// FetchCovers downloads boxart from libretro-thumbnails for matched games
+func FetchCovers(database Store, platform, outputDir string, force bool) error {
+	return FetchCoversWithOptions(database, Options{Platform: platform, OutputDir: outputDir, Force: force})
+}
+
+// FetchStatus is the outcome of fetching one cover
+type FetchStatus string
+
+const (
+	FetchOK       FetchStatus = "ok"
+	FetchCached   FetchStatus = "cached"    // already on disk; nothing was downloaded
+	FetchNotFound FetchStatus = "not_found" // libretro-thumbnails has no cover
+	FetchError    FetchStatus = "error"     // network, HTTP or write error; retried next run
+)
+
+// fetchResult is the outcome of looking up one game's cover
+type fetchResult struct {
+	rom     db.EnrichableRom
+	outPath string
+	copied  bool        // found in SourceDir rather than downloaded
+	variant string      // title variant the cover was found under
+	status  FetchStatus // "" if only SourceDir was checked
+	err     error
+}
+
+// FetchCoversWithOptions fetches boxart for matched games, from a local
+// thumbnails clone and/or the network. Each platform's outcomes are kept in
+// <OutputDir>/<platform>/.manifest.json; games libretro-thumbnails turned out
+// not to have are skipped on later runs unless Force or RetryMissing.
+func FetchCoversWithOptions(database Store, opts Options) error {
+	outputDir := opts.OutputDir
 	if outputDir == "" {
-		outputDir = filepath.Join(home, ".romu", "covers")
+		outputDir = defaultOutputDir()
+	}
+	baseURL, err := resolveBaseURL(opts.BaseURL)
+	if err != nil {
+		return err
+	}
+	platform := opts.Platform
+	force := opts.Force
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 4
 	}
 
 	// Get platforms to process
@@ -42,7 +111,6 @@ func FetchCovers(database *db.DB, platform, outputDir string, force bool) error
 	if platform != "" {
 		platforms = []string{platform}
 	} else {
-		var err error
 		platforms, err = database.GetPlatforms()
 		if err != nil {
 			return err
@@ -58,72 +126,329 @@ func FetchCovers(database *db.DB, platform, outputDir string, force bool) error
 			continue
 		}
 
-		roms, _, err := database.GetEnrichableRoms(plat)
+		// Without --force only games lacking a recorded boxart are fetched
+		var roms []db.EnrichableRom
+		var err error
+		if force {
+			roms, _, err = database.GetEnrichableRoms(plat)
+		} else {
+			roms, err = database.GetGamesWithoutCovers(plat, BoxartType)
+		}
 		if err != nil {
 			return fmt.Errorf("[%s] db error: %w", plat, err)
 		}
 		if len(roms) == 0 {
-			fmt.Printf("[%s] No matched games\n", plat)
+			fmt.Printf("[%s] No games missing covers\n", plat)
 			continue
 		}
 
 		dir := filepath.Join(outputDir, plat)
 		os.MkdirAll(dir, 0755)
+		m := loadManifest(dir)
 
-		fetched, notFound, skipped := 0, 0, 0
+		fetched, notFound, skipped, copied, known, variants := 0, 0, 0, 0, 0, 0
 		total := len(roms)
+		done := 0
+		progress := func() {
+			done++
+			if !logging.Quiet() && (done%10 == 0 || done == total) {
+				fmt.Printf("\r[%s] %d/%d fetched (%d not found)    ", plat, fetched, total, notFound)
+			}
+		}
 
-		for i, rom := range roms {
+		var pending []fetchResult
+		for _, rom := range roms {
 			// Sanitize filename: libretro uses the game name directly
-			safeName := sanitizeForFilename(rom.TitleEN)
-			outPath := filepath.Join(dir, safeName+".png")
-
+			outPath := filepath.Join(dir, sanitizeForFilename(rom.TitleEN)+".png")
 			if !force {
+				// Downloaded before covers were recorded in the DB
 				if _, err := os.Stat(outPath); err == nil {
+					if err := database.SetCoverArt(rom.GameID, BoxartType, outPath); err != nil {
+						return fmt.Errorf("[%s] db error: %w", plat, err)
+					}
+					m.set(rom.TitleEN, FetchOK, "", nil)
 					skipped++
 					fetched++
+					progress()
 					continue
 				}
-			}
-
-			// Build URL
-			encodedName := url.PathEscape(strings.ReplaceAll(rom.TitleEN, "&", "_"))
-			imgURL := fmt.Sprintf("https://raw.githubusercontent.com/libretro-thumbnails/%s/master/Named_Boxarts/%s.png", sys, encodedName)
-
-			resp, err := client.Get(imgURL)
-			if err != nil {
-				notFound++
-				if (i+1)%100 == 0 || i+1 == total {
-					fmt.Printf("\r[%s] %d/%d fetched (%d not found)", plat, fetched, total, notFound)
+				if !opts.RetryMissing && m.knownMissing(rom.TitleEN) {
+					slog.Debug("cover known missing, skipped", "platform", plat, "title", rom.TitleEN)
+					known++
+					notFound++
+					progress()
+					continue
 				}
-				time.Sleep(100 * time.Millisecond)
-				continue
 			}
+			pending = append(pending, fetchResult{rom: rom, outPath: outPath})
+		}
 
-			if resp.StatusCode == 404 {
-				resp.Body.Close()
-				notFound++
-			} else if resp.StatusCode == 200 {
-				data, _ := io.ReadAll(resp.Body)
-				resp.Body.Close()
-				os.WriteFile(outPath, data, 0644)
-				fetched++
-			} else {
-				resp.Body.Close()
+		fetchErr := fetchAll(pending, workers, func(r *fetchResult) {
+			fetchCover(client, baseURL, sys, opts, r)
+		}, func(r fetchResult) error {
+			defer progress()
+			if !r.copied && r.status != FetchOK {
+				if r.status == FetchError {
+					slog.Warn("cover fetch failed", "platform", plat, "title", r.rom.TitleEN, "err", r.err)
+				}
+				if r.status != "" {
+					m.set(r.rom.TitleEN, r.status, "", r.err)
+				}
 				notFound++
+				return nil
 			}
-
-			if (i+1)%10 == 0 || i+1 == total {
-				fmt.Printf("\r[%s] %d/%d fetched (%d not found)    ", plat, fetched, total, notFound)
+			if err := database.SetCoverArt(r.rom.GameID, BoxartType, r.outPath); err != nil {
+				return fmt.Errorf("[%s] db error: %w", plat, err)
+			}
+			m.set(r.rom.TitleEN, FetchOK, r.variant, nil)
+			if r.copied {
+				copied++
+			}
+			if r.variant != r.rom.TitleEN {
+				variants++
+			}
+			fetched++
+			// Checkpoint so an interrupted run loses little
+			if fetched%25 == 0 {
+				m.save()
 			}
+			return nil
+		})
+		if err := m.save(); err != nil && fetchErr == nil {
+			fetchErr = fmt.Errorf("[%s] manifest: %w", plat, err)
+		}
+		if fetchErr != nil {
+			fmt.Println()
+			return fetchErr
+		}
 
-			time.Sleep(100 * time.Millisecond)
+		summary := fmt.Sprintf("%d not found, %d cached", notFound, skipped)
+		if known > 0 {
+			summary += fmt.Sprintf(", %d known missing", known)
+		}
+		if opts.SourceDir != "" {
+			summary += fmt.Sprintf(", %d copied", copied)
 		}
-		fmt.Printf("\r[%s] %d/%d fetched (%d not found, %d cached)\n", plat, fetched, total, notFound, skipped)
+		if variants > 0 {
+			summary += fmt.Sprintf(", %d under another title", variants)
+		}
+		fmt.Printf("\r[%s] %d/%d fetched (%s)\n", plat, fetched, total, summary)
 	}
 	return nil
 }
 
+// EnsureGameCover returns the path of a game's cover of the given image
+// type, downloading it from libretro-thumbnails first if it isn't on disk.
+// Only opts.OutputDir and opts.BaseURL are used. It returns db.ErrNotFound
+// for unknown games and ErrNoCover if there is no cover to download, which
+// is always the case for a read-only database.
+func EnsureGameCover(database Store, gameID int64, imageType string, opts Options) (string, error) {
+	if imageType != BoxartType {
+		return "", fmt.Errorf("unsupported image type %q", imageType)
+	}
+	g, err := database.GetGame(gameID)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range g.Covers {
+		if c.ImageType != imageType {
+			continue
+		}
+		if _, err := os.Stat(c.FilePath); err == nil {
+			return c.FilePath, nil
+		}
+	}
+
+	sys, ok := LibretroSystems[g.Platform]
+	if !ok || g.TitleEN == "" || database.ReadOnly() {
+		return "", ErrNoCover
+	}
+	baseURL, err := resolveBaseURL(opts.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = defaultOutputDir()
+	}
+	outPath := filepath.Join(outputDir, g.Platform, sanitizeForFilename(g.TitleEN)+".png")
+	status, _, err := FetchCoverForGame(&http.Client{Timeout: 30 * time.Second}, baseURL, sys, g.TitleEN, outPath, false)
+	if status == FetchNotFound {
+		return "", ErrNoCover
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := database.SetCoverArt(g.ID, imageType, outPath); err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
+
+// FetchCoverForGame downloads the boxart for title from the sys
+// libretro-thumbnails repository under baseURL to outPath, trying the
+// titleVariants of title in turn and returning the one found. Unless force,
+// an existing file is kept and FetchCached returned.
+func FetchCoverForGame(client *http.Client, baseURL, sys, title, outPath string, force bool) (FetchStatus, string, error) {
+	if !force {
+		if _, err := os.Stat(outPath); err == nil {
+			return FetchCached, "", nil
+		}
+	}
+
+	for _, variant := range titleVariants(title) {
+		data, status, err := download(client, baseURL, sys, variant)
+		if status == FetchNotFound {
+			continue
+		}
+		if err != nil {
+			return status, "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+			return FetchError, "", err
+		}
+		if err := fsutil.WriteFileAtomic(outPath, data); err != nil {
+			return FetchError, "", err
+		}
+		return FetchOK, variant, nil
+	}
+	return FetchNotFound, "", nil
+}
+
+// download requests one Named_Boxarts image
+func download(client *http.Client, baseURL, sys, name string) ([]byte, FetchStatus, error) {
+	imgURL, err := coverURL(baseURL, sys, name)
+	if err != nil {
+		return nil, FetchError, err
+	}
+	resp, err := client.Get(imgURL)
+	if err != nil {
+		slog.Debug("cover request failed", "url", imgURL, "err", err)
+		return nil, FetchError, err
+	}
+	defer resp.Body.Close()
+	slog.Debug("cover request", "url", imgURL, "status", resp.StatusCode)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, FetchNotFound, nil
+	default:
+		return nil, FetchError, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, FetchError, err
+	}
+	return data, FetchOK, nil
+}
+
+// coverURL returns the Named_Boxarts URL of title, named as
+// libretro-thumbnails names it. The name is escaped, as JoinPath would
+// otherwise take a % for an escape.
+func coverURL(baseURL, sys, title string) (string, error) {
+	return url.JoinPath(baseURL, sys, "master", "Named_Boxarts", url.PathEscape(thumbnailName(title)+".png"))
+}
+
+// resolveBaseURL returns baseURL, or the default if it is empty, checking
+// it is an absolute http(s) URL
+func resolveBaseURL(baseURL string) (string, error) {
+	if baseURL == "" {
+		return defaultThumbnailsURL, nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid thumbnails base URL %q: must be an http(s) URL", baseURL)
+	}
+	return baseURL, nil
+}
+
+func defaultOutputDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".romu", "covers")
+}
+
+// fetchAll runs fetch over jobs with the given number of workers and passes
+// each result to handle on the calling goroutine, stopping at the first
+// error handle returns.
+func fetchAll(jobs []fetchResult, workers int, fetch func(*fetchResult), handle func(fetchResult) error) error {
+	in := make(chan fetchResult)
+	out := make(chan fetchResult)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(in)
+		for _, j := range jobs {
+			select {
+			case in <- j:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range in {
+				fetch(&j)
+				select {
+				case out <- j:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	var err error
+	for r := range out {
+		if err != nil {
+			continue
+		}
+		if err = handle(r); err != nil {
+			close(stop)
+		}
+	}
+	return err
+}
+
+// fetchCover copies r's cover from opts.SourceDir or, if it's not there
+// and allowed, downloads it from libretro-thumbnails
+func fetchCover(client *http.Client, baseURL, sys string, opts Options, r *fetchResult) {
+	if opts.SourceDir != "" {
+		for _, variant := range titleVariants(r.rom.TitleEN) {
+			src := filepath.Join(opts.SourceDir, sys, "Named_Boxarts", thumbnailName(variant)+".png")
+			data, err := os.ReadFile(src)
+			if err != nil {
+				continue
+			}
+			if r.err = fsutil.WriteFileAtomic(r.outPath, data); r.err != nil {
+				r.status = FetchError
+				return
+			}
+			r.copied, r.variant = true, variant
+			return
+		}
+		if !opts.AllowNetwork {
+			return
+		}
+	}
+
+	r.status, r.variant, r.err = FetchCoverForGame(client, baseURL, sys, r.rom.TitleEN, r.outPath, true)
+	time.Sleep(100 * time.Millisecond)
+}
+
+// thumbnailName returns the file name libretro-thumbnails uses for a title:
+// &*/:`<>?\| are replaced with underscores
+func thumbnailName(title string) string {
+	return strings.NewReplacer("&", "_", "`", "_").Replace(sanitizeForFilename(title))
+}
+
 func sanitizeForFilename(name string) string {
 	// Replace characters not allowed in filenames
 	replacer := strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
@@ -0,0 +1,342 @@
+package covers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/retronian/romu/internal/db"
+)
+
+// libretro-thumbnails repos are named "Vendor_-_System", with spaces as
+// underscores and optional further " - " parts
+var repoName = regexp.MustCompile(`^[A-Z][A-Za-z]*_-_[A-Za-z0-9][A-Za-z0-9_-]*[A-Za-z0-9]$`)
+
+func TestLibretroSystems(t *testing.T) {
+	for _, plat := range []string{"FC", "SFC", "GB", "GBC", "GBA", "MD", "PS1", "PS2", "N64", "NDS", "PCE",
+		"GG", "SMS", "WS", "WSC", "NGP", "SS", "DC", "MSX", "PCFX", "LYNX"} {
+		if _, ok := LibretroSystems[plat]; !ok {
+			t.Errorf("no libretro system for %s", plat)
+		}
+	}
+	for plat, sys := range LibretroSystems {
+		if !repoName.MatchString(sys) {
+			t.Errorf("%s: %q is not a libretro-thumbnails repo name", plat, sys)
+		}
+	}
+}
+
+func TestLibretroNeoGeo(t *testing.T) {
+	// NEOGEO is the arcade/AES system, not the handheld
+	if LibretroSystems["NEOGEO"] == LibretroSystems["NGP"] {
+		t.Errorf("NEOGEO and NGP both map to %q", LibretroSystems["NGP"])
+	}
+	if got := LibretroSystems["NEOGEO"]; got != "SNK_-_Neo_Geo" {
+		t.Errorf("NEOGEO maps to %q", got)
+	}
+	if got := LibretroSystems["NGP"]; got != "SNK_-_Neo_Geo_Pocket" {
+		t.Errorf("NGP maps to %q", got)
+	}
+}
+
+func TestFetchCoversFromSourceDir(t *testing.T) {
+	tmp := t.TempDir()
+	database, err := db.OpenMemory()
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	defer database.Close()
+
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/fc/a.nes", Filename: "a.nes", CRC32: "00000001", Platform: "FC"})
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/fc/b.nes", Filename: "b.nes", CRC32: "00000002", Platform: "FC"})
+	database.MatchROMs([]db.DATRom{
+		{GameTitle: "Mario & Luigi", Platform: "FC", CRC32: "00000001"},
+		{GameTitle: "Missing Game", Platform: "FC", CRC32: "00000002"},
+	})
+
+	src := filepath.Join(tmp, "thumbs")
+	boxarts := filepath.Join(src, LibretroSystems["FC"], "Named_Boxarts")
+	os.MkdirAll(boxarts, 0755)
+	os.WriteFile(filepath.Join(boxarts, "Mario _ Luigi.png"), []byte("png"), 0644)
+
+	out := filepath.Join(tmp, "covers")
+	if err := FetchCoversWithOptions(database, Options{Platform: "FC", OutputDir: out, SourceDir: src}); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(out, "FC", "Mario & Luigi.png")); err != nil || string(data) != "png" {
+		t.Errorf("expected cover copied from source dir, got %q (%v)", data, err)
+	}
+	missing, _ := database.GetGamesWithoutCovers("FC", BoxartType)
+	if len(missing) != 1 || missing[0].TitleEN != "Missing Game" {
+		t.Errorf("expected only Missing Game without a cover, got %+v", missing)
+	}
+}
+
+func TestFetchCoversManifest(t *testing.T) {
+	tmp := t.TempDir()
+	database, err := db.OpenMemory()
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	defer database.Close()
+
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/fc/a.nes", Filename: "a.nes", CRC32: "00000001", Platform: "FC"})
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/fc/b.nes", Filename: "b.nes", CRC32: "00000002", Platform: "FC"})
+	database.MatchROMs([]db.DATRom{
+		{GameTitle: "Found Game", Platform: "FC", CRC32: "00000001"},
+		{GameTitle: "Missing Game", Platform: "FC", CRC32: "00000002"},
+	})
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/Found Game.png") {
+			w.Write([]byte("png"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(tmp, "covers")
+	for i := 0; i < 2; i++ {
+		if err := FetchCoversWithOptions(database, Options{Platform: "FC", OutputDir: out, BaseURL: srv.URL}); err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "FC", "Found Game.png")); err != nil || string(data) != "png" {
+		t.Errorf("expected downloaded cover, got %q (%v)", data, err)
+	}
+	missingPath := "/" + LibretroSystems["FC"] + "/master/Named_Boxarts/Missing Game.png"
+	if requests[missingPath] != 1 {
+		t.Errorf("expected the known-missing cover to be requested once, got %d", requests[missingPath])
+	}
+
+	m := loadManifest(filepath.Join(out, "FC"))
+	if m.Entries["Found Game"].Status != FetchOK || m.Entries["Missing Game"].Status != FetchNotFound {
+		t.Errorf("unexpected manifest: %+v", m.Entries)
+	}
+
+	if err := FetchCoversWithOptions(database, Options{Platform: "FC", OutputDir: out, BaseURL: srv.URL, RetryMissing: true}); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if requests[missingPath] != 2 {
+		t.Errorf("expected --retry-missing to request the cover again, got %d requests", requests[missingPath])
+	}
+}
+
+func TestEnsureGameCover(t *testing.T) {
+	tmp := t.TempDir()
+	database, err := db.OpenMemory()
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	defer database.Close()
+
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/fc/a.nes", Filename: "a.nes", CRC32: "00000001", Platform: "FC"})
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/fc/b.nes", Filename: "b.nes", CRC32: "00000002", Platform: "FC"})
+	database.MatchROMs([]db.DATRom{
+		{GameTitle: "Found Game", Platform: "FC", CRC32: "00000001"},
+		{GameTitle: "Missing Game", Platform: "FC", CRC32: "00000002"},
+	})
+	roms, _, _ := database.GetEnrichableRoms("FC")
+	ids := map[string]int64{}
+	for _, r := range roms {
+		ids[r.TitleEN] = r.GameID
+	}
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if strings.HasSuffix(r.URL.Path, "/Found Game.png") {
+			w.Write([]byte("png"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	opts := Options{OutputDir: filepath.Join(tmp, "covers"), BaseURL: srv.URL}
+
+	for i := 0; i < 2; i++ {
+		path, err := EnsureGameCover(database, ids["Found Game"], BoxartType, opts)
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != "png" {
+			t.Errorf("expected downloaded cover, got %q (%v)", data, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the cover to be downloaded once, got %d requests", requests)
+	}
+
+	if _, err := EnsureGameCover(database, ids["Missing Game"], BoxartType, opts); err != ErrNoCover {
+		t.Errorf("expected ErrNoCover, got %v", err)
+	}
+	if _, err := EnsureGameCover(database, 9999, BoxartType, opts); err != db.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFetchCoverForGame(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Sys/master/Named_Boxarts/Mario _ Luigi.png":
+			w.Write([]byte("png"))
+		case "/Sys/master/Named_Boxarts/Legend of Zelda, The (USA).png":
+			w.Write([]byte("zelda"))
+		case "/Sys/master/Named_Boxarts/Broken.png":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	client := srv.Client()
+	found := filepath.Join(dir, "FC", "Mario & Luigi.png")
+
+	status, variant, err := FetchCoverForGame(client, srv.URL, "Sys", "Mario & Luigi", found, false)
+	if status != FetchOK || variant != "Mario & Luigi" || err != nil {
+		t.Fatalf("expected ok, got %s %q (%v)", status, variant, err)
+	}
+	if data, err := os.ReadFile(found); err != nil || string(data) != "png" {
+		t.Errorf("expected cover written, got %q (%v)", data, err)
+	}
+	if status, _, err := FetchCoverForGame(client, srv.URL, "Sys", "Mario & Luigi", found, false); status != FetchCached || err != nil {
+		t.Errorf("expected cached, got %s (%v)", status, err)
+	}
+	if status, _, err := FetchCoverForGame(client, srv.URL, "Sys", "Mario & Luigi", found, true); status != FetchOK || err != nil {
+		t.Errorf("expected forced re-download, got %s (%v)", status, err)
+	}
+
+	zelda := filepath.Join(dir, "FC", "The Legend of Zelda.png")
+	status, variant, err = FetchCoverForGame(client, srv.URL, "Sys", "The Legend of Zelda", zelda, false)
+	if status != FetchOK || variant != "Legend of Zelda, The (USA)" || err != nil {
+		t.Errorf("expected the No-Intro variant, got %s %q (%v)", status, variant, err)
+	}
+	if data, _ := os.ReadFile(zelda); string(data) != "zelda" {
+		t.Errorf("expected the variant's cover written, got %q", data)
+	}
+
+	missing := filepath.Join(dir, "FC", "Missing.png")
+	if status, _, err := FetchCoverForGame(client, srv.URL, "Sys", "Missing", missing, false); status != FetchNotFound || err != nil {
+		t.Errorf("expected not found, got %s (%v)", status, err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("expected no file for a missing cover, got %v", err)
+	}
+
+	broken := filepath.Join(dir, "FC", "Broken.png")
+	if status, _, err := FetchCoverForGame(client, srv.URL, "Sys", "Broken", broken, false); status != FetchError || err == nil {
+		t.Errorf("expected an error for HTTP 500, got %s (%v)", status, err)
+	}
+	if _, err := os.Stat(broken); !os.IsNotExist(err) {
+		t.Errorf("expected no file after an error, got %v", err)
+	}
+
+	srv.Close()
+	if status, _, err := FetchCoverForGame(client, srv.URL, "Sys", "Missing", missing, false); status != FetchError || err == nil {
+		t.Errorf("expected an error with the server down, got %s (%v)", status, err)
+	}
+}
+
+func TestResolveBaseURL(t *testing.T) {
+	if got, err := resolveBaseURL(""); got != defaultThumbnailsURL || err != nil {
+		t.Errorf("expected the default, got %q (%v)", got, err)
+	}
+	if got, _ := resolveBaseURL("https://mirror.example/"); got != "https://mirror.example/" {
+		t.Errorf("expected the given URL, got %q", got)
+	}
+	for _, bad := range []string{"mirror.example", "ftp://mirror.example", "http://", ":bad"} {
+		if _, err := resolveBaseURL(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	for _, base := range []string{"http://cache.local/thumbs", "http://cache.local/thumbs/"} {
+		got, err := coverURL(base, "Sys", "Mario & Luigi #2?")
+		want := "http://cache.local/thumbs/Sys/master/Named_Boxarts/Mario%20_%20Luigi%20%232_.png"
+		if got != want || err != nil {
+			t.Errorf("coverURL(%q) = %q (%v), want %q", base, got, err, want)
+		}
+	}
+	// Slashes and dots stay in the file name, and % is no escape
+	for title, want := range map[string]string{
+		"AC/DC: 100%": "http://cache.local/Sys/master/Named_Boxarts/AC_DC_%20100%25.png",
+		"../Game":     "http://cache.local/Sys/master/Named_Boxarts/.._Game.png",
+	} {
+		if got, err := coverURL("http://cache.local", "Sys", title); got != want || err != nil {
+			t.Errorf("coverURL(%q) = %q (%v), want %q", title, got, err, want)
+		}
+	}
+}
+
+func TestFetchCoverForGameVariants(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, path.Base(r.URL.Path))
+		if r.URL.Path == "/Sys/master/Named_Boxarts/Ninja Gaiden (USA).png" {
+			w.Write([]byte("png"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "cover.png")
+	status, variant, err := FetchCoverForGame(srv.Client(), srv.URL, "Sys", "Ninja Gaiden", out, false)
+	if status != FetchOK || variant != "Ninja Gaiden (USA)" || err != nil {
+		t.Fatalf("got %v %q %v", status, variant, err)
+	}
+	if want := []string{"Ninja Gaiden.png", "Ninja Gaiden (USA).png"}; !slices.Equal(requested, want) {
+		t.Errorf("expected the variants up to the first hit requested, got %q", requested)
+	}
+
+	requested = nil
+	if status, _, _ := FetchCoverForGame(srv.Client(), srv.URL, "Sys", "The Missing Game", out+".2", false); status != FetchNotFound || len(requested) > maxVariants {
+		t.Errorf("expected at most %d requests for a missing cover, got %v after %q", maxVariants, status, requested)
+	}
+}
+
+func TestTitleVariants(t *testing.T) {
+	tests := []struct {
+		title string
+		want  []string // must be among the variants
+	}{
+		{"The Legend of Zelda", []string{"Legend of Zelda, The", "Legend of Zelda, The (USA)", "Legend of Zelda, The (Japan)"}},
+		{"Legend of Zelda, The (USA)", []string{"Legend of Zelda, The", "The Legend of Zelda (USA)"}},
+		{"Mario & Luigi (Japan)", []string{"Mario and Luigi (Japan)", "Mario & Luigi"}},
+		{"Ninja Gaiden", []string{"Ninja Gaiden (USA)", "Ninja Gaiden (World)"}},
+		{"The Legend of Zelda (USA) [!]", []string{"Legend of Zelda, The (USA) [!]", "Legend of Zelda, The"}},
+	}
+	for _, tt := range tests {
+		got := titleVariants(tt.title)
+		if got[0] != tt.title {
+			t.Errorf("%q: expected the title itself first, got %q", tt.title, got[0])
+		}
+		for _, w := range tt.want {
+			if !slices.Contains(got, w) {
+				t.Errorf("%q: %q missing from %q", tt.title, w, got)
+			}
+		}
+		if len(got) > maxVariants {
+			t.Errorf("%q: expected at most %d variants, got %q", tt.title, maxVariants, got)
+		}
+	}
+	if got := titleVariants("Tetris (World) (Rev 1)"); slices.Contains(got, "Tetris (World) (Rev 1) (USA)") {
+		t.Errorf("expected no region added to a tagged title, got %q", got)
+	}
+}
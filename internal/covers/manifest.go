@@ -0,0 +1,64 @@
+package covers
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/retronian/romu/internal/fsutil"
+)
+
+// manifestName is the file in each platform's cover directory recording
+// what earlier runs found, so interrupted fetches can resume
+const manifestName = ".manifest.json"
+
+type manifestEntry struct {
+	Status  FetchStatus `json:"status"`
+	Variant string      `json:"variant,omitempty"` // title the cover was found under, if not the game's
+	Error   string      `json:"error,omitempty"`
+	Updated time.Time   `json:"updated"`
+}
+
+// manifest maps game names to the outcome of their last fetch
+type manifest struct {
+	path    string
+	Entries map[string]manifestEntry `json:"entries"`
+}
+
+// loadManifest reads dir's manifest. A missing or unreadable one starts empty.
+func loadManifest(dir string) *manifest {
+	m := &manifest{path: filepath.Join(dir, manifestName)}
+	if data, err := os.ReadFile(m.path); err == nil {
+		json.Unmarshal(data, m)
+	}
+	if m.Entries == nil {
+		m.Entries = map[string]manifestEntry{}
+	}
+	return m
+}
+
+func (m *manifest) set(name string, status FetchStatus, variant string, err error) {
+	e := manifestEntry{Status: status, Updated: time.Now().UTC()}
+	if variant != name {
+		e.Variant = variant
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	m.Entries[name] = e
+}
+
+// knownMissing reports whether an earlier run found libretro-thumbnails has
+// no cover for name. Successes need no lookup: their file is on disk.
+func (m *manifest) knownMissing(name string) bool {
+	return m.Entries[name].Status == FetchNotFound
+}
+
+func (m *manifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(m.path, data)
+}
@@ -0,0 +1,73 @@
+package covers
+
+import (
+	"strings"
+
+	"github.com/retronian/romu/internal/nointro"
+)
+
+// regionTags are tried on titles that carry none: libretro-thumbnails files
+// covers under full No-Intro names, which nearly always include a region
+var regionTags = []string{"(USA)", "(Japan)", "(World)", "(Europe)"}
+
+// maxVariants caps the names tried for a title, as each one not found costs
+// a request to libretro-thumbnails
+const maxVariants = 4
+
+// titleVariants returns the names libretro-thumbnails might file title's
+// cover under, most likely first and at most maxVariants: title itself,
+// then the No-Intro form with the leading article moved behind ("Legend of
+// Zelda, The"), with a region tag if title has no tags, or without its
+// tags. The other article form and "&" and "and" swapped come last. Names
+// that give the same file are tried once.
+func titleVariants(title string) []string {
+	base, _ := nointro.Parse(title)
+	tags := nointro.Tags(title)
+	if tags != "" {
+		tags = " " + tags
+	}
+	noIntro := nointro.InvertArticle(base)
+	bases := []string{noIntro, nointro.Canonicalize(base)}
+	for _, b := range bases[:2] {
+		if s := swapAnd(b); s != "" {
+			bases = append(bases, s)
+		}
+	}
+
+	var variants []string
+	seen := map[string]bool{}
+	add := func(v string) {
+		if name := thumbnailName(v); !seen[name] && len(variants) < maxVariants {
+			seen[name] = true
+			variants = append(variants, v)
+		}
+	}
+	add(title)
+	add(noIntro + tags)
+	if tags != "" {
+		add(noIntro)
+	} else {
+		for _, region := range regionTags {
+			add(noIntro + " " + region)
+		}
+	}
+	for _, b := range bases[1:] {
+		add(b + tags)
+		if tags != "" {
+			add(b)
+		}
+	}
+	return variants
+}
+
+// swapAnd swaps " & " for " and " or the reverse, returning "" if title has
+// neither
+func swapAnd(title string) string {
+	if strings.Contains(title, " & ") {
+		return strings.ReplaceAll(title, " & ", " and ")
+	}
+	if strings.Contains(title, " and ") {
+		return strings.ReplaceAll(title, " and ", " & ")
+	}
+	return ""
+}
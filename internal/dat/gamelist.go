@@ -1,11 +1,17 @@
 package dat
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 // EmulationStation gamelist.xml structures
@@ -14,19 +20,20 @@ type GameList struct {
 	Games   []GameListGame `xml:"game"`
 }
 
+// GameListGame is one <game> entry. Empty fields are omitted on export.
 type GameListGame struct {
 	Path        string `xml:"path"`
-	Name        string `xml:"name"`
-	Desc        string `xml:"desc"`
-	ReleaseDate string `xml:"releasedate"`
-	Developer   string `xml:"developer"`
-	Publisher   string `xml:"publisher"`
-	Genre       string `xml:"genre"`
-	Players     string `xml:"players"`
-	Rating      string `xml:"rating"`
-	Thumbnail   string `xml:"thumbnail"`
-	Image       string `xml:"image"`
-	Marquee     string `xml:"marquee"`
+	Name        string `xml:"name,omitempty"`
+	Desc        string `xml:"desc,omitempty"`
+	ReleaseDate string `xml:"releasedate,omitempty"`
+	Developer   string `xml:"developer,omitempty"`
+	Publisher   string `xml:"publisher,omitempty"`
+	Genre       string `xml:"genre,omitempty"`
+	Players     string `xml:"players,omitempty"`
+	Rating      string `xml:"rating,omitempty"`
+	Image       string `xml:"image,omitempty"`
+	Thumbnail   string `xml:"thumbnail,omitempty"`
+	Marquee     string `xml:"marquee,omitempty"`
 }
 
 // GameListEntry holds a parsed gamelist.xml entry
@@ -53,11 +60,35 @@ func ParseGameList(path string) ([]GameListEntry, error) {
 	}
 	defer f.Close()
 
+	// Some frontends write a UTF-8 BOM, which the XML decoder rejects
+	r := bufio.NewReader(f)
+	if bom, _ := r.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		r.Discard(3)
+	}
+
 	var gl GameList
-	if err := xml.NewDecoder(f).Decode(&gl); err != nil {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = charsetReader
+	if err := dec.Decode(&gl); err != nil {
 		return nil, fmt.Errorf("parse gamelist XML: %w", err)
 	}
 
+	// Media paths are relative to the gamelist's directory, or to the home
+	// directory when written as "~/..."
+	dir := filepath.Dir(path)
+	home, _ := os.UserHomeDir()
+	resolve := func(p string) string {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "" || filepath.IsAbs(p):
+			return p
+		case strings.HasPrefix(p, "~/") && home != "":
+			return filepath.Join(home, p[2:])
+		default:
+			return filepath.Join(dir, p)
+		}
+	}
+
 	var entries []GameListEntry
 	for _, g := range gl.Games {
 		filename := filepath.Base(g.Path)
@@ -73,11 +104,36 @@ func ParseGameList(path string) ([]GameListEntry, error) {
 				Genre:       strings.TrimSpace(g.Genre),
 				Players:     strings.TrimSpace(g.Players),
 				Rating:      strings.TrimSpace(g.Rating),
-				Thumbnail:   strings.TrimSpace(g.Thumbnail),
-				Image:       strings.TrimSpace(g.Image),
-				Marquee:     strings.TrimSpace(g.Marquee),
+				Thumbnail:   resolve(g.Thumbnail),
+				Image:       resolve(g.Image),
+				Marquee:     resolve(g.Marquee),
 			})
 		}
 	}
 	return entries, nil
 }
+
+// MarshalGameList renders games as an indented gamelist.xml document
+func MarshalGameList(games []GameListGame) ([]byte, error) {
+	data, err := xml.MarshalIndent(GameList{Games: games}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(xml.Header), data...), '\n'), nil
+}
+
+// parseGameListDate parses a gamelist.xml date such as "19850913T000000"
+func parseGameListDate(s string) (time.Time, bool) {
+	t, err := time.Parse("20060102T150405", s)
+	return t, err == nil
+}
+
+// charsetReader decodes the non-UTF-8 encodings a gamelist.xml may declare,
+// such as windows-1252 or Shift_JIS
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q", label)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
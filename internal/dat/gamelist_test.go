@@ -0,0 +1,88 @@
+package dat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestParseGameListEncodings(t *testing.T) {
+	sjis, err := japanese.ShiftJIS.NewEncoder().String(`<?xml version="1.0" encoding="Shift_JIS"?>
+<gameList><game><path>./rockman.nes</path><name>ロックマン</name></game></gameList>`)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"bom", "\xef\xbb\xbf<?xml version=\"1.0\"?>\n<gameList><game><path>./rockman.nes</path><name>ロックマン</name></game></gameList>", "ロックマン"},
+		{"shift_jis", sjis, "ロックマン"},
+		{"windows-1252", "<?xml version=\"1.0\" encoding=\"windows-1252\"?>\n<gameList><game><path>./pokemon.gb</path><name>Pok\xe9mon</name></game></gameList>", "Pokémon"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "gamelist.xml")
+		os.WriteFile(path, []byte(tt.data), 0644)
+		entries, err := ParseGameList(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(entries) != 1 || entries[0].Name != tt.want {
+			t.Errorf("%s: got %+v, want name %q", tt.name, entries, tt.want)
+		}
+	}
+}
+
+func TestParseGameListMediaPaths(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gamelist.xml")
+	os.WriteFile(path, []byte(`<gameList><game>
+	<path>./game.nes</path><name>Game</name>
+	<image>./images/game.png</image>
+	<thumbnail>/abs/thumb.png</thumbnail>
+</game></gameList>`), 0644)
+
+	entries, err := ParseGameList(path)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("parse: %d entries (%v)", len(entries), err)
+	}
+	if e := entries[0]; e.Image != filepath.Join(dir, "images", "game.png") || e.Thumbnail != "/abs/thumb.png" || e.Marquee != "" {
+		t.Errorf("unexpected media paths: %+v", e)
+	}
+}
+
+func TestMarshalGameList(t *testing.T) {
+	data, err := MarshalGameList([]GameListGame{
+		{Path: "./a.nes", Name: "Tom & Jerry <J>", Desc: "line1\nline2\x01"},
+		{Path: "./b.zip"},
+	})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<gameList>
+  <game>
+    <path>./a.nes</path>
+    <name>Tom &amp; Jerry &lt;J&gt;</name>
+    <desc>line1&#xA;line2` + "\uFFFD" + `</desc>
+  </game>
+  <game>
+    <path>./b.zip</path>
+  </game>
+</gameList>
+`
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+
+	// The output parses back
+	path := filepath.Join(t.TempDir(), "gamelist.xml")
+	os.WriteFile(path, data, 0644)
+	if entries, err := ParseGameList(path); err != nil || len(entries) != 1 || entries[0].Name != "Tom & Jerry <J>" {
+		t.Errorf("round trip: %+v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,57 @@
+package dat
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+)
+
+// LaunchBox is a LaunchBox platform file, Data/Platforms/<platform>.xml
+type LaunchBox struct {
+	XMLName xml.Name        `xml:"LaunchBox"`
+	Games   []LaunchBoxGame `xml:"Game"`
+}
+
+// LaunchBoxGame is one <Game>. ReleaseDate is in gamelist.xml form, as romu
+// stores it ("19850913T000000"), and is converted when marshaling. Empty
+// fields other than the title, path and platform are omitted.
+type LaunchBoxGame struct {
+	ID              string `xml:"ID"` // derived from the path if empty
+	Title           string `xml:"Title"`
+	ApplicationPath string `xml:"ApplicationPath"`
+	Platform        string `xml:"Platform"`
+	ReleaseDate     string `xml:"ReleaseDate,omitempty"`
+	Developer       string `xml:"Developer,omitempty"`
+	Publisher       string `xml:"Publisher,omitempty"`
+	Genre           string `xml:"Genre,omitempty"`
+	Notes           string `xml:"Notes,omitempty"`
+}
+
+// MarshalLaunchBox renders games as an indented LaunchBox platform file
+func MarshalLaunchBox(games []LaunchBoxGame) ([]byte, error) {
+	out := make([]LaunchBoxGame, len(games))
+	for i, g := range games {
+		if g.ID == "" {
+			g.ID = launchBoxID(g.ApplicationPath)
+		}
+		g.ReleaseDate = ""
+		if t, ok := parseGameListDate(games[i].ReleaseDate); ok {
+			g.ReleaseDate = t.Format("2006-01-02T15:04:05")
+		}
+		out[i] = g
+	}
+	data, err := xml.MarshalIndent(LaunchBox{Games: out}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(xml.Header), data...), '\n'), nil
+}
+
+// launchBoxID returns a GUID that stays the same for a path, so importing
+// an export again updates games instead of adding them twice
+func launchBoxID(path string) string {
+	h := sha1.Sum([]byte(path))
+	h[6] = h[6]&0x0f | 0x50 // version 5
+	h[8] = h[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
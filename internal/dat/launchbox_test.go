@@ -0,0 +1,61 @@
+package dat
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestMarshalLaunchBox(t *testing.T) {
+	data, err := MarshalLaunchBox([]LaunchBoxGame{
+		{
+			Title:           "Super Mario Bros.",
+			ApplicationPath: "/roms/fc/Super Mario Bros. (World).nes",
+			Platform:        "Nintendo Entertainment System",
+			ReleaseDate:     "19850913T000000",
+			Developer:       "Nintendo",
+			Publisher:       "Nintendo",
+			Genre:           "Platform",
+			Notes:           "Save the princess & stomp on Goombas.",
+		},
+		{Title: "Unknown", ApplicationPath: "/roms/fc/unknown.nes", Platform: "Nintendo Entertainment System", ReleaseDate: "1985"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header+"<LaunchBox>\n  <Game>\n") {
+		t.Errorf("unexpected start:\n%s", data)
+	}
+
+	var doc LaunchBox
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+	if len(doc.Games) != 2 {
+		t.Fatalf("got %+v", doc.Games)
+	}
+	g := doc.Games[0]
+	want := LaunchBoxGame{
+		ID:              g.ID,
+		Title:           "Super Mario Bros.",
+		ApplicationPath: "/roms/fc/Super Mario Bros. (World).nes",
+		Platform:        "Nintendo Entertainment System",
+		ReleaseDate:     "1985-09-13T00:00:00",
+		Developer:       "Nintendo",
+		Publisher:       "Nintendo",
+		Genre:           "Platform",
+		Notes:           "Save the princess & stomp on Goombas.",
+	}
+	if g != want {
+		t.Errorf("got %+v, want %+v", g, want)
+	}
+
+	// IDs are GUIDs that stay the same for a path
+	if len(g.ID) != 36 || g.ID != launchBoxID(g.ApplicationPath) || g.ID == doc.Games[1].ID {
+		t.Errorf("unexpected IDs %q, %q", g.ID, doc.Games[1].ID)
+	}
+	// Dates that aren't gamelist dates are left out
+	if doc.Games[1].ReleaseDate != "" || strings.Count(string(data), "<ReleaseDate>") != 1 {
+		t.Errorf("unexpected release date %q", doc.Games[1].ReleaseDate)
+	}
+}
@@ -4,21 +4,20 @@ import (
 	"bufio"
 	"encoding/xml"
 	"fmt"
+	"io"
+	"maps"
 	"os"
-	"regexp"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/platform"
 )
 
-// No-Intro DAT XML structure
-type Datafile struct {
-	XMLName xml.Name  `xml:"datafile"`
-	Header  Header    `xml:"header"`
-	Games   []XMLGame `xml:"game"`
-}
-
+// No-Intro DAT XML structure, decoded a <header> or <game> at a time by
+// streamXML
 type Header struct {
 	Name        string `xml:"name"`
 	Description string `xml:"description"`
@@ -30,18 +29,54 @@ type XMLGame struct {
 }
 
 type XMLRom struct {
-	Name string `xml:"name,attr"`
-	Size string `xml:"size,attr"`
-	CRC  string `xml:"crc,attr"`
-	MD5  string `xml:"md5,attr"`
-	SHA1 string `xml:"sha1,attr"`
+	Name   string `xml:"name,attr"`
+	Size   string `xml:"size,attr"`
+	CRC    string `xml:"crc,attr"`
+	MD5    string `xml:"md5,attr"`
+	SHA1   string `xml:"sha1,attr"`
+	Status string `xml:"status,attr"`
 }
 
-// ParseDAT parses a No-Intro DAT file (XML or ClrMamePro format)
+// ParseDAT parses a No-Intro DAT file (XML or ClrMamePro format). platform,
+// if set, is the platform of every game. Otherwise a game's platform comes
+// from its DAT header, and where the header names no platform, from its ROMs'
+// file extensions if only one platform uses them. Several DATs concatenated
+// into one file are read as one, each with its own header; the header name
+// returned is then theirs joined by " + ". ROMs the DAT marks as bad or
+// missing dumps are returned with their Status; see db.DumpedRoms.
 func ParseDAT(path string, platform string) ([]db.DATRom, string, error) {
+	return ParseDATProgress(path, platform, nil)
+}
+
+// progressEvery is how many games ParseDATProgress reads between progress
+// reports
+const progressEvery = 1000
+
+// ParseDATProgress is ParseDAT, calling progress, if set, with the number of
+// games read so far every so many games and once all are, as a big DAT takes
+// a while
+func ParseDATProgress(path string, platform string, progress func(games int)) ([]db.DATRom, string, error) {
+	var roms []db.DATRom
+	header, err := StreamDAT(path, platform, progress, func(r db.DATRom) error {
+		roms = append(roms, r)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return roms, header, nil
+}
+
+// StreamDAT is ParseDATProgress, passing each ROM to rom as it is read
+// rather than returning them all, so that reading even a MAME DAT of
+// gigabytes takes no more memory than its biggest game. It stops at the
+// first error rom returns. Games whose platform can't be told fail it only
+// once the whole DAT is read, after the others' ROMs were passed on, so a
+// caller storing them should do so in a transaction it can roll back.
+func StreamDAT(path string, platform string, progress func(games int), rom func(db.DATRom) error) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, "", fmt.Errorf("open DAT: %w", err)
+		return "", fmt.Errorf("open DAT: %w", err)
 	}
 	defer f.Close()
 
@@ -51,165 +86,398 @@ func ParseDAT(path string, platform string) ([]db.DATRom, string, error) {
 	firstLine := strings.TrimSpace(scanner.Text())
 	f.Seek(0, 0)
 
+	a := &assigner{platform: platform, found: map[string]bool{}, rom: rom}
+	games := 0
+	game := func(g datGame) error {
+		if games++; progress != nil && games%progressEvery == 0 {
+			progress(games)
+		}
+		return a.game(g)
+	}
 	if strings.HasPrefix(firstLine, "clrmamepro") || strings.HasPrefix(firstLine, "clrmamepro (") {
-		return parseClrMamePro(f, platform)
+		err = parseClrMamePro(f, a.section, game)
+	} else {
+		err = parseXML(f, a.section, game)
 	}
-	return parseXML(f, platform)
+	if err != nil {
+		return "", err
+	}
+	if progress != nil && games%progressEvery != 0 {
+		progress(games)
+	}
+	return a.done()
 }
 
-func parseXML(f *os.File, platform string) ([]db.DATRom, string, error) {
-	var datafile Datafile
-	dec := xml.NewDecoder(f)
-	if err := dec.Decode(&datafile); err != nil {
-		return nil, "", fmt.Errorf("parse DAT XML: %w", err)
-	}
+type datGame struct {
+	roms  []db.DATRom
+	files []string // the ROMs' file names, hinting at the platform
+}
+
+// assigner gives every ROM of a DAT, read a game at a time, its platform as
+// ParseDAT describes, and passes it on to rom
+type assigner struct {
+	platform string // given, if any
+	current  string // the platform of the DAT being read, if its header tells
+	rom      func(db.DATRom) error
 
-	if platform == "" {
-		platform = detectPlatformFromHeader(datafile.Header.Name)
+	headers, unknown []string
+	found            map[string]bool
+	roms             int
+}
+
+// section starts another DAT of the file, with the given header name
+func (a *assigner) section(header string) {
+	if header != "" {
+		a.headers = append(a.headers, header)
 	}
-	if platform == "" {
-		return nil, "", fmt.Errorf("cannot detect platform from DAT header %q, use --platform flag", datafile.Header.Name)
+	a.current = a.platform
+	if a.current == "" {
+		a.current = detectPlatformFromHeader(header)
 	}
+}
 
-	var roms []db.DATRom
-	for _, g := range datafile.Games {
-		for _, r := range g.ROMs {
-			size, _ := strconv.ParseInt(r.Size, 10, 64)
-			roms = append(roms, db.DATRom{
-				GameTitle: g.Name,
-				Platform:  platform,
-				CRC32:     strings.ToUpper(r.CRC),
-				MD5:       strings.ToUpper(r.MD5),
-				SHA1:      strings.ToUpper(r.SHA1),
-				Size:      size,
-			})
+// game passes on g's ROMs, or notes it as unknown if its platform can't be
+// told
+func (a *assigner) game(g datGame) error {
+	p := a.current
+	if p == "" {
+		p = detectPlatformFromFiles(g.files)
+	}
+	if p == "" {
+		if len(g.roms) > 0 {
+			a.unknown = append(a.unknown, g.roms[0].GameTitle)
 		}
+		return nil
 	}
-	return roms, datafile.Header.Name, nil
+	a.found[p] = true
+	for _, r := range g.roms {
+		r.Platform = p
+		if err := a.rom(r); err != nil {
+			return err
+		}
+		a.roms++
+	}
+	return nil
+}
+
+// done returns the DAT's header name once it is read, failing if any game's
+// platform couldn't be told
+func (a *assigner) done() (string, error) {
+	header := strings.Join(a.headers, " + ")
+	switch {
+	case len(a.unknown) == 0:
+		return header, nil
+	case a.roms == 0:
+		return "", fmt.Errorf("cannot detect platform from DAT header %q, use --platform flag", header)
+	}
+	examples := a.unknown[:min(len(a.unknown), 3)]
+	return "", fmt.Errorf("cannot detect platform of %d game(s) in DAT %q, such as %s, from header or ROM extensions; the others are %s. Use --platform flag or split the DAT",
+		len(a.unknown), header, strings.Join(quoteAll(examples), ", "), strings.Join(slices.Sorted(maps.Keys(a.found)), ", "))
+}
+
+func quoteAll(ss []string) []string {
+	q := make([]string, len(ss))
+	for i, s := range ss {
+		q[i] = strconv.Quote(s)
+	}
+	return q
+}
+
+// parseXML reads an XML DAT, calling section with the header name of each
+// of its DATs before game with each of their games
+func parseXML(f *os.File, section func(header string), game func(datGame) error) error {
+	return streamXML(f,
+		func(h Header) { section(h.Name) },
+		func(g XMLGame) error {
+			var dg datGame
+			for _, r := range g.ROMs {
+				size, _ := strconv.ParseInt(r.Size, 10, 64)
+				dg.roms = append(dg.roms, dumpStatus(db.DATRom{
+					GameTitle: g.Name,
+					CRC32:     db.NormalizeHash(r.CRC, db.CRC32Width),
+					MD5:       db.NormalizeHash(r.MD5, db.MD5Width),
+					SHA1:      db.NormalizeHash(r.SHA1, db.SHA1Width),
+					Size:      size,
+				}, r.Status))
+				dg.files = append(dg.files, r.Name)
+			}
+			return game(dg)
+		})
 }
 
-// ClrMamePro format parser
-var clrRomLineRe = regexp.MustCompile(`rom\s*\(\s*name\s+"([^"]+)"\s+size\s+(\d+)\s+crc\s+(\w+)\s+md5\s+(\w+)\s+sha1\s+(\w+)(?:\s+[^)]*?)?\s*\)`)
+// streamXML reads DAT XML one element at a time, so that only a game, not
+// the whole DAT, is decoded at once: big MAME DATs run to gigabytes. Each
+// <datafile>, of which there may be several, is passed to datafile with its
+// header, which is empty if it has none, before its games, both <game> and
+// MAME's <machine>, are passed to game, stopping at the first error it
+// returns.
+func streamXML(r io.Reader, datafile func(Header), game func(XMLGame) error) error {
+	dec := xml.NewDecoder(r)
+	found := false
+	// The datafile started and not yet passed on, waiting for its header
+	var pending *Header
+	flush := func() {
+		if pending != nil {
+			datafile(*pending)
+			pending = nil
+		}
+	}
+	for depth := 0; ; {
+		tok, err := dec.Token()
+		if err == io.EOF && found && depth == 0 {
+			return nil
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("parse DAT XML: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case depth == 0 && t.Name.Local != "datafile":
+				return fmt.Errorf("parse DAT XML: expected element type <datafile> but have <%s>", t.Name.Local)
+			case depth == 0:
+				found = true
+				depth++
+				pending = &Header{}
+			case depth == 1 && t.Name.Local == "header" && pending != nil:
+				if err := dec.DecodeElement(pending, &t); err != nil {
+					return fmt.Errorf("parse DAT XML: %w", err)
+				}
+			case depth == 1 && (t.Name.Local == "game" || t.Name.Local == "machine"):
+				var g XMLGame
+				if err := dec.DecodeElement(&g, &t); err != nil {
+					return fmt.Errorf("parse DAT XML: %w", err)
+				}
+				flush()
+				if err := game(g); err != nil {
+					return err
+				}
+			default:
+				if err := dec.Skip(); err != nil {
+					return fmt.Errorf("parse DAT XML: %w", err)
+				}
+			}
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				flush()
+			}
+		}
+	}
+}
 
-func parseClrMamePro(f *os.File, platform string) ([]db.DATRom, string, error) {
+// ClrMamePro format parser. Statements are read a line at a time: a header
+// or game block's "name", and "rom ( ... )" lines of key-value pairs in any
+// order, such as MAME's "rom ( name 136014-221.ic38 size 2048 crc 1e83e8b3
+// sha1 ... flags baddump )". section and game are called as by parseXML, a
+// game once the next starts, as its ROMs may run to then.
+func parseClrMamePro(f *os.File, section func(header string), game func(datGame) error) error {
 	scanner := bufio.NewScanner(f)
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 
-	headerName := ""
-	var roms []db.DATRom
+	header := ""
+	started := false // header passed to section, which a DAT's first game does
+	var g *datGame   // the game being read
+	inHeader := false
 	currentGame := ""
 
+	start := func() {
+		if !started {
+			section(header)
+			started = true
+		}
+	}
+	end := func() error {
+		if g == nil {
+			return nil
+		}
+		err := game(*g)
+		g = nil
+		return err
+	}
+
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		tokens := cmpTokens(scanner.Text())
+		if len(tokens) == 0 {
+			continue
+		}
+		first := tokens[0]
 
+		switch {
+		// Each header block starts another DAT, unless it is the first
+		case first.is("clrmamepro"):
+			if err := end(); err != nil {
+				return err
+			}
+			if !started && header != "" {
+				section(header)
+			}
+			started = false
+			inHeader = true
+			header = cmpValue(tokens, "name")
 		// Header name
-		if strings.HasPrefix(line, `name "`) {
-			val := extractQuoted(line, "name")
-			if headerName == "" {
-				headerName = val
+		case inHeader && first.is("name"):
+			if header == "" {
+				header = cmpValue(tokens, "name")
 			}
+		// Game block start, possibly with its name and ROMs inline
+		case first.is("game") || first.is("machine") || first.is("resource"):
+			if err := end(); err != nil {
+				return err
+			}
+			start()
+			inHeader = false
+			currentGame = cmpValue(tokens, "name")
+			g = &datGame{}
+		// Game name inside block
+		case !inHeader && currentGame == "" && first.is("name"):
+			currentGame = cmpValue(tokens, "name")
 		}
 
-		// Game block start
-		if strings.HasPrefix(line, "game (") || line == "game (" {
-			currentGame = ""
-		}
-
-		// Game name inside block
-		if currentGame == "" && strings.HasPrefix(line, `name "`) {
-			currentGame = extractQuoted(line, "name")
-		}
-
-		// ROM line (can be inline with game or separate)
-		if strings.Contains(line, "rom (") || strings.HasPrefix(line, "rom (") {
-			m := clrRomLineRe.FindStringSubmatch(line)
-			if m != nil {
-				gameName := currentGame
-				if gameName == "" {
-					// Try to extract from rom filename
-					gameName = m[1]
-				}
-				size, _ := strconv.ParseInt(m[2], 10, 64)
-				roms = append(roms, db.DATRom{
-					GameTitle: gameName,
-					Platform:  "", // set below
-					CRC32:     strings.ToUpper(m[3]),
-					MD5:       strings.ToUpper(m[4]),
-					SHA1:      strings.ToUpper(m[5]),
-					Size:      size,
-				})
+		for _, attrs := range cmpRoms(tokens) {
+			gameName := currentGame
+			if gameName == "" {
+				// Try to extract from rom filename
+				gameName = attrs["name"]
+			}
+			if g == nil {
+				start()
+				g = &datGame{}
 			}
+			// MAME writes the status as "flags", others as "status"
+			status := attrs["status"]
+			if status == "" {
+				status = attrs["flags"]
+			}
+			size, _ := strconv.ParseInt(attrs["size"], 10, 64)
+			g.roms = append(g.roms, dumpStatus(db.DATRom{
+				GameTitle: gameName,
+				CRC32:     db.NormalizeHash(attrs["crc"], db.CRC32Width),
+				MD5:       db.NormalizeHash(attrs["md5"], db.MD5Width),
+				SHA1:      db.NormalizeHash(attrs["sha1"], db.SHA1Width),
+				Size:      size,
+			}, status))
+			g.files = append(g.files, attrs["name"])
 		}
 	}
-
-	if platform == "" {
-		platform = detectPlatformFromHeader(headerName)
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read DAT: %w", err)
 	}
-	if platform == "" {
-		return nil, "", fmt.Errorf("cannot detect platform from DAT header %q, use --platform flag", headerName)
+	if err := end(); err != nil {
+		return err
 	}
+	if !started && header != "" {
+		section(header)
+	}
+	return nil
+}
 
-	// Set platform on all roms
-	for i := range roms {
-		roms[i].Platform = platform
+// dumpStatus sets r's Status from the DAT's status of it. A ROM without
+// hashes, whatever the DAT says, was never dumped either.
+func dumpStatus(r db.DATRom, status string) db.DATRom {
+	switch {
+	case r.CRC32 == "" && r.MD5 == "" && r.SHA1 == "":
+		r.Status = db.StatusNoDump
+	case strings.EqualFold(status, db.StatusNoDump), strings.EqualFold(status, db.StatusBadDump):
+		r.Status = strings.ToLower(status)
 	}
+	return r
+}
 
-	return roms, headerName, nil
+// cmpToken is a word, a parenthesis or a quoted string of a ClrMamePro line
+type cmpToken struct {
+	text   string
+	quoted bool
 }
 
-func extractQuoted(line, key string) string {
-	prefix := key + ` "`
-	idx := strings.Index(line, prefix)
-	if idx < 0 {
-		return ""
+// is reports whether t is the bare word or parenthesis s
+func (t cmpToken) is(s string) bool {
+	return !t.quoted && t.text == s
+}
+
+// cmpTokens splits a ClrMamePro line into tokens. Quoted strings may hold
+// spaces, parentheses and \" or \\ escapes, other backslashes being kept;
+// an unclosed one runs to the end of the line.
+func cmpTokens(line string) []cmpToken {
+	var tokens []cmpToken
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, cmpToken{text: string(c)})
+			i++
+		case c == '"':
+			var b strings.Builder
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			tokens = append(tokens, cmpToken{text: b.String(), quoted: true})
+			i++
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r()\"", rune(line[j])) {
+				j++
+			}
+			tokens = append(tokens, cmpToken{text: line[i:j]})
+			i = j
+		}
 	}
-	start := idx + len(prefix)
-	end := strings.Index(line[start:], `"`)
-	if end < 0 {
-		return line[start:]
+	return tokens
+}
+
+// cmpValue returns the token following the first bare key in tokens, or ""
+func cmpValue(tokens []cmpToken, key string) string {
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].is(key) {
+			return tokens[i+1].text
+		}
 	}
-	return line[start : start+end]
+	return ""
+}
+
+// cmpRoms returns the key-value pairs of each "rom ( ... )" in tokens
+func cmpRoms(tokens []cmpToken) []map[string]string {
+	var roms []map[string]string
+	for i := 0; i+1 < len(tokens); i++ {
+		if !tokens[i].is("rom") || !tokens[i+1].is("(") {
+			continue
+		}
+		attrs := map[string]string{}
+		for i += 2; i < len(tokens) && !tokens[i].is(")"); i += 2 {
+			if i+1 < len(tokens) && !tokens[i+1].is(")") {
+				attrs[tokens[i].text] = tokens[i+1].text
+			}
+		}
+		roms = append(roms, attrs)
+	}
+	return roms
 }
 
 func detectPlatformFromHeader(name string) string {
-	lower := strings.ToLower(name)
-	patterns := map[string]string{
-		"nintendo entertainment system":     "FC",
-		"famicom":                           "FC",
-		"super nintendo":                    "SFC",
-		"super famicom":                     "SFC",
-		"game boy advance":                  "GBA",
-		"game boy color":                    "GBC",
-		"game boy":                          "GB",
-		"mega drive":                        "MD",
-		"genesis":                           "MD",
-		"playstation":                       "PS1",
-		"nintendo 64":                       "N64",
-		"nintendo ds":                       "NDS",
-		"pc engine":                         "PCE",
-		"turbografx":                        "PCE",
-		"game gear":                         "GG",
-		"master system":                     "SMS",
-		"wonderswan color":                  "WSC",
-		"wonderswan":                        "WS",
-		"neo geo pocket":                    "NGP",
-	}
-	// Check longer patterns first to avoid false matches
-	order := []string{
-		"game boy advance", "game boy color", "game boy",
-		"wonderswan color", "wonderswan",
-		"super nintendo", "super famicom",
-		"nintendo entertainment system", "famicom",
-		"mega drive", "genesis",
-		"nintendo 64", "nintendo ds",
-		"pc engine", "turbografx",
-		"game gear", "master system",
-		"neo geo pocket", "playstation",
-	}
-	for _, pattern := range order {
-		if strings.Contains(lower, pattern) {
-			return patterns[pattern]
+	return platform.FromDATHeader(name)
+}
+
+// detectPlatformFromFiles returns the platform the extensions of a game's
+// ROM files point to, or "" if they point to none or to several
+func detectPlatformFromFiles(files []string) string {
+	code := ""
+	for _, f := range files {
+		p := platform.FromExtension(path.Ext(f))
+		if p == "" {
+			continue
 		}
+		if code != "" && p != code {
+			return ""
+		}
+		code = p
 	}
-	return ""
+	return code
 }
@@ -1,9 +1,16 @@
 package dat
 
 import (
+	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/retronian/romu/internal/db"
 )
 
 func TestParseDAT(t *testing.T) {
@@ -66,3 +73,262 @@ func TestDetectPlatformFromHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCombinedDAT(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(tmp, name)
+		os.WriteFile(p, []byte(content), 0644)
+		return p
+	}
+	platforms := func(roms []db.DATRom) map[string]string {
+		m := map[string]string{}
+		for _, r := range roms {
+			m[r.GameTitle] = r.Platform
+		}
+		return m
+	}
+
+	// A generic header, the games' ROM extensions telling their platform
+	generic := write("generic.dat", `<?xml version="1.0"?>
+<datafile>
+	<header><name>My Favorites</name></header>
+	<game name="Tetris (World)"><rom name="Tetris (World).gb" size="32768" crc="46DF91AD"/></game>
+	<game name="Super Mario Bros. (World)"><rom name="Super Mario Bros. (World).nes" size="40976" crc="3337EC46"/></game>
+	<game name="Sonic (World)"><rom name="Sonic (World).MD" size="524288" crc="F9394E97"/></game>
+</datafile>`)
+	roms, header, err := ParseDAT(generic, "")
+	want := map[string]string{"Tetris (World)": "GB", "Super Mario Bros. (World)": "FC", "Sonic (World)": "MD"}
+	if err != nil || header != "My Favorites" || !maps.Equal(platforms(roms), want) {
+		t.Errorf("generic header: %v, %q, %v", platforms(roms), header, err)
+	}
+
+	// Concatenated DATs, each with its own header
+	concatenated := write("concat.dat", `<?xml version="1.0"?>
+<datafile>
+	<header><name>Nintendo - Game Boy</name></header>
+	<game name="Tetris (World)"><rom name="Tetris (World).gb" size="32768" crc="46DF91AD"/></game>
+</datafile>
+<?xml version="1.0"?>
+<datafile>
+	<header><name>Nintendo - Game Boy Advance</name></header>
+	<game name="Mother 3 (Japan)"><rom name="Mother 3 (Japan).bin" size="33554432" crc="42AC9CB9"/></game>
+</datafile>`)
+	roms, header, err = ParseDAT(concatenated, "")
+	want = map[string]string{"Tetris (World)": "GB", "Mother 3 (Japan)": "GBA"}
+	if err != nil || header != "Nintendo - Game Boy + Nintendo - Game Boy Advance" || !maps.Equal(platforms(roms), want) {
+		t.Errorf("concatenated: %v, %q, %v", platforms(roms), header, err)
+	}
+
+	clrmamepro := write("concat-cmp.dat", `clrmamepro (
+	name "Nintendo - Nintendo Entertainment System"
+)
+
+game (
+	name "Super Mario Bros. (World)"
+	rom ( name "Super Mario Bros. (World).nes" size 40976 crc 3337EC46 md5 811B027EAF99C2DEF7B933C5208636DE sha1 FACEE9C577A5262DBE33AC4930BB0B58C8C037F7 )
+)
+
+clrmamepro (
+	name "Sega - Game Gear"
+)
+
+game (
+	name "Columns (Japan)"
+	rom ( name "Columns (Japan).bin" size 32768 crc 83FA26D9 md5 00000000000000000000000000000000 sha1 0000000000000000000000000000000000000000 )
+)
+`)
+	roms, header, err = ParseDAT(clrmamepro, "")
+	want = map[string]string{"Super Mario Bros. (World)": "FC", "Columns (Japan)": "GG"}
+	if err != nil || header != "Nintendo - Nintendo Entertainment System + Sega - Game Gear" || !maps.Equal(platforms(roms), want) {
+		t.Errorf("concatenated ClrMamePro: %v, %q, %v", platforms(roms), header, err)
+	}
+
+	// --platform still overrides everything
+	if roms, _, err := ParseDAT(concatenated, "GBC"); err != nil || roms[0].Platform != "GBC" || roms[1].Platform != "GBC" {
+		t.Errorf("--platform: %v, %v", platforms(roms), err)
+	}
+
+	// Games no header or extension places are named in the error
+	ambiguous := write("ambiguous.dat", `<?xml version="1.0"?>
+<datafile>
+	<header><name>My Favorites</name></header>
+	<game name="Tetris (World)"><rom name="Tetris (World).gb" size="32768" crc="46DF91AD"/></game>
+	<game name="Ridge Racer (Japan)"><rom name="Ridge Racer (Japan).bin" size="1" crc="00000001"/></game>
+</datafile>`)
+	_, _, err = ParseDAT(ambiguous, "")
+	if err == nil || !strings.Contains(err.Error(), `"Ridge Racer (Japan)"`) || !strings.Contains(err.Error(), "the others are GB") {
+		t.Errorf("ambiguous: %v", err)
+	}
+}
+
+func TestParseLargeXML(t *testing.T) {
+	const n = 20000
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE datafile PUBLIC "-//Logiqx//DTD ROM Management Datafile//EN" "http://www.logiqx.com/Dats/datafile.dtd">
+<datafile>
+	<header><name>MAME</name><description>MAME 0.261</description></header>
+`)
+	for i := range n {
+		// MAME writes <machine>, others <game>
+		elem := "game"
+		if i%2 == 1 {
+			elem = "machine"
+		}
+		fmt.Fprintf(&b, `	<%s name="game%d"><description>Game %d</description><rom name="game%d.rom" size="%d" crc="%08x"/><rom name="nodump%d.rom" size="1" status="nodump"/></%s>
+`, elem, i, i, i, i, i, i, elem)
+	}
+	b.WriteString("</datafile>\n")
+	path := filepath.Join(t.TempDir(), "mame.xml")
+	os.WriteFile(path, []byte(b.String()), 0644)
+
+	var reports []int
+	roms, header, err := ParseDATProgress(path, "ARCADE", func(games int) { reports = append(reports, games) })
+	if err != nil || header != "MAME" {
+		t.Fatalf("parse: %q, %v", header, err)
+	}
+	if len(reports) != n/1000 || reports[len(reports)-1] != n {
+		t.Errorf("progress reported %v", reports)
+	}
+	if len(roms) != 2*n {
+		t.Fatalf("got %d ROMs, want %d", len(roms), 2*n)
+	}
+	for _, i := range []int{0, 1, n - 1} {
+		r := roms[2*i]
+		want := db.DATRom{GameTitle: fmt.Sprintf("game%d", i), Platform: "ARCADE", CRC32: fmt.Sprintf("%08X", i), Size: int64(i)}
+		if r != want {
+			t.Errorf("ROM %d = %+v, want %+v", 2*i, r, want)
+		}
+		if nd := roms[2*i+1]; nd.GameTitle != want.GameTitle || nd.Status != db.StatusNoDump {
+			t.Errorf("ROM %d = %+v, want a nodump of %s", 2*i+1, nd, want.GameTitle)
+		}
+	}
+
+	// Cut short, the DAT fails rather than importing what it got so far
+	os.WriteFile(path, []byte(b.String()[:b.Len()/2]), 0644)
+	if _, _, err := ParseDAT(path, "ARCADE"); err == nil {
+		t.Error("truncated DAT: expected an error")
+	}
+}
+
+func TestStreamDAT(t *testing.T) {
+	// Two concatenated DATs, the second's platform only told by extensions
+	var b strings.Builder
+	b.WriteString("clrmamepro (\n\tname \"Nintendo - Game Boy\"\n)\n\n")
+	for i := range 3000 {
+		fmt.Fprintf(&b, "game (\n\tname \"GB %d\"\n\trom ( name \"GB %d.gb\" size 1 crc %08x )\n)\n\n", i, i, i)
+	}
+	b.WriteString("clrmamepro (\n\tname \"Favorites\"\n)\n\n")
+	b.WriteString("game (\n\tname \"Rockman\"\n\trom ( name \"Rockman.nes\" size 1 crc ffffffff )\n)\n")
+	path := filepath.Join(t.TempDir(), "both.dat")
+	os.WriteFile(path, []byte(b.String()), 0644)
+
+	var roms []db.DATRom
+	var reports []int
+	header, err := StreamDAT(path, "", func(games int) { reports = append(reports, games) }, func(r db.DATRom) error {
+		roms = append(roms, r)
+		return nil
+	})
+	if err != nil || header != "Nintendo - Game Boy + Favorites" {
+		t.Fatalf("stream: %q, %v", header, err)
+	}
+	if !slices.Equal(reports, []int{1000, 2000, 3000, 3001}) {
+		t.Errorf("progress reported %v", reports)
+	}
+	if len(roms) != 3001 || roms[0] != (db.DATRom{GameTitle: "GB 0", Platform: "GB", CRC32: "00000000", Size: 1}) || roms[3000].Platform != "FC" {
+		t.Errorf("got %d ROMs, first %+v, last %+v", len(roms), roms[0], roms[len(roms)-1])
+	}
+
+	// Each ROM is passed on as it is read, and an error stops the reading
+	stop := errors.New("stop")
+	calls := 0
+	reports = nil
+	_, err = StreamDAT(path, "", func(games int) { reports = append(reports, games) }, func(db.DATRom) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 || len(reports) != 0 {
+		t.Errorf("stopped stream: %v after %d ROMs and progress reports %v", err, calls, reports)
+	}
+}
+
+func TestParseClrMamePro(t *testing.T) {
+	// As MAME writes them: unquoted names, no md5, and flags after sha1
+	dat := `clrmamepro (
+	name "MAME"
+	description "MAME 0.261"
+)
+
+game (
+	name 720
+	description "720 Degrees (rev 4)"
+	year 1986
+	manufacturer "Atari Games"
+	rom ( name 136047-3126.7lm size 65536 crc 43abd367 sha1 1b1b4d7e3e2ec5d3df4ee3b0e2e0cd13f0fd85e9 )
+	rom ( name 136047-1121.16s size 32768 crc 7adb5f9a sha1 c7c8ab7c0d8a2e5fd6b3b8f4e6a7e0c9a1b2c3d4 flags baddump )
+	rom ( name 136047-1122.16r size 32768 flags nodump )
+)
+
+game (
+	name "Quote \"Test\" (Japan) [b]"
+	rom ( name "Quote \"Test\" (Japan) [b].zip" size 40976 crc 3337EC46 md5 811B027EAF99C2DEF7B933C5208636DE sha1 FACEE9C577A5262DBE33AC4930BB0B58C8C037F7 status baddump )
+)
+
+game ( name "Inline (USA)" rom ( name "dir/Inline (USA).zip" size 16 crc a ) )
+`
+	path := filepath.Join(t.TempDir(), "mame.dat")
+	os.WriteFile(path, []byte(dat), 0644)
+
+	roms, header, err := ParseDAT(path, "ARCADE")
+	if err != nil || header != "MAME" {
+		t.Fatalf("parse: %q, %v", header, err)
+	}
+	want := []db.DATRom{
+		{GameTitle: "720", Platform: "ARCADE", CRC32: "43ABD367", SHA1: "1B1B4D7E3E2EC5D3DF4EE3B0E2E0CD13F0FD85E9", Size: 65536},
+		{GameTitle: "720", Platform: "ARCADE", CRC32: "7ADB5F9A", SHA1: "C7C8AB7C0D8A2E5FD6B3B8F4E6A7E0C9A1B2C3D4", Size: 32768, Status: "baddump"},
+		{GameTitle: "720", Platform: "ARCADE", Size: 32768, Status: "nodump"},
+		{GameTitle: `Quote "Test" (Japan) [b]`, Platform: "ARCADE", CRC32: "3337EC46", MD5: "811B027EAF99C2DEF7B933C5208636DE", SHA1: "FACEE9C577A5262DBE33AC4930BB0B58C8C037F7", Size: 40976, Status: "baddump"},
+		{GameTitle: "Inline (USA)", Platform: "ARCADE", CRC32: "0000000A", Size: 16},
+	}
+	if !slices.Equal(roms, want) {
+		t.Errorf("roms\n%+v\nwant\n%+v", roms, want)
+	}
+}
+
+func TestParseNoDump(t *testing.T) {
+	dat := `<?xml version="1.0"?>
+<datafile>
+	<header><name>Nintendo - Game Boy</name></header>
+	<game name="Tetris (World)"><rom name="Tetris (World).gb" size="32768" crc="46DF91AD"/></game>
+	<game name="Lost Prototype (Japan)"><rom name="Lost Prototype (Japan).gb" size="0" crc="00000000" status="nodump"/></game>
+	<game name="Glitchy (USA)"><rom name="Glitchy (USA).gb" size="1" crc="00000001" status="baddump"/></game>
+</datafile>`
+	path := filepath.Join(t.TempDir(), "gb.dat")
+	os.WriteFile(path, []byte(dat), 0644)
+
+	roms, _, err := ParseDAT(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The nodump entry's placeholder CRC would match any empty file
+	dumped, skipped := db.DumpedRoms(roms)
+	var titles []string
+	for _, r := range dumped {
+		titles = append(titles, r.GameTitle+" "+r.Status)
+	}
+	if skipped != 1 || !slices.Equal(titles, []string{"Tetris (World) ", "Glitchy (USA) baddump"}) {
+		t.Errorf("dumped ROMs %q, %d skipped", titles, skipped)
+	}
+}
+
+func TestCmpTokens(t *testing.T) {
+	var got []string
+	for _, tok := range cmpTokens(`rom ( name "a \"b\" (c)\d.bin" size 1 crc ff)`) {
+		got = append(got, tok.text)
+	}
+	want := []string{"rom", "(", "name", `a "b" (c)\d.bin`, "size", "1", "crc", "ff", ")"}
+	if !slices.Equal(got, want) {
+		t.Errorf("cmpTokens = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package dat
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PegasusGame is one game: entry of a Pegasus metadata.pegasus.txt file.
+// ReleaseDate and Rating are in gamelist.xml form, as romu stores them
+// ("19850913T000000", "0.8"), and are converted when marshaling. Empty
+// fields are omitted.
+type PegasusGame struct {
+	Title       string
+	Files       []string // relative to the metadata file's directory
+	Developer   string
+	Publisher   string
+	Genre       string
+	Players     string
+	ReleaseDate string
+	Rating      string
+	Desc        string
+}
+
+// MarshalPegasus renders a collection header followed by its games as a
+// metadata.pegasus.txt document
+func MarshalPegasus(collection, shortName string, games []PegasusGame) []byte {
+	var b bytes.Buffer
+	writePegasus(&b, "collection", collection)
+	writePegasus(&b, "shortname", shortName)
+	for _, g := range games {
+		b.WriteByte('\n')
+		writePegasus(&b, "game", g.Title)
+		if len(g.Files) == 1 {
+			writePegasus(&b, "file", g.Files[0])
+		} else if len(g.Files) > 1 {
+			writePegasus(&b, "files", strings.Join(g.Files, "\n"))
+		}
+		writePegasus(&b, "developer", g.Developer)
+		writePegasus(&b, "publisher", g.Publisher)
+		writePegasus(&b, "genre", g.Genre)
+		writePegasus(&b, "players", g.Players)
+		writePegasus(&b, "release", pegasusDate(g.ReleaseDate))
+		writePegasus(&b, "rating", pegasusRating(g.Rating))
+		writePegasus(&b, "description", g.Desc)
+	}
+	return b.Bytes()
+}
+
+// writePegasus writes "key: value", unless value is empty. A value of
+// several lines goes on indented lines after the key, with empty lines,
+// which Pegasus would skip, written as "." to keep paragraphs apart.
+func writePegasus(b *bytes.Buffer, key, value string) {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(value, "\r\n", "\n")), "\n")
+	if lines[0] == "" {
+		return
+	}
+	if len(lines) == 1 {
+		fmt.Fprintf(b, "%s: %s\n", key, lines[0])
+		return
+	}
+	fmt.Fprintf(b, "%s:\n", key)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = "."
+		}
+		fmt.Fprintf(b, "  %s\n", line)
+	}
+}
+
+// pegasusDate turns a gamelist.xml date into Pegasus's YYYY-MM-DD, or ""
+// if it isn't one
+func pegasusDate(s string) string {
+	t, ok := parseGameListDate(s)
+	if !ok {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// pegasusRating turns a gamelist.xml rating from 0 to 1 into a percentage,
+// or "" if it isn't one
+func pegasusRating(s string) string {
+	r, err := strconv.ParseFloat(s, 64)
+	if err != nil || r <= 0 || r > 1 {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%%", r*100)
+}
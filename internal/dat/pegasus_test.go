@@ -0,0 +1,44 @@
+package dat
+
+import "testing"
+
+func TestMarshalPegasus(t *testing.T) {
+	data := MarshalPegasus("Famicom / NES", "fc", []PegasusGame{
+		{
+			Title:       "Super Mario Bros.",
+			Files:       []string{"Super Mario Bros. (World).nes"},
+			Developer:   "Nintendo",
+			Publisher:   "Nintendo",
+			Genre:       "Platform",
+			Players:     "1-2",
+			ReleaseDate: "19850913T000000",
+			Rating:      "0.85",
+			Desc:        "Save the princess.\r\n\r\n  Stomp on Goombas.\n",
+		},
+		{Title: "Disk Set", Files: []string{"Disk 1.fds", "Disk 2.fds"}, ReleaseDate: "1986", Rating: "5"},
+	})
+	want := `collection: Famicom / NES
+shortname: fc
+
+game: Super Mario Bros.
+file: Super Mario Bros. (World).nes
+developer: Nintendo
+publisher: Nintendo
+genre: Platform
+players: 1-2
+release: 1985-09-13
+rating: 85%
+description:
+  Save the princess.
+  .
+  Stomp on Goombas.
+
+game: Disk Set
+files:
+  Disk 1.fds
+  Disk 2.fds
+`
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
@@ -0,0 +1,48 @@
+package dat
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/retronian/romu/internal/db"
+)
+
+// Playlist is a RetroArch .lpl playlist in its JSON format (RetroArch 1.7.6
+// and later)
+type Playlist struct {
+	Version            string            `json:"version"`
+	DefaultCorePath    string            `json:"default_core_path"`
+	DefaultCoreName    string            `json:"default_core_name"`
+	LabelDisplayMode   int               `json:"label_display_mode"`
+	RightThumbnailMode int               `json:"right_thumbnail_mode"`
+	LeftThumbnailMode  int               `json:"left_thumbnail_mode"`
+	SortMode           int               `json:"sort_mode"`
+	Items              []db.PlaylistItem `json:"items"`
+}
+
+// MarshalPlaylist renders items as an indented .lpl playlist
+func MarshalPlaylist(items []db.PlaylistItem) ([]byte, error) {
+	if items == nil {
+		items = []db.PlaylistItem{}
+	}
+	data, err := json.MarshalIndent(Playlist{Version: "1.5", Items: items}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ParsePlaylist reads the items of a RetroArch .lpl playlist. Only the JSON
+// format RetroArch has written since 1.7.6 is supported.
+func ParsePlaylist(path string) ([]db.PlaylistItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pl Playlist
+	if err := json.Unmarshal(data, &pl); err != nil {
+		return nil, fmt.Errorf("parse playlist (only JSON playlists are supported): %w", err)
+	}
+	return pl.Items, nil
+}
@@ -0,0 +1,117 @@
+package dat
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/retronian/romu/internal/db"
+)
+
+func TestMarshalPlaylist(t *testing.T) {
+	data, err := MarshalPlaylist([]db.PlaylistItem{{
+		Path:     "/roms/fc/Pack.zip#a.nes",
+		Label:    "Tom & Jerry",
+		CorePath: "DETECT",
+		CoreName: "DETECT",
+		CRC32:    "0000000A|crc",
+		DBName:   "Nintendo - Nintendo Entertainment System.lpl",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RetroArch reads the playlist's settings and each item's fields by name
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	for _, key := range []string{"version", "default_core_path", "default_core_name", "label_display_mode",
+		"right_thumbnail_mode", "left_thumbnail_mode", "sort_mode"} {
+		if _, ok := doc[key]; !ok {
+			t.Errorf("missing %q", key)
+		}
+	}
+	items, _ := doc["items"].([]any)
+	if len(items) != 1 {
+		t.Fatalf("items: %v", doc["items"])
+	}
+	want := map[string]any{
+		"path":      "/roms/fc/Pack.zip#a.nes",
+		"label":     "Tom & Jerry",
+		"core_path": "DETECT",
+		"core_name": "DETECT",
+		"crc32":     "0000000A|crc",
+		"db_name":   "Nintendo - Nintendo Entertainment System.lpl",
+	}
+	item := items[0].(map[string]any)
+	if len(item) != len(want) {
+		t.Errorf("item has fields %v", item)
+	}
+	for k, v := range want {
+		if item[k] != v {
+			t.Errorf("%s = %v, want %v", k, item[k], v)
+		}
+	}
+
+	// An empty playlist still has an items array
+	data, _ = MarshalPlaylist(nil)
+	doc = nil
+	json.Unmarshal(data, &doc)
+	if items, ok := doc["items"].([]any); !ok || len(items) != 0 {
+		t.Errorf("empty playlist: %s", data)
+	}
+}
+
+func TestParsePlaylist(t *testing.T) {
+	const lpl = `{
+  "version": "1.5",
+  "default_core_path": "",
+  "default_core_name": "",
+  "label_display_mode": 0,
+  "right_thumbnail_mode": 0,
+  "left_thumbnail_mode": 0,
+  "sort_mode": 0,
+  "items": [
+    {
+      "path": "C:\\RetroArch\\roms\\Pack.zip#Tetris (World).gb",
+      "label": "Tetris (World)",
+      "core_path": "DETECT",
+      "core_name": "DETECT",
+      "crc32": "46DF91AD|crc",
+      "db_name": "Nintendo - Game Boy.lpl"
+    },
+    {
+      "path": "/roms/gb/homebrew.gb",
+      "label": "Homebrew",
+      "core_path": "/cores/gambatte_libretro.so",
+      "core_name": "Gambatte",
+      "crc32": "DETECT",
+      "db_name": "Nintendo - Game Boy.lpl",
+      "entry_slot": 0
+    }
+  ]
+}`
+	path := filepath.Join(t.TempDir(), "Nintendo - Game Boy.lpl")
+	os.WriteFile(path, []byte(lpl), 0644)
+	items, err := ParsePlaylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []db.PlaylistItem{
+		{Path: `C:\RetroArch\roms\Pack.zip#Tetris (World).gb`, Label: "Tetris (World)", CorePath: "DETECT", CoreName: "DETECT",
+			CRC32: "46DF91AD|crc", DBName: "Nintendo - Game Boy.lpl"},
+		{Path: "/roms/gb/homebrew.gb", Label: "Homebrew", CorePath: "/cores/gambatte_libretro.so", CoreName: "Gambatte",
+			CRC32: "DETECT", DBName: "Nintendo - Game Boy.lpl"},
+	}
+	if len(items) != len(want) || items[0] != want[0] || items[1] != want[1] {
+		t.Errorf("got %+v, want %+v", items, want)
+	}
+
+	// Playlists from before RetroArch 1.7.6 are plain text
+	os.WriteFile(path, []byte("/roms/gb/tetris.gb\nTetris\nDETECT\nDETECT\n46DF91AD|crc\nNintendo - Game Boy.lpl\n"), 0644)
+	if _, err := ParsePlaylist(path); err == nil {
+		t.Error("expected an error for an old-style playlist")
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLite allows one writer at a time. The driver's busy timeout makes a
+// statement wait for the write lock, but a transaction that read before
+// writing fails with SQLITE_BUSY at once if another connection wrote in the
+// meantime, as waiting could not bring its snapshot up to date. So
+// transactions begin IMMEDIATE (_txlock=immediate), taking the write lock
+// up front, and writes that still find the database busy, e.g. while the
+// server runs a scan, are retried.
+
+// busyRetry is how long a write keeps retrying a busy database
+const busyRetry = 5 * time.Second
+
+// isBusy reports whether err means another connection holds a lock
+func isBusy(err error) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) && (se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked)
+}
+
+// retryBusy calls f until it returns anything but a busy error or busyRetry
+// has passed
+func retryBusy(f func() error) error {
+	deadline := time.Now().Add(busyRetry)
+	wait := 5 * time.Millisecond
+	for {
+		err := f()
+		if !isBusy(err) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(wait)
+		wait = min(wait*2, 100*time.Millisecond)
+	}
+}
+
+// Exec runs a statement, retrying while the database is busy
+func (d *DB) Exec(query string, args ...any) (sql.Result, error) {
+	var res sql.Result
+	err := retryBusy(func() (err error) {
+		res, err = d.DB.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+// Begin starts a write transaction, retrying while another connection
+// holds the write lock
+func (d *DB) Begin() (*sql.Tx, error) {
+	return d.BeginTx(context.Background(), nil)
+}
+
+// BeginTx is Begin for a transaction that is rolled back once ctx is done
+func (d *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	var tx *sql.Tx
+	err := retryBusy(func() (err error) {
+		tx, err = d.DB.BeginTx(ctx, opts)
+		return err
+	})
+	return tx, err
+}
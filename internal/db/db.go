@@ -1,17 +1,36 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/retronian/romu/internal/platform"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
 type DB struct {
 	*sql.DB
+	path     string
+	readOnly bool
+	changes  notifier
+
+	upsertMu   sync.Mutex
+	upsertStmt *sql.Stmt // upsertRomFileSQL, prepared on first use
 }
 
 type RomFile struct {
@@ -33,36 +52,136 @@ type RomFile struct {
 	Genre       *string
 	Players     *string
 	Rating      *string
+	UserRating  int // personal 0-5 stars, 0 = unrated
+	Favorite    bool
 }
 
 type Game struct {
 	ID          int64
 	TitleEN     string
+	TitleJA     string
 	Platform    string
+	DescJA      string
 	Developer   string
 	Publisher   string
 	ReleaseDate string
+	Genre       string
+	Players     string
+	Rating      string
+	Covers      []CoverArt
+}
+
+// CoverArt is an image file recorded for a game
+type CoverArt struct {
+	ImageType string
+	FilePath  string
 }
 
+// Open opens ~/.romu/romu.db, creating it if needed
 func Open() (*DB, error) {
-	home, err := os.UserHomeDir()
+	return OpenPath("")
+}
+
+// OpenPath opens the database at dbPath, or ~/.romu/romu.db if it is empty,
+// creating it and its directory if needed
+func OpenPath(dbPath string) (*DB, error) {
+	if dbPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dbPath = filepath.Join(home, ".romu", "romu.db")
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+	// Transactions take the write lock when they begin (see busy.go), and
+	// statements wait up to 5s for another connection's lock. Foreign keys
+	// are enforced per connection, so they are set here rather than by PRAGMA.
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_txlock=immediate&_busy_timeout=5000&_foreign_keys=on")
 	if err != nil {
 		return nil, err
 	}
-	dir := filepath.Join(home, ".romu")
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := migrate(db); err != nil {
+		db.Close()
 		return nil, err
 	}
-	dbPath := filepath.Join(dir, "romu.db")
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+	slog.Debug("opened database", "path", dbPath)
+	return &DB{DB: db, path: dbPath}, nil
+}
+
+// memoryDBs numbers the databases OpenMemory creates, so each is its own
+var memoryDBs atomic.Int64
+
+// OpenMemory opens a new, empty in-memory database, for tests. It lives as
+// long as its one connection, so it is gone once closed.
+func OpenMemory() (*DB, error) {
+	dsn := fmt.Sprintf("file:romu%d?mode=memory&cache=shared&_txlock=immediate&_foreign_keys=on", memoryDBs.Add(1))
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
 	}
+	// A second connection to the shared cache would see table locks
+	// instead of waiting for a writer
+	db.SetMaxOpenConns(1)
 	if err := migrate(db); err != nil {
 		db.Close()
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{DB: db, path: ":memory:"}, nil
+}
+
+// OpenReadOnly opens ~/.romu/romu.db read-only
+func OpenReadOnly() (*DB, error) {
+	return OpenReadOnlyPath("")
+}
+
+// OpenReadOnlyPath opens an existing database at dbPath, or ~/.romu/romu.db
+// if it is empty, for reading only. The schema is left as it is, so an older
+// romu can browse a database a newer one upgraded, and writes fail.
+func OpenReadOnlyPath(dbPath string) (*DB, error) {
+	if dbPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dbPath = filepath.Join(home, ".romu", "romu.db")
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	uri := &url.URL{Scheme: "file", OmitHost: true, Path: dbPath, RawQuery: "mode=ro&_busy_timeout=5000"}
+	db, err := sql.Open("sqlite3", uri.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	slog.Debug("opened database read-only", "path", dbPath)
+	return &DB{DB: db, path: dbPath, readOnly: true}, nil
+}
+
+// Path returns the database file location
+func (d *DB) Path() string {
+	return d.path
+}
+
+// ReadOnly reports whether the database was opened by OpenReadOnly
+func (d *DB) ReadOnly() bool {
+	return d.readOnly
+}
+
+// Close closes the prepared statements and then the database
+func (d *DB) Close() error {
+	d.upsertMu.Lock()
+	if d.upsertStmt != nil {
+		d.upsertStmt.Close()
+		d.upsertStmt = nil
+	}
+	d.upsertMu.Unlock()
+	return d.DB.Close()
 }
 
 func migrate(db *sql.DB) error {
@@ -89,60 +208,350 @@ func migrate(db *sql.DB) error {
 		hash_md5 TEXT,
 		hash_sha1 TEXT,
 		platform TEXT NOT NULL,
-		game_id INTEGER REFERENCES games(id),
+		game_id INTEGER REFERENCES games(id) ON DELETE SET NULL,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE TABLE IF NOT EXISTS cover_arts (
 		id INTEGER PRIMARY KEY,
-		game_id INTEGER REFERENCES games(id),
+		game_id INTEGER REFERENCES games(id) ON DELETE CASCADE,
 		image_type TEXT NOT NULL,
 		file_path TEXT NOT NULL,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+	CREATE TABLE IF NOT EXISTS dat_roms (
+		id INTEGER PRIMARY KEY,
+		game_title TEXT NOT NULL,
+		platform TEXT NOT NULL,
+		hash_crc32 TEXT NOT NULL DEFAULT '',
+		hash_md5 TEXT NOT NULL DEFAULT '',
+		hash_sha1 TEXT NOT NULL DEFAULT '',
+		size INTEGER,
+		UNIQUE(platform, game_title, hash_crc32, hash_md5, hash_sha1)
+	);
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE
+	);
+	CREATE TABLE IF NOT EXISTS rom_file_tags (
+		rom_file_id INTEGER NOT NULL REFERENCES rom_files(id) ON DELETE CASCADE,
+		tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		PRIMARY KEY (rom_file_id, tag_id)
+	);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	// Add columns if missing
+	addColumn(db, `ALTER TABLE games ADD COLUMN players TEXT`)
+	addColumn(db, `ALTER TABLE games ADD COLUMN rating TEXT`)
+	addColumn(db, `ALTER TABLE rom_files ADD COLUMN modtime INTEGER`)
+	addColumn(db, `ALTER TABLE rom_files ADD COLUMN user_rating INTEGER`)
+	addColumn(db, `ALTER TABLE rom_files ADD COLUMN favorite BOOLEAN NOT NULL DEFAULT 0`)
+	// Hashes of the data after a copier/emulator header, for ROMs that have one
+	addColumn(db, `ALTER TABLE rom_files ADD COLUMN hash_crc32_nohdr TEXT`)
+	addColumn(db, `ALTER TABLE rom_files ADD COLUMN hash_md5_nohdr TEXT`)
+	addColumn(db, `ALTER TABLE rom_files ADD COLUMN hash_sha1_nohdr TEXT`)
+	// Set when the platform was reassigned by hand, so rescans keep it
+	addColumn(db, `ALTER TABLE rom_files ADD COLUMN platform_override BOOLEAN NOT NULL DEFAULT 0`)
+	addColumn(db, `ALTER TABLE dat_roms ADD COLUMN status TEXT NOT NULL DEFAULT ''`)
+	if err := upgrade(db); err != nil {
+		return err
+	}
+
+	// Indexes and triggers come last, as upgrades that rebuild a table
+	// drop them
+	_, err := db.Exec(`
+	CREATE TRIGGER IF NOT EXISTS rom_files_delete_tags AFTER DELETE ON rom_files BEGIN
+		DELETE FROM rom_file_tags WHERE rom_file_id = OLD.id;
+	END;
 	CREATE INDEX IF NOT EXISTS idx_rom_files_crc32 ON rom_files(hash_crc32);
 	CREATE INDEX IF NOT EXISTS idx_rom_files_md5 ON rom_files(hash_md5);
 	CREATE INDEX IF NOT EXISTS idx_rom_files_sha1 ON rom_files(hash_sha1);
 	CREATE INDEX IF NOT EXISTS idx_games_platform ON games(platform);
-	`
-	_, err := db.Exec(schema)
+	CREATE INDEX IF NOT EXISTS idx_dat_roms_crc32 ON dat_roms(hash_crc32);
+	CREATE INDEX IF NOT EXISTS idx_dat_roms_md5 ON dat_roms(hash_md5);
+	CREATE INDEX IF NOT EXISTS idx_dat_roms_sha1 ON dat_roms(hash_sha1);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_cover_arts_game_type ON cover_arts(game_id, image_type);
+	CREATE INDEX IF NOT EXISTS idx_rom_files_crc32_nohdr ON rom_files(hash_crc32_nohdr);
+	CREATE INDEX IF NOT EXISTS idx_rom_files_md5_nohdr ON rom_files(hash_md5_nohdr);
+	CREATE INDEX IF NOT EXISTS idx_rom_files_sha1_nohdr ON rom_files(hash_sha1_nohdr);
+	`)
+	return err
+}
+
+// addColumn runs an ALTER TABLE ... ADD COLUMN, which fails harmlessly when
+// an earlier version already added the column. Other failures are logged
+// rather than returned, so an old database still opens.
+func addColumn(db *sql.DB, stmt string) {
+	_, err := db.Exec(stmt)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		slog.Warn("database migration failed", "stmt", stmt, "err", err)
+	}
+}
+
+// Hex widths of the hash columns
+const (
+	CRC32Width = 8
+	MD5Width   = 32
+	SHA1Width  = 40
+)
+
+// NormalizeHash returns h as fixed-width uppercase hex: surrounding space and
+// a 0x prefix are dropped and values shorter than width are left-padded with
+// zeros (DATs sometimes strip leading zeros from CRC32s). Empty stays empty.
+func NormalizeHash(h string, width int) string {
+	h = strings.TrimSpace(h)
+	if len(h) > 2 && (h[:2] == "0x" || h[:2] == "0X") {
+		h = h[2:]
+	}
+	if h == "" {
+		return ""
+	}
+	h = strings.ToUpper(h)
+	if len(h) < width {
+		h = strings.Repeat("0", width-len(h)) + h
+	}
+	return h
+}
+
+const upsertRomFileSQL = `
+	INSERT INTO rom_files (path, filename, size, modtime, hash_crc32, hash_md5, hash_sha1,
+		hash_crc32_nohdr, hash_md5_nohdr, hash_sha1_nohdr, platform, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(path) DO UPDATE SET
+		filename=excluded.filename, size=excluded.size, modtime=excluded.modtime,
+		hash_crc32=excluded.hash_crc32, hash_md5=excluded.hash_md5, hash_sha1=excluded.hash_sha1,
+		hash_crc32_nohdr=excluded.hash_crc32_nohdr, hash_md5_nohdr=excluded.hash_md5_nohdr, hash_sha1_nohdr=excluded.hash_sha1_nohdr,
+		platform=CASE WHEN rom_files.platform_override THEN rom_files.platform ELSE excluded.platform END,
+		updated_at=CURRENT_TIMESTAMP
+`
+
+// RomFileInput is a scanned file to be recorded in rom_files. ModTime is the
+// file's modification time in Unix seconds and is used to detect unchanged
+// files on rescan. The NoHdr hashes cover the data after a copier/emulator
+// header and are empty when the file has none.
+type RomFileInput struct {
+	Path       string
+	Filename   string
+	Size       int64
+	ModTime    int64
+	CRC32      string
+	MD5        string
+	SHA1       string
+	CRC32NoHdr string
+	MD5NoHdr   string
+	SHA1NoHdr  string
+	Platform   string
+}
+
+func (f RomFileInput) args() []interface{} {
+	return []interface{}{f.Path, f.Filename, f.Size, f.ModTime,
+		NormalizeHash(f.CRC32, CRC32Width), NormalizeHash(f.MD5, MD5Width), NormalizeHash(f.SHA1, SHA1Width),
+		nullIfEmpty(NormalizeHash(f.CRC32NoHdr, CRC32Width)), nullIfEmpty(NormalizeHash(f.MD5NoHdr, MD5Width)), nullIfEmpty(NormalizeHash(f.SHA1NoHdr, SHA1Width)),
+		f.Platform}
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// upsertRomFile returns upsertRomFileSQL prepared once for the DB, so a
+// scan doesn't parse it again for every file
+func (d *DB) upsertRomFile() (*sql.Stmt, error) {
+	d.upsertMu.Lock()
+	defer d.upsertMu.Unlock()
+	if d.upsertStmt == nil {
+		stmt, err := d.Prepare(upsertRomFileSQL)
+		if err != nil {
+			return nil, err
+		}
+		d.upsertStmt = stmt
+	}
+	return d.upsertStmt, nil
+}
+
+// UpsertRomFile records a single scanned file
+func (d *DB) UpsertRomFile(f RomFileInput) error {
+	stmt, err := d.upsertRomFile()
 	if err != nil {
 		return err
 	}
-	// Add columns if missing (ignore errors = already exists)
-	db.Exec(`ALTER TABLE games ADD COLUMN players TEXT`)
-	db.Exec(`ALTER TABLE games ADD COLUMN rating TEXT`)
-	return nil
+	err = retryBusy(func() error {
+		_, err := stmt.Exec(f.args()...)
+		return err
+	})
+	return d.changed(err)
 }
 
-func (d *DB) UpsertRomFile(path, filename string, size int64, crc32, md5, sha1, platform string) error {
-	_, err := d.Exec(`
-		INSERT INTO rom_files (path, filename, size, hash_crc32, hash_md5, hash_sha1, platform, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-		ON CONFLICT(path) DO UPDATE SET
-			filename=excluded.filename, size=excluded.size,
-			hash_crc32=excluded.hash_crc32, hash_md5=excluded.hash_md5, hash_sha1=excluded.hash_sha1,
-			platform=excluded.platform, updated_at=CURRENT_TIMESTAMP
-	`, path, filename, size, crc32, md5, sha1, platform)
-	return err
+// UpsertRomFilesBatch records many scanned files in one transaction with
+// the statement UpsertRomFile prepares. If any insert fails the whole batch
+// is rolled back.
+func (d *DB) UpsertRomFilesBatch(files []RomFileInput) error {
+	if len(files) == 0 {
+		return nil
+	}
+	prepared, err := d.upsertRomFile()
+	if err != nil {
+		return err
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := tx.Stmt(prepared)
+	defer stmt.Close()
+
+	for _, f := range files {
+		if _, err := stmt.Exec(f.args()...); err != nil {
+			return fmt.Errorf("upsert %s: %w", f.Path, err)
+		}
+	}
+	return d.changed(tx.Commit())
 }
 
-func (d *DB) ListRomFiles() ([]RomFile, error) {
-	rows, err := d.Query(`
-		SELECT r.id, r.path, r.filename, r.size, r.hash_crc32, r.hash_md5, r.hash_sha1, r.platform, r.game_id, g.title_en, g.title_ja,
-			g.description_ja, g.developer, g.publisher, g.release_date, g.genre, g.players, g.rating
-		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
-		ORDER BY r.platform, r.filename
-	`)
+// RomFileState is the size and modification time recorded at the last scan
+type RomFileState struct {
+	Size    int64
+	ModTime int64
+}
+
+// GetRomFileStates returns the recorded size and modtime of every rom_file keyed by path
+func (d *DB) GetRomFileStates() (map[string]RomFileState, error) {
+	rows, err := d.Query(`SELECT path, COALESCE(size, 0), COALESCE(modtime, 0) FROM rom_files`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := make(map[string]RomFileState)
+	for rows.Next() {
+		var path string
+		var st RomFileState
+		if err := rows.Scan(&path, &st.Size, &st.ModTime); err != nil {
+			return nil, err
+		}
+		states[path] = st
+	}
+	return states, rows.Err()
+}
+
+// RomFileLocation identifies a rom_file row by id and path
+type RomFileLocation struct {
+	ID   int64
+	Path string
+}
+
+// FindRomFilesBySHA1 returns the rom_files recorded with the given SHA1
+func (d *DB) FindRomFilesBySHA1(sha1 string) ([]RomFileLocation, error) {
+	rows, err := d.Query(`SELECT id, path FROM rom_files WHERE hash_sha1 = ? ORDER BY id`, sha1)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	var locs []RomFileLocation
+	for rows.Next() {
+		var l RomFileLocation
+		if err := rows.Scan(&l.ID, &l.Path); err != nil {
+			return nil, err
+		}
+		locs = append(locs, l)
+	}
+	return locs, rows.Err()
+}
+
+// ReassignPlatform changes the platform of a rom_file, or returns
+// ErrNotFound. A link to a game of another platform no longer holds and is
+// cleared. Rescans keep the new platform rather than the detected one.
+func (d *DB) ReassignPlatform(romID int64, platform string) error {
+	n, err := d.reassign(platform, `id = ?`, romID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return d.changed(nil)
+}
+
+// reassign is the UPDATE behind ReassignPlatform and BulkReassignPlatform;
+// it moves the rom_files matching where and returns how many changed
+func (d *DB) reassign(platform, where string, args ...interface{}) (int, error) {
+	res, err := d.Exec(`UPDATE rom_files SET platform = ?, platform_override = 1,
+			game_id = CASE WHEN game_id IN (SELECT id FROM games WHERE platform != ?) THEN NULL ELSE game_id END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE `+where, append([]interface{}{platform, platform}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// SetRomPlatform is ReassignPlatform
+func (d *DB) SetRomPlatform(romID int64, platform string) error {
+	return d.ReassignPlatform(romID, platform)
+}
+
+// ReassignFilter selects the rom_files BulkReassignPlatform moves. At least
+// one field must be set.
+type ReassignFilter struct {
+	Platform   string // current platform
+	PathPrefix string // a directory; files anywhere below it match
+}
+
+// BulkReassignPlatform moves every rom_file matching f to platform in one
+// statement, clearing links to games of other platforms like
+// ReassignPlatform, and returns how many rom_files changed
+func (d *DB) BulkReassignPlatform(f ReassignFilter, platform string) (int, error) {
+	where := `platform != ?`
+	args := []interface{}{platform}
+	if f.Platform == "" && f.PathPrefix == "" {
+		return 0, errors.New("reassign: no filter given")
+	}
+	if f.Platform != "" {
+		where += ` AND platform = ?`
+		args = append(args, f.Platform)
+	}
+	if f.PathPrefix != "" {
+		// Paths below dir sort between "dir/" and "dir0" ('0' follows '/')
+		dir := strings.TrimSuffix(f.PathPrefix, string(filepath.Separator))
+		where += ` AND path >= ? AND path < ?`
+		args = append(args, dir+string(filepath.Separator), dir+string(filepath.Separator+1))
+	}
+
+	n, err := d.reassign(platform, where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return n, d.changed(nil)
+}
+
+// RelocateRomFile moves a rom_file to a new path in place, keeping its game link
+func (d *DB) RelocateRomFile(oldID int64, newPath, newFilename string) error {
+	_, err := d.Exec(`UPDATE rom_files SET path = ?, filename = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		newPath, newFilename, oldID)
+	return d.changed(err)
+}
+
+// romFileColumns are the rom_files/games columns read by scanRomFiles, for
+// queries over "rom_files r LEFT JOIN games g"
+const romFileColumns = `r.id, r.path, r.filename, r.size, r.hash_crc32, r.hash_md5, r.hash_sha1, r.platform, r.game_id, g.title_en, g.title_ja,
+	g.description_ja, g.developer, g.publisher, g.release_date, g.genre, g.players, g.rating,
+	COALESCE(r.user_rating, 0), COALESCE(r.favorite, 0)`
+
+func scanRomFiles(rows *sql.Rows) ([]RomFile, error) {
 	defer rows.Close()
 	var files []RomFile
 	for rows.Next() {
 		var f RomFile
 		if err := rows.Scan(&f.ID, &f.Path, &f.Filename, &f.Size, &f.HashCRC32, &f.HashMD5, &f.HashSHA1, &f.Platform, &f.GameID, &f.TitleEN, &f.TitleJA,
-			&f.DescJA, &f.Developer, &f.Publisher, &f.ReleaseDate, &f.Genre, &f.Players, &f.Rating); err != nil {
+			&f.DescJA, &f.Developer, &f.Publisher, &f.ReleaseDate, &f.Genre, &f.Players, &f.Rating,
+			&f.UserRating, &f.Favorite); err != nil {
 			return nil, err
 		}
 		files = append(files, f)
@@ -150,6 +559,108 @@ func (d *DB) ListRomFiles() ([]RomFile, error) {
 	return files, rows.Err()
 }
 
+func (d *DB) ListRomFiles() ([]RomFile, error) {
+	rows, err := d.Query(`SELECT ` + romFileColumns + `
+		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
+		ORDER BY r.platform, r.filename
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRomFiles(rows)
+}
+
+// ListFilter narrows ListRomFilesFiltered results. Zero fields don't filter.
+type ListFilter struct {
+	Platform string
+	Since    time.Time // first recorded at or after
+}
+
+func (f ListFilter) where() (string, []interface{}) {
+	where := `FROM rom_files r LEFT JOIN games g ON r.game_id = g.id WHERE 1=1`
+	var args []interface{}
+	if f.Platform != "" {
+		where += ` AND r.platform = ?`
+		args = append(args, f.Platform)
+	}
+	if !f.Since.IsZero() {
+		// created_at is SQLite's CURRENT_TIMESTAMP: UTC "YYYY-MM-DD HH:MM:SS"
+		where += ` AND r.created_at >= ?`
+		args = append(args, f.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return where, args
+}
+
+// ListRomFilesFiltered lists rom_files matching f, ordered like ListRomFiles
+func (d *DB) ListRomFilesFiltered(f ListFilter) ([]RomFile, error) {
+	where, args := f.where()
+	rows, err := d.Query(`SELECT `+romFileColumns+` `+where+` ORDER BY r.platform, r.filename`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRomFiles(rows)
+}
+
+// ListRomFilesPaged returns up to limit rom_files matching f after skipping
+// offset, along with the total number matching. A negative limit returns
+// everything after offset.
+func (d *DB) ListRomFilesPaged(f ListFilter, limit, offset int) ([]RomFile, int, error) {
+	where, args := f.where()
+	var total int
+	if err := d.QueryRow("SELECT COUNT(*) "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	selectArgs := append(args, limit, offset)
+	rows, err := d.Query(`SELECT `+romFileColumns+` `+where+` ORDER BY r.platform, r.filename LIMIT ? OFFSET ?`, selectArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	files, err := scanRomFiles(rows)
+	return files, total, err
+}
+
+// ListRomFilesSince lists rom_files first recorded at or after t
+func (d *DB) ListRomFilesSince(t time.Time) ([]RomFile, error) {
+	return d.ListRomFilesFiltered(ListFilter{Since: t})
+}
+
+// ListRomFilesByPlatform lists one platform's rom_files
+func (d *DB) ListRomFilesByPlatform(platform string) ([]RomFile, error) {
+	return d.ListRomFilesFiltered(ListFilter{Platform: platform})
+}
+
+// TopBySize returns the limit largest rom_files, biggest first. An empty
+// platform means all platforms.
+func (d *DB) TopBySize(platform string, limit int) ([]RomFile, error) {
+	rows, err := d.Query(`SELECT `+romFileColumns+`
+		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
+		WHERE ? = '' OR r.platform = ?
+		ORDER BY r.size DESC, r.filename LIMIT ?`, platform, platform, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanRomFiles(rows)
+}
+
+// GetRomFile returns a rom_file with its game's fields, or ErrNotFound
+func (d *DB) GetRomFile(id int64) (*RomFile, error) {
+	rows, err := d.Query(`SELECT `+romFileColumns+`
+		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
+		WHERE r.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	files, err := scanRomFiles(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrNotFound
+	}
+	return &files[0], nil
+}
+
 func (d *DB) InsertGame(titleEN, platform, crc32, md5, sha1 string, size int64) (int64, error) {
 	res, err := d.Exec(`
 		INSERT INTO games (title_en, platform) VALUES (?, ?)
@@ -178,94 +689,331 @@ type DATRom struct {
 	MD5       string
 	SHA1      string
 	Size      int64
+	Status    string // StatusNoDump, StatusBadDump, or "" for a good dump
+}
+
+// DAT ROM statuses. A nodump ROM's hashes, if it has any, are placeholders
+// that would match unrelated files; a baddump's are of a real, if flawed,
+// dump.
+const (
+	StatusNoDump  = "nodump"
+	StatusBadDump = "baddump"
+)
+
+// DumpedRoms returns roms without those never dumped, and how many it left
+// out. ImportDATGames would still create their games; matching skips them
+// either way.
+func DumpedRoms(roms []DATRom) ([]DATRom, int) {
+	dumped := make([]DATRom, 0, len(roms))
+	for _, r := range roms {
+		if r.Status != StatusNoDump {
+			dumped = append(dumped, r)
+		}
+	}
+	return dumped, len(roms) - len(dumped)
 }
 
 func (d *DB) ImportDATGames(roms []DATRom) (int, error) {
-	tx, err := d.Begin()
+	return d.ImportDATGamesContext(context.Background(), roms)
+}
+
+// ImportDATGamesContext is ImportDATGames, giving up and rolling back once
+// ctx is done
+func (d *DB) ImportDATGamesContext(ctx context.Context, roms []DATRom) (int, error) {
+	return d.ImportDATGamesFunc(ctx, func(add func(DATRom) error) error {
+		for _, r := range roms {
+			if err := add(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+}
+
+// importProgressEvery is how many ROM entries ImportDATGamesFunc imports
+// between progress reports
+const importProgressEvery = 1000
+
+// ImportDATGamesFunc is ImportDATGamesContext for DATs too big to hold in
+// memory: read is called with add, which imports each ROM read, such as by
+// dat.StreamDAT, all in one transaction that is rolled back if read fails.
+// progress, if set, is called with the number of ROM entries imported so far
+// every so many entries and once all are, as a big DAT takes minutes.
+func (d *DB) ImportDATGamesFunc(ctx context.Context, read func(add func(DATRom) error) error, progress func(done int)) (int, error) {
+	tx, err := d.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
 	defer tx.Rollback()
 
-	count := 0
-	for _, r := range roms {
-		// Insert game if not exists
+	count, done := 0, 0
+	err = read(func(r DATRom) error {
+		added, err := importDATRom(ctx, tx, r)
+		if err != nil {
+			return err
+		}
+		if added {
+			count++
+		}
+		if done++; progress != nil && done%importProgressEvery == 0 {
+			progress(done)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if progress != nil && done%importProgressEvery != 0 {
+		progress(done)
+	}
+	return count, d.changed(tx.Commit())
+}
+
+// importDATRom stores r and, unless there is one, its game, reporting
+// whether it added the game
+func importDATRom(ctx context.Context, tx *sql.Tx, r DATRom) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	added := false
+	// Insert game if not exists
+	var gameID int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM games WHERE title_en = ? AND platform = ?`, r.GameTitle, r.Platform).Scan(&gameID)
+	if err == sql.ErrNoRows {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO games (title_en, platform) VALUES (?, ?)`, r.GameTitle, r.Platform); err != nil {
+			return false, fmt.Errorf("insert game %q: %w", r.GameTitle, err)
+		}
+		added = true
+	} else if err != nil {
+		return false, err
+	}
+
+	// Keep the ROM hashes so matching can run later without the DAT file
+	_, err = tx.ExecContext(ctx, `INSERT OR IGNORE INTO dat_roms (game_title, platform, hash_crc32, hash_md5, hash_sha1, size, status) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.GameTitle, r.Platform, r.CRC32, r.MD5, r.SHA1, r.Size, r.Status)
+	if err != nil {
+		return added, fmt.Errorf("insert dat rom %q: %w", r.GameTitle, err)
+	}
+	return added, nil
+}
+
+// GetDATRoms returns the ROM entries stored by ImportDATGames, optionally
+// filtered by platform
+func (d *DB) GetDATRoms(platform string) ([]DATRom, error) {
+	query := `SELECT game_title, platform, hash_crc32, hash_md5, hash_sha1, COALESCE(size, 0), status FROM dat_roms`
+	args := []interface{}{}
+	if platform != "" {
+		query += ` WHERE platform = ?`
+		args = append(args, platform)
+	}
+	rows, err := d.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roms []DATRom
+	for rows.Next() {
+		var r DATRom
+		if err := rows.Scan(&r.GameTitle, &r.Platform, &r.CRC32, &r.MD5, &r.SHA1, &r.Size, &r.Status); err != nil {
+			return nil, err
+		}
+		roms = append(roms, r)
+	}
+	return roms, rows.Err()
+}
+
+// gameListKeys returns the names a gamelist.xml entry may refer to a
+// rom_file by: the basename of a plain file, and for ZIP entries (stored as
+// "archive.zip/dir/inner.ext") both the archive name and the inner basename.
+func gameListKeys(filename string) []string {
+	archive, inner, ok := strings.Cut(filename, "/")
+	if !ok {
+		return []string{filename}
+	}
+	keys := []string{archive}
+	if base := path.Base(inner); base != archive {
+		keys = append(keys, base)
+	}
+	return keys
+}
+
+// MatchByGameList matches rom_files to games using filename from gamelist.xml.
+// An entry's ROMs already linked to a game, e.g. by DAT, give that game the
+// entry's title_ja and metadata; otherwise the game with the entry's
+// title_ja is used, or created. All of the entry's ROMs are linked to it.
+func (d *DB) MatchByGameList(entries []GameListEntry, platform string) (created int, matched int, err error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Index the platform's rom_files by every name a gamelist may use for
+	// them, noting the games they are linked to
+	rows, err := tx.Query(`SELECT id, filename, game_id FROM rom_files WHERE platform = ?`, platform)
+	if err != nil {
+		return 0, 0, err
+	}
+	byName := map[string][]int64{}
+	linked := map[int64]int64{} // rom_file ID -> game ID
+	for rows.Next() {
+		var id int64
+		var filename string
+		var gameID sql.NullInt64
+		if err := rows.Scan(&id, &filename, &gameID); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		for _, key := range gameListKeys(filename) {
+			byName[key] = append(byName[key], id)
+		}
+		if gameID.Valid {
+			linked[id] = gameID.Int64
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, e := range entries {
+		romIDs := byName[path.Base(filepath.ToSlash(e.Filename))]
+		if len(romIDs) == 0 {
+			continue
+		}
+
+		// Use the game a ROM is linked to, else find or create one
 		var gameID int64
-		err := tx.QueryRow(`SELECT id FROM games WHERE title_en = ? AND platform = ?`, r.GameTitle, r.Platform).Scan(&gameID)
-		if err == sql.ErrNoRows {
-			res, err := tx.Exec(`INSERT INTO games (title_en, platform) VALUES (?, ?)`, r.GameTitle, r.Platform)
+		found := false
+		for _, rid := range romIDs {
+			if gameID, found = linked[rid]; found {
+				break
+			}
+		}
+		if !found {
+			found = tx.QueryRow(`SELECT id FROM games WHERE title_ja = ? AND platform = ?`, e.Name, platform).Scan(&gameID) == nil
+		}
+		if !found {
+			res, err := tx.Exec(`INSERT INTO games (title_ja, platform, description_ja, developer, publisher, release_date, genre, players, rating) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				e.Name, platform, e.Desc, e.Developer, e.Publisher, e.ReleaseDate, e.Genre, e.Players, e.Rating)
 			if err != nil {
-				return 0, fmt.Errorf("insert game %q: %w", r.GameTitle, err)
+				return 0, 0, fmt.Errorf("insert game %q: %w", e.Name, err)
 			}
 			gameID, _ = res.LastInsertId()
-			count++
-		} else if err != nil {
-			return 0, err
+			created++
+		} else {
+			// Update metadata on existing game
+			tx.Exec(`UPDATE games SET title_ja=COALESCE(NULLIF(?, ''), title_ja), description_ja=COALESCE(NULLIF(?, ''), description_ja), developer=COALESCE(NULLIF(?, ''), developer), publisher=COALESCE(NULLIF(?, ''), publisher), release_date=COALESCE(NULLIF(?, ''), release_date), genre=COALESCE(NULLIF(?, ''), genre), players=COALESCE(NULLIF(?, ''), players), rating=COALESCE(NULLIF(?, ''), rating), updated_at=CURRENT_TIMESTAMP WHERE id=?`,
+				e.Name, e.Desc, e.Developer, e.Publisher, e.ReleaseDate, e.Genre, e.Players, e.Rating, gameID)
+		}
+
+		// Keep the gamelist's media references
+		for _, m := range []struct{ imageType, path string }{
+			{"image", e.Image}, {"thumbnail", e.Thumbnail}, {"marquee", e.Marquee},
+		} {
+			if m.path == "" {
+				continue
+			}
+			if _, err := tx.Exec(setCoverArtSQL, gameID, m.imageType, m.path); err != nil {
+				return 0, 0, fmt.Errorf("cover art for %q: %w", e.Name, err)
+			}
+		}
+
+		// Link rom_files to game
+		for _, rid := range romIDs {
+			_, err = tx.Exec(`UPDATE rom_files SET game_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, gameID, rid)
+			if err != nil {
+				return 0, 0, err
+			}
+			linked[rid] = gameID
+			matched++
 		}
 	}
 
-	return count, tx.Commit()
+	return created, matched, d.changed(tx.Commit())
 }
 
-// MatchByGameList matches rom_files to games using filename from gamelist.xml
-// It creates games with title_ja and links them to rom_files by filename match.
-func (d *DB) MatchByGameList(entries []GameListEntry, platform string) (created int, matched int, err error) {
+// MatchByPlaylist links a platform's unmatched rom_files to games named by
+// RetroArch playlist items. Items find their ROMs by CRC32, or else by file
+// name as in MatchByGameList, the inner one for "archive.zip#inner" paths.
+// Games are found by the item's label as English or Japanese title, or
+// created with it as English title.
+func (d *DB) MatchByPlaylist(items []PlaylistItem, platform string) (created int, matched int, err error) {
 	tx, err := d.Begin()
 	if err != nil {
 		return 0, 0, err
 	}
 	defer tx.Rollback()
 
-	for _, e := range entries {
-		// Find rom_files matching this filename and platform
-		// Match exact filename, or "zipname/inner" pattern, or path containing the zip name
-		rows, err := tx.Query(
-			`SELECT id FROM rom_files WHERE platform = ? AND (filename = ? OR filename LIKE ? OR filename LIKE ?)`,
-			platform, e.Filename, "%/"+e.Filename, e.Filename+"/%",
-		)
-		if err != nil {
+	rows, err := tx.Query(`SELECT id, filename, COALESCE(hash_crc32, '') FROM rom_files WHERE platform = ? AND game_id IS NULL`, platform)
+	if err != nil {
+		return 0, 0, err
+	}
+	byCRC := map[string][]int64{}
+	byName := map[string][]int64{}
+	for rows.Next() {
+		var id int64
+		var filename, crc string
+		if err := rows.Scan(&id, &filename, &crc); err != nil {
+			rows.Close()
 			return 0, 0, err
 		}
-		var romIDs []int64
-		for rows.Next() {
-			var id int64
-			rows.Scan(&id)
-			romIDs = append(romIDs, id)
+		if crc != "" {
+			byCRC[crc] = append(byCRC[crc], id)
 		}
-		rows.Close()
+		for _, key := range gameListKeys(filename) {
+			byName[key] = append(byName[key], id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
 
+	linked := map[int64]bool{}
+	for _, item := range items {
+		var romIDs []int64
+		if crc, _, _ := strings.Cut(item.CRC32, "|"); crc != "" && crc != "DETECT" {
+			romIDs = byCRC[strings.ToUpper(crc)]
+		}
 		if len(romIDs) == 0 {
+			// Playlists written on Windows use backslashes
+			file, inner, ok := strings.Cut(strings.ReplaceAll(item.Path, `\`, "/"), "#")
+			if ok {
+				romIDs = byName[path.Base(inner)]
+			}
+			if len(romIDs) == 0 {
+				romIDs = byName[path.Base(file)]
+			}
+		}
+		romIDs = slices.DeleteFunc(slices.Clone(romIDs), func(id int64) bool { return linked[id] })
+		if len(romIDs) == 0 || item.Label == "" {
 			continue
 		}
 
-		// Find or create game
 		var gameID int64
-		err = tx.QueryRow(`SELECT id FROM games WHERE title_ja = ? AND platform = ?`, e.Name, platform).Scan(&gameID)
-		if err != nil {
-			res, err := tx.Exec(`INSERT INTO games (title_ja, platform, description_ja, developer, publisher, release_date, genre, players, rating) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-				e.Name, platform, e.Desc, e.Developer, e.Publisher, e.ReleaseDate, e.Genre, e.Players, e.Rating)
+		err = tx.QueryRow(`SELECT id FROM games WHERE (title_en = ? OR title_ja = ?) AND platform = ? ORDER BY id LIMIT 1`, item.Label, item.Label, platform).Scan(&gameID)
+		if errors.Is(err, sql.ErrNoRows) {
+			res, err := tx.Exec(`INSERT INTO games (title_en, platform) VALUES (?, ?)`, item.Label, platform)
 			if err != nil {
-				return 0, 0, fmt.Errorf("insert game %q: %w", e.Name, err)
+				return 0, 0, fmt.Errorf("insert game %q: %w", item.Label, err)
 			}
 			gameID, _ = res.LastInsertId()
 			created++
-		} else {
-			// Update metadata on existing game
-			tx.Exec(`UPDATE games SET description_ja=COALESCE(NULLIF(?, ''), description_ja), developer=COALESCE(NULLIF(?, ''), developer), publisher=COALESCE(NULLIF(?, ''), publisher), release_date=COALESCE(NULLIF(?, ''), release_date), genre=COALESCE(NULLIF(?, ''), genre), players=COALESCE(NULLIF(?, ''), players), rating=COALESCE(NULLIF(?, ''), rating), updated_at=CURRENT_TIMESTAMP WHERE id=?`,
-				e.Desc, e.Developer, e.Publisher, e.ReleaseDate, e.Genre, e.Players, e.Rating, gameID)
+		} else if err != nil {
+			return 0, 0, err
 		}
 
-		// Link rom_files to game
 		for _, rid := range romIDs {
-			_, err = tx.Exec(`UPDATE rom_files SET game_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, gameID, rid)
-			if err != nil {
+			if _, err := tx.Exec(`UPDATE rom_files SET game_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, gameID, rid); err != nil {
 				return 0, 0, err
 			}
+			linked[rid] = true
 			matched++
 		}
 	}
 
-	return created, matched, tx.Commit()
+	return created, matched, d.changed(tx.Commit())
 }
 
 // GameListEntry for import
@@ -279,11 +1027,15 @@ type GameListEntry struct {
 	Genre       string
 	Players     string
 	Rating      string
+	Image       string // media paths, stored in cover_arts
+	Thumbnail   string
+	Marquee     string
 }
 
 // ExportGameListEntry holds data for gamelist.xml export
 type ExportGameListEntry struct {
-	Path        string
+	Path        string // relative to the platform folder, see gameListPath
+	File        string // the ROM on disk; the archive for archive entries
 	Name        string
 	Desc        string
 	ReleaseDate string
@@ -292,16 +1044,61 @@ type ExportGameListEntry struct {
 	Genre       string
 	Players     string
 	Rating      string
+	Image       string
+	Thumbnail   string
+	Marquee     string
+	Boxart      string // from fetch-covers
+}
+
+// archiveExts are the archives whose entries are stored as
+// "archive.ext/inner/path"
+var archiveExts = []string{".zip", ".7z"}
+
+// SplitArchivePath splits the rom_file path of an archive entry, stored as
+// "archive.zip!inner", into the archive and the entry's name within it.
+// Only "!" right after an archive extension separates them: No-Intro names
+// such as "Pitfall! (USA).a26" are plain files, not entries.
+func SplitArchivePath(p string) (archive, inner string, ok bool) {
+	lower := strings.ToLower(p)
+	at := -1
+	for _, ext := range archiveExts {
+		if i := strings.Index(lower, ext+"!"); i >= 0 && (at < 0 || i+len(ext) < at) {
+			at = i + len(ext)
+		}
+	}
+	if at < 0 {
+		return p, "", false
+	}
+	return p[:at], p[at+1:], true
+}
+
+// gameListPath returns the gamelist.xml <path> of a rom_file, relative to
+// its platform folder: the archive itself for an archive entry, such as
+// "./Pack.zip" for "Pack.zip/dir/game.nes", and the base name otherwise.
+func gameListPath(filename string) string {
+	lower := strings.ToLower(filename)
+	for _, ext := range archiveExts {
+		if i := strings.Index(lower, ext+"/"); i >= 0 {
+			return "./" + path.Base(filename[:i+len(ext)])
+		}
+	}
+	return "./" + path.Base(filename)
 }
 
 // ExportGameList returns entries for gamelist.xml export for a given platform
 func (d *DB) ExportGameList(platform string) ([]ExportGameListEntry, error) {
 	rows, err := d.Query(`
-		SELECT r.filename, COALESCE(g.title_ja, g.title_en, r.filename), 
+		SELECT r.path, r.filename, COALESCE(g.title_ja, g.title_en, r.filename), 
 			COALESCE(g.description_ja, ''), COALESCE(g.release_date, ''),
 			COALESCE(g.developer, ''), COALESCE(g.publisher, ''),
-			COALESCE(g.genre, ''), COALESCE(g.players, ''), COALESCE(g.rating, '')
+			COALESCE(g.genre, ''), COALESCE(g.players, ''), COALESCE(g.rating, ''),
+			COALESCE(img.file_path, ''), COALESCE(thumb.file_path, ''), COALESCE(marq.file_path, ''),
+			COALESCE(box.file_path, '')
 		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
+		LEFT JOIN cover_arts img ON img.game_id = g.id AND img.image_type = 'image'
+		LEFT JOIN cover_arts thumb ON thumb.game_id = g.id AND thumb.image_type = 'thumbnail'
+		LEFT JOIN cover_arts marq ON marq.game_id = g.id AND marq.image_type = 'marquee'
+		LEFT JOIN cover_arts box ON box.game_id = g.id AND box.image_type = 'boxart'
 		WHERE r.platform = ?
 		ORDER BY r.filename
 	`, platform)
@@ -312,29 +1109,142 @@ func (d *DB) ExportGameList(platform string) ([]ExportGameListEntry, error) {
 	var entries []ExportGameListEntry
 	for rows.Next() {
 		var e ExportGameListEntry
-		var filename string
-		if err := rows.Scan(&filename, &e.Name, &e.Desc, &e.ReleaseDate, &e.Developer, &e.Publisher, &e.Genre, &e.Players, &e.Rating); err != nil {
+		var romPath, filename string
+		if err := rows.Scan(&romPath, &filename, &e.Name, &e.Desc, &e.ReleaseDate, &e.Developer, &e.Publisher, &e.Genre, &e.Players, &e.Rating,
+			&e.Image, &e.Thumbnail, &e.Marquee, &e.Boxart); err != nil {
 			return nil, err
 		}
-		// For ZIP files (filename like "zipname.zip/inner.ext"), use just the zip part
-		if idx := strings.Index(filename, ".zip/"); idx >= 0 {
-			filename = filename[:idx+4]
-		}
-		e.Path = "./" + filename
+		e.Path = gameListPath(filename)
+		e.File, _, _ = SplitArchivePath(romPath)
 		entries = append(entries, e)
 	}
 	return entries, rows.Err()
 }
 
-// SearchResult holds a ROM search result
-type SearchResult struct {
+// PlaylistItem is one entry of a RetroArch .lpl playlist
+type PlaylistItem struct {
+	Path     string `json:"path"` // archive entries as "archive.zip#inner"
+	Label    string `json:"label"`
+	CorePath string `json:"core_path"` // "DETECT" lets RetroArch ask for a core
+	CoreName string `json:"core_name"`
+	CRC32    string `json:"crc32"`   // "ABCD1234|crc", or "DETECT" if unknown
+	DBName   string `json:"db_name"` // the playlist's file name
+}
+
+// ExportPlaylist returns a platform's ROMs as RetroArch playlist items.
+// Labels prefer the English title, which RetroArch finds thumbnails by, and
+// fall back to the Japanese title and the file name. The playlist is named
+// after RetroArch's database for the platform, or the platform code.
+func (d *DB) ExportPlaylist(code string) ([]PlaylistItem, error) {
+	name := platform.RetroArchDB(code)
+	if name == "" {
+		name = code
+	}
+	rows, err := d.Query(`
+		SELECT r.path, r.filename, COALESCE(g.title_en, g.title_ja, ''), COALESCE(r.hash_crc32, '')
+		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
+		WHERE r.platform = ?
+		ORDER BY r.filename
+	`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PlaylistItem
+	for rows.Next() {
+		var romPath, filename, label, crc string
+		if err := rows.Scan(&romPath, &filename, &label, &crc); err != nil {
+			return nil, err
+		}
+		if label == "" {
+			base := path.Base(filename)
+			label = strings.TrimSuffix(base, path.Ext(base))
+		}
+		crc32 := "DETECT"
+		if crc != "" {
+			crc32 = crc + "|crc"
+		}
+		if archive, inner, ok := SplitArchivePath(romPath); ok {
+			romPath = archive + "#" + inner
+		}
+		items = append(items, PlaylistItem{
+			Path:     romPath,
+			Label:    label,
+			CorePath: "DETECT",
+			CoreName: "DETECT",
+			CRC32:    crc32,
+			DBName:   name + ".lpl",
+		})
+	}
+	return items, rows.Err()
+}
+
+// SetUserRating sets the personal star rating of a rom_file (0 clears it)
+func (d *DB) SetUserRating(romID int64, stars int) error {
+	if stars < 0 || stars > 5 {
+		return fmt.Errorf("rating must be between 0 and 5, got %d", stars)
+	}
+	var rating interface{} = stars
+	if stars == 0 {
+		rating = nil
+	}
+	res, err := d.Exec(`UPDATE rom_files SET user_rating = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, rating, romID)
+	if err != nil {
+		return err
+	}
+	return d.changed(requireRow(res, romID))
+}
+
+// SetFavorite marks or unmarks a rom_file as a favorite
+func (d *DB) SetFavorite(romID int64, fav bool) error {
+	res, err := d.Exec(`UPDATE rom_files SET favorite = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, fav, romID)
+	if err != nil {
+		return err
+	}
+	return d.changed(requireRow(res, romID))
+}
+
+// ErrNotFound is returned when a row referenced by id doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// requireRow returns ErrNotFound if res affected no rows
+func requireRow(res sql.Result, id int64) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("rom_file %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// SearchFilter narrows SearchRoms results. Empty fields don't filter.
+type SearchFilter struct {
+	Query    string // matched against filename and both titles
 	Platform string
-	Filename string
-	Title    string
+	Tag      string // case-insensitive
+	Favorite bool   // only favorites
+	Matched  *bool  // only ROMs linked (true) or not linked (false) to a game
+	// Only ROMs whose game lacks a title in that language (unmatched ROMs
+	// lack both)
+	MissingTitleEN bool
+	MissingTitleJA bool
+	Sort           string // SortTitleJA, or "" for platform and filename
+}
+
+// SortTitleJA orders search results by Japanese title in kana (gojūon)
+// order, with ROMs lacking one last
+const SortTitleJA = "title_ja"
+
+// SearchRoms searches ROMs by title/filename with optional filters
+func (d *DB) SearchRoms(f SearchFilter, page, perPage int) ([]RomFile, int, error) {
+	return d.SearchRomsContext(context.Background(), f, page, perPage)
 }
 
-// SearchRoms searches ROMs by title/filename with optional platform filter
-func (d *DB) SearchRoms(query, platform string, page, perPage int) ([]RomFile, int, error) {
+// SearchRomsContext is SearchRoms, with its queries cancelled once ctx is
+// done
+func (d *DB) SearchRomsContext(ctx context.Context, f SearchFilter, page, perPage int) ([]RomFile, int, error) {
 	if perPage <= 0 {
 		perPage = 50
 	}
@@ -342,57 +1252,152 @@ func (d *DB) SearchRoms(query, platform string, page, perPage int) ([]RomFile, i
 		page = 1
 	}
 	offset := (page - 1) * perPage
-	q := "%" + query + "%"
+	q := "%" + f.Query + "%"
 
 	baseWhere := `FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
 		WHERE (r.filename LIKE ? OR g.title_ja LIKE ? OR g.title_en LIKE ?)`
 	args := []interface{}{q, q, q}
-	if platform != "" {
+	if f.Platform != "" {
 		baseWhere += ` AND r.platform = ?`
-		args = append(args, platform)
+		args = append(args, f.Platform)
+	}
+	if f.Tag != "" {
+		baseWhere += ` AND r.id IN (SELECT rt.rom_file_id FROM rom_file_tags rt JOIN tags t ON t.id = rt.tag_id WHERE t.name = ?)`
+		args = append(args, f.Tag)
+	}
+	if f.Favorite {
+		baseWhere += ` AND r.favorite = 1`
+	}
+	if f.Matched != nil {
+		if *f.Matched {
+			baseWhere += ` AND r.game_id IS NOT NULL`
+		} else {
+			baseWhere += ` AND r.game_id IS NULL`
+		}
+	}
+	if f.MissingTitleEN {
+		baseWhere += ` AND (g.title_en IS NULL OR g.title_en = '')`
+	}
+	if f.MissingTitleJA {
+		baseWhere += ` AND (g.title_ja IS NULL OR g.title_ja = '')`
 	}
 
 	var total int
-	err := d.QueryRow("SELECT COUNT(*) "+baseWhere, args...).Scan(&total)
+	err := d.QueryRowContext(ctx, "SELECT COUNT(*) "+baseWhere, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	if f.Sort == SortTitleJA {
+		// SQLite can only compare the UTF-8 bytes, so sort every match here
+		// and page afterwards
+		rows, err := d.QueryContext(ctx, `SELECT `+romFileColumns+` `+baseWhere+` ORDER BY r.platform, r.filename`, args...)
+		if err != nil {
+			return nil, 0, err
+		}
+		files, err := scanRomFiles(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		sortByTitleJA(files)
+		if offset > len(files) {
+			offset = len(files)
+		}
+		return files[offset:min(offset+perPage, len(files))], total, nil
+	}
+
 	selectArgs := append(args, perPage, offset)
-	rows, err := d.Query(`SELECT r.id, r.path, r.filename, r.size, r.hash_crc32, r.hash_md5, r.hash_sha1, r.platform, r.game_id, g.title_en, g.title_ja,
-		g.description_ja, g.developer, g.publisher, g.release_date, g.genre, g.players, g.rating `+baseWhere+` ORDER BY r.platform, r.filename LIMIT ? OFFSET ?`, selectArgs...)
+	rows, err := d.QueryContext(ctx, `SELECT `+romFileColumns+` `+baseWhere+` ORDER BY r.platform, r.filename LIMIT ? OFFSET ?`, selectArgs...)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer rows.Close()
-	var files []RomFile
-	for rows.Next() {
-		var f RomFile
-		if err := rows.Scan(&f.ID, &f.Path, &f.Filename, &f.Size, &f.HashCRC32, &f.HashMD5, &f.HashSHA1, &f.Platform, &f.GameID, &f.TitleEN, &f.TitleJA,
-			&f.DescJA, &f.Developer, &f.Publisher, &f.ReleaseDate, &f.Genre, &f.Players, &f.Rating); err != nil {
-			return nil, 0, err
+	files, err := scanRomFiles(rows)
+	return files, total, err
+}
+
+// sortByTitleJA stably sorts files by TitleJA, those without one last,
+// with the Japanese collation: kana in gojūon order, hiragana and katakana
+// interleaved, and kanji after them in JIS order, which mostly follows
+// their reading.
+func sortByTitleJA(files []RomFile) {
+	c := collate.New(language.Japanese)
+	title := func(f RomFile) string {
+		if f.TitleJA == nil {
+			return ""
+		}
+		return *f.TitleJA
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := title(files[i]), title(files[j])
+		if a == "" || b == "" {
+			return a != "" && b == ""
+		}
+		if n := c.CompareString(spellLongVowels(a), spellLongVowels(b)); n != 0 {
+			return n < 0
+		}
+		return c.CompareString(a, b) < 0
+	})
+}
+
+// kanaVowels maps hiragana to the vowel they end in
+var kanaVowels = map[rune]rune{}
+
+func init() {
+	for v, row := range map[rune]string{
+		'あ': "ぁあかがさざただなはばぱまゃやらゎわ",
+		'い': "ぃいきぎしじちぢにひびぴみりゐ",
+		'う': "ぅうくぐすずっつづぬふぶぷむゅゆるゔ",
+		'え': "ぇえけげせぜてでねへべぺめれゑ",
+		'お': "ぉおこごそぞとどのほぼぽもょよろを",
+	} {
+		for _, r := range row {
+			kanaVowels[r] = v
+		}
+	}
+}
+
+// spellLongVowels replaces each long vowel mark after a kana with the vowel
+// it lengthens, "カービィ" giving "カあビィ". The Japanese collation sorts
+// the mark as that vowel, but x/text puts the vowel before the kana, which
+// sorts "カービィ" among titles starting with "ア".
+func spellLongVowels(s string) string {
+	if !strings.ContainsRune(s, 'ー') {
+		return s
+	}
+	rs := []rune(s)
+	for i := 1; i < len(rs); i++ {
+		if rs[i] != 'ー' {
+			continue
+		}
+		prev := rs[i-1]
+		if prev >= 'ァ' && prev <= 'ヶ' {
+			prev -= 'ァ' - 'ぁ'
+		}
+		if v, ok := kanaVowels[prev]; ok {
+			rs[i] = v
 		}
-		files = append(files, f)
 	}
-	return files, total, rows.Err()
+	return string(rs)
 }
 
 // PlatformStats holds stats for one platform
 type PlatformStats struct {
-	Platform  string `json:"platform"`
-	Total     int    `json:"total"`
-	Matched   int    `json:"matched"`
-	Unmatched int    `json:"unmatched"`
-	HasTitleEN int   `json:"has_title_en"`
-	HasTitleJA int   `json:"has_title_ja"`
+	Platform   string `json:"platform"`
+	Total      int    `json:"total"`
+	Matched    int    `json:"matched"`
+	Unmatched  int    `json:"unmatched"`
+	HasTitleEN int    `json:"has_title_en"`
+	HasTitleJA int    `json:"has_title_ja"`
+	TotalBytes int64  `json:"total_bytes"`
 }
 
 // Stats holds overall collection stats
 type Stats struct {
-	Platforms []PlatformStats `json:"platforms"`
-	Total     int             `json:"total"`
-	Matched   int             `json:"matched"`
-	Unmatched int             `json:"unmatched"`
+	Platforms  []PlatformStats `json:"platforms"`
+	Total      int             `json:"total"`
+	Matched    int             `json:"matched"`
+	Unmatched  int             `json:"unmatched"`
+	TotalBytes int64           `json:"total_bytes"`
 }
 
 // GetStats returns collection statistics
@@ -403,7 +1408,8 @@ func (d *DB) GetStats() (*Stats, error) {
 			COUNT(r.game_id) as matched,
 			COUNT(*) - COUNT(r.game_id) as unmatched,
 			COUNT(g.title_en) as has_en,
-			COUNT(g.title_ja) as has_ja
+			COUNT(g.title_ja) as has_ja,
+			COALESCE(SUM(r.size), 0) as total_bytes
 		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
 		GROUP BY r.platform ORDER BY r.platform
 	`)
@@ -415,12 +1421,13 @@ func (d *DB) GetStats() (*Stats, error) {
 	s := &Stats{}
 	for rows.Next() {
 		var p PlatformStats
-		if err := rows.Scan(&p.Platform, &p.Total, &p.Matched, &p.Unmatched, &p.HasTitleEN, &p.HasTitleJA); err != nil {
+		if err := rows.Scan(&p.Platform, &p.Total, &p.Matched, &p.Unmatched, &p.HasTitleEN, &p.HasTitleJA, &p.TotalBytes); err != nil {
 			return nil, err
 		}
 		s.Total += p.Total
 		s.Matched += p.Matched
 		s.Unmatched += p.Unmatched
+		s.TotalBytes += p.TotalBytes
 		s.Platforms = append(s.Platforms, p)
 	}
 	return s, rows.Err()
@@ -487,20 +1494,167 @@ func (d *DB) GetEnrichableRoms(platform string) ([]EnrichableRom, int, error) {
 	return result, noMatch, rows.Err()
 }
 
-// UpdateGameMetadata updates metadata fields on a game
-func (d *DB) UpdateGameMetadata(gameID int64, titleJA, descJA, developer, publisher, releaseDate, genre, players string) error {
-	_, err := d.Exec(`UPDATE games SET
-		title_ja = COALESCE(NULLIF(?, ''), title_ja),
-		description_ja = COALESCE(NULLIF(?, ''), description_ja),
-		developer = COALESCE(NULLIF(?, ''), developer),
-		publisher = COALESCE(NULLIF(?, ''), publisher),
-		release_date = COALESCE(NULLIF(?, ''), release_date),
-		genre = COALESCE(NULLIF(?, ''), genre),
-		players = COALESCE(NULLIF(?, ''), players),
-		updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?`,
-		titleJA, descJA, developer, publisher, releaseDate, genre, players, gameID)
-	return err
+// JapaneseOnlyGame is a game known only by its Japanese title, e.g. one
+// created from a Japanese gamelist.xml
+type JapaneseOnlyGame struct {
+	GameID   int64
+	TitleJA  string
+	Platform string
+}
+
+// GetJapaneseOnlyGames returns games with title_ja but no title_en that
+// have a ROM, optionally limited to a platform
+func (d *DB) GetJapaneseOnlyGames(platform string) ([]JapaneseOnlyGame, error) {
+	query := `SELECT g.id, g.title_ja, MIN(r.platform) FROM rom_files r
+		JOIN games g ON r.game_id = g.id
+		WHERE (g.title_en IS NULL OR g.title_en = '') AND g.title_ja IS NOT NULL AND g.title_ja != ''`
+	args := []interface{}{}
+	if platform != "" {
+		query += ` AND r.platform = ?`
+		args = append(args, platform)
+	}
+	query += ` GROUP BY g.id ORDER BY g.id`
+
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []JapaneseOnlyGame
+	for rows.Next() {
+		var g JapaneseOnlyGame
+		if err := rows.Scan(&g.GameID, &g.TitleJA, &g.Platform); err != nil {
+			return nil, err
+		}
+		result = append(result, g)
+	}
+	return result, rows.Err()
+}
+
+// GamesMissingJA returns games with a ROM and an English title that lack a
+// Japanese title or description, optionally limited to a platform, ordered
+// by platform and English title
+func (d *DB) GamesMissingJA(platform string) ([]Game, error) {
+	query := `SELECT g.id, g.title_en, COALESCE(g.title_ja,''), g.platform, COALESCE(g.description_ja,'') FROM games g
+		WHERE g.title_en IS NOT NULL AND g.title_en != ''
+		AND (g.title_ja IS NULL OR g.title_ja = '' OR g.description_ja IS NULL OR g.description_ja = '')
+		AND EXISTS (SELECT 1 FROM rom_files r WHERE r.game_id = g.id)`
+	args := []interface{}{}
+	if platform != "" {
+		query += ` AND g.platform = ?`
+		args = append(args, platform)
+	}
+	rows, err := d.Query(query+` ORDER BY g.platform, g.title_en, g.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var games []Game
+	for rows.Next() {
+		var g Game
+		if err := rows.Scan(&g.ID, &g.TitleEN, &g.TitleJA, &g.Platform, &g.DescJA); err != nil {
+			return nil, err
+		}
+		games = append(games, g)
+	}
+	return games, rows.Err()
+}
+
+// SetGameTitleEN sets the English title of a game that has none
+func (d *DB) SetGameTitleEN(gameID int64, titleEN string) error {
+	_, err := d.Exec(`UPDATE games SET title_en = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (title_en IS NULL OR title_en = '')`, titleEN, gameID)
+	return d.changed(err)
+}
+
+// GameMetadata is the enrichable metadata of a game
+type GameMetadata struct {
+	TitleJA     string
+	DescJA      string
+	Developer   string
+	Publisher   string
+	ReleaseDate string
+	Genre       string
+	Players     string
+	Rating      string
+}
+
+// GetGame returns a game with its metadata and cover art, or ErrNotFound
+func (d *DB) GetGame(id int64) (*Game, error) {
+	g := &Game{ID: id}
+	err := d.QueryRow(`SELECT COALESCE(title_en,''), COALESCE(title_ja,''), platform, COALESCE(description_ja,''),
+		COALESCE(developer,''), COALESCE(publisher,''), COALESCE(release_date,''), COALESCE(genre,''),
+		COALESCE(players,''), COALESCE(rating,'')
+		FROM games WHERE id = ?`, id).Scan(&g.TitleEN, &g.TitleJA, &g.Platform, &g.DescJA,
+		&g.Developer, &g.Publisher, &g.ReleaseDate, &g.Genre, &g.Players, &g.Rating)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.Query(`SELECT image_type, file_path FROM cover_arts WHERE game_id = ? ORDER BY image_type`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c CoverArt
+		if err := rows.Scan(&c.ImageType, &c.FilePath); err != nil {
+			return nil, err
+		}
+		g.Covers = append(g.Covers, c)
+	}
+	return g, rows.Err()
+}
+
+// GetGameMetadata returns a game's current metadata, with NULLs as empty
+// strings
+func (d *DB) GetGameMetadata(gameID int64) (GameMetadata, error) {
+	var m GameMetadata
+	err := d.QueryRow(`SELECT COALESCE(title_ja,''), COALESCE(description_ja,''), COALESCE(developer,''),
+		COALESCE(publisher,''), COALESCE(release_date,''), COALESCE(genre,''), COALESCE(players,''), COALESCE(rating,'')
+		FROM games WHERE id = ?`, gameID).Scan(&m.TitleJA, &m.DescJA, &m.Developer, &m.Publisher, &m.ReleaseDate, &m.Genre, &m.Players, &m.Rating)
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	return m, err
+}
+
+// UpdateGameMetadata updates metadata fields on a game, keeping existing
+// values where the new ones are empty
+func (d *DB) UpdateGameMetadata(gameID int64, titleJA, descJA, developer, publisher, releaseDate, genre, players, rating string) error {
+	return d.SetGameMetadata(gameID, GameMetadata{
+		TitleJA:     titleJA,
+		DescJA:      descJA,
+		Developer:   developer,
+		Publisher:   publisher,
+		ReleaseDate: releaseDate,
+		Genre:       genre,
+		Players:     players,
+		Rating:      rating,
+	}, false)
+}
+
+// SetGameMetadata updates a game's metadata. Without overwrite, empty fields
+// keep their current value; with overwrite every field is replaced and empty
+// ones are cleared.
+func (d *DB) SetGameMetadata(gameID int64, m GameMetadata, overwrite bool) error {
+	// col = NULLIF(?, '') replaces, col = COALESCE(NULLIF(?, ''), col) merges
+	set := func(col string) string {
+		if overwrite {
+			return col + " = NULLIF(?, '')"
+		}
+		return col + " = COALESCE(NULLIF(?, ''), " + col + ")"
+	}
+	cols := []string{"title_ja", "description_ja", "developer", "publisher", "release_date", "genre", "players", "rating"}
+	for i, c := range cols {
+		cols[i] = set(c)
+	}
+	_, err := d.Exec(`UPDATE games SET `+strings.Join(cols, ", ")+`, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		m.TitleJA, m.DescJA, m.Developer, m.Publisher, m.ReleaseDate, m.Genre, m.Players, m.Rating, gameID)
+	return d.changed(err)
 }
 
 // UnmatchedRom represents a rom_file without a game_id
@@ -508,16 +1662,72 @@ type UnmatchedRom struct {
 	ID       int64
 	Filename string
 	Platform string
+	CRC32    string
+	MD5      string
+	SHA1     string
+}
+
+// GetGameHashes returns the hashes of one of the ROMs linked to a game, or
+// ErrNotFound if none is
+func (d *DB) GetGameHashes(gameID int64) (crc32, md5, sha1 string, err error) {
+	err = d.QueryRow(`SELECT COALESCE(hash_crc32,''), COALESCE(hash_md5,''), COALESCE(hash_sha1,'')
+		FROM rom_files WHERE game_id = ? ORDER BY id LIMIT 1`, gameID).Scan(&crc32, &md5, &sha1)
+	if err == sql.ErrNoRows {
+		err = ErrNotFound
+	}
+	return crc32, md5, sha1, err
+}
+
+// GetGamesWithoutCovers returns matched games with title_en set that have no
+// cover_arts row of the given image type, optionally limited to a platform
+func (d *DB) GetGamesWithoutCovers(platform, imageType string) ([]EnrichableRom, error) {
+	query := `SELECT g.id, g.title_en, MIN(r.platform) FROM rom_files r
+		JOIN games g ON r.game_id = g.id
+		LEFT JOIN cover_arts c ON c.game_id = g.id AND c.image_type = ?
+		WHERE g.title_en IS NOT NULL AND g.title_en != '' AND c.id IS NULL`
+	args := []interface{}{imageType}
+	if platform != "" {
+		query += ` AND r.platform = ?`
+		args = append(args, platform)
+	}
+	query += ` GROUP BY g.id ORDER BY g.id`
+
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []EnrichableRom
+	for rows.Next() {
+		var e EnrichableRom
+		if err := rows.Scan(&e.GameID, &e.TitleEN, &e.Platform); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// SetCoverArt records the image file of the given type for a game,
+// replacing any previous one
+func (d *DB) SetCoverArt(gameID int64, imageType, filePath string) error {
+	_, err := d.Exec(setCoverArtSQL, gameID, imageType, filePath)
+	return d.changed(err)
 }
 
+const setCoverArtSQL = `INSERT INTO cover_arts (game_id, image_type, file_path) VALUES (?, ?, ?)
+	ON CONFLICT(game_id, image_type) DO UPDATE SET file_path=excluded.file_path, created_at=CURRENT_TIMESTAMP`
+
 // GetUnmatchedRoms returns rom_files that have no game_id
 func (d *DB) GetUnmatchedRoms(platform string) ([]UnmatchedRom, error) {
-	query := `SELECT id, filename, platform FROM rom_files WHERE game_id IS NULL`
+	query := `SELECT id, filename, platform, COALESCE(hash_crc32,''), COALESCE(hash_md5,''), COALESCE(hash_sha1,'')
+		FROM rom_files WHERE game_id IS NULL`
 	args := []interface{}{}
 	if platform != "" {
 		query += ` AND platform = ?`
 		args = append(args, platform)
 	}
+	query += ` ORDER BY platform, filename`
 	rows, err := d.Query(query, args...)
 	if err != nil {
 		return nil, err
@@ -526,27 +1736,34 @@ func (d *DB) GetUnmatchedRoms(platform string) ([]UnmatchedRom, error) {
 	var result []UnmatchedRom
 	for rows.Next() {
 		var r UnmatchedRom
-		rows.Scan(&r.ID, &r.Filename, &r.Platform)
+		rows.Scan(&r.ID, &r.Filename, &r.Platform, &r.CRC32, &r.MD5, &r.SHA1)
 		result = append(result, r)
 	}
 	return result, rows.Err()
 }
 
 // CreateGameAndLink creates a game entry and links it to a rom_file
-func (d *DB) CreateGameAndLink(romID int64, titleEN, platform, titleJA, descJA, developer, publisher, releaseDate, genre, players string) error {
-	res, err := d.Exec(`INSERT INTO games (title_en, platform, title_ja, description_ja, developer, publisher, release_date, genre, players) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		titleEN, platform, titleJA, descJA, developer, publisher, releaseDate, genre, players)
+func (d *DB) CreateGameAndLink(romID int64, titleEN, platform, titleJA, descJA, developer, publisher, releaseDate, genre, players, rating string) error {
+	res, err := d.Exec(`INSERT INTO games (title_en, platform, title_ja, description_ja, developer, publisher, release_date, genre, players, rating) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))`,
+		titleEN, platform, titleJA, descJA, developer, publisher, releaseDate, genre, players, rating)
 	if err != nil {
 		return err
 	}
 	gameID, _ := res.LastInsertId()
 	_, err = d.Exec(`UPDATE rom_files SET game_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, gameID, romID)
-	return err
+	return d.changed(err)
 }
 
-// MatchByHash matches rom_files to games using DAT ROM info
+// MatchROMs matches rom_files to games using DAT ROM info. Nodump entries
+// are skipped, as their hashes are placeholders.
 func (d *DB) MatchROMs(datRoms []DATRom) (int, error) {
-	tx, err := d.Begin()
+	return d.MatchROMsContext(context.Background(), datRoms)
+}
+
+// MatchROMsContext is MatchROMs, giving up and rolling back once ctx is
+// done
+func (d *DB) MatchROMsContext(ctx context.Context, datRoms []DATRom) (int, error) {
+	tx, err := d.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -554,25 +1771,31 @@ func (d *DB) MatchROMs(datRoms []DATRom) (int, error) {
 
 	matched := 0
 	for _, dr := range datRoms {
-		// Find rom_files by hash (SHA1 > MD5 > CRC32)
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if dr.Status == StatusNoDump {
+			continue
+		}
+		// Find rom_files by hash (SHA1 > MD5 > CRC32), with or without header
 		var query string
 		var hashVal string
 		if dr.SHA1 != "" {
-			query = `SELECT id, game_id FROM rom_files WHERE hash_sha1 = ?`
+			query = `SELECT id, game_id FROM rom_files WHERE hash_sha1 = ? OR hash_sha1_nohdr = ?`
 			hashVal = dr.SHA1
 		} else if dr.MD5 != "" {
-			query = `SELECT id, game_id FROM rom_files WHERE hash_md5 = ?`
+			query = `SELECT id, game_id FROM rom_files WHERE hash_md5 = ? OR hash_md5_nohdr = ?`
 			hashVal = dr.MD5
 		} else if dr.CRC32 != "" {
-			query = `SELECT id, game_id FROM rom_files WHERE hash_crc32 = ?`
+			query = `SELECT id, game_id FROM rom_files WHERE hash_crc32 = ? OR hash_crc32_nohdr = ?`
 			hashVal = dr.CRC32
 		} else {
 			continue
 		}
 
-		rows, err := tx.Query(query, hashVal)
+		rows, err := tx.QueryContext(ctx, query, hashVal, hashVal)
 		if err != nil {
-			continue
+			return 0, err
 		}
 		type romMatch struct {
 			id     int64
@@ -581,36 +1804,136 @@ func (d *DB) MatchROMs(datRoms []DATRom) (int, error) {
 		var matches []romMatch
 		for rows.Next() {
 			var rm romMatch
-			rows.Scan(&rm.id, &rm.gameID)
+			if err := rows.Scan(&rm.id, &rm.gameID); err != nil {
+				rows.Close()
+				return 0, err
+			}
 			matches = append(matches, rm)
 		}
 		rows.Close()
-
-		if len(matches) == 0 {
-			continue
+		if err := rows.Err(); err != nil {
+			return 0, err
 		}
 
 		for _, rm := range matches {
 			if rm.gameID != nil {
 				// ROM already linked to a game — update that game's title_en
-				tx.Exec(`UPDATE games SET title_en = ? WHERE id = ? AND (title_en IS NULL OR title_en = '')`,
-					dr.GameTitle, *rm.gameID)
+				if _, err := tx.ExecContext(ctx, `UPDATE games SET title_en = ? WHERE id = ? AND (title_en IS NULL OR title_en = '')`,
+					dr.GameTitle, *rm.gameID); err != nil {
+					return 0, err
+				}
 				matched++
-			} else {
-				// ROM not linked — find or create a game with this title_en
-				var gameID int64
-				err := tx.QueryRow(`SELECT id FROM games WHERE title_en = ? AND platform = ?`, dr.GameTitle, dr.Platform).Scan(&gameID)
+				continue
+			}
+			// ROM not linked — find or create a game with this title_en
+			var gameID int64
+			err := tx.QueryRowContext(ctx, `SELECT id FROM games WHERE title_en = ? AND platform = ?`, dr.GameTitle, dr.Platform).Scan(&gameID)
+			if err == sql.ErrNoRows {
+				res, err := tx.ExecContext(ctx, `INSERT INTO games (title_en, platform) VALUES (?, ?)`, dr.GameTitle, dr.Platform)
 				if err != nil {
-					res, err := tx.Exec(`INSERT INTO games (title_en, platform) VALUES (?, ?)`, dr.GameTitle, dr.Platform)
-					if err != nil {
-						continue
-					}
-					gameID, _ = res.LastInsertId()
+					return 0, fmt.Errorf("insert game %q: %w", dr.GameTitle, err)
 				}
-				tx.Exec(`UPDATE rom_files SET game_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, gameID, rm.id)
-				matched++
+				gameID, _ = res.LastInsertId()
+			} else if err != nil {
+				return 0, err
 			}
+			if _, err := tx.ExecContext(ctx, `UPDATE rom_files SET game_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, gameID, rm.id); err != nil {
+				return 0, err
+			}
+			matched++
 		}
 	}
-	return matched, tx.Commit()
+	return matched, d.changed(tx.Commit())
+}
+
+// MatchAllStored matches rom_files against every stored DAT entry (see
+// ImportDATGames) in a single transaction, optionally limited to one
+// platform's DAT entries. Like MatchROMs, each DAT entry is matched on its
+// strongest hash (SHA1 > MD5 > CRC32), against both the full and the
+// headerless ROM hashes; unlinked ROMs are linked to a game with the DAT
+// title, created if needed, and linked games missing title_en get the DAT
+// title. Nodump entries are skipped. Returns the number of ROMs matched.
+func (d *DB) MatchAllStored(platform string) (int, error) {
+	return d.MatchAllStoredContext(context.Background(), platform)
+}
+
+// MatchAllStoredContext is MatchAllStored, with its statements interrupted
+// once ctx is done
+func (d *DB) MatchAllStoredContext(ctx context.Context, platform string) (int, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DROP TABLE IF EXISTS temp.match_pairs`); err != nil {
+		return 0, err
+	}
+	// One branch per hash, each against both the full and the headerless
+	// hash; a DAT entry only uses its strongest hash
+	conds := []string{
+		`d.hash_sha1 != '' AND r.hash_sha1 = d.hash_sha1`,
+		`d.hash_sha1 != '' AND r.hash_sha1_nohdr = d.hash_sha1`,
+		`d.hash_sha1 = '' AND d.hash_md5 != '' AND r.hash_md5 = d.hash_md5`,
+		`d.hash_sha1 = '' AND d.hash_md5 != '' AND r.hash_md5_nohdr = d.hash_md5`,
+		`d.hash_sha1 = '' AND d.hash_md5 = '' AND d.hash_crc32 != '' AND r.hash_crc32 = d.hash_crc32`,
+		`d.hash_sha1 = '' AND d.hash_md5 = '' AND d.hash_crc32 != '' AND r.hash_crc32_nohdr = d.hash_crc32`,
+	}
+	branches := make([]string, len(conds))
+	args := []interface{}{}
+	for i, c := range conds {
+		branches[i] = `SELECT r.id AS rom_id, d.game_title, d.platform FROM rom_files r JOIN dat_roms d ON ` + c +
+			` WHERE d.status != ?`
+		args = append(args, StatusNoDump)
+		if platform != "" {
+			branches[i] += ` AND d.platform = ?`
+			args = append(args, platform)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `CREATE TEMP TABLE match_pairs AS
+		SELECT rom_id, MIN(game_title) AS game_title, MIN(platform) AS platform FROM (
+			`+strings.Join(branches, "\n\t\t\tUNION ALL\n\t\t\t")+`
+		) GROUP BY rom_id`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("collect matches: %w", err)
+	}
+
+	var matched int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM match_pairs`).Scan(&matched); err != nil {
+		return 0, err
+	}
+
+	// Games for unlinked ROMs that don't exist yet
+	_, err = tx.ExecContext(ctx, `INSERT INTO games (title_en, platform)
+		SELECT DISTINCT m.game_title, m.platform FROM match_pairs m
+		JOIN rom_files r ON r.id = m.rom_id
+		WHERE r.game_id IS NULL
+		AND NOT EXISTS (SELECT 1 FROM games g WHERE g.title_en = m.game_title AND g.platform = m.platform)`)
+	if err != nil {
+		return 0, fmt.Errorf("create games: %w", err)
+	}
+
+	// ROMs already linked to a game: fill in a missing title_en
+	_, err = tx.ExecContext(ctx, `UPDATE games SET title_en = (
+			SELECT m.game_title FROM match_pairs m JOIN rom_files r ON r.id = m.rom_id
+			WHERE r.game_id = games.id LIMIT 1)
+		WHERE (title_en IS NULL OR title_en = '')
+		AND id IN (SELECT r.game_id FROM rom_files r JOIN match_pairs m ON m.rom_id = r.id)`)
+	if err != nil {
+		return 0, fmt.Errorf("update titles: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE rom_files SET game_id = (
+			SELECT g.id FROM match_pairs m JOIN games g ON g.title_en = m.game_title AND g.platform = m.platform
+			WHERE m.rom_id = rom_files.id ORDER BY g.id LIMIT 1),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE game_id IS NULL AND id IN (SELECT rom_id FROM match_pairs)`)
+	if err != nil {
+		return 0, fmt.Errorf("link roms: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DROP TABLE temp.match_pairs`); err != nil {
+		return 0, err
+	}
+	return matched, d.changed(tx.Commit())
 }
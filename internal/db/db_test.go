@@ -0,0 +1,1544 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func openTestDB(tb testing.TB) *DB {
+	tb.Helper()
+	database, err := OpenMemory()
+	if err != nil {
+		tb.Fatalf("db open: %v", err)
+	}
+	tb.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestNormalizeHash(t *testing.T) {
+	tests := []struct {
+		in    string
+		width int
+		want  string
+	}{
+		{"3337ec46", CRC32Width, "3337EC46"},
+		{"337EC46", CRC32Width, "0337EC46"},
+		{"0x1a2b", CRC32Width, "00001A2B"},
+		{" 811b027eaf99c2def7b933c5208636de ", MD5Width, "811B027EAF99C2DEF7B933C5208636DE"},
+		{"", CRC32Width, ""},
+		{"  ", SHA1Width, ""},
+	}
+	for _, tt := range tests {
+		got := NormalizeHash(tt.in, tt.width)
+		if got != tt.want {
+			t.Errorf("NormalizeHash(%q, %d) = %q, want %q", tt.in, tt.width, got, tt.want)
+		}
+	}
+}
+
+func testRomFiles(n int) []RomFileInput {
+	files := make([]RomFileInput, n)
+	for i := range files {
+		name := fmt.Sprintf("game%05d.nes", i)
+		files[i] = RomFileInput{Path: "/roms/fc/" + name, Filename: name, Size: 1024, CRC32: fmt.Sprintf("%08X", i), Platform: "FC"}
+	}
+	return files
+}
+
+func TestUpsertRomFilesBatch(t *testing.T) {
+	database := openTestDB(t)
+
+	files := testRomFiles(3)
+	if err := database.UpsertRomFilesBatch(files); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	// Upserting again updates in place
+	files[0].Size = 2048
+	if err := database.UpsertRomFilesBatch(files); err != nil {
+		t.Fatalf("batch again: %v", err)
+	}
+
+	list, err := database.ListRomFiles()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(list))
+	}
+	if list[0].Size != 2048 {
+		t.Errorf("expected updated size 2048, got %d", list[0].Size)
+	}
+}
+
+// The batch variant commits once per 500 files instead of once per file
+func BenchmarkUpsertRomFile(b *testing.B) {
+	database := openTestDB(b)
+	files := testRomFiles(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, f := range files {
+			if err := database.UpsertRomFile(f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// Inserting 10k new rows one at a time, as "romu scan" did before batching
+func BenchmarkUpsertRomFile10k(b *testing.B) {
+	files := testRomFiles(10000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		database := openTestDB(b)
+		b.StartTimer()
+		for _, f := range files {
+			if err := database.UpsertRomFile(f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkUpsertRomFilesBatch(b *testing.B) {
+	database := openTestDB(b)
+	files := testRomFiles(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := database.UpsertRomFilesBatch(files); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestListRomFilesSince(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.UpsertRomFilesBatch(testRomFiles(3)); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	database.Exec(`UPDATE rom_files SET created_at = datetime('now', '-3 days') WHERE filename != 'game00001.nes'`)
+
+	files, err := database.ListRomFilesSince(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("list since: %v", err)
+	}
+	if len(files) != 1 || files[0].Filename != "game00001.nes" {
+		t.Errorf("expected only game00001.nes, got %+v", files)
+	}
+	if files, _ := database.ListRomFilesSince(time.Now().Add(-7 * 24 * time.Hour)); len(files) != 3 {
+		t.Errorf("expected 3 files in the last week, got %d", len(files))
+	}
+}
+
+func TestListRomFilesByPlatform(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(3)
+	files[2].Platform = "SFC"
+	if err := database.UpsertRomFilesBatch(files); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+
+	fc, err := database.ListRomFilesByPlatform("FC")
+	if err != nil || len(fc) != 2 {
+		t.Errorf("expected 2 FC files, got %d (%v)", len(fc), err)
+	}
+	all, _ := database.ListRomFilesFiltered(ListFilter{})
+	if len(all) != 3 {
+		t.Errorf("expected 3 files without a filter, got %d", len(all))
+	}
+}
+
+func TestListRomFilesPaged(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.UpsertRomFilesBatch(testRomFiles(5)); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+
+	files, total, err := database.ListRomFilesPaged(ListFilter{}, 2, 1)
+	if err != nil {
+		t.Fatalf("paged: %v", err)
+	}
+	if total != 5 || len(files) != 2 || files[0].Filename != "game00001.nes" || files[1].Filename != "game00002.nes" {
+		t.Errorf("unexpected page: total %d, %+v", total, files)
+	}
+	if files, total, _ := database.ListRomFilesPaged(ListFilter{}, -1, 3); total != 5 || len(files) != 2 {
+		t.Errorf("expected the last 2 of 5 without a limit, got %d of %d", len(files), total)
+	}
+}
+
+func TestTags(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.UpsertRomFilesBatch(testRomFiles(2)); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	files, _ := database.ListRomFiles()
+
+	database.AddTag(files[0].ID, "Favorites")
+	database.AddTag(files[1].ID, "favorites")
+
+	tagged, err := database.ListByTag("FAVORITES")
+	if err != nil {
+		t.Fatalf("list by tag: %v", err)
+	}
+	if len(tagged) != 2 {
+		t.Errorf("expected 2 tagged files regardless of case, got %d", len(tagged))
+	}
+
+	_, total, err := database.SearchRoms(SearchFilter{Tag: "favorites"}, 1, 50)
+	if err != nil || total != 2 {
+		t.Errorf("expected 2 search results for tag, got %d (%v)", total, err)
+	}
+
+	// Deleting a rom_file drops its tag links
+	database.Exec(`DELETE FROM rom_files WHERE id = ?`, files[0].ID)
+	tags, _ := database.ListTags()
+	if len(tags) != 1 || tags[0].Name != "Favorites" || tags[0].Count != 1 {
+		t.Errorf("unexpected tags after delete: %+v", tags)
+	}
+}
+
+func TestGetStatsTotalBytes(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(3)
+	files[2].Platform = "SFC"
+	files[2].Size = 4096
+	if err := database.UpsertRomFilesBatch(files); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.TotalBytes != 6144 || len(stats.Platforms) != 2 ||
+		stats.Platforms[0].TotalBytes != 2048 || stats.Platforms[1].TotalBytes != 4096 {
+		t.Errorf("unexpected sizes: %+v", stats)
+	}
+}
+
+func TestTopBySize(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(3)
+	files[1].Size = 8192
+	files[2].Size = 4096
+	files[2].Platform = "SFC"
+	if err := database.UpsertRomFilesBatch(files); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+
+	top, err := database.TopBySize("", 2)
+	if err != nil {
+		t.Fatalf("top: %v", err)
+	}
+	if len(top) != 2 || top[0].Filename != "game00001.nes" || top[1].Filename != "game00002.nes" {
+		t.Errorf("unexpected top files: %+v", top)
+	}
+	if top, _ := database.TopBySize("FC", 5); len(top) != 2 || top[0].Size != 8192 {
+		t.Errorf("unexpected FC top files: %+v", top)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(1)
+	database.UpsertRomFilesBatch(files)
+	database.MatchROMs([]DATRom{{GameTitle: "Game A", Platform: "FC", CRC32: files[0].CRC32}})
+	f, _ := database.ListRomFiles()
+	id := *f[0].GameID
+	database.SetCoverArt(id, "boxart", "/covers/a.png")
+
+	if _, err := database.Exec(`UPDATE rom_files SET game_id = 9999 WHERE id = ?`, f[0].ID); err == nil {
+		t.Error("linking a ROM to a missing game should fail")
+	}
+
+	// Deleting a game unlinks its ROMs and deletes its covers
+	if _, err := database.Exec(`DELETE FROM games WHERE id = ?`, id); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := database.ListRomFiles(); len(f) != 1 || f[0].GameID != nil {
+		t.Errorf("expected the ROM to be kept and unlinked, got %+v", f)
+	}
+	var n int
+	database.QueryRow(`SELECT COUNT(*) FROM cover_arts`).Scan(&n)
+	if n != 0 {
+		t.Errorf("expected covers to be deleted with the game, %d left", n)
+	}
+
+	// rom_file_tags.tag_id cascades
+	database.AddTag(f[0].ID, "rpg")
+	if _, err := database.Exec(`DELETE FROM tags WHERE name = 'rpg'`); err != nil {
+		t.Fatal(err)
+	}
+	database.QueryRow(`SELECT COUNT(*) FROM rom_file_tags`).Scan(&n)
+	if n != 0 {
+		t.Errorf("expected tag links to be deleted with the tag, %d left", n)
+	}
+}
+
+func TestUpgradeForeignKeys(t *testing.T) {
+	// A database from before foreign keys had delete actions, with a ROM
+	// and a cover pointing at a game that no longer exists
+	path := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+	CREATE TABLE games (id INTEGER PRIMARY KEY, title_en TEXT, title_ja TEXT, description_ja TEXT,
+		platform TEXT NOT NULL, developer TEXT, publisher TEXT, release_date TEXT, genre TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+	CREATE TABLE rom_files (id INTEGER PRIMARY KEY, path TEXT NOT NULL UNIQUE, filename TEXT NOT NULL,
+		size INTEGER, hash_crc32 TEXT, hash_md5 TEXT, hash_sha1 TEXT, platform TEXT NOT NULL,
+		game_id INTEGER REFERENCES games(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+	CREATE TABLE cover_arts (id INTEGER PRIMARY KEY, game_id INTEGER REFERENCES games(id),
+		image_type TEXT NOT NULL, file_path TEXT NOT NULL, created_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+	INSERT INTO games (id, title_en, platform) VALUES (1, 'Game A', 'FC');
+	INSERT INTO rom_files (path, filename, size, platform, game_id, hash_crc32, hash_md5, hash_sha1) VALUES
+		('/roms/fc/a.nes', 'a.nes', 1024, 'FC', 1, '00000001', '', ''),
+		('/roms/fc/b.nes', 'b.nes', 1024, 'FC', 2, '00000002', '', '');
+	INSERT INTO cover_arts (game_id, image_type, file_path) VALUES (1, 'boxart', '/covers/a.png'), (2, 'boxart', '/covers/b.png');
+	`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := OpenPath(path)
+	if err != nil {
+		t.Fatalf("open old database: %v", err)
+	}
+	defer database.Close()
+	var version int
+	database.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if version != len(upgrades) {
+		t.Errorf("user_version = %d, want %d", version, len(upgrades))
+	}
+	f, err := database.ListRomFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f) != 2 || f[0].GameID == nil || *f[0].GameID != 1 || f[1].GameID != nil {
+		t.Fatalf("expected a.nes linked and b.nes unlinked, got %+v", f)
+	}
+	var indexes int
+	database.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'rom_files' AND name LIKE 'idx_%'`).Scan(&indexes)
+	if indexes != 6 {
+		t.Errorf("expected the 6 rom_files indexes to be recreated, got %d", indexes)
+	}
+
+	if _, err := database.Exec(`DELETE FROM games WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	var linked, covers int
+	database.QueryRow(`SELECT COUNT(*) FROM rom_files WHERE game_id IS NOT NULL`).Scan(&linked)
+	database.QueryRow(`SELECT COUNT(*) FROM cover_arts`).Scan(&covers)
+	if linked != 0 || covers != 0 {
+		t.Errorf("after deleting the game: %d linked ROMs, %d covers", linked, covers)
+	}
+}
+
+func TestPruneOrphanGames(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.UpsertRomFilesBatch(testRomFiles(3)); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	files, _ := database.ListRomFiles()
+	for _, f := range files {
+		database.CreateGameAndLink(f.ID, f.Filename, "FC", "", "", "", "", "", "", "", "")
+	}
+	files, _ = database.ListRomFiles()
+	database.SetCoverArt(*files[1].GameID, "boxart", "/covers/b.png")
+	database.SetCoverArt(*files[2].GameID, "boxart", "/covers/c.png")
+	// files[0]'s game is left with nothing, files[1]'s with its cover
+	database.Exec(`DELETE FROM rom_files WHERE id IN (?, ?)`, files[0].ID, files[1].ID)
+
+	n, err := database.PruneOrphanGames()
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 pruned game, got %d (%v)", n, err)
+	}
+	if _, err := database.GetGame(*files[0].GameID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the orphan game to be deleted, got %v", err)
+	}
+	if _, err := database.GetGame(*files[1].GameID); err != nil {
+		t.Errorf("expected the game with cover art to be kept, got %v", err)
+	}
+	var covers int
+	database.QueryRow(`SELECT COUNT(*) FROM cover_arts`).Scan(&covers)
+	if covers != 2 {
+		t.Errorf("expected both cover_arts rows kept, %d left", covers)
+	}
+	if n, _ := database.PruneOrphanGames(); n != 0 {
+		t.Errorf("expected nothing left to prune, got %d", n)
+	}
+}
+
+func TestSearchRomsMatched(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.UpsertRomFilesBatch(testRomFiles(3)); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	database.MatchROMs([]DATRom{{GameTitle: "Game", Platform: "FC", CRC32: "00000001"}})
+
+	matched, unmatched := true, false
+	files, total, err := database.SearchRoms(SearchFilter{Matched: &unmatched}, 1, 50)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if total != 2 || len(files) != 2 || files[0].Filename != "game00000.nes" || files[1].Filename != "game00002.nes" {
+		t.Errorf("expected the 2 unmatched files, got %d: %+v", total, files)
+	}
+	if files, total, _ := database.SearchRoms(SearchFilter{Matched: &matched}, 1, 50); total != 1 || files[0].Filename != "game00001.nes" {
+		t.Errorf("expected the matched file, got %d: %+v", total, files)
+	}
+	if _, total, _ := database.SearchRoms(SearchFilter{}, 1, 50); total != 3 {
+		t.Errorf("expected all 3 files without a match filter, got %d", total)
+	}
+}
+
+func TestSearchRomsMissingTitle(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.UpsertRomFilesBatch(testRomFiles(3)); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	files, _ := database.ListRomFiles()
+	database.CreateGameAndLink(files[0].ID, "Both", "FC", "両方", "", "", "", "", "", "", "")
+	database.CreateGameAndLink(files[1].ID, "English Only", "FC", "", "", "", "", "", "", "", "")
+	// files[2] stays unmatched
+
+	noJA, total, err := database.SearchRoms(SearchFilter{MissingTitleJA: true}, 1, 50)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if total != 2 || noJA[0].Filename != "game00001.nes" || noJA[1].Filename != "game00002.nes" {
+		t.Errorf("expected 2 ROMs without a Japanese title, got %d: %+v", total, noJA)
+	}
+	noEN, total, err := database.SearchRoms(SearchFilter{MissingTitleEN: true}, 1, 50)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if total != 1 || noEN[0].Filename != "game00002.nes" {
+		t.Errorf("expected only the unmatched ROM without an English title, got %d: %+v", total, noEN)
+	}
+}
+
+func TestSearchRomsSortTitleJA(t *testing.T) {
+	database := openTestDB(t)
+	titles := []string{"魔界村", "ドラゴンクエスト", "", "がんばれゴエモン", "カービィ", "すごろく", "アクトレイザー", "ストリートファイター", "スーパーマリオ", "スイートホーム", "三國志", "悪魔城ドラキュラ"}
+	if err := database.UpsertRomFilesBatch(testRomFiles(len(titles))); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	files, _ := database.ListRomFiles()
+	for i, title := range titles {
+		if title != "" {
+			database.CreateGameAndLink(files[i].ID, fmt.Sprint("Game ", i), "FC", title, "", "", "", "", "", "", "")
+		}
+	}
+
+	// A long vowel mark sorts as the vowel it lengthens ("スーパー" as
+	// "スウパア"), and kanji in JIS order, which mostly follows their reading
+	want := []string{"アクトレイザー", "カービィ", "がんばれゴエモン", "スイートホーム", "スーパーマリオ", "すごろく", "ストリートファイター", "ドラゴンクエスト", "悪魔城ドラキュラ", "三國志", "魔界村", ""}
+	sorted, total, err := database.SearchRoms(SearchFilter{Sort: SortTitleJA}, 1, 50)
+	if err != nil || total != len(want) {
+		t.Fatalf("search: %d results (%v)", total, err)
+	}
+	for i, f := range sorted {
+		got := ""
+		if f.TitleJA != nil {
+			got = *f.TitleJA
+		}
+		if got != want[i] {
+			t.Errorf("result %d: got %q, want %q", i, got, want[i])
+		}
+	}
+
+	// Pages come from the sorted order
+	page, _, _ := database.SearchRoms(SearchFilter{Sort: SortTitleJA}, 2, 3)
+	if len(page) != 3 || *page[0].TitleJA != "スイートホーム" {
+		t.Errorf("unexpected second page: %+v", page)
+	}
+	if page, _, _ := database.SearchRoms(SearchFilter{Sort: SortTitleJA}, 9, 3); len(page) != 0 {
+		t.Errorf("expected an empty page past the end, got %d", len(page))
+	}
+}
+
+func TestReassignPlatform(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.UpsertRomFilesBatch(testRomFiles(2)); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	files, _ := database.ListRomFiles()
+	database.CreateGameAndLink(files[0].ID, "Game", "FC", "", "", "", "", "", "", "", "")
+	database.CreateGameAndLink(files[1].ID, "Other", "SFC", "", "", "", "", "", "", "", "")
+
+	// files[0]'s game is FC, so moving it to SFC unlinks it; files[1] was
+	// linked to an SFC game and keeps it
+	if err := database.ReassignPlatform(files[0].ID, "SFC"); err != nil {
+		t.Fatalf("reassign: %v", err)
+	}
+	if err := database.SetRomPlatform(files[1].ID, "SFC"); err != nil {
+		t.Fatalf("set platform: %v", err)
+	}
+	a, _ := database.GetRomFile(files[0].ID)
+	b, _ := database.GetRomFile(files[1].ID)
+	if a.Platform != "SFC" || a.GameID != nil {
+		t.Errorf("expected SFC with the FC game unlinked, got %s %v", a.Platform, a.GameID)
+	}
+	if b.Platform != "SFC" || b.GameID == nil {
+		t.Errorf("expected the SFC game link kept, got %s %v", b.Platform, b.GameID)
+	}
+	if err := database.ReassignPlatform(999, "SFC"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestBulkReassignPlatform(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(4)
+	files[2].Path = "/roms/misc/a.rom"
+	files[3].Path = "/roms/misc2/b.rom"
+	if err := database.UpsertRomFilesBatch(files); err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+
+	n, err := database.BulkReassignPlatform(ReassignFilter{PathPrefix: "/roms/misc/"}, "MSX")
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 ROM under /roms/misc reassigned, got %d (%v)", n, err)
+	}
+	if msx, _ := database.ListRomFilesByPlatform("MSX"); len(msx) != 1 || msx[0].Path != "/roms/misc/a.rom" {
+		t.Errorf("unexpected MSX ROMs: %+v", msx)
+	}
+
+	if n, err := database.BulkReassignPlatform(ReassignFilter{Platform: "FC"}, "SFC"); err != nil || n != 3 {
+		t.Errorf("expected the 3 FC ROMs reassigned, got %d (%v)", n, err)
+	}
+	if _, err := database.BulkReassignPlatform(ReassignFilter{}, "SFC"); err == nil {
+		t.Error("expected an error without a filter")
+	}
+}
+
+func TestUserRatingAndFavorite(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch(testRomFiles(2))
+	files, _ := database.ListRomFiles()
+
+	if err := database.SetUserRating(files[0].ID, 6); err == nil {
+		t.Error("expected error for 6 stars")
+	}
+	if err := database.SetUserRating(files[0].ID, 4); err != nil {
+		t.Fatalf("set rating: %v", err)
+	}
+	if err := database.SetFavorite(files[1].ID, true); err != nil {
+		t.Fatalf("set favorite: %v", err)
+	}
+	if err := database.SetFavorite(999, true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for missing rom, got %v", err)
+	}
+
+	files, _ = database.ListRomFiles()
+	if files[0].UserRating != 4 || files[0].Favorite {
+		t.Errorf("unexpected first file: rating %d, favorite %v", files[0].UserRating, files[0].Favorite)
+	}
+
+	favs, total, err := database.SearchRoms(SearchFilter{Favorite: true}, 1, 50)
+	if err != nil || total != 1 || favs[0].ID != files[1].ID {
+		t.Errorf("expected only the favorite in search, got %d results (%v)", total, err)
+	}
+}
+
+func TestGetGamesWithoutCovers(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(2)
+	database.UpsertRomFilesBatch(files)
+	if _, err := database.MatchROMs([]DATRom{
+		{GameTitle: "Game A", Platform: "FC", CRC32: files[0].CRC32},
+		{GameTitle: "Game B", Platform: "FC", CRC32: files[1].CRC32},
+	}); err != nil {
+		t.Fatalf("match: %v", err)
+	}
+
+	games, err := database.GetGamesWithoutCovers("FC", "boxart")
+	if err != nil || len(games) != 2 {
+		t.Fatalf("expected 2 games without covers, got %d (%v)", len(games), err)
+	}
+	if err := database.SetCoverArt(games[0].GameID, "boxart", "/covers/a.png"); err != nil {
+		t.Fatalf("set cover: %v", err)
+	}
+
+	games, _ = database.GetGamesWithoutCovers("FC", "boxart")
+	if len(games) != 1 || games[0].TitleEN != "Game B" {
+		t.Errorf("expected only Game B to lack boxart, got %+v", games)
+	}
+	if games, _ := database.GetGamesWithoutCovers("", "snap"); len(games) != 2 {
+		t.Errorf("expected both games to lack snaps, got %d", len(games))
+	}
+	if games, _ := database.GetGamesWithoutCovers("GB", "boxart"); len(games) != 0 {
+		t.Errorf("expected no GB games, got %d", len(games))
+	}
+}
+
+func TestSetGameMetadata(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(1)
+	database.UpsertRomFilesBatch(files)
+	database.MatchROMs([]DATRom{{GameTitle: "Game A", Platform: "FC", CRC32: files[0].CRC32}})
+	games, _ := database.GetGamesWithoutCovers("FC", "boxart")
+	id := games[0].GameID
+
+	database.SetGameMetadata(id, GameMetadata{TitleJA: "ゲーム", Developer: "Wrong Co.", Rating: "0.8"}, false)
+	// Merging keeps values the update leaves empty
+	database.SetGameMetadata(id, GameMetadata{Genre: "Action"}, false)
+	m, err := database.GetGameMetadata(id)
+	if err != nil || m.TitleJA != "ゲーム" || m.Developer != "Wrong Co." || m.Genre != "Action" || m.Rating != "0.8" {
+		t.Errorf("unexpected merged metadata: %+v (%v)", m, err)
+	}
+
+	// Overwriting replaces everything, clearing empty fields
+	database.SetGameMetadata(id, GameMetadata{TitleJA: "ゲーム", Developer: "Right Co."}, true)
+	m, _ = database.GetGameMetadata(id)
+	if m != (GameMetadata{TitleJA: "ゲーム", Developer: "Right Co."}) {
+		t.Errorf("unexpected overwritten metadata: %+v", m)
+	}
+}
+
+func TestJapaneseOnlyGames(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(2)
+	database.UpsertRomFilesBatch(files)
+	database.MatchROMs([]DATRom{
+		{GameTitle: "Game A", Platform: "FC", CRC32: files[0].CRC32},
+		{GameTitle: "Game B", Platform: "FC", CRC32: files[1].CRC32},
+	})
+	f, _ := database.ListRomFiles()
+	id := *f[0].GameID
+	database.Exec(`UPDATE games SET title_en = NULL, title_ja = 'ゲームA' WHERE id = ?`, id)
+
+	games, err := database.GetJapaneseOnlyGames("FC")
+	if err != nil || len(games) != 1 || games[0] != (JapaneseOnlyGame{GameID: id, TitleJA: "ゲームA", Platform: "FC"}) {
+		t.Fatalf("unexpected Japanese-only games: %+v (%v)", games, err)
+	}
+	if games, _ := database.GetJapaneseOnlyGames("GB"); len(games) != 0 {
+		t.Errorf("expected no GB games, got %+v", games)
+	}
+
+	if err := database.SetGameTitleEN(id, "Game A (Japan)"); err != nil {
+		t.Fatal(err)
+	}
+	// An English title that is already set is kept
+	database.SetGameTitleEN(id, "Other")
+	if g, _ := database.GetGame(id); g.TitleEN != "Game A (Japan)" {
+		t.Errorf("title_en = %q", g.TitleEN)
+	}
+	if games, _ := database.GetJapaneseOnlyGames(""); len(games) != 0 {
+		t.Errorf("expected no Japanese-only games left, got %+v", games)
+	}
+}
+
+func TestGamesMissingJA(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/a.nes", Filename: "a.nes", Size: 1, CRC32: "00000001", Platform: "FC"},
+		{Path: "/roms/fc/b.nes", Filename: "b.nes", Size: 1, CRC32: "00000002", Platform: "FC"},
+		{Path: "/roms/fc/c.nes", Filename: "c.nes", Size: 1, CRC32: "00000003", Platform: "FC"},
+		{Path: "/roms/gb/d.gb", Filename: "d.gb", Size: 1, CRC32: "00000004", Platform: "GB"},
+	})
+	database.MatchROMs([]DATRom{
+		{GameTitle: "Zelda", Platform: "FC", CRC32: "00000001"},
+		{GameTitle: "Mother", Platform: "FC", CRC32: "00000002"},
+		{GameTitle: "Rockman", Platform: "FC", CRC32: "00000003"},
+		{GameTitle: "Tetris", Platform: "GB", CRC32: "00000004"},
+	})
+	// A DAT game without ROMs is no work for anyone
+	database.ImportDATGames([]DATRom{{GameTitle: "Unowned", Platform: "FC"}})
+	ids := map[string]int64{}
+	files, _ := database.ListRomFiles()
+	for _, f := range files {
+		ids[*f.TitleEN] = *f.GameID
+	}
+	database.SetGameMetadata(ids["Mother"], GameMetadata{TitleJA: "マザー"}, false)
+	database.SetGameMetadata(ids["Rockman"], GameMetadata{TitleJA: "ロックマン", DescJA: "説明"}, false)
+
+	games, err := database.GamesMissingJA("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, g := range games {
+		got = append(got, g.Platform+" "+g.TitleEN+" "+g.TitleJA)
+	}
+	want := []string{"FC Mother マザー", "FC Zelda ", "GB Tetris "}
+	if !slices.Equal(got, want) {
+		t.Errorf("GamesMissingJA = %q, want %q", got, want)
+	}
+	if games, _ := database.GamesMissingJA("GB"); len(games) != 1 || games[0].ID != ids["Tetris"] {
+		t.Errorf("GB games %+v", games)
+	}
+}
+
+func TestGetGame(t *testing.T) {
+	database := openTestDB(t)
+	files := testRomFiles(1)
+	database.UpsertRomFilesBatch(files)
+	database.MatchROMs([]DATRom{{GameTitle: "Game A", Platform: "FC", CRC32: files[0].CRC32}})
+	f, _ := database.ListRomFiles()
+	id := *f[0].GameID
+
+	database.SetGameMetadata(id, GameMetadata{TitleJA: "ゲーム", Genre: "Action"}, false)
+	database.SetCoverArt(id, "boxart", "/covers/a.png")
+
+	g, err := database.GetGame(id)
+	if err != nil {
+		t.Fatalf("get game: %v", err)
+	}
+	if g.TitleEN != "Game A" || g.TitleJA != "ゲーム" || g.Genre != "Action" || g.Platform != "FC" {
+		t.Errorf("unexpected game: %+v", g)
+	}
+	if len(g.Covers) != 1 || g.Covers[0] != (CoverArt{"boxart", "/covers/a.png"}) {
+		t.Errorf("unexpected covers: %+v", g.Covers)
+	}
+	if _, err := database.GetGame(999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	database := openTestDB(t)
+	dir := t.TempDir()
+	present := dir + "/present.nes"
+	os.WriteFile(present, []byte("rom"), 0644)
+	// "!" in a plain file's name doesn't make it a ZIP entry
+	bang := dir + "/Pitfall! (USA).nes"
+	os.WriteFile(bang, []byte("rom"), 0644)
+	pack := dir + "/Pack.zip"
+	os.WriteFile(pack, []byte("zip"), 0644)
+
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: present, Filename: "present.nes", CRC32: "00000001", MD5: "01", SHA1: "AA", Platform: "FC"},
+		{Path: dir + "/gone.nes", Filename: "gone.nes", CRC32: "00000002", MD5: "02", SHA1: "AA", Platform: "FC"},
+		{Path: bang, Filename: "Pitfall! (USA).nes", CRC32: "00000004", MD5: "04", SHA1: "BB", Platform: "FC"},
+		{Path: pack + "!inner.xyz", Filename: "Pack.zip/inner.xyz", CRC32: "00000003", Platform: "XYZ"},
+	})
+	database.InsertGame("Lonely Game", "FC", "", "", "", 0)
+
+	r, err := database.Diagnose([]string{"FC"})
+	if err != nil {
+		t.Fatalf("diagnose: %v", err)
+	}
+	if r.EmptyHashes.Count != 1 || r.MissingFiles.Count != 1 || r.MissingFiles.Samples[0] != dir+"/gone.nes" {
+		t.Errorf("unexpected critical issues: %+v, %+v", r.EmptyHashes, r.MissingFiles)
+	}
+	if r.OrphanGames.Count != 1 || r.DuplicateSHA1.Count != 1 || r.UnknownPlatforms.Samples[0] != "XYZ" {
+		t.Errorf("unexpected warnings: %+v, %+v, %+v", r.OrphanGames, r.DuplicateSHA1, r.UnknownPlatforms)
+	}
+	if !r.Critical() {
+		t.Error("expected report to be critical")
+	}
+}
+
+func TestGameListMediaRoundTrip(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFile(RomFileInput{Path: "/roms/fc/game.nes", Filename: "game.nes", Platform: "FC"})
+
+	entries := []GameListEntry{{Filename: "game.nes", Name: "ゲーム", Image: "/roms/fc/images/game.png", Marquee: "/roms/fc/marquees/game.png"}}
+	if _, matched, err := database.MatchByGameList(entries, "FC"); err != nil || matched != 1 {
+		t.Fatalf("import: matched %d (%v)", matched, err)
+	}
+	// Re-importing replaces rather than duplicates media
+	entries[0].Image = "/roms/fc/images/game-new.png"
+	database.MatchByGameList(entries, "FC")
+
+	exported, err := database.ExportGameList("FC")
+	if err != nil || len(exported) != 1 {
+		t.Fatalf("export: %d entries (%v)", len(exported), err)
+	}
+	e := exported[0]
+	if e.Image != "/roms/fc/images/game-new.png" || e.Marquee != "/roms/fc/marquees/game.png" || e.Thumbnail != "" {
+		t.Errorf("unexpected exported media: image %q, thumbnail %q, marquee %q", e.Image, e.Thumbnail, e.Marquee)
+	}
+}
+
+func TestUpsertRomFileRoundTrip(t *testing.T) {
+	database := openTestDB(t)
+	in := RomFileInput{Path: "/roms/sfc/zoop.sfc", Filename: "zoop.sfc", Size: 524800, ModTime: 1700000000,
+		CRC32: "abcdef", MD5: "0x" + strings.Repeat("a", 32), SHA1: strings.Repeat("b", 40),
+		CRC32NoHdr: "12345678", Platform: "SFC"}
+	if err := database.UpsertRomFile(in); err != nil {
+		t.Fatal(err)
+	}
+	files, err := database.ListRomFiles()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d (%v)", len(files), err)
+	}
+	f := files[0]
+	if f.Path != in.Path || f.Filename != in.Filename || f.Size != in.Size || f.Platform != "SFC" || f.GameID != nil {
+		t.Errorf("unexpected file %+v", f)
+	}
+	// Hashes are stored normalized
+	if f.HashCRC32 != "00ABCDEF" || f.HashMD5 != strings.Repeat("A", 32) || f.HashSHA1 != strings.Repeat("B", 40) {
+		t.Errorf("unexpected hashes %s %s %s", f.HashCRC32, f.HashMD5, f.HashSHA1)
+	}
+	states, _ := database.GetRomFileStates()
+	if st := states[in.Path]; st.Size != in.Size || st.ModTime != in.ModTime {
+		t.Errorf("unexpected state %+v", st)
+	}
+
+	// Rescanning the path replaces the row, keeping its ID
+	in.Filename, in.CRC32, in.CRC32NoHdr = "Zoop (USA).sfc", "11111111", ""
+	database.UpsertRomFile(in)
+	if again, _ := database.ListRomFiles(); len(again) != 1 || again[0].ID != f.ID || again[0].Filename != "Zoop (USA).sfc" || again[0].HashCRC32 != "11111111" {
+		t.Errorf("expected the row updated in place, got %+v", again)
+	}
+	var nohdr sql.NullString
+	database.QueryRow(`SELECT hash_crc32_nohdr FROM rom_files`).Scan(&nohdr)
+	if nohdr.Valid {
+		t.Errorf("expected the headerless hash cleared, got %q", nohdr.String)
+	}
+}
+
+func TestMatchROMs(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/a.nes", Filename: "a.nes", Size: 1, CRC32: "00000001", SHA1: strings.Repeat("1", 40), Platform: "FC"},
+		{Path: "/roms/fc/b.nes", Filename: "b.nes", Size: 1, CRC32: "00000002", CRC32NoHdr: "0000000B", Platform: "FC"},
+		{Path: "/roms/fc/b2.nes", Filename: "b2.nes", Size: 1, CRC32: "0000000B", Platform: "FC"},
+		{Path: "/roms/fc/c.nes", Filename: "c.nes", Size: 1, CRC32: "00000003", Platform: "FC"},
+		{Path: "/roms/fc/d.nes", Filename: "d.nes", Size: 1, CRC32: "00000004", Platform: "FC"},
+	})
+	// c.nes is already linked to a game known only by its Japanese title
+	database.MatchByGameList([]GameListEntry{{Filename: "c.nes", Name: "ゲームC"}}, "FC")
+
+	matched, err := database.MatchROMs([]DATRom{
+		// SHA1 wins over a CRC32 that doesn't match
+		{GameTitle: "Game A", Platform: "FC", CRC32: "FFFFFFFF", SHA1: strings.Repeat("1", 40)},
+		// Headerless hashes match too, and both ROMs share one game
+		{GameTitle: "Game B", Platform: "FC", CRC32: "0000000B"},
+		{GameTitle: "Game C", Platform: "FC", CRC32: "00000003"},
+		{GameTitle: "Unknown", Platform: "FC", CRC32: "00000009"},
+		{GameTitle: "No Hashes", Platform: "FC"},
+		// A nodump's placeholder hash matches nothing
+		{GameTitle: "Never Dumped", Platform: "FC", CRC32: "00000004", Status: StatusNoDump},
+	})
+	if err != nil || matched != 4 {
+		t.Fatalf("expected 4 matched, got %d (%v)", matched, err)
+	}
+
+	files, _ := database.ListRomFiles()
+	titles := map[string]string{}
+	games := map[string]int64{}
+	for _, f := range files {
+		if f.GameID == nil {
+			titles[f.Filename] = ""
+			continue
+		}
+		titles[f.Filename] = *f.TitleEN
+		games[f.Filename] = *f.GameID
+	}
+	want := map[string]string{"a.nes": "Game A", "b.nes": "Game B", "b2.nes": "Game B", "c.nes": "Game C", "d.nes": ""}
+	for name, title := range want {
+		if titles[name] != title {
+			t.Errorf("%s: title %q, want %q", name, titles[name], title)
+		}
+	}
+	if games["b.nes"] != games["b2.nes"] {
+		t.Error("expected ROMs matching the same DAT title to share a game")
+	}
+	// The linked game keeps its ID and Japanese title, gaining an English one
+	if g, _ := database.GetGame(games["c.nes"]); g == nil || g.TitleJA != "ゲームC" {
+		t.Errorf("expected c.nes to keep its game, got %+v", g)
+	}
+
+	// Matching again creates no games and keeps existing English titles
+	database.MatchROMs([]DATRom{{GameTitle: "Game A (Rev 1)", Platform: "FC", SHA1: strings.Repeat("1", 40)}})
+	var n int
+	database.QueryRow(`SELECT COUNT(*) FROM games`).Scan(&n)
+	if g, _ := database.GetGame(games["a.nes"]); n != 3 || g.TitleEN != "Game A" {
+		t.Errorf("rematch: %d games, a.nes titled %q", n, g.TitleEN)
+	}
+}
+
+// cancelAfter is a context cancelled on its nth Err call, for cancelling a
+// loop partway through. database/sql calls Err from its own goroutines too.
+type cancelAfter struct {
+	context.Context
+	cancel context.CancelFunc
+	n      atomic.Int64
+}
+
+func newCancelAfter(n int) *cancelAfter {
+	c := &cancelAfter{}
+	c.Context, c.cancel = context.WithCancel(context.Background())
+	c.n.Store(int64(n))
+	return c
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n.Add(-1) < 0 {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+func TestImportDATGamesFuncProgress(t *testing.T) {
+	database := openTestDB(t)
+	var reports []int
+	count, err := database.ImportDATGamesFunc(context.Background(), func(add func(DATRom) error) error {
+		for i := range 2500 {
+			if err := add(DATRom{GameTitle: fmt.Sprintf("Game %d", i), Platform: "FC", CRC32: fmt.Sprintf("%08X", i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(done int) { reports = append(reports, done) })
+	if err != nil || count != 2500 {
+		t.Fatalf("import: %d, %v", count, err)
+	}
+	if !slices.Equal(reports, []int{1000, 2000, 2500}) {
+		t.Errorf("progress reported %v", reports)
+	}
+}
+
+func TestImportDATGamesFunc(t *testing.T) {
+	database := openTestDB(t)
+	read := func(n int, err error) func(add func(DATRom) error) error {
+		return func(add func(DATRom) error) error {
+			for i := range n {
+				if err := add(DATRom{GameTitle: fmt.Sprintf("Game %d", i/2), Platform: "FC", CRC32: fmt.Sprintf("%08X", i)}); err != nil {
+					return err
+				}
+			}
+			return err
+		}
+	}
+
+	// Failing once read, as on a DAT with games of unknown platform, nothing is kept
+	if _, err := database.ImportDATGamesFunc(context.Background(), read(10, errors.New("unknown platform")), nil); err == nil {
+		t.Fatal("expected the read error")
+	}
+	var games, roms int
+	database.QueryRow(`SELECT COUNT(*) FROM games`).Scan(&games)
+	database.QueryRow(`SELECT COUNT(*) FROM dat_roms`).Scan(&roms)
+	if games != 0 || roms != 0 {
+		t.Fatalf("failed import left %d games and %d DAT ROMs", games, roms)
+	}
+
+	count, err := database.ImportDATGamesFunc(context.Background(), read(10, nil), nil)
+	if err != nil || count != 5 {
+		t.Fatalf("import: %d, %v", count, err)
+	}
+	if stored, _ := database.GetDATRoms("FC"); len(stored) != 10 {
+		t.Errorf("stored %d DAT ROMs, want 10", len(stored))
+	}
+}
+
+func TestMatchAllStoredNoDump(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/a.nes", Filename: "a.nes", Size: 1, CRC32: "00000000", Platform: "FC"},
+		{Path: "/roms/fc/b.nes", Filename: "b.nes", Size: 1, CRC32: "00000001", Platform: "FC"},
+	})
+	// As import-dat --include-nodump stores them
+	_, err := database.ImportDATGames([]DATRom{
+		{GameTitle: "Never Dumped", Platform: "FC", CRC32: "00000000", Status: StatusNoDump},
+		{GameTitle: "Game B", Platform: "FC", CRC32: "00000001", Status: StatusBadDump},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roms, _ := database.GetDATRoms("FC"); len(roms) != 2 || roms[0].Status != StatusNoDump || roms[1].Status != StatusBadDump {
+		t.Fatalf("expected the statuses stored, got %+v", roms)
+	}
+
+	if matched, err := database.MatchAllStored(""); err != nil || matched != 1 {
+		t.Fatalf("expected only the baddump matched, got %d (%v)", matched, err)
+	}
+	roms, _ := database.GetDATRoms("FC")
+	if matched, err := database.MatchROMs(roms); err != nil || matched != 1 {
+		t.Errorf("MatchROMs on the stored entries: %d (%v), want 1", matched, err)
+	}
+	if files, _ := database.GetUnmatchedRoms("FC"); len(files) != 1 || files[0].Filename != "a.nes" {
+		t.Errorf("expected a.nes unmatched, got %+v", files)
+	}
+}
+
+func TestMatchROMsContext(t *testing.T) {
+	// database/sql drops the connection of a cancelled transaction, and with
+	// it an in-memory database
+	database, err := OpenPath(filepath.Join(t.TempDir(), "romu.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	var inputs []RomFileInput
+	var dat []DATRom
+	for i := range 50 {
+		crc := fmt.Sprintf("%08X", i+1)
+		inputs = append(inputs, RomFileInput{Path: fmt.Sprintf("/roms/fc/%d.nes", i), Filename: fmt.Sprintf("%d.nes", i), Size: 1, CRC32: crc, Platform: "FC"})
+		dat = append(dat, DATRom{GameTitle: fmt.Sprintf("Game %d", i), Platform: "FC", CRC32: crc})
+	}
+	database.UpsertRomFilesBatch(inputs)
+
+	// Cancelled partway through, nothing is linked
+	matched, err := database.MatchROMsContext(newCancelAfter(10), dat)
+	if !errors.Is(err, context.Canceled) || matched != 0 {
+		t.Fatalf("cancelled match: %d matched, %v", matched, err)
+	}
+	var linked, games int
+	database.QueryRow(`SELECT COUNT(*) FROM rom_files WHERE game_id IS NOT NULL`).Scan(&linked)
+	database.QueryRow(`SELECT COUNT(*) FROM games`).Scan(&games)
+	if linked != 0 || games != 0 {
+		t.Errorf("cancelled match left %d ROMs linked and %d games", linked, games)
+	}
+
+	// A context cancelled up front stops the other long operations too
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := database.ImportDATGamesContext(ctx, dat); !errors.Is(err, context.Canceled) {
+		t.Errorf("ImportDATGamesContext: %v", err)
+	}
+	if _, err := database.MatchAllStoredContext(ctx, ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("MatchAllStoredContext: %v", err)
+	}
+	if _, _, err := database.SearchRomsContext(ctx, SearchFilter{}, 1, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("SearchRomsContext: %v", err)
+	}
+
+	matched, err = database.MatchROMsContext(context.Background(), dat)
+	if err != nil || matched != len(dat) {
+		t.Errorf("expected %d matched, got %d (%v)", len(dat), matched, err)
+	}
+}
+
+func TestMergeGames(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/a.nes", Filename: "a.nes", Size: 1, CRC32: "00000001", Platform: "FC"},
+		{Path: "/roms/fc/b.nes", Filename: "b.nes", Size: 1, CRC32: "00000002", Platform: "FC"},
+		{Path: "/roms/fc/c.nes", Filename: "c.nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/fc/d.nes", Filename: "d.nes", Size: 1, CRC32: "00000004", Platform: "FC"},
+		{Path: "/roms/fc/e.nes", Filename: "e.nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/gb/f.gb", Filename: "f.gb", Size: 1, CRC32: "00000006", Platform: "GB"},
+	})
+	database.MatchROMs([]DATRom{
+		{GameTitle: "Rockman (Japan)", Platform: "FC", CRC32: "00000001"},
+		{GameTitle: "Rockman (Japan) (Rev 1)", Platform: "FC", CRC32: "00000002"},
+		{GameTitle: "Tetris (World)", Platform: "FC", CRC32: "00000004"},
+		{GameTitle: "Tetris (World)", Platform: "GB", CRC32: "00000006"},
+	})
+	database.MatchByGameList([]GameListEntry{
+		{Filename: "c.nes", Name: "ロックマン", Developer: "Capcom"},
+		{Filename: "e.nes", Name: "ＴＥＴＲＩＳ  (World) [!]"},
+	}, "FC")
+	ids := map[string]int64{}
+	files, _ := database.ListRomFiles()
+	for _, f := range files {
+		ids[f.Filename] = *f.GameID
+	}
+	// Both DAT releases are known as ロックマン after enriching
+	database.SetGameMetadata(ids["a.nes"], GameMetadata{TitleJA: "ロックマン"}, false)
+	database.SetGameMetadata(ids["b.nes"], GameMetadata{TitleJA: "ロックマン"}, false)
+
+	// The gamelist games pair up with a DAT game each, but the two releases,
+	// with different English titles, and the GB game stay apart
+	dups, err := database.FindDuplicateGames("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range dups {
+		var gameIDs []string
+		for _, g := range d.Games {
+			gameIDs = append(gameIDs, fmt.Sprint(g.ID))
+		}
+		got = append(got, d.Platform+" "+d.Title+" "+strings.Join(gameIDs, ","))
+	}
+	want := []string{
+		fmt.Sprintf("FC ロックマン %d,%d", ids["a.nes"], ids["c.nes"]),
+		fmt.Sprintf("FC ＴＥＴＲＩＳ  (World) [!] %d,%d", ids["d.nes"], ids["e.nes"]),
+	}
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("duplicates %q, want %q", got, want)
+	}
+	if dups, _ := database.FindDuplicateGames("GB"); len(dups) != 0 {
+		t.Errorf("GB duplicates %+v", dups)
+	}
+
+	database.SetCoverArt(ids["a.nes"], "boxart", "/covers/a.png")
+	database.SetCoverArt(ids["c.nes"], "boxart", "/covers/c.png")
+	database.SetCoverArt(ids["c.nes"], "screenshot", "/covers/c-shot.png")
+	if err := database.MergeGames(ids["a.nes"], ids["c.nes"]); err != nil {
+		t.Fatal(err)
+	}
+	g, err := database.GetGame(ids["a.nes"])
+	if err != nil || g.TitleEN != "Rockman (Japan)" || g.TitleJA != "ロックマン" || g.Developer != "Capcom" {
+		t.Errorf("kept game %+v, %v", g, err)
+	}
+	covers := map[string]string{}
+	for _, c := range g.Covers {
+		covers[c.ImageType] = c.FilePath
+	}
+	if len(covers) != 2 || covers["boxart"] != "/covers/a.png" || covers["screenshot"] != "/covers/c-shot.png" {
+		t.Errorf("kept covers %v", covers)
+	}
+	if _, err := database.GetGame(ids["c.nes"]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("merged game: %v", err)
+	}
+	var n int
+	database.QueryRow(`SELECT COUNT(*) FROM rom_files WHERE game_id = ?`, ids["a.nes"]).Scan(&n)
+	if n != 2 {
+		t.Errorf("%d ROMs on the kept game, want 2", n)
+	}
+
+	// Nothing changes when a merge can't be done
+	if err := database.MergeGames(ids["d.nes"], ids["d.nes"]); err == nil {
+		t.Error("merged a game with itself")
+	}
+	if err := database.MergeGames(ids["d.nes"], ids["f.gb"]); err == nil {
+		t.Error("merged games of different platforms")
+	}
+	if err := database.MergeGames(ids["d.nes"], 9999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("merging a missing game: %v", err)
+	}
+	if g, err := database.GetGame(ids["d.nes"]); err != nil || g.TitleEN != "Tetris (World)" {
+		t.Errorf("failed merge changed %+v, %v", g, err)
+	}
+}
+
+func TestMatchByGameList(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/plain.nes", Filename: "plain.nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/fc/Pack.zip/inner.nes", Filename: "Pack.zip/inner.nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/fc/Other.zip/dir/deep.nes", Filename: "Other.zip/dir/deep.nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/sfc/plain.nes", Filename: "plain.nes", Size: 1, Platform: "SFC"},
+	})
+
+	created, matched, err := database.MatchByGameList([]GameListEntry{
+		{Filename: "./plain.nes", Name: "プレーン", Developer: "Dev", Desc: "説明"},
+		{Filename: "./Pack.zip", Name: "パック"},      // by archive name
+		{Filename: "./sub/deep.nes", Name: "ディープ"}, // by the inner file's name
+		{Filename: "./missing.nes", Name: "ない"},    // no such ROM
+	}, "FC")
+	if err != nil || created != 3 || matched != 3 {
+		t.Fatalf("expected 3 created and matched, got %d, %d (%v)", created, matched, err)
+	}
+	files, _ := database.ListRomFilesByPlatform("FC")
+	var plainGame int64
+	for _, f := range files {
+		if f.TitleJA == nil {
+			t.Errorf("%s was not matched", f.Filename)
+			continue
+		}
+		if f.Filename == "plain.nes" {
+			plainGame = *f.GameID
+		}
+	}
+	if other, _ := database.ListRomFilesByPlatform("SFC"); other[0].GameID != nil {
+		t.Error("a ROM of another platform was matched")
+	}
+
+	// Importing again fills in new metadata without clearing what the
+	// gamelist leaves empty
+	created, _, _ = database.MatchByGameList([]GameListEntry{{Filename: "plain.nes", Name: "プレーン", Publisher: "Pub"}}, "FC")
+	g, _ := database.GetGame(plainGame)
+	if created != 0 || g.TitleJA != "プレーン" || g.Developer != "Dev" || g.Publisher != "Pub" || g.DescJA != "説明" {
+		t.Errorf("reimport: created %d, game %+v", created, g)
+	}
+}
+
+func TestMatchByGameListLinkedGame(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/rockman.nes", Filename: "rockman.nes", Size: 1, CRC32: "00000001", Platform: "FC"},
+		{Path: "/roms/fc/homebrew.nes", Filename: "homebrew.nes", Size: 1, Platform: "FC"},
+	})
+	if _, err := database.ImportDATGames([]DATRom{{GameTitle: "Rockman (Japan)", Platform: "FC", CRC32: "00000001"}}); err != nil {
+		t.Fatal(err)
+	}
+	if matched, err := database.MatchAllStored("FC"); err != nil || matched != 1 {
+		t.Fatalf("match: %d, %v", matched, err)
+	}
+
+	// The DAT game gains the gamelist's Japanese title and metadata
+	created, matched, err := database.MatchByGameList([]GameListEntry{
+		{Filename: "./rockman.nes", Name: "ロックマン", Developer: "Capcom"},
+		{Filename: "./homebrew.nes", Name: "自作"},
+	}, "FC")
+	if err != nil || created != 1 || matched != 2 {
+		t.Fatalf("expected 1 created and 2 matched, got %d, %d (%v)", created, matched, err)
+	}
+	var games int
+	database.QueryRow(`SELECT COUNT(*) FROM games WHERE platform = 'FC'`).Scan(&games)
+	files, _ := database.ListRomFilesByPlatform("FC")
+	var rockman *RomFile
+	for i := range files {
+		if files[i].Filename == "rockman.nes" {
+			rockman = &files[i]
+		}
+	}
+	if games != 2 || rockman == nil || rockman.GameID == nil {
+		t.Fatalf("%d games, rockman.nes %+v", games, rockman)
+	}
+	g, _ := database.GetGame(*rockman.GameID)
+	if g.TitleEN != "Rockman (Japan)" || g.TitleJA != "ロックマン" || g.Developer != "Capcom" {
+		t.Errorf("linked game %+v", g)
+	}
+}
+
+func TestMultiRomArchive(t *testing.T) {
+	database := openTestDB(t)
+	// The scanner records each ZIP entry by archive!entry
+	entries := []RomFileInput{
+		{Path: "/roms/fc/pack.zip!a.nes", Filename: "pack.zip/a.nes", Size: 1, CRC32: "0000000A", Platform: "FC"},
+		{Path: "/roms/fc/pack.zip!b.nes", Filename: "pack.zip/b.nes", Size: 2, CRC32: "0000000B", Platform: "FC"},
+	}
+	if err := database.UpsertRomFilesBatch(entries); err != nil {
+		t.Fatal(err)
+	}
+	// Updating one entry leaves the other alone
+	entries[0].Size = 3
+	database.UpsertRomFilesBatch(entries[:1])
+
+	files, _ := database.ListRomFiles()
+	if len(files) != 2 || files[0].Size != 3 || files[1].Size != 2 {
+		t.Fatalf("expected both entries, got %+v", files)
+	}
+	states, _ := database.GetRomFileStates()
+	if len(states) != 2 {
+		t.Errorf("expected a state per entry, got %v", states)
+	}
+
+	// A gamelist naming the entries gives each its own game
+	created, matched, err := database.MatchByGameList([]GameListEntry{
+		{Filename: "./a.nes", Name: "エー"},
+		{Filename: "./b.nes", Name: "ビー"},
+	}, "FC")
+	if err != nil || created != 2 || matched != 2 {
+		t.Fatalf("expected 2 created and matched, got %d, %d (%v)", created, matched, err)
+	}
+	files, _ = database.ListRomFiles()
+	if files[0].TitleJA == nil || *files[0].TitleJA != "エー" || files[1].TitleJA == nil || *files[1].TitleJA != "ビー" {
+		t.Errorf("unexpected matches %v, %v", files[0].TitleJA, files[1].TitleJA)
+	}
+}
+
+func TestExportPlaylist(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/Pack.zip!inner.nes", Filename: "Pack.zip/inner.nes", Size: 1, CRC32: "0000000A", Platform: "FC"},
+		{Path: "/roms/fc/plain.nes", Filename: "plain.nes", Size: 1, CRC32: "00000002", Platform: "FC"},
+		{Path: "/roms/fc/unknown.nes", Filename: "unknown.nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/fc/Yes! (Japan).nes", Filename: "Yes! (Japan).nes", Size: 1, Platform: "FC"},
+	})
+	database.MatchROMs([]DATRom{{GameTitle: "Packed Game", Platform: "FC", CRC32: "0000000A"}})
+	database.MatchByGameList([]GameListEntry{{Filename: "plain.nes", Name: "プレーン"}}, "FC")
+
+	items, err := database.ExportPlaylist("FC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const dbName = "Nintendo - Nintendo Entertainment System.lpl"
+	want := []PlaylistItem{
+		{Path: "/roms/fc/Pack.zip#inner.nes", Label: "Packed Game", CRC32: "0000000A|crc"},
+		// Only the archive's "!" separates an entry
+		{Path: "/roms/fc/Yes! (Japan).nes", Label: "Yes! (Japan)", CRC32: "DETECT"},
+		{Path: "/roms/fc/plain.nes", Label: "プレーン", CRC32: "00000002|crc"},
+		{Path: "/roms/fc/unknown.nes", Label: "unknown", CRC32: "DETECT"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %+v", items)
+	}
+	for i, w := range want {
+		w.CorePath, w.CoreName, w.DBName = "DETECT", "DETECT", dbName
+		if items[i] != w {
+			t.Errorf("item %d = %+v, want %+v", i, items[i], w)
+		}
+	}
+
+	// Platforms RetroArch has no database for are named by their code
+	database.UpsertRomFilesBatch([]RomFileInput{{Path: "/roms/arcade/x.zip", Filename: "x.zip", Size: 1, Platform: "ARCADE"}})
+	if items, _ := database.ExportPlaylist("ARCADE"); len(items) != 1 || items[0].DBName != "ARCADE.lpl" {
+		t.Errorf("arcade: %+v", items)
+	}
+}
+
+func TestMatchByPlaylist(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/gb/tetris.gb", Filename: "tetris.gb", Size: 1, CRC32: "46DF91AD", Platform: "GB"},
+		{Path: "/roms/gb/Pack.zip!Kirby (Japan).gb", Filename: "Pack.zip/Kirby (Japan).gb", Size: 1, Platform: "GB"},
+		{Path: "/roms/gb/mario.gb", Filename: "mario.gb", Size: 1, CRC32: "00000001", Platform: "GB"},
+		{Path: "/roms/gb/matched.gb", Filename: "matched.gb", Size: 1, CRC32: "00000002", Platform: "GB"},
+	})
+	database.MatchROMs([]DATRom{{GameTitle: "Already Matched", Platform: "GB", CRC32: "00000002"}})
+	database.InsertGame("Super Mario Land (World)", "GB", "", "", "", 0)
+
+	created, matched, err := database.MatchByPlaylist([]PlaylistItem{
+		{Path: "/elsewhere/renamed.gb", Label: "Tetris (World)", CRC32: "46df91ad|crc"},                // by CRC32
+		{Path: `C:\roms\Other.zip#Kirby (Japan).gb`, Label: "Hoshi no Kirby (Japan)", CRC32: "DETECT"}, // by inner name
+		{Path: "/roms/gb/mario.gb", Label: "Super Mario Land (World)", CRC32: "DETECT"},                // existing game
+		{Path: "/roms/gb/matched.gb", Label: "Other Title", CRC32: "00000002|crc"},                     // already matched
+		{Path: "/roms/gb/missing.gb", Label: "Missing", CRC32: "DETECT"},
+	}, "GB")
+	if err != nil || created != 2 || matched != 3 {
+		t.Fatalf("expected 2 created and 3 matched, got %d, %d (%v)", created, matched, err)
+	}
+	titles := map[string]string{}
+	files, _ := database.ListRomFilesByPlatform("GB")
+	for _, f := range files {
+		if f.TitleEN != nil {
+			titles[f.Filename] = *f.TitleEN
+		}
+	}
+	want := map[string]string{
+		"tetris.gb":                 "Tetris (World)",
+		"Pack.zip/Kirby (Japan).gb": "Hoshi no Kirby (Japan)",
+		"mario.gb":                  "Super Mario Land (World)",
+		"matched.gb":                "Already Matched",
+	}
+	for file, title := range want {
+		if titles[file] != title {
+			t.Errorf("%s: got %q, want %q", file, titles[file], title)
+		}
+	}
+}
+
+func TestExportGameListPaths(t *testing.T) {
+	database := openTestDB(t)
+	database.UpsertRomFilesBatch([]RomFileInput{
+		{Path: "/roms/fc/Pack.zip!inner.nes", Filename: "Pack.zip/inner.nes", Size: 1, CRC32: "00000001", Platform: "FC"},
+		{Path: "/roms/fc/plain.nes", Filename: "plain.nes", Size: 1, CRC32: "00000002", Platform: "FC"},
+		{Path: "/roms/fc/unknown.nes", Filename: "unknown.nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/fc/Yes! (Japan).nes", Filename: "Yes! (Japan).nes", Size: 1, Platform: "FC"},
+		{Path: "/roms/fc/Yes! (Japan).nes", Filename: "Yes! (Japan).nes", Size: 1, Platform: "FC"},
+	})
+	database.MatchROMs([]DATRom{
+		{GameTitle: "Packed Game", Platform: "FC", CRC32: "00000001"},
+		{GameTitle: "Plain Game", Platform: "FC", CRC32: "00000002"},
+	})
+	database.MatchByGameList([]GameListEntry{{Filename: "plain.nes", Name: "プレーン"}}, "FC")
+
+	entries, err := database.ExportGameList("FC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, e := range entries {
+		got[e.Path] = e.Name
+		if want := "/roms/fc" + e.Path[1:]; e.File != want {
+			t.Errorf("%s: file %q, want %q", e.Path, e.File, want)
+		}
+	}
+	// Names fall back from title_ja to title_en to the file name
+	want := map[string]string{"./Pack.zip": "Packed Game", "./plain.nes": "プレーン", "./unknown.nes": "unknown.nes", "./Yes! (Japan).nes": "Yes! (Japan).nes"}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for path, name := range want {
+		if got[path] != name {
+			t.Errorf("%s: name %q, want %q", path, got[path], name)
+		}
+	}
+}
+
+func TestGameListPath(t *testing.T) {
+	for filename, want := range map[string]string{
+		"game.nes":                  "./game.nes",
+		"Pack.zip/inner.nes":        "./Pack.zip",
+		"Pack.ZIP/dir/inner.nes":    "./Pack.ZIP",
+		"Pack.7z/inner.nes":         "./Pack.7z",
+		"Sonic (Europe).zip":        "./Sonic (Europe).zip",
+		"sub/dir/game.nes":          "./game.nes",
+		"sub/Pack.7z/dir/inner.nes": "./Pack.7z",
+		"Not.zipped/inner/game.nes": "./game.nes",
+	} {
+		if got := gameListPath(filename); got != want {
+			t.Errorf("gameListPath(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	for path, want := range map[string][3]string{
+		"/roms/fc/Pack.zip!inner.nes":             {"/roms/fc/Pack.zip", "inner.nes", "true"},
+		"/roms/fc/Pack.ZIP!dir/inner.nes":         {"/roms/fc/Pack.ZIP", "dir/inner.nes", "true"},
+		"/roms/fc/Punch-Out!! (USA).zip!p.nes":    {"/roms/fc/Punch-Out!! (USA).zip", "p.nes", "true"},
+		"/roms/Hits!/Pitfall! (USA).zip!Pit!.a26": {"/roms/Hits!/Pitfall! (USA).zip", "Pit!.a26", "true"},
+		"/roms/a2600/Pitfall! (USA).a26":          {"/roms/a2600/Pitfall! (USA).a26", "", "false"},
+		"/roms/Yes!/game.nes":                     {"/roms/Yes!/game.nes", "", "false"},
+		"/roms/fc/plain.nes":                      {"/roms/fc/plain.nes", "", "false"},
+	} {
+		archive, inner, ok := SplitArchivePath(path)
+		if got := [3]string{archive, inner, fmt.Sprint(ok)}; got != want {
+			t.Errorf("SplitArchivePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	// Locking between connections needs a database file
+	database, err := OpenPath(filepath.Join(t.TempDir(), "romu.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	database.UpsertRomFilesBatch(testRomFiles(100))
+
+	// A long write transaction that reads before it writes, like MatchROMs
+	// during a scan
+	tx, err := database.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM rom_files`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := database.GetStats(); err != nil {
+					errs <- fmt.Errorf("read: %w", err)
+				}
+				if _, _, err := database.ListRomFilesPaged(ListFilter{Platform: "FC"}, 10, 0); err != nil {
+					errs <- fmt.Errorf("list: %w", err)
+				}
+			}
+		}()
+	}
+	// Writers wait for the transaction instead of failing
+	files, _ := database.ListRomFiles()
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := database.AddTag(files[i].ID, "busy"); err != nil {
+				errs <- fmt.Errorf("tag: %w", err)
+			}
+			if err := database.SetFavorite(files[i].ID, true); err != nil {
+				errs <- fmt.Errorf("favorite: %w", err)
+			}
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	// Had a writer slipped in since the SELECT, this would fail at once
+	if _, err := tx.Exec(`UPDATE rom_files SET size = size + 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got, _ := database.ListByTag("busy"); len(got) != 3 {
+		t.Errorf("expected 3 tagged ROMs, got %d", len(got))
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	// The path needs escaping in a file: URI
+	path := filepath.Join(t.TempDir(), "my roms #1.db")
+	if _, err := OpenReadOnlyPath(path); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+
+	rw, err := OpenPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+	if err := rw.UpsertRomFilesBatch(testRomFiles(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	ro, err := OpenReadOnlyPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if !ro.ReadOnly() || rw.ReadOnly() {
+		t.Errorf("ReadOnly() = %v for the read-only handle, %v for the other", ro.ReadOnly(), rw.ReadOnly())
+	}
+	if f, err := ro.ListRomFiles(); err != nil || len(f) != 2 {
+		t.Errorf("expected 2 ROMs, got %d: %v", len(f), err)
+	}
+	if err := ro.AddTag(1, "rpg"); err == nil {
+		t.Error("expected writes to fail")
+	}
+
+	// Writes from another connection show up
+	rw.UpsertRomFilesBatch(testRomFiles(3))
+	if f, _ := ro.ListRomFiles(); len(f) != 3 {
+		t.Errorf("expected 3 ROMs after another write, got %d", len(f))
+	}
+}
+
+func TestBackupTo(t *testing.T) {
+	dir := t.TempDir()
+	database, err := OpenPath(filepath.Join(dir, "romu.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	database.UpsertRomFilesBatch(testRomFiles(3))
+	database.InsertGame("Game", "FC", "", "", "", 0)
+	database.AddTag(1, "rpg")
+
+	// Reading while backing up, as the server would
+	rows, err := database.Query(`SELECT id FROM rom_files`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	dest := filepath.Join(dir, "backup.db")
+	if err := database.BackupTo(dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.BackupTo(dest); err == nil {
+		t.Error("expected an error backing up over an existing file")
+	}
+	rows.Close()
+
+	backup, err := OpenReadOnlyPath(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	for table, want := range map[string]int{"rom_files": 3, "games": 1, "rom_file_tags": 1} {
+		var n int
+		if err := backup.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil || n != want {
+			t.Errorf("%s: got %d rows, want %d (%v)", table, n, want, err)
+		}
+	}
+
+	// A read-only handle can back up too
+	ro, err := OpenReadOnlyPath(filepath.Join(dir, "romu.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := ro.BackupTo(filepath.Join(dir, "backup2.db")); err != nil {
+		t.Errorf("read-only backup: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".*.tmp")); len(matches) != 0 {
+		t.Errorf("left behind %v", matches)
+	}
+}
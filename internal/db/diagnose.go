@@ -0,0 +1,109 @@
+package db
+
+import (
+	"os"
+)
+
+// maxSamples is how many offenders a DiagnoseIssue lists
+const maxSamples = 5
+
+// DiagnoseIssue counts one kind of anomaly and lists a few examples
+type DiagnoseIssue struct {
+	Count   int
+	Samples []string
+}
+
+func (i *DiagnoseIssue) add(sample string) {
+	i.Count++
+	if len(i.Samples) < maxSamples {
+		i.Samples = append(i.Samples, sample)
+	}
+}
+
+// DiagnoseReport is the result of Diagnose. EmptyHashes and MissingFiles are
+// critical; the rest are warnings.
+type DiagnoseReport struct {
+	EmptyHashes      DiagnoseIssue // rom_files missing a CRC32, MD5 or SHA1
+	MissingFiles     DiagnoseIssue // rom_files whose file is gone from disk
+	OrphanGames      DiagnoseIssue // games no rom_file links to
+	DuplicateSHA1    DiagnoseIssue // groups of rom_files with the same SHA1
+	UnknownPlatforms DiagnoseIssue // rom_files platforms not in knownPlatforms
+}
+
+// Critical reports whether the report contains critical issues
+func (r *DiagnoseReport) Critical() bool {
+	return r.EmptyHashes.Count > 0 || r.MissingFiles.Count > 0
+}
+
+// Diagnose checks the database for anomalies. knownPlatforms lists the
+// platform codes romu supports; rom_files with any other are reported.
+func (d *DB) Diagnose(knownPlatforms []string) (*DiagnoseReport, error) {
+	r := &DiagnoseReport{}
+	known := make(map[string]bool, len(knownPlatforms))
+	for _, p := range knownPlatforms {
+		known[p] = true
+	}
+
+	rows, err := d.Query(`SELECT path, platform, COALESCE(hash_crc32,''), COALESCE(hash_md5,''), COALESCE(hash_sha1,'')
+		FROM rom_files ORDER BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	unknown := map[string]bool{}
+	for rows.Next() {
+		var path, platform, crc, md5, sha1 string
+		if err := rows.Scan(&path, &platform, &crc, &md5, &sha1); err != nil {
+			return nil, err
+		}
+		if crc == "" || md5 == "" || sha1 == "" {
+			r.EmptyHashes.add(path)
+		}
+		// ZIP entries ("archive.zip!inner") are checked by their archive
+		file, _, _ := SplitArchivePath(path)
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			r.MissingFiles.add(path)
+		}
+		if !known[platform] && !unknown[platform] {
+			unknown[platform] = true
+			r.UnknownPlatforms.add(platform)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = d.Query(`SELECT g.platform, COALESCE(g.title_en, g.title_ja, '') FROM games g
+		WHERE NOT EXISTS (SELECT 1 FROM rom_files r WHERE r.game_id = g.id)
+		ORDER BY g.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var platform, title string
+		if err := rows.Scan(&platform, &title); err != nil {
+			return nil, err
+		}
+		r.OrphanGames.add("[" + platform + "] " + title)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = d.Query(`SELECT hash_sha1, GROUP_CONCAT(path, ', ') FROM rom_files
+		WHERE hash_sha1 IS NOT NULL AND hash_sha1 != ''
+		GROUP BY hash_sha1 HAVING COUNT(*) > 1 ORDER BY hash_sha1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sha1, paths string
+		if err := rows.Scan(&sha1, &paths); err != nil {
+			return nil, err
+		}
+		r.DuplicateSHA1.add(sha1 + ": " + paths)
+	}
+	return r, rows.Err()
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Vacuum rebuilds the database file to reclaim free pages and refreshes the
+// query planner statistics.
+func (d *DB) Vacuum() error {
+	if _, err := d.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if _, err := d.Exec(`ANALYZE`); err != nil {
+		return fmt.Errorf("analyze: %w", err)
+	}
+	// Fold the WAL back into the main file so its size reflects the vacuum
+	if _, err := d.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
+// BackupTo writes a consistent copy of the database to dest, which must not
+// exist yet, while other connections go on reading and writing. It works on
+// read-only databases too. The copy is written next to dest and renamed into
+// place, so an interrupted backup leaves no partial file behind.
+func (d *DB) BackupTo(dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("backup: %s already exists", dest)
+	}
+	tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	os.Remove(tmp)
+	// Harmless after a successful rename
+	defer os.Remove(tmp)
+	if _, err := d.Exec(`VACUUM INTO ?`, tmp); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return os.Rename(tmp, dest)
+}
+
+// Integrity runs SQLite's integrity check and returns an error listing any
+// problems it reports.
+func (d *DB) Integrity() error {
+	rows, err := d.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// FileSize returns the size in bytes of the database file plus its WAL
+func (d *DB) FileSize() (int64, error) {
+	var total int64
+	for _, p := range []string{d.path, d.path + "-wal"} {
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// PruneOrphanGames deletes games that neither a rom_file nor a cover_arts
+// row refers to, and returns how many were deleted. Games with cover art
+// are kept, as a rescan or a DAT import may link them again.
+func (d *DB) PruneOrphanGames() (int, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM games WHERE
+		NOT EXISTS (SELECT 1 FROM rom_files r WHERE r.game_id = games.id) AND
+		NOT EXISTS (SELECT 1 FROM cover_arts c WHERE c.game_id = games.id)`)
+	if err != nil {
+		return 0, fmt.Errorf("prune games: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(n), d.changed(nil)
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/retronian/romu/internal/nointro"
+	"golang.org/x/text/width"
+)
+
+// gameColumns are the games columns MergeGames fills in from the merged game
+var gameColumns = []string{"title_en", "title_ja", "description_ja", "developer", "publisher", "release_date", "genre", "players", "rating"}
+
+// MergeGames folds the game mergeID into keepID: its rom_files and
+// cover_arts move to keepID, which takes its metadata where it has none,
+// and mergeID is deleted. Cover art of a type keepID already has is
+// dropped. Both games must exist and belong to the same platform.
+func (d *DB) MergeGames(keepID, mergeID int64) error {
+	if keepID == mergeID {
+		return fmt.Errorf("merge games: game %d with itself", keepID)
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var keepPlatform, mergePlatform string
+	if err := tx.QueryRow(`SELECT platform FROM games WHERE id = ?`, keepID).Scan(&keepPlatform); err != nil {
+		return fmt.Errorf("merge games: game %d: %w", keepID, notFound(err))
+	}
+	if err := tx.QueryRow(`SELECT platform FROM games WHERE id = ?`, mergeID).Scan(&mergePlatform); err != nil {
+		return fmt.Errorf("merge games: game %d: %w", mergeID, notFound(err))
+	}
+	if keepPlatform != mergePlatform {
+		return fmt.Errorf("merge games: game %d is %s, game %d is %s", keepID, keepPlatform, mergeID, mergePlatform)
+	}
+
+	set := make([]string, len(gameColumns))
+	for i, c := range gameColumns {
+		set[i] = c + " = COALESCE(NULLIF(" + c + ", ''), (SELECT " + c + " FROM games WHERE id = :merge))"
+	}
+	steps := []struct{ what, query string }{
+		{"metadata", `UPDATE games SET ` + strings.Join(set, ", ") + `, updated_at = CURRENT_TIMESTAMP WHERE id = :keep`},
+		{"rom_files", `UPDATE rom_files SET game_id = :keep, updated_at = CURRENT_TIMESTAMP WHERE game_id = :merge`},
+		{"cover_arts", `DELETE FROM cover_arts WHERE game_id = :merge
+			AND image_type IN (SELECT image_type FROM cover_arts WHERE game_id = :keep)`},
+		{"cover_arts", `UPDATE cover_arts SET game_id = :keep WHERE game_id = :merge`},
+		{"game", `DELETE FROM games WHERE id = :merge`},
+	}
+	for _, s := range steps {
+		if _, err := tx.Exec(s.query, sql.Named("keep", keepID), sql.Named("merge", mergeID)); err != nil {
+			return fmt.Errorf("merge games: %s: %w", s.what, err)
+		}
+	}
+	return d.changed(tx.Commit())
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DuplicateGames is a set of games that likely are one
+type DuplicateGames struct {
+	Platform string
+	Title    string // the title they share
+	// Games, the one to keep first: one with an English title, which
+	// matching by DAT finds, else the oldest
+	Games []Game
+}
+
+// FindDuplicateGames returns games of the same platform, optionally only
+// platform's, that share a title: an English or Japanese title of one
+// equals one of the other's, ignoring case, spacing, character width and
+// GoodTools flags such as "[!]". Games with different English titles, such
+// as a DAT's regional releases sharing a Japanese title, are kept apart.
+// Each game is in at most one set.
+func (d *DB) FindDuplicateGames(platform string) ([]DuplicateGames, error) {
+	query := `SELECT id, COALESCE(title_en,''), COALESCE(title_ja,''), platform FROM games`
+	args := []interface{}{}
+	if platform != "" {
+		query += ` WHERE platform = ?`
+		args = append(args, platform)
+	}
+	rows, err := d.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Union games sharing a platform and title key, by index into games.
+	// english holds each set's English title key, at its root.
+	var games []Game
+	var parent []int
+	var english []string
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	union := func(i, j int) {
+		ri, rj := find(i), find(j)
+		if ri == rj || english[ri] != "" && english[rj] != "" && english[ri] != english[rj] {
+			return
+		}
+		parent[ri] = rj
+		if english[rj] == "" {
+			english[rj] = english[ri]
+		}
+	}
+	first := map[[2]string]int{} // platform and title key -> first game
+	for rows.Next() {
+		var g Game
+		if err := rows.Scan(&g.ID, &g.TitleEN, &g.TitleJA, &g.Platform); err != nil {
+			return nil, err
+		}
+		i := len(games)
+		games = append(games, g)
+		parent = append(parent, i)
+		english = append(english, titleKey(g.TitleEN))
+		for _, t := range []string{g.TitleEN, g.TitleJA} {
+			key := [2]string{g.Platform, titleKey(t)}
+			if key[1] == "" {
+				continue
+			}
+			if j, ok := first[key]; ok {
+				union(i, j)
+			} else {
+				first[key] = i
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	groups := map[int][]Game{}
+	for i, g := range games {
+		root := find(i)
+		groups[root] = append(groups[root], g)
+	}
+	var dups []DuplicateGames
+	for _, gs := range groups {
+		if len(gs) < 2 {
+			continue
+		}
+		// Already in ID order; stably move games with an English title first
+		sort.SliceStable(gs, func(a, b int) bool { return gs[a].TitleEN != "" && gs[b].TitleEN == "" })
+		dups = append(dups, DuplicateGames{Platform: gs[0].Platform, Title: sharedTitle(gs), Games: gs})
+	}
+	sort.Slice(dups, func(i, j int) bool {
+		if dups[i].Platform != dups[j].Platform {
+			return dups[i].Platform < dups[j].Platform
+		}
+		return dups[i].Games[0].ID < dups[j].Games[0].ID
+	})
+	return dups, nil
+}
+
+// titleKey is what FindDuplicateGames compares titles by
+func titleKey(title string) string {
+	return strings.ToLower(width.Fold.String(nointro.Normalize(title)))
+}
+
+// sharedTitle returns a title at least two of the games share, as one of
+// them writes it
+func sharedTitle(games []Game) string {
+	seen := map[string]bool{}
+	for _, g := range games {
+		for _, t := range []string{g.TitleEN, g.TitleJA} {
+			if t != "" && seen[titleKey(t)] {
+				return t
+			}
+		}
+		for _, t := range []string{g.TitleEN, g.TitleJA} {
+			if t != "" {
+				seen[titleKey(t)] = true
+			}
+		}
+	}
+	return games[0].TitleEN
+}
@@ -0,0 +1,45 @@
+package db
+
+import "sync"
+
+// notifier tracks subscribers interested in database writes
+type notifier struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+// Subscribe returns a channel that receives a value after writes made
+// through this DB, and a function to stop the subscription. Notifications
+// are coalesced: a burst of writes may be reported once. Writes made by other
+// processes are not reported.
+func (d *DB) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	d.changes.mu.Lock()
+	if d.changes.subs == nil {
+		d.changes.subs = make(map[chan struct{}]struct{})
+	}
+	d.changes.subs[ch] = struct{}{}
+	d.changes.mu.Unlock()
+	return ch, func() {
+		d.changes.mu.Lock()
+		delete(d.changes.subs, ch)
+		d.changes.mu.Unlock()
+	}
+}
+
+// changed notifies subscribers if err is nil and returns err unchanged, so
+// write methods can end with `return d.changed(err)`.
+func (d *DB) changed(err error) error {
+	if err != nil {
+		return err
+	}
+	d.changes.mu.Lock()
+	defer d.changes.mu.Unlock()
+	for ch := range d.changes.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
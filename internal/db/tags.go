@@ -0,0 +1,108 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tag is a user-defined collection name with the number of tagged ROMs
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// AddTag tags a rom_file, creating the tag if needed. Tag names are
+// case-insensitive; the first spelling used is kept.
+func (d *DB) AddTag(romID int64, tag string) error {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return fmt.Errorf("empty tag")
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO tags (name) VALUES (?)`, tag); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO rom_file_tags (rom_file_id, tag_id) SELECT ?, id FROM tags WHERE name = ?`, romID, tag)
+	if err != nil {
+		return err
+	}
+	return d.changed(tx.Commit())
+}
+
+// RemoveTag removes a tag from a rom_file. Tags left with no ROMs are deleted.
+func (d *DB) RemoveTag(romID int64, tag string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM rom_file_tags WHERE rom_file_id = ? AND tag_id IN (SELECT id FROM tags WHERE name = ?)`, romID, tag)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM rom_file_tags)`); err != nil {
+		return err
+	}
+	return d.changed(tx.Commit())
+}
+
+// ListByTag returns the rom_files carrying tag
+func (d *DB) ListByTag(tag string) ([]RomFile, error) {
+	rows, err := d.Query(`SELECT `+romFileColumns+`
+		FROM rom_files r LEFT JOIN games g ON r.game_id = g.id
+		JOIN rom_file_tags rt ON rt.rom_file_id = r.id
+		JOIN tags t ON t.id = rt.tag_id
+		WHERE t.name = ?
+		ORDER BY r.platform, r.filename`, tag)
+	if err != nil {
+		return nil, err
+	}
+	return scanRomFiles(rows)
+}
+
+// ListTags returns every tag with its ROM count, by name
+func (d *DB) ListTags() ([]Tag, error) {
+	rows, err := d.Query(`SELECT t.name, COUNT(rt.rom_file_id) FROM tags t
+		LEFT JOIN rom_file_tags rt ON rt.tag_id = t.id
+		GROUP BY t.id ORDER BY t.name COLLATE NOCASE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// FindRomFilesByPath returns the rom_file at path, or every entry inside it
+// when path is an archive whose contents were scanned
+func (d *DB) FindRomFilesByPath(path string) ([]RomFileLocation, error) {
+	// Archive entries are stored as "archive.zip!inner"; '"' sorts right after '!'
+	rows, err := d.Query(`SELECT id, path FROM rom_files WHERE path = ? OR (path >= ? AND path < ?) ORDER BY path`,
+		path, path+"!", path+`"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var locs []RomFileLocation
+	for rows.Next() {
+		var l RomFileLocation
+		if err := rows.Scan(&l.ID, &l.Path); err != nil {
+			return nil, err
+		}
+		locs = append(locs, l)
+	}
+	return locs, rows.Err()
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// upgrades are schema changes beyond what CREATE TABLE IF NOT EXISTS and
+// addColumn can do, such as rebuilding a table. PRAGMA user_version counts
+// those applied; append new ones, never reorder them.
+var upgrades = []func(tx *sql.Tx) error{
+	fkDeleteActions,
+}
+
+// upgrade applies the upgrades the database hasn't had yet, each in its own
+// transaction. Foreign keys are off meanwhile, as SQLite requires for
+// rebuilding a table that others reference, and checked before each commit.
+func upgrade(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var version int
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= len(upgrades) {
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+	for ; version < len(upgrades); version++ {
+		if err := upgradeTo(ctx, conn, version+1); err != nil {
+			return fmt.Errorf("upgrade database to version %d: %w", version+1, err)
+		}
+		slog.Debug("upgraded database", "version", version+1)
+	}
+	return nil
+}
+
+func upgradeTo(ctx context.Context, conn *sql.Conn, version int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upgrades[version-1](tx); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	violation := rows.Next()
+	rows.Close()
+	if violation {
+		return fmt.Errorf("foreign key violations left")
+	}
+	// PRAGMA doesn't take parameters
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// onDelete returns the ON DELETE action of table's foreign key on column
+func onDelete(tx *sql.Tx, table, column string) (string, error) {
+	rows, err := tx.Query(`SELECT "from", on_delete FROM pragma_foreign_key_list(?)`, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var from, action string
+		if err := rows.Scan(&from, &action); err != nil {
+			return "", err
+		}
+		if from == column {
+			return action, nil
+		}
+	}
+	return "", rows.Err()
+}
+
+// fkDeleteActions makes deleting a game unlink its ROMs and delete its
+// cover_arts rows. Older databases declared both foreign keys without an
+// ON DELETE action, and SQLite can only change that by rebuilding the
+// tables. References to missing games, possible while foreign keys weren't
+// enforced, are dropped first.
+func fkDeleteActions(tx *sql.Tx) error {
+	romAction, err := onDelete(tx, "rom_files", "game_id")
+	if err != nil {
+		return err
+	}
+	coverAction, err := onDelete(tx, "cover_arts", "game_id")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	UPDATE rom_files SET game_id = NULL WHERE game_id IS NOT NULL AND game_id NOT IN (SELECT id FROM games);
+	DELETE FROM cover_arts WHERE game_id IS NOT NULL AND game_id NOT IN (SELECT id FROM games);
+	`)
+	if err != nil {
+		return err
+	}
+
+	if romAction != "SET NULL" {
+		const cols = `id, path, filename, size, hash_crc32, hash_md5, hash_sha1, platform, game_id,
+			created_at, updated_at, modtime, user_rating, favorite,
+			hash_crc32_nohdr, hash_md5_nohdr, hash_sha1_nohdr, platform_override`
+		_, err := tx.Exec(`
+		CREATE TABLE rom_files_new (
+			id INTEGER PRIMARY KEY,
+			path TEXT NOT NULL UNIQUE,
+			filename TEXT NOT NULL,
+			size INTEGER,
+			hash_crc32 TEXT,
+			hash_md5 TEXT,
+			hash_sha1 TEXT,
+			platform TEXT NOT NULL,
+			game_id INTEGER REFERENCES games(id) ON DELETE SET NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			modtime INTEGER,
+			user_rating INTEGER,
+			favorite BOOLEAN NOT NULL DEFAULT 0,
+			hash_crc32_nohdr TEXT,
+			hash_md5_nohdr TEXT,
+			hash_sha1_nohdr TEXT,
+			platform_override BOOLEAN NOT NULL DEFAULT 0
+		);
+		INSERT INTO rom_files_new (` + cols + `) SELECT ` + cols + ` FROM rom_files;
+		DROP TABLE rom_files;
+		ALTER TABLE rom_files_new RENAME TO rom_files;
+		`)
+		if err != nil {
+			return fmt.Errorf("rebuild rom_files: %w", err)
+		}
+	}
+
+	if coverAction != "CASCADE" {
+		const cols = `id, game_id, image_type, file_path, created_at`
+		_, err := tx.Exec(`
+		CREATE TABLE cover_arts_new (
+			id INTEGER PRIMARY KEY,
+			game_id INTEGER REFERENCES games(id) ON DELETE CASCADE,
+			image_type TEXT NOT NULL,
+			file_path TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO cover_arts_new (` + cols + `) SELECT ` + cols + ` FROM cover_arts;
+		DROP TABLE cover_arts;
+		ALTER TABLE cover_arts_new RENAME TO cover_arts;
+		`)
+		if err != nil {
+			return fmt.Errorf("rebuild cover_arts: %w", err)
+		}
+	}
+	return nil
+}
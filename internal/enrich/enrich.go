@@ -0,0 +1,88 @@
+// Package enrich defines the metadata sources `romu enrich` draws from, how
+// several of them are combined, and the enrichment run itself.
+package enrich
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/retronian/romu/internal/gamedb"
+)
+
+// Enricher looks up metadata for a game by platform code and title. It
+// returns nil without an error if the source doesn't know the game.
+type Enricher interface {
+	Name() string
+	Lookup(platform, titleEN string) (*gamedb.GameEntry, error)
+}
+
+// HashLookuper is implemented by sources that can also identify a ROM by its
+// hashes, which is tried before the title. Any hash may be empty.
+type HashLookuper interface {
+	LookupByHash(crc32, md5, sha1, platform string) (*gamedb.GameEntry, error)
+}
+
+// Query describes a game to look up. The hashes are those of one of its
+// ROMs, if known.
+type Query struct {
+	Platform string
+	Title    string
+	CRC32    string
+	MD5      string
+	SHA1     string
+}
+
+// Find looks q up in e, by hash first if e supports it and q has hashes
+func Find(e Enricher, q Query) (*gamedb.GameEntry, error) {
+	if c, ok := e.(Chain); ok {
+		return c.find(q)
+	}
+	if h, ok := e.(HashLookuper); ok && (q.CRC32 != "" || q.MD5 != "" || q.SHA1 != "") {
+		entry, err := h.LookupByHash(q.CRC32, q.MD5, q.SHA1, q.Platform)
+		if err != nil || entry != nil {
+			return entry, err
+		}
+	}
+	return e.Lookup(q.Platform, q.Title)
+}
+
+// Chain tries several sources in priority order and returns the first hit.
+// A failing source doesn't stop the others; its error is only returned if
+// no source knows the game.
+type Chain []Enricher
+
+func (c Chain) Name() string {
+	names := make([]string, len(c))
+	for i, e := range c {
+		names[i] = e.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c Chain) Lookup(platform, titleEN string) (*gamedb.GameEntry, error) {
+	return c.find(Query{Platform: platform, Title: titleEN})
+}
+
+func (c Chain) find(q Query) (*gamedb.GameEntry, error) {
+	var errs []error
+	for _, e := range c {
+		entry, err := Find(e, q)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if entry != nil {
+			return entry, nil
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// GameDB is the embedded (and user-overlaid) gamedb
+type GameDB struct{}
+
+func (GameDB) Name() string { return "gamedb" }
+
+func (GameDB) Lookup(platform, titleEN string) (*gamedb.GameEntry, error) {
+	return gamedb.Lookup(platform, titleEN), nil
+}
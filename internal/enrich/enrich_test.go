@@ -0,0 +1,92 @@
+package enrich
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/retronian/romu/internal/gamedb"
+)
+
+// fake knows a fixed set of titles and optionally hashes
+type fake struct {
+	name    string
+	titles  map[string]*gamedb.GameEntry
+	hashes  map[string]*gamedb.GameEntry
+	err     error
+	lookups int
+}
+
+func (f *fake) Name() string { return f.name }
+
+func (f *fake) Lookup(platform, titleEN string) (*gamedb.GameEntry, error) {
+	f.lookups++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.titles[titleEN], nil
+}
+
+type hashFake struct{ *fake }
+
+func (f hashFake) LookupByHash(crc32, md5, sha1, platform string) (*gamedb.GameEntry, error) {
+	return f.hashes[crc32], nil
+}
+
+func TestChain(t *testing.T) {
+	a := &gamedb.GameEntry{TitleJA: "A"}
+	b := &gamedb.GameEntry{TitleJA: "B"}
+	broken := &fake{name: "broken", err: errors.New("offline")}
+	first := &fake{name: "first", titles: map[string]*gamedb.GameEntry{"Game A": a}}
+	second := &fake{name: "second", titles: map[string]*gamedb.GameEntry{"Game A": b, "Game B": b}}
+	c := Chain{broken, first, second}
+
+	if c.Name() != "broken,first,second" {
+		t.Errorf("unexpected name %q", c.Name())
+	}
+	if e, err := Find(c, Query{Title: "Game A"}); e != a || err != nil {
+		t.Errorf("expected first source's entry despite a failing source, got %+v (%v)", e, err)
+	}
+	if e, _ := Find(c, Query{Title: "Game B"}); e != b {
+		t.Errorf("expected fallback to second source, got %+v", e)
+	}
+	if e, err := Find(c, Query{Title: "Game C"}); e != nil || !errors.Is(err, broken.err) {
+		t.Errorf("expected miss with the failing source's error, got %+v (%v)", e, err)
+	}
+}
+
+func TestFindByHash(t *testing.T) {
+	byHash := &gamedb.GameEntry{TitleJA: "hash"}
+	byTitle := &gamedb.GameEntry{TitleJA: "title"}
+	f := &fake{name: "hashes",
+		titles: map[string]*gamedb.GameEntry{"Game": byTitle},
+		hashes: map[string]*gamedb.GameEntry{"3337EC46": byHash}}
+	h := hashFake{f}
+
+	if e, _ := Find(h, Query{Title: "Game", CRC32: "3337EC46"}); e != byHash {
+		t.Errorf("expected hash match first, got %+v", e)
+	}
+	if e, _ := Find(h, Query{Title: "Game", CRC32: "00000000"}); e != byTitle {
+		t.Errorf("expected title fallback, got %+v", e)
+	}
+	if e, _ := Find(Chain{GameDB{}, h}, Query{Platform: "FC", Title: "Unknown", CRC32: "3337EC46"}); e != byHash {
+		t.Errorf("expected hash match through a chain, got %+v", e)
+	}
+}
+
+func TestDeriveTitle(t *testing.T) {
+	tests := map[string]string{
+		"Rockman (Japan).nes":                          "Rockman (Japan)",
+		"Asteroids (USA).a78":                          "Asteroids (USA)",
+		"Tetris (World) [!].gb":                        "Tetris (World)",
+		"Super Mario Bros. (World)":                    "Super Mario Bros. (World)",
+		"Dr. Mario (World).gb":                         "Dr. Mario (World)",
+		"Pack.zip/Kirby's Dream Land (USA, Europe).gb": "Kirby's Dream Land (USA, Europe)",
+		"Pack.zip/sub/dir/Zoop (USA).sfc":              "Zoop (USA)",
+		"Sonic Compilation (Europe).zip":               "Sonic Compilation (Europe)",
+	}
+	for in, want := range tests {
+		if got := DeriveTitle(in); got != want {
+			t.Errorf("DeriveTitle(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package enrich
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/gamedb"
+	"github.com/retronian/romu/internal/nointro"
+)
+
+// Store is the part of *db.DB that Run reads and writes
+type Store interface {
+	GetEnrichableRoms(platform string) ([]db.EnrichableRom, int, error)
+	GetGameHashes(gameID int64) (crc32, md5, sha1 string, err error)
+	GetGameMetadata(gameID int64) (db.GameMetadata, error)
+	SetGameMetadata(gameID int64, m db.GameMetadata, overwrite bool) error
+	GetUnmatchedRoms(platform string) ([]db.UnmatchedRom, error)
+	CreateGameAndLink(romID int64, titleEN, platform, titleJA, descJA, developer, publisher, releaseDate, genre, players, rating string) error
+	GetJapaneseOnlyGames(platform string) ([]db.JapaneseOnlyGame, error)
+	SetGameTitleEN(gameID int64, titleEN string) error
+}
+
+// Options configures Run
+type Options struct {
+	Platform string   // only this platform; empty means all
+	Source   Enricher // where metadata comes from
+	// JapaneseTitles also gives games known only by a Japanese title their
+	// English title from the gamedb's reverse index
+	JapaneseTitles bool
+	DryRun         bool // collect what would change without writing
+	Overwrite      bool // replace existing metadata
+	// Warn, if set, is called with each lookup error and failed update
+	Warn func(error)
+}
+
+// EnrichResult counts what Run did
+type EnrichResult struct {
+	Enriched         int `json:"enriched"`          // matched games updated
+	Skipped          int `json:"skipped"`           // matched games no source knew
+	FilenameEnriched int `json:"filename_enriched"` // unmatched ROMs given a game by filename
+	FilenameSkipped  int `json:"filename_skipped"`
+	JapaneseEnriched int `json:"japanese_enriched"` // Japanese-titled games given an English title
+	Failed           int `json:"failed"`            // updates that failed
+	NoMatch          int `json:"no_match"`          // ROMs without a game match
+	// SkippedTitles lists the titles no source knew by platform, sorted
+	SkippedTitles map[string][]string `json:"-"`
+	// Planned describes each change a dry run would make
+	Planned []string `json:"-"`
+}
+
+// Run fills in game metadata from opts.Source: for matched games by title
+// and ROM hashes, for unmatched ROMs by file name, creating their game, and
+// with opts.JapaneseTitles for games known only by title_ja. Failed lookups
+// and updates are counted and passed to opts.Warn rather than stopping the
+// run; the error is for the database queries it can't do without.
+func Run(database Store, opts Options) (*EnrichResult, error) {
+	warn := opts.Warn
+	if warn == nil {
+		warn = func(error) {}
+	}
+	lookup := func(q Query) *gamedb.GameEntry {
+		entry, err := Find(opts.Source, q)
+		if err != nil {
+			warn(err)
+		}
+		return entry
+	}
+
+	roms, noMatch, err := database.GetEnrichableRoms(opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	res := &EnrichResult{NoMatch: noMatch, SkippedTitles: make(map[string][]string)}
+	for _, r := range roms {
+		crc, md5, sha1, _ := database.GetGameHashes(r.GameID)
+		entry := lookup(Query{Platform: r.Platform, Title: r.TitleEN, CRC32: crc, MD5: md5, SHA1: sha1})
+		if entry == nil {
+			res.Skipped++
+			res.SkippedTitles[r.Platform] = append(res.SkippedTitles[r.Platform], r.TitleEN)
+			continue
+		}
+		if opts.DryRun {
+			cur, err := database.GetGameMetadata(r.GameID)
+			if err != nil {
+				warn(fmt.Errorf("reading game %d: %w", r.GameID, err))
+				res.Failed++
+				continue
+			}
+			res.Planned = append(res.Planned, fmt.Sprintf("update game %d %s [%s]: %s", r.GameID, r.TitleEN, r.Platform, describeFields(metadataChanges(cur, entry, opts.Overwrite))))
+			res.Enriched++
+			continue
+		}
+		if err := database.SetGameMetadata(r.GameID, gameMetadata(entry), opts.Overwrite); err != nil {
+			warn(fmt.Errorf("updating game %d: %w", r.GameID, err))
+			res.Failed++
+			continue
+		}
+		res.Enriched++
+	}
+
+	// Also try to enrich unmatched ROMs by filename
+	unmatchedRoms, err := database.GetUnmatchedRoms(opts.Platform)
+	if err == nil {
+		for _, ur := range unmatchedRoms {
+			title := DeriveTitle(ur.Filename)
+			// Also try the zip name (before /) as fallback
+			zipTitle := title
+			if archive, _, ok := strings.Cut(ur.Filename, "/"); ok {
+				zipTitle = DeriveTitle(archive)
+			}
+			entry := lookup(Query{Platform: ur.Platform, Title: title, CRC32: ur.CRC32, MD5: ur.MD5, SHA1: ur.SHA1})
+			lookupTitle := title
+			if entry == nil {
+				entry = lookup(Query{Platform: ur.Platform, Title: zipTitle})
+				lookupTitle = zipTitle
+			}
+			if entry == nil {
+				res.FilenameSkipped++
+				res.SkippedTitles[ur.Platform] = append(res.SkippedTitles[ur.Platform], title)
+				continue
+			}
+			if opts.DryRun {
+				res.Planned = append(res.Planned, fmt.Sprintf("create game %s [%s] for %s: %s", lookupTitle, ur.Platform, ur.Filename, describeFields(metadataChanges(db.GameMetadata{}, entry, false))))
+				res.FilenameEnriched++
+				continue
+			}
+			err := database.CreateGameAndLink(ur.ID, lookupTitle, ur.Platform, entry.TitleJA, entry.DescJA, entry.Developer, entry.Publisher, entry.ReleaseDate, entry.Genre, entry.Players, entry.Rating)
+			if err != nil {
+				warn(fmt.Errorf("creating game for %s: %w", title, err))
+				res.Failed++
+				continue
+			}
+			res.FilenameEnriched++
+		}
+	}
+
+	// Games known only by title_ja (e.g. from a Japanese gamelist.xml) get
+	// their English title and metadata from the gamedb's reverse index
+	if opts.JapaneseTitles {
+		jaGames, err := database.GetJapaneseOnlyGames(opts.Platform)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range jaGames {
+			entry := gamedb.LookupByJA(g.Platform, g.TitleJA)
+			if entry == nil {
+				continue
+			}
+			if opts.DryRun {
+				res.Planned = append(res.Planned, fmt.Sprintf("title game %d %s [%s]: %s", g.GameID, g.TitleJA, g.Platform, entry.TitleEN))
+				res.JapaneseEnriched++
+				continue
+			}
+			err := database.SetGameTitleEN(g.GameID, entry.TitleEN)
+			if err == nil {
+				err = database.SetGameMetadata(g.GameID, gameMetadata(entry), opts.Overwrite)
+			}
+			if err != nil {
+				warn(fmt.Errorf("updating game %d: %w", g.GameID, err))
+				res.Failed++
+				continue
+			}
+			res.JapaneseEnriched++
+		}
+	}
+
+	for _, titles := range res.SkippedTitles {
+		sort.Strings(titles)
+	}
+	return res, nil
+}
+
+// DeriveTitle turns a ROM's file name, which for ZIP entries is
+// "archive.zip/dir/rom.ext", into the No-Intro style title gamedb is keyed
+// by: the base name without its extension, normalized.
+func DeriveTitle(filename string) string {
+	name := filepath.Base(filename)
+	// "Super Mario Bros. (World)" has no extension, just a dot
+	if ext := filepath.Ext(name); !strings.ContainsAny(ext, " ()[]") {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return nointro.Normalize(name)
+}
+
+func gameMetadata(e *gamedb.GameEntry) db.GameMetadata {
+	return db.GameMetadata{
+		TitleJA:     e.TitleJA,
+		DescJA:      e.DescJA,
+		Developer:   e.Developer,
+		Publisher:   e.Publisher,
+		ReleaseDate: e.ReleaseDate,
+		Genre:       e.Genre,
+		Players:     e.Players,
+		Rating:      e.Rating,
+	}
+}
+
+// metadataChanges returns the columns enriching a game with cur metadata
+// from entry would change. As in SetGameMetadata, empty values are ignored
+// unless overwriting, where they clear the column.
+func metadataChanges(cur db.GameMetadata, entry *gamedb.GameEntry, overwrite bool) []string {
+	fields := []struct {
+		column   string
+		old, new string
+	}{
+		{"title_ja", cur.TitleJA, entry.TitleJA},
+		{"description_ja", cur.DescJA, entry.DescJA},
+		{"developer", cur.Developer, entry.Developer},
+		{"publisher", cur.Publisher, entry.Publisher},
+		{"release_date", cur.ReleaseDate, entry.ReleaseDate},
+		{"genre", cur.Genre, entry.Genre},
+		{"players", cur.Players, entry.Players},
+		{"rating", cur.Rating, entry.Rating},
+	}
+	var changed []string
+	for _, f := range fields {
+		if (f.new != "" || overwrite) && f.new != f.old {
+			changed = append(changed, f.column)
+		}
+	}
+	return changed
+}
+
+func describeFields(fields []string) string {
+	if len(fields) == 0 {
+		return "no changes"
+	}
+	return strings.Join(fields, ", ")
+}
@@ -0,0 +1,163 @@
+package enrich
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/gamedb"
+)
+
+// fakeStore serves fixed games and ROMs and records what Run writes
+type fakeStore struct {
+	games     []db.EnrichableRom
+	hashes    map[int64]string // game ID -> CRC32
+	unmatched []db.UnmatchedRom
+	failSet   bool // SetGameMetadata fails
+
+	metadata map[int64]db.GameMetadata
+	created  map[int64]string // ROM ID -> title
+}
+
+func (s *fakeStore) GetEnrichableRoms(platform string) ([]db.EnrichableRom, int, error) {
+	return s.games, len(s.unmatched), nil
+}
+
+func (s *fakeStore) GetGameHashes(gameID int64) (string, string, string, error) {
+	return s.hashes[gameID], "", "", nil
+}
+
+func (s *fakeStore) GetGameMetadata(gameID int64) (db.GameMetadata, error) {
+	return s.metadata[gameID], nil
+}
+
+func (s *fakeStore) SetGameMetadata(gameID int64, m db.GameMetadata, overwrite bool) error {
+	if s.failSet {
+		return errors.New("disk full")
+	}
+	s.metadata[gameID] = m
+	return nil
+}
+
+func (s *fakeStore) GetUnmatchedRoms(platform string) ([]db.UnmatchedRom, error) {
+	return s.unmatched, nil
+}
+
+func (s *fakeStore) CreateGameAndLink(romID int64, titleEN, platform, titleJA, descJA, developer, publisher, releaseDate, genre, players, rating string) error {
+	s.created[romID] = titleEN
+	return nil
+}
+
+func (s *fakeStore) GetJapaneseOnlyGames(platform string) ([]db.JapaneseOnlyGame, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) SetGameTitleEN(gameID int64, titleEN string) error {
+	return nil
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		games: []db.EnrichableRom{
+			{GameID: 1, TitleEN: "Rockman (Japan)", Platform: "FC"},
+			{GameID: 2, TitleEN: "Zelda no Densetsu (Japan)", Platform: "FC"},
+			{GameID: 3, TitleEN: "Renamed (Japan)", Platform: "GB"},
+		},
+		hashes: map[int64]string{3: "46DF91AD"},
+		unmatched: []db.UnmatchedRom{
+			{ID: 10, Filename: "Tetris (World) [!].gb", Platform: "GB"},
+			{ID: 11, Filename: "Mother (Japan).zip/mother.nes", Platform: "FC"},
+			{ID: 12, Filename: "Unknown Homebrew.nes", Platform: "FC"},
+		},
+		metadata: map[int64]db.GameMetadata{},
+		created:  map[int64]string{},
+	}
+}
+
+func newFakeSource() hashFake {
+	return hashFake{&fake{
+		name: "fake",
+		titles: map[string]*gamedb.GameEntry{
+			"Rockman (Japan)": {TitleJA: "ロックマン"},
+			"Tetris (World)":  {TitleJA: "テトリス"},
+			"Mother (Japan)":  {TitleJA: "マザー"},
+		},
+		hashes: map[string]*gamedb.GameEntry{"46DF91AD": {TitleJA: "テトリス"}},
+	}}
+}
+
+func TestRun(t *testing.T) {
+	store := newFakeStore()
+	res, err := Run(store, Options{Source: newFakeSource()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Matched games by title, or by hash if the title isn't known
+	if res.Enriched != 2 || res.Skipped != 1 || res.NoMatch != 3 {
+		t.Errorf("matched games: %+v", res)
+	}
+	if store.metadata[1].TitleJA != "ロックマン" || store.metadata[3].TitleJA != "テトリス" {
+		t.Errorf("unexpected metadata %v", store.metadata)
+	}
+
+	// Unmatched ROMs by file name, falling back to the ZIP's name
+	if res.FilenameEnriched != 2 || res.FilenameSkipped != 1 {
+		t.Errorf("unmatched ROMs: %+v", res)
+	}
+	if store.created[10] != "Tetris (World)" || store.created[11] != "Mother (Japan)" || len(store.created) != 2 {
+		t.Errorf("unexpected games created %v", store.created)
+	}
+
+	want := map[string][]string{"FC": {"Unknown Homebrew", "Zelda no Densetsu (Japan)"}}
+	if len(res.SkippedTitles) != 1 || !slices.Equal(res.SkippedTitles["FC"], want["FC"]) {
+		t.Errorf("SkippedTitles = %v, want %v", res.SkippedTitles, want)
+	}
+	if res.Failed != 0 || len(res.Planned) != 0 {
+		t.Errorf("expected no failures or plans: %+v", res)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	store := newFakeStore()
+	res, err := Run(store, Options{Source: newFakeSource(), DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.metadata) != 0 || len(store.created) != 0 {
+		t.Errorf("dry run wrote %v, %v", store.metadata, store.created)
+	}
+	if res.Enriched != 2 || res.FilenameEnriched != 2 || len(res.Planned) != 4 {
+		t.Errorf("unexpected dry run %+v", res)
+	}
+	if res.Planned[0] != "update game 1 Rockman (Japan) [FC]: title_ja" {
+		t.Errorf("unexpected plan %q", res.Planned[0])
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	store := newFakeStore()
+	store.failSet = true
+	var warnings []error
+	res, err := Run(store, Options{Source: newFakeSource(), Warn: func(err error) { warnings = append(warnings, err) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A failed update is neither enriched nor skipped
+	if res.Failed != 2 || res.Enriched != 0 || res.Skipped != 1 || res.FilenameEnriched != 2 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("expected a warning per failure, got %v", warnings)
+	}
+
+	// Lookup errors are passed on, but only fail the lookup
+	store.failSet = false
+	warnings = nil
+	broken := &fake{name: "broken", err: errors.New("quota exceeded")}
+	res, err = Run(store, Options{Source: broken, Warn: func(err error) { warnings = append(warnings, err) }})
+	if err != nil || res.Skipped != 3 || res.FilenameSkipped != 3 || res.Failed != 0 || len(warnings) == 0 {
+		t.Errorf("lookup errors: %+v, %d warnings, %v", res, len(warnings), err)
+	}
+}
@@ -0,0 +1,31 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gamelist.xml")
+	os.WriteFile(path, []byte("old"), 0644)
+
+	if err := WriteFileAtomic(path, []byte("new")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("got %q, want %q", data, "new")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Errorf("unexpected mode %v", info.Mode())
+	}
+	// No temp files are left behind
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the target file, got %d entries", len(entries))
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "x"), nil); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
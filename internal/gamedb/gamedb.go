@@ -3,6 +3,11 @@ package gamedb
 import (
 	"embed"
 	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -11,6 +16,7 @@ import (
 var dataFS embed.FS
 
 type GameEntry struct {
+	TitleEN     string // the canonical title the entry is filed under
 	TitleJA     string
 	DescJA      string
 	Developer   string
@@ -18,42 +24,110 @@ type GameEntry struct {
 	ReleaseDate string
 	Genre       string
 	Players     string
+	Rating      string // 0-1, as in gamelist.xml
 }
 
-// platform -> titleEN -> GameEntry
-var cache map[string]map[string]*GameEntry
+// platformIndex holds one platform's entries keyed by canonical title_en,
+// plus reverse indexes of alternate titles and of title_ja pointing at the
+// same entries.
+type platformIndex struct {
+	titles map[string]*GameEntry
+	alts   map[string]*GameEntry
+	// ja maps title_ja to its entry, or to nil if several entries share it
+	ja map[string]*GameEntry
+}
+
+// indexJA rebuilds the title_ja index from titles
+func (idx *platformIndex) indexJA() {
+	idx.ja = make(map[string]*GameEntry, len(idx.titles))
+	for _, e := range idx.titles {
+		if e.TitleJA == "" {
+			continue
+		}
+		if _, ok := idx.ja[e.TitleJA]; ok {
+			idx.ja[e.TitleJA] = nil
+			continue
+		}
+		idx.ja[e.TitleJA] = e
+	}
+}
+
+// lookup matches canonical titles first, then alternate titles
+func (idx *platformIndex) lookup(title string) *GameEntry {
+	if e, ok := idx.titles[title]; ok {
+		return e
+	}
+	return idx.alts[title]
+}
+
+// platform -> index. mu guards cache, which LoadFrom changes while
+// lookups, say by the server, may be reading it.
+var cache map[string]*platformIndex
 var once sync.Once
+var mu sync.RWMutex
 
+// load (re)loads the embedded data alone
 func load() {
-	cache = make(map[string]map[string]*GameEntry)
-	entries, err := dataFS.ReadDir("data")
+	embedded, _ := loadFS(dataFS, "data")
+	mu.Lock()
+	cache = embedded
+	mu.Unlock()
+}
+
+// loadFS reads every <platform>.json file in dir of fsys. Files that can't be
+// read or parsed are skipped; the first such failure is returned alongside
+// whatever loaded successfully.
+func loadFS(fsys fs.FS, dir string) (map[string]*platformIndex, error) {
+	result := make(map[string]*platformIndex)
+	entries, err := fs.ReadDir(fsys, dir)
 	if err != nil {
-		return
+		return result, err
 	}
+	var firstErr error
 	for _, e := range entries {
 		if !strings.HasSuffix(e.Name(), ".json") {
 			continue
 		}
 		platform := strings.TrimSuffix(e.Name(), ".json")
-		data, err := dataFS.ReadFile("data/" + e.Name())
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
 		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
 			continue
 		}
 		var raw map[string]struct {
-			TitleJA     string `json:"title_ja"`
-			DescJA      string `json:"desc_ja"`
-			Developer   string `json:"developer"`
-			Publisher   string `json:"publisher"`
-			ReleaseDate string `json:"release_date"`
-			Genre       string `json:"genre"`
-			Players     string `json:"players"`
+			TitleJA     string   `json:"title_ja"`
+			DescJA      string   `json:"desc_ja"`
+			Developer   string   `json:"developer"`
+			Publisher   string   `json:"publisher"`
+			ReleaseDate string   `json:"release_date"`
+			Genre       string   `json:"genre"`
+			Players     string   `json:"players"`
+			Rating      string   `json:"rating"`
+			AltTitles   []string `json:"alt_titles"`
 		}
 		if err := json.Unmarshal(data, &raw); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", e.Name(), err)
+			}
 			continue
 		}
-		m := make(map[string]*GameEntry, len(raw))
-		for k, v := range raw {
-			m[k] = &GameEntry{
+		idx := &platformIndex{
+			titles: make(map[string]*GameEntry, len(raw)),
+			alts:   make(map[string]*GameEntry),
+		}
+		// Sorted so that an alt title claimed by two entries resolves the
+		// same way on every run
+		keys := make([]string, 0, len(raw))
+		for k := range raw {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := raw[k]
+			entry := &GameEntry{
+				TitleEN:     k,
 				TitleJA:     v.TitleJA,
 				DescJA:      v.DescJA,
 				Developer:   v.Developer,
@@ -61,19 +135,103 @@ func load() {
 				ReleaseDate: v.ReleaseDate,
 				Genre:       v.Genre,
 				Players:     v.Players,
+				Rating:      v.Rating,
 			}
+			idx.titles[k] = entry
+			for _, alt := range v.AltTitles {
+				if _, ok := idx.alts[alt]; !ok {
+					idx.alts[alt] = entry
+				}
+			}
+		}
+		idx.indexJA()
+		result[strings.ToUpper(platform)] = idx
+	}
+	return result, firstErr
+}
+
+// LoadFrom overlays user-supplied <platform>.json files from dir on top of the
+// embedded data. User entries replace embedded entries with the same title.
+// A missing dir is not an error and leaves the embedded data in place.
+// Call it before any lookups.
+func LoadFrom(dir string) error {
+	once.Do(load)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	overlay, err := loadFS(os.DirFS(dir), ".")
+	mu.Lock()
+	defer mu.Unlock()
+	for platform, idx := range overlay {
+		base, ok := cache[platform]
+		if !ok {
+			cache[platform] = idx
+			continue
 		}
-		cache[strings.ToUpper(platform)] = m
+		base.merge(idx)
+	}
+	if err != nil {
+		return fmt.Errorf("load gamedb from %s: %w", dir, err)
 	}
+	return nil
 }
 
+// merge copies every title and alt title of other into idx, replacing
+// existing entries. idx's alt titles of a replaced entry lead to its
+// replacement.
+func (idx *platformIndex) merge(other *platformIndex) {
+	for k, v := range other.titles {
+		idx.titles[k] = v
+	}
+	for k, v := range idx.alts {
+		if e, ok := other.titles[v.TitleEN]; ok {
+			idx.alts[k] = e
+		}
+	}
+	for k, v := range other.alts {
+		idx.alts[k] = v
+	}
+	idx.indexJA()
+}
+
+// Lookup returns the entry for titleEN, matching canonical titles first and
+// then alternate titles.
 func Lookup(platform, titleEN string) *GameEntry {
 	once.Do(load)
-	m, ok := cache[strings.ToUpper(platform)]
+	mu.RLock()
+	defer mu.RUnlock()
+	idx, ok := cache[strings.ToUpper(platform)]
+	if !ok {
+		return nil
+	}
+	return idx.lookup(titleEN)
+}
+
+// Counts returns the number of titles per platform code, not counting
+// alternate titles. Before LoadFrom that is the embedded data alone.
+func Counts() map[string]int {
+	once.Do(load)
+	mu.RLock()
+	defer mu.RUnlock()
+	counts := make(map[string]int, len(cache))
+	for platform, idx := range cache {
+		counts[platform] = len(idx.titles)
+	}
+	return counts
+}
+
+// LookupByJA returns the entry whose title_ja is titleJA, for games known
+// only by their Japanese title. It returns nil if no entry or more than one
+// has that title.
+func LookupByJA(platform, titleJA string) *GameEntry {
+	once.Do(load)
+	mu.RLock()
+	defer mu.RUnlock()
+	idx, ok := cache[strings.ToUpper(platform)]
 	if !ok {
 		return nil
 	}
-	return m[titleEN]
+	return idx.ja[titleJA]
 }
 
 func LookupByHash(platform, crc32, md5, sha1 string) *GameEntry {
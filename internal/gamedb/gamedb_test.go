@@ -0,0 +1,158 @@
+package gamedb
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAltTitles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data/fc.json": {Data: []byte(`{
+			"Rockman (Japan)": {"title_ja": "ロックマン", "alt_titles": ["Mega Man (USA)", "Mega Man (Europe)", "Mega Man (Australia)"]},
+			"Mega Man (USA)": {"title_ja": "メガマン"}
+		}`)},
+	}
+	indexes, err := loadFS(fsys, "data")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	idx := indexes["FC"]
+	if idx == nil {
+		t.Fatal("FC not loaded")
+	}
+
+	canonical := idx.lookup("Rockman (Japan)")
+	if canonical == nil || canonical.TitleJA != "ロックマン" {
+		t.Fatalf("canonical lookup failed: %+v", canonical)
+	}
+	for _, alt := range []string{"Mega Man (Europe)", "Mega Man (Australia)"} {
+		if got := idx.lookup(alt); got != canonical {
+			t.Errorf("alt title %q should resolve to the canonical entry, got %+v", alt, got)
+		}
+	}
+	// An exact canonical match wins over another entry's alt title
+	if got := idx.lookup("Mega Man (USA)"); got == nil || got.TitleJA != "メガマン" {
+		t.Errorf("canonical match should take precedence, got %+v", got)
+	}
+	if got := idx.lookup("Unknown"); got != nil {
+		t.Errorf("expected nil for unknown title, got %+v", got)
+	}
+}
+
+func TestLookupByJA(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data/sfc.json": {Data: []byte(`{
+			"Rockman X (Japan)": {"title_ja": "ロックマンX", "alt_titles": ["Mega Man X (USA)"]},
+			"Super Mario World (Japan)": {"title_ja": "スーパーマリオワールド"},
+			"Super Mario World (USA)": {"title_ja": "スーパーマリオワールド"}
+		}`)},
+	}
+	indexes, err := loadFS(fsys, "data")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	idx := indexes["SFC"]
+	if e := idx.ja["ロックマンX"]; e == nil || e.TitleEN != "Rockman X (Japan)" {
+		t.Errorf("expected the canonical entry for ロックマンX, got %+v", e)
+	}
+	if e, ok := idx.ja["スーパーマリオワールド"]; !ok || e != nil {
+		t.Errorf("a title_ja shared by two entries should be ambiguous, got %+v", e)
+	}
+
+	// An overlay that takes the title back to one entry resolves it
+	idx.merge(&platformIndex{titles: map[string]*GameEntry{
+		"Super Mario World (USA)": {TitleEN: "Super Mario World (USA)", TitleJA: "スーパーマリオワールド (北米版)"},
+	}})
+	if e := idx.ja["スーパーマリオワールド"]; e == nil || e.TitleEN != "Super Mario World (Japan)" {
+		t.Errorf("expected the Japanese entry after the overlay, got %+v", e)
+	}
+
+	if e := LookupByJA("FC", "スーパーマリオブラザーズ"); e == nil || e.TitleEN == "" {
+		t.Errorf("embedded LookupByJA failed: %+v", e)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	counts := Counts()
+	for _, platform := range []string{"FC", "SFC", "GB"} {
+		if counts[platform] == 0 {
+			t.Errorf("no embedded %s entries counted", platform)
+		}
+	}
+}
+
+func TestLoadFrom(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fc.json"), []byte(`{
+		"Super Mario Bros. (Japan)": {"title_ja": "上書き"},
+		"Homebrew Quest (World)": {"title_ja": "自作クエスト"}
+	}`), 0644)
+
+	if err := LoadFrom(dir); err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+	// Later tests expect just the embedded data
+	t.Cleanup(load)
+	if e := Lookup("FC", "Super Mario Bros. (Japan)"); e == nil || e.TitleJA != "上書き" {
+		t.Errorf("user entry should override embedded entry, got %+v", e)
+	}
+	if e := Lookup("FC", "Homebrew Quest (World)"); e == nil || e.TitleJA != "自作クエスト" {
+		t.Errorf("user-only entry not found, got %+v", e)
+	}
+	if err := LoadFrom(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("missing dir should be ignored, got %v", err)
+	}
+}
+
+func TestMergeAltTitles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"embedded/fc.json": {Data: []byte(`{"Rockman (Japan)": {"title_ja": "ロックマン", "alt_titles": ["Mega Man (USA)"]}}`)},
+		"user/fc.json":     {Data: []byte(`{"Rockman (Japan)": {"title_ja": "ロックマン（改）"}}`)},
+	}
+	base, err := loadFS(fsys, "embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := loadFS(fsys, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := base["FC"]
+	idx.merge(overlay["FC"])
+	// The embedded alt title leads to the user's entry, not the one it replaced
+	if e := idx.lookup("Mega Man (USA)"); e == nil || e.TitleJA != "ロックマン（改）" {
+		t.Errorf("alt title lookup = %+v, want the user entry", e)
+	}
+}
+
+func TestLoadFromConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "gb.json"), []byte(`{"Homebrew Quest (World)": {"title_ja": "自作クエスト"}}`), 0644)
+	t.Cleanup(load)
+
+	// Lookups, as the server makes, may run while an overlay is loaded;
+	// go test -race checks they don't touch the cache at the same time
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				Lookup("GB", "Homebrew Quest (World)")
+				Counts()
+			}
+		}()
+	}
+	for range 10 {
+		if err := LoadFrom(dir); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+	if e := Lookup("GB", "Homebrew Quest (World)"); e == nil {
+		t.Error("overlay entry not found")
+	}
+}
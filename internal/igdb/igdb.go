@@ -0,0 +1,304 @@
+// Package igdb looks up game metadata on IGDB (igdb.com) by title.
+package igdb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/retronian/romu/internal/enrich"
+	"github.com/retronian/romu/internal/gamedb"
+	"github.com/retronian/romu/internal/nointro"
+)
+
+const (
+	defaultBaseURL  = "https://api.igdb.com/v4"
+	defaultTokenURL = "https://id.twitch.tv/oauth2/token"
+)
+
+// PlatformIDs maps platform codes to IGDB platform ids. Where IGDB splits a
+// system by region (NES/Famicom, SNES/Super Famicom) both are listed.
+var PlatformIDs = map[string][]int{
+	"FC":     {18, 99},
+	"SFC":    {19, 58},
+	"GB":     {33},
+	"GBC":    {22},
+	"GBA":    {24},
+	"MD":     {29},
+	"PS1":    {7},
+	"PS2":    {8},
+	"N64":    {4},
+	"NDS":    {20},
+	"PCE":    {86},
+	"MSX":    {27},
+	"GG":     {35},
+	"SMS":    {64},
+	"WS":     {57},
+	"WSC":    {123},
+	"NGP":    {119, 120},
+	"LYNX":   {61},
+	"PCFX":   {274},
+	"NEOGEO": {80},
+	"SS":     {32},
+	"DC":     {23},
+	"ARCADE": {52},
+}
+
+var _ enrich.Enricher = (*Client)(nil)
+
+// Client queries the IGDB API using Twitch client-credentials auth. The
+// access token and responses are cached in CacheDir.
+type Client struct {
+	BaseURL      string
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	CacheDir     string
+
+	http *http.Client
+	mu   sync.Mutex
+	tok  *token
+}
+
+type token struct {
+	AccessToken string    `json:"access_token"`
+	Expires     time.Time `json:"expires"`
+}
+
+// NewFromEnv returns a client using IGDB_CLIENT_ID and IGDB_CLIENT_SECRET
+// (a Twitch application's credentials), caching in ~/.romu/cache/igdb.
+func NewFromEnv() (*Client, error) {
+	c := &Client{
+		BaseURL:      defaultBaseURL,
+		TokenURL:     defaultTokenURL,
+		ClientID:     os.Getenv("IGDB_CLIENT_ID"),
+		ClientSecret: os.Getenv("IGDB_CLIENT_SECRET"),
+		http:         &http.Client{Timeout: 30 * time.Second},
+	}
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return nil, errors.New("igdb: IGDB_CLIENT_ID and IGDB_CLIENT_SECRET must be set")
+	}
+	home, _ := os.UserHomeDir()
+	c.CacheDir = filepath.Join(home, ".romu", "cache", "igdb")
+	return c, nil
+}
+
+// Name identifies the source in `romu enrich --source`
+func (c *Client) Name() string { return "igdb" }
+
+// Lookup searches IGDB for title on the platform. DAT-style tags such as
+// "(Japan)" are stripped before searching.
+func (c *Client) Lookup(platform, title string) (*gamedb.GameEntry, error) {
+	query := fmt.Sprintf(`search "%s"; fields name,summary,first_release_date,total_rating,genres.name,`+
+		`involved_companies.company.name,involved_companies.developer,involved_companies.publisher,`+
+		`alternative_names.name,alternative_names.comment;`, strings.ReplaceAll(searchTitle(title), `"`, `\"`))
+	if ids, ok := PlatformIDs[platform]; ok {
+		s := make([]string, len(ids))
+		for i, id := range ids {
+			s[i] = fmt.Sprint(id)
+		}
+		query += " where platforms = (" + strings.Join(s, ",") + ");"
+	}
+	query += " limit 1;"
+
+	var games []game
+	if err := c.post("games", query, &games); err != nil {
+		return nil, err
+	}
+	if len(games) == 0 {
+		return nil, nil
+	}
+	return games[0].entry(), nil
+}
+
+// post sends an Apicalypse query to endpoint and decodes the result into v,
+// using the cached response if there is one.
+func (c *Client) post(endpoint, query string, v interface{}) error {
+	sum := sha1.Sum([]byte(endpoint + "\n" + query))
+	cachePath := filepath.Join(c.CacheDir, hex.EncodeToString(sum[:])+".json")
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return json.Unmarshal(data, v)
+	}
+
+	for attempt := 0; ; attempt++ {
+		tok, err := c.token()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", strings.TrimSuffix(c.BaseURL, "/")+"/"+endpoint, strings.NewReader(query))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Client-ID", c.ClientID)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		status, body, err := c.do(req)
+		if err != nil {
+			return err
+		}
+		switch {
+		case status == http.StatusOK:
+			if err := json.Unmarshal(body, v); err != nil {
+				return fmt.Errorf("igdb: %s: %w", endpoint, err)
+			}
+			if err := os.MkdirAll(c.CacheDir, 0755); err == nil {
+				os.WriteFile(cachePath, body, 0644)
+			}
+			return nil
+		case status == http.StatusUnauthorized && attempt == 0:
+			// Token revoked or expired early; get a new one
+			c.mu.Lock()
+			c.tok = nil
+			c.mu.Unlock()
+			os.Remove(filepath.Join(c.CacheDir, "token.json"))
+		case status == http.StatusTooManyRequests && attempt < 3:
+			// IGDB allows 4 requests per second
+			time.Sleep(time.Second)
+		default:
+			return fmt.Errorf("igdb: %s: HTTP %d", endpoint, status)
+		}
+	}
+}
+
+// token returns a valid access token, from memory, the disk cache or a new
+// client-credentials grant
+func (c *Client) token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tokenPath := filepath.Join(c.CacheDir, "token.json")
+	if c.tok == nil {
+		if data, err := os.ReadFile(tokenPath); err == nil {
+			var t token
+			if json.Unmarshal(data, &t) == nil {
+				c.tok = &t
+			}
+		}
+	}
+	if c.tok != nil && time.Now().Add(time.Minute).Before(c.tok.Expires) {
+		return c.tok.AccessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("client_id", c.ClientID)
+	form.Set("client_secret", c.ClientSecret)
+	form.Set("grant_type", "client_credentials")
+	req, err := http.NewRequest("POST", c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	status, body, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("igdb: token request: HTTP %d", status)
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("igdb: token request: %w", err)
+	}
+	c.tok = &token{AccessToken: resp.AccessToken, Expires: time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)}
+	if data, err := json.Marshal(c.tok); err == nil && os.MkdirAll(c.CacheDir, 0755) == nil {
+		os.WriteFile(tokenPath, data, 0600)
+	}
+	return c.tok.AccessToken, nil
+}
+
+func (c *Client) do(req *http.Request) (int, []byte, error) {
+	client := c.http
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// searchTitle strips No-Intro style tags and moves a trailing article back:
+// "Legend of Zelda, The (Japan) (Rev 1)" -> "The Legend of Zelda"
+func searchTitle(title string) string {
+	return nointro.Canonicalize(title)
+}
+
+// game is the subset of an IGDB game record romu uses
+type game struct {
+	Name             string  `json:"name"`
+	Summary          string  `json:"summary"`
+	FirstReleaseDate int64   `json:"first_release_date"`
+	TotalRating      float64 `json:"total_rating"` // 0-100
+	Genres           []struct {
+		Name string `json:"name"`
+	} `json:"genres"`
+	InvolvedCompanies []struct {
+		Company struct {
+			Name string `json:"name"`
+		} `json:"company"`
+		Developer bool `json:"developer"`
+		Publisher bool `json:"publisher"`
+	} `json:"involved_companies"`
+	AlternativeNames []struct {
+		Name    string `json:"name"`
+		Comment string `json:"comment"`
+	} `json:"alternative_names"`
+}
+
+// entry converts g to a gamedb entry. IGDB summaries are English; they are
+// stored as the description since it is the only description field.
+func (g *game) entry() *gamedb.GameEntry {
+	e := &gamedb.GameEntry{DescJA: g.Summary}
+	if g.FirstReleaseDate != 0 {
+		e.ReleaseDate = time.Unix(g.FirstReleaseDate, 0).UTC().Format("20060102") + "T000000"
+	}
+	if len(g.Genres) > 0 {
+		e.Genre = g.Genres[0].Name
+	}
+	if g.TotalRating > 0 {
+		e.Rating = strconv.FormatFloat(math.Round(g.TotalRating)/100, 'f', -1, 64)
+	}
+	for _, ic := range g.InvolvedCompanies {
+		if ic.Developer && e.Developer == "" {
+			e.Developer = ic.Company.Name
+		}
+		if ic.Publisher && e.Publisher == "" {
+			e.Publisher = ic.Company.Name
+		}
+	}
+	// Japanese alternative names come both romanized and in Japanese script
+	for _, an := range g.AlternativeNames {
+		if strings.HasPrefix(an.Comment, "Japanese") && !isASCII(an.Name) {
+			e.TitleJA = an.Name
+			break
+		}
+	}
+	return e
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package igdb
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const gamesResponse = `[{
+	"name":"Mega Man",
+	"summary":"Fight Dr. Wily's robots.",
+	"first_release_date":566697600,
+	"total_rating":78.4,
+	"genres":[{"name":"Platform"},{"name":"Shooter"}],
+	"involved_companies":[
+		{"company":{"name":"Capcom USA"},"developer":false,"publisher":true},
+		{"company":{"name":"Capcom"},"developer":true,"publisher":true}
+	],
+	"alternative_names":[{"name":"Rockman","comment":"Japanese title - translated"},{"name":"ロックマン","comment":"Japanese title - original"}]
+}]`
+
+func TestLookup(t *testing.T) {
+	tokens, queries := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			tokens++
+			w.Write([]byte(`{"access_token":"tok","expires_in":3600}`))
+		case "/games":
+			queries++
+			if r.Header.Get("Authorization") != "Bearer tok" || r.Header.Get("Client-ID") != "id" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(body), `search "Rockman"`) || !strings.Contains(string(body), "platforms = (18,99)") {
+				t.Errorf("unexpected query: %s", body)
+			}
+			w.Write([]byte(gamesResponse))
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL, TokenURL: srv.URL + "/token", ClientID: "id", ClientSecret: "secret", CacheDir: t.TempDir()}
+	e, err := c.Lookup("FC", "Rockman (Japan) [b]")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if e == nil || e.TitleJA != "ロックマン" || e.Developer != "Capcom" || e.Publisher != "Capcom USA" ||
+		e.Genre != "Platform" || e.ReleaseDate != "19871217T000000" || e.DescJA != "Fight Dr. Wily's robots." || e.Rating != "0.78" {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+
+	// A fresh client reuses the cached token and response
+	c2 := &Client{BaseURL: srv.URL, TokenURL: srv.URL + "/token", ClientID: "id", ClientSecret: "secret", CacheDir: c.CacheDir}
+	if e, _ := c2.Lookup("FC", "Rockman (Japan) [b]"); e == nil {
+		t.Error("expected cached entry")
+	}
+	if tokens != 1 || queries != 1 {
+		t.Errorf("expected 1 token and 1 query request, got %d and %d", tokens, queries)
+	}
+}
+
+func TestSearchTitle(t *testing.T) {
+	tests := map[string]string{
+		"Rockman (Japan)":                   "Rockman",
+		"Super Mario Bros. (World) [!]":     "Super Mario Bros.",
+		"Final Fantasy III (Japan) (Rev 1)": "Final Fantasy III",
+		"Tetris":                            "Tetris",
+		"Legend of Zelda, The (USA)":        "The Legend of Zelda",
+	}
+	for in, want := range tests {
+		if got := searchTitle(in); got != want {
+			t.Errorf("searchTitle(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
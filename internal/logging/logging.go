@@ -0,0 +1,62 @@
+// Package logging holds how much romu prints, set once at startup from the
+// global --quiet, --verbose and --log-format flags. Diagnostics go through
+// log/slog to stderr; per-item progress on stdout checks Quiet.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Level is an output verbosity
+type Level int
+
+const (
+	LevelQuiet   Level = -1 // summaries, warnings and errors only
+	LevelNormal  Level = 0  // plus per-item progress
+	LevelVerbose Level = 1  // plus debug logs: skip reasons, HTTP requests, timings
+)
+
+var level = LevelNormal
+
+// Setup sets the verbosity and installs the default slog logger, writing
+// to stderr in format "text" or "json". It is not safe to call
+// concurrently with output, so it belongs at startup.
+func Setup(l Level, format string) error {
+	h, err := newHandler(os.Stderr, l, format)
+	if err != nil {
+		return err
+	}
+	level = l
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+func newHandler(w io.Writer, l Level, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	switch l {
+	case LevelQuiet:
+		opts.Level = slog.LevelWarn
+	case LevelVerbose:
+		opts.Level = slog.LevelDebug
+	}
+	switch format {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (text or json)", format)
+}
+
+// Quiet reports whether per-item output is suppressed
+func Quiet() bool {
+	return level <= LevelQuiet
+}
+
+// Verbose reports whether extra detail is wanted
+func Verbose() bool {
+	return level >= LevelVerbose
+}
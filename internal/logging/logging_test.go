@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	defer Setup(LevelNormal, "text")
+	for _, tt := range []struct {
+		level          Level
+		quiet, verbose bool
+	}{
+		{LevelQuiet, true, false},
+		{LevelNormal, false, false},
+		{LevelVerbose, false, true},
+	} {
+		if err := Setup(tt.level, "text"); err != nil {
+			t.Fatal(err)
+		}
+		if Quiet() != tt.quiet || Verbose() != tt.verbose {
+			t.Errorf("level %d: Quiet() = %v, Verbose() = %v", tt.level, Quiet(), Verbose())
+		}
+		if got := slog.Default().Enabled(context.Background(), slog.LevelDebug); got != tt.verbose {
+			t.Errorf("level %d: debug logging enabled = %v", tt.level, got)
+		}
+	}
+	if err := Setup(LevelNormal, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestJSONHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := newHandler(&buf, LevelQuiet, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(h)
+	log.Info("hidden")
+	log.Warn("scan error", "path", "/roms/fc/a.nes")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "scan error" || rec["path"] != "/roms/fc/a.nes" || rec["level"] != "WARN" {
+		t.Errorf("unexpected record %v", rec)
+	}
+}
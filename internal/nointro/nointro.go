@@ -0,0 +1,127 @@
+// Package nointro parses No-Intro style game names such as
+// "Legend of Zelda, The - A Link to the Past (USA) (Rev 1)".
+package nointro
+
+import "strings"
+
+// articles are the leading articles No-Intro moves behind the title
+var articles = []string{"The", "A", "An"}
+
+// Parse splits a name into its title and its parenthesized or bracketed
+// tags, in order and without their delimiters: "Tetris (Japan) (En) [!]"
+// gives "Tetris" and ["Japan", "En", "!"]. Nested groups stay inside their
+// tag, e.g. "(Proto (Alt 1))" gives "Proto (Alt 1)", and an unclosed group
+// runs to the end of the name.
+func Parse(name string) (base string, tags []string) {
+	base, groups := split(name)
+	for _, g := range groups {
+		tags = append(tags, g.text)
+	}
+	return base, tags
+}
+
+// Tags returns the tags of a name as they were written, each in its own
+// brackets and in order: "Tetris (Japan) [!]" gives "(Japan) [!]"
+func Tags(name string) string {
+	_, groups := split(name)
+	parts := make([]string, len(groups))
+	for i, g := range groups {
+		if g.open == '[' {
+			parts[i] = "[" + g.text + "]"
+		} else {
+			parts[i] = "(" + g.text + ")"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Normalize tidies a name the way No-Intro writes it: single spaces, tags
+// in parentheses after the title, and GoodTools-style bracketed flags such
+// as "[!]" or "[b1]", which No-Intro names never have, dropped.
+// "Tetris  [!] (Japan)" gives "Tetris (Japan)".
+func Normalize(name string) string {
+	base, groups := split(name)
+	for _, g := range groups {
+		if g.open == '(' {
+			base += " (" + g.text + ")"
+		}
+	}
+	return base
+}
+
+// group is one top-level tag of a name
+type group struct {
+	open rune // '(' or '['
+	text string
+}
+
+func split(name string) (string, []group) {
+	var title, tag strings.Builder
+	var groups []group
+	var open rune
+	depth := 0
+	for _, r := range name {
+		switch {
+		case r == '(' || r == '[':
+			if depth > 0 {
+				tag.WriteRune(r)
+			} else {
+				open = r
+			}
+			depth++
+		case (r == ')' || r == ']') && depth > 0:
+			depth--
+			if depth > 0 {
+				tag.WriteRune(r)
+				continue
+			}
+			groups = append(groups, group{open, strings.TrimSpace(tag.String())})
+			tag.Reset()
+			// Keep words on either side of a tag apart
+			title.WriteByte(' ')
+		case depth > 0:
+			tag.WriteRune(r)
+		default:
+			title.WriteRune(r)
+		}
+	}
+	if depth > 0 {
+		groups = append(groups, group{open, strings.TrimSpace(tag.String())})
+	}
+	return strings.Join(strings.Fields(title.String()), " "), groups
+}
+
+// Canonicalize returns the title of a name with its tags dropped and a
+// trailing article moved back to the front: "Legend of Zelda, The - A Link
+// to the Past (USA)" gives "The Legend of Zelda - A Link to the Past".
+func Canonicalize(name string) string {
+	base, _ := Parse(name)
+	main, sub, hasSub := strings.Cut(base, " - ")
+	for _, a := range articles {
+		if rest, ok := strings.CutSuffix(main, ", "+a); ok {
+			main = a + " " + rest
+			break
+		}
+	}
+	if hasSub {
+		return main + " - " + sub
+	}
+	return main
+}
+
+// InvertArticle moves a title's leading article behind it the way No-Intro
+// names do: "The Legend of Zelda - A Link to the Past" gives "Legend of
+// Zelda, The - A Link to the Past". Titles without one are returned as is.
+func InvertArticle(title string) string {
+	main, sub, hasSub := strings.Cut(title, " - ")
+	for _, a := range articles {
+		if rest, ok := strings.CutPrefix(main, a+" "); ok && rest != "" {
+			main = rest + ", " + a
+			break
+		}
+	}
+	if hasSub {
+		return main + " - " + sub
+	}
+	return main
+}
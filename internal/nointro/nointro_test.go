@@ -0,0 +1,101 @@
+package nointro
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		tags []string
+	}{
+		{"Rockman (Japan)", "Rockman", []string{"Japan"}},
+		{"Super Mario Bros. (World)", "Super Mario Bros.", []string{"World"}},
+		{"Legend of Zelda, The (USA)", "Legend of Zelda, The", []string{"USA"}},
+		{"Legend of Zelda, The - A Link to the Past (USA)", "Legend of Zelda, The - A Link to the Past", []string{"USA"}},
+		{"Pokemon - Red Version (USA, Europe) (SGB Enhanced)", "Pokemon - Red Version", []string{"USA, Europe", "SGB Enhanced"}},
+		{"Tetris (Japan) (En) (Rev 1)", "Tetris", []string{"Japan", "En", "Rev 1"}},
+		{"Donkey Kong Country (USA) (Rev 2)", "Donkey Kong Country", []string{"USA", "Rev 2"}},
+		{"Super Mario Bros. (World) [!]", "Super Mario Bros.", []string{"World", "!"}},
+		{"Street Fighter II' - Special Champion Edition (Europe)", "Street Fighter II' - Special Champion Edition", []string{"Europe"}},
+		{"Dragon Quest V - Tenkuu no Hanayome (Japan) (Proto (Alt 1))", "Dragon Quest V - Tenkuu no Hanayome", []string{"Japan", "Proto (Alt 1)"}},
+		{"Final Fantasy Adventure (USA) (Beta) [b]", "Final Fantasy Adventure", []string{"USA", "Beta", "b"}},
+		{"Castlevania II - Simon's Quest (USA, Europe) (En,Fr,De)", "Castlevania II - Simon's Quest", []string{"USA, Europe", "En,Fr,De"}},
+		{"[BIOS] Game Boy Color (Japan)", "Game Boy Color", []string{"BIOS", "Japan"}},
+		{"Star Fox (USA) (Rev 2", "Star Fox", []string{"USA", "Rev 2"}},
+		{"Tetris", "Tetris", nil},
+	}
+	for _, tt := range tests {
+		base, tags := Parse(tt.name)
+		if base != tt.base || !slices.Equal(tags, tt.tags) {
+			t.Errorf("Parse(%q) = %q, %q, want %q, %q", tt.name, base, tags, tt.base, tt.tags)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	tests := map[string]string{
+		"Tetris (Japan) (En) [!]":                "(Japan) (En) [!]",
+		"Final Fantasy Adventure [b1] (USA)":     "[b1] (USA)",
+		"Dragon Quest V (Japan) (Proto (Alt 1))": "(Japan) (Proto (Alt 1))",
+		"Tetris":                                 "",
+	}
+	for in, want := range tests {
+		if got := Tags(in); got != want {
+			t.Errorf("Tags(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Super Mario Bros. (World) [!]":          "Super Mario Bros. (World)",
+		"Tetris  (Japan)   (En)":                 "Tetris (Japan) (En)",
+		"[BIOS] Game Boy Color (Japan)":          "Game Boy Color (Japan)",
+		"Final Fantasy Adventure [b1] (USA)":     "Final Fantasy Adventure (USA)",
+		"Dragon Quest V (Japan) (Proto (Alt 1))": "Dragon Quest V (Japan) (Proto (Alt 1))",
+		"Rockman (Japan)":                        "Rockman (Japan)",
+	}
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := map[string]string{
+		"Legend of Zelda, The (USA)":                                  "The Legend of Zelda",
+		"Legend of Zelda, The - A Link to the Past (USA)":             "The Legend of Zelda - A Link to the Past",
+		"Boy and His Blob, A - Trouble on Blobolonia (USA)":           "A Boy and His Blob - Trouble on Blobolonia",
+		"Addams Family, The - Pugsley's Scavenger Hunt (USA) (Rev 1)": "The Addams Family - Pugsley's Scavenger Hunt",
+		"Pokemon - Red Version (USA, Europe)":                         "Pokemon - Red Version",
+		"Where in Time Is Carmen Sandiego? (USA)":                     "Where in Time Is Carmen Sandiego?",
+		"Tetris": "Tetris",
+	}
+	for in, want := range tests {
+		if got := Canonicalize(in); got != want {
+			t.Errorf("Canonicalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestInvertArticle(t *testing.T) {
+	tests := map[string]string{
+		"The Legend of Zelda":                        "Legend of Zelda, The",
+		"The Legend of Zelda - A Link to the Past":   "Legend of Zelda, The - A Link to the Past",
+		"A Boy and His Blob - Trouble on Blobolonia": "Boy and His Blob, A - Trouble on Blobolonia",
+		"Theme Park":       "Theme Park",
+		"Super Mario Land": "Super Mario Land",
+	}
+	for in, want := range tests {
+		if got := InvertArticle(in); got != want {
+			t.Errorf("InvertArticle(%q) = %q, want %q", in, got, want)
+		}
+		if got := Canonicalize(InvertArticle(in)); got != in {
+			t.Errorf("Canonicalize(InvertArticle(%q)) = %q", in, got)
+		}
+	}
+}
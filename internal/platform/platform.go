@@ -0,0 +1,304 @@
+// Package platform holds what romu knows about each platform code: how the
+// scanner recognizes its folders and files, how DAT headers name it, where
+// libretro-thumbnails keeps its covers and what LaunchBox calls it.
+package platform
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Unknown is the code of files no platform was found for, kept until they
+// are reassigned
+const Unknown = "UNKNOWN"
+
+// Platform describes one platform code
+type Platform struct {
+	Code        string
+	DisplayName string
+	Folders     []string // lowercase folder names meaning this platform
+	Extensions  []string // lowercase ROM file extensions, with the dot
+	ZipIsROM    bool     // a .zip is the ROM itself (arcade sets), not an archive of ROMs
+	DATNames    []string // lowercase substrings of No-Intro/Redump header names
+	Libretro    string   // libretro-thumbnails repository, "" if there is none
+	LaunchBox   string   // LaunchBox platform name, "" if there is none
+}
+
+// all lists every platform. DAT names are tried in this order, so platforms
+// whose names contain another's ("Super Nintendo Entertainment System",
+// "Game Boy Advance") must come before it.
+var all = []Platform{
+	{Code: "SFC", DisplayName: "Super Famicom / SNES", Folders: []string{"sfc", "snes"}, Extensions: []string{".sfc", ".smc"},
+		DATNames: []string{"super nintendo", "super famicom"}, Libretro: "Nintendo_-_Super_Nintendo_Entertainment_System", LaunchBox: "Super Nintendo Entertainment System"},
+	{Code: "FC", DisplayName: "Famicom / NES", Folders: []string{"fc", "nes"}, Extensions: []string{".nes"},
+		DATNames: []string{"nintendo entertainment system", "famicom"}, Libretro: "Nintendo_-_Nintendo_Entertainment_System", LaunchBox: "Nintendo Entertainment System"},
+	{Code: "GBA", DisplayName: "Game Boy Advance", Folders: []string{"gba"}, Extensions: []string{".gba"},
+		DATNames: []string{"game boy advance"}, Libretro: "Nintendo_-_Game_Boy_Advance", LaunchBox: "Nintendo Game Boy Advance"},
+	{Code: "GBC", DisplayName: "Game Boy Color", Folders: []string{"gbc"}, Extensions: []string{".gbc"},
+		DATNames: []string{"game boy color"}, Libretro: "Nintendo_-_Game_Boy_Color", LaunchBox: "Nintendo Game Boy Color"},
+	{Code: "GB", DisplayName: "Game Boy", Folders: []string{"gb"}, Extensions: []string{".gb"},
+		DATNames: []string{"game boy"}, Libretro: "Nintendo_-_Game_Boy", LaunchBox: "Nintendo Game Boy"},
+	{Code: "N64", DisplayName: "Nintendo 64", Folders: []string{"n64"}, Extensions: []string{".n64", ".z64", ".v64"},
+		DATNames: []string{"nintendo 64"}, Libretro: "Nintendo_-_Nintendo_64", LaunchBox: "Nintendo 64"},
+	{Code: "NDS", DisplayName: "Nintendo DS", Folders: []string{"nds"}, Extensions: []string{".nds"},
+		DATNames: []string{"nintendo ds"}, Libretro: "Nintendo_-_Nintendo_DS", LaunchBox: "Nintendo DS"},
+	{Code: "MD", DisplayName: "Mega Drive / Genesis", Folders: []string{"md", "genesis", "megadrive"}, Extensions: []string{".md", ".bin", ".gen"},
+		DATNames: []string{"mega drive", "genesis"}, Libretro: "Sega_-_Mega_Drive_-_Genesis", LaunchBox: "Sega Genesis"},
+	{Code: "SMS", DisplayName: "Master System", Folders: []string{"sms"}, Extensions: []string{".sms"},
+		DATNames: []string{"master system"}, Libretro: "Sega_-_Master_System_-_Mark_III", LaunchBox: "Sega Master System"},
+	{Code: "GG", DisplayName: "Game Gear", Folders: []string{"gg"}, Extensions: []string{".gg"},
+		DATNames: []string{"game gear"}, Libretro: "Sega_-_Game_Gear", LaunchBox: "Sega Game Gear"},
+	{Code: "SS", DisplayName: "Sega Saturn", Folders: []string{"segasaturn"}, Extensions: []string{".iso", ".bin", ".cue"},
+		Libretro: "Sega_-_Saturn", LaunchBox: "Sega Saturn"},
+	{Code: "DC", DisplayName: "Dreamcast", Libretro: "Sega_-_Dreamcast", LaunchBox: "Sega Dreamcast"},
+	{Code: "PS1", DisplayName: "PlayStation", Folders: []string{"ps1", "psx"}, Extensions: []string{".bin", ".cue", ".img", ".iso"},
+		DATNames: []string{"playstation"}, Libretro: "Sony_-_PlayStation", LaunchBox: "Sony Playstation"},
+	{Code: "PS2", DisplayName: "PlayStation 2", Folders: []string{"ps2"}, Extensions: []string{".iso", ".bin", ".cue"},
+		Libretro: "Sony_-_PlayStation_2", LaunchBox: "Sony Playstation 2"},
+	{Code: "PCE", DisplayName: "PC Engine / TurboGrafx-16", Folders: []string{"pce", "pcengine", "pcenginecd"}, Extensions: []string{".pce"},
+		DATNames: []string{"pc engine", "turbografx"}, Libretro: "NEC_-_PC_Engine_-_TurboGrafx_16", LaunchBox: "NEC TurboGrafx-16"},
+	{Code: "PCFX", DisplayName: "PC-FX", Folders: []string{"pcfx"}, Extensions: []string{".iso", ".bin", ".cue"},
+		Libretro: "NEC_-_PC-FX", LaunchBox: "NEC PC-FX"},
+	{Code: "MSX", DisplayName: "MSX", Folders: []string{"msx"}, Extensions: []string{".rom"},
+		Libretro: "Microsoft_-_MSX", LaunchBox: "Microsoft MSX"},
+	{Code: "WSC", DisplayName: "WonderSwan Color", Folders: []string{"wsc", "wonderswancolor"}, Extensions: []string{".wsc"},
+		DATNames: []string{"wonderswan color"}, Libretro: "Bandai_-_WonderSwan_Color", LaunchBox: "WonderSwan Color"},
+	{Code: "WS", DisplayName: "WonderSwan", Folders: []string{"ws", "wonderswan"}, Extensions: []string{".ws"},
+		DATNames: []string{"wonderswan"}, Libretro: "Bandai_-_WonderSwan", LaunchBox: "WonderSwan"},
+	{Code: "NGP", DisplayName: "Neo Geo Pocket", Folders: []string{"ngp"}, Extensions: []string{".ngp"},
+		DATNames: []string{"neo geo pocket"}, Libretro: "SNK_-_Neo_Geo_Pocket", LaunchBox: "SNK Neo Geo Pocket"},
+	{Code: "NEOGEO", DisplayName: "Neo Geo", Folders: []string{"neogeo"}, Extensions: []string{".zip"}, ZipIsROM: true,
+		Libretro: "SNK_-_Neo_Geo", LaunchBox: "SNK Neo Geo AES"},
+	{Code: "LYNX", DisplayName: "Atari Lynx", Folders: []string{"lynx"}, Extensions: []string{".lnx"},
+		DATNames: []string{"lynx"}, Libretro: "Atari_-_Lynx", LaunchBox: "Atari Lynx"},
+	{Code: "PICO8", DisplayName: "PICO-8", Folders: []string{"pico8"}, Extensions: []string{".p8", ".png"}, LaunchBox: "PICO-8"},
+	{Code: "ARCADE", DisplayName: "Arcade", Folders: []string{"arcade"}, Extensions: []string{".zip"}, ZipIsROM: true, LaunchBox: "Arcade"},
+}
+
+var (
+	byCode   = map[string]*Platform{}
+	byFolder = map[string]string{}
+)
+
+func init() {
+	index()
+}
+
+func index() {
+	clear(byCode)
+	clear(byFolder)
+	for i := range all {
+		p := &all[i]
+		byCode[p.Code] = p
+		for _, f := range p.Folders {
+			byFolder[f] = p.Code
+		}
+	}
+}
+
+// Extend adds folder names and extensions to a platform, replacing its own
+// if replace is set, or adds a new platform if code is unknown; a new
+// platform without folders is found in a folder named after its lowercased
+// code. name, if not empty, sets the display name. Extend is not safe to
+// call while platforms are being looked up, so it belongs at startup.
+func Extend(code, name string, folders, extensions []string, replace bool) {
+	p, ok := byCode[code]
+	if !ok {
+		if len(folders) == 0 {
+			folders = []string{strings.ToLower(code)}
+		}
+		all = append(all, Platform{Code: code, DisplayName: code})
+		p = &all[len(all)-1]
+	}
+	if name != "" {
+		p.DisplayName = name
+	}
+	if replace && len(folders) > 0 {
+		p.Folders = nil
+	}
+	if replace && len(extensions) > 0 {
+		p.Extensions = nil
+	}
+	for _, f := range folders {
+		if !slices.Contains(p.Folders, f) {
+			p.Folders = append(p.Folders, f)
+		}
+	}
+	for _, ext := range extensions {
+		if !slices.Contains(p.Extensions, ext) {
+			p.Extensions = append(p.Extensions, ext)
+		}
+	}
+	index()
+}
+
+// Save returns a function that puts the platforms back as they are now,
+// for tests that Extend them
+func Save() (restore func()) {
+	saved := make([]Platform, len(all))
+	for i, p := range all {
+		p.Folders = slices.Clone(p.Folders)
+		p.Extensions = slices.Clone(p.Extensions)
+		saved[i] = p
+	}
+	return func() {
+		all = saved
+		index()
+	}
+}
+
+// All returns every platform
+func All() []Platform {
+	return append([]Platform(nil), all...)
+}
+
+// Get returns the platform with the given code
+func Get(code string) (Platform, bool) {
+	p, ok := byCode[code]
+	if !ok {
+		return Platform{}, false
+	}
+	return *p, true
+}
+
+// Normalize returns the platform code s stands for when it is a folder name
+// such as "snes" or "genesis", and s itself otherwise
+func Normalize(s string) string {
+	if _, ok := byCode[s]; ok {
+		return s
+	}
+	if code := FromFolder(s); code != "" {
+		return code
+	}
+	return s
+}
+
+// Check returns an error if code is neither a platform code nor Unknown,
+// suggesting the code that was probably meant
+func Check(code string) error {
+	if _, ok := byCode[code]; ok || code == Unknown {
+		return nil
+	}
+	if s := Suggest(code); s != "" {
+		return fmt.Errorf("unknown platform %q (did you mean %s?)", code, s)
+	}
+	codes := make([]string, 0, len(all))
+	for _, p := range all {
+		codes = append(codes, p.Code)
+	}
+	sort.Strings(codes)
+	return fmt.Errorf("unknown platform %q (known: %s)", code, strings.Join(codes, ", "))
+}
+
+// Suggest returns the platform code s probably stands for: a code in
+// another case ("sfc"), a folder name ("snes") or a display or LaunchBox
+// name ("Game Boy"), or "" if there is none
+func Suggest(s string) string {
+	if p, ok := byCode[strings.ToUpper(s)]; ok {
+		return p.Code
+	}
+	if strings.EqualFold(s, Unknown) {
+		return Unknown
+	}
+	if code := FromFolder(s); code != "" {
+		return code
+	}
+	for _, p := range all {
+		if strings.EqualFold(s, p.DisplayName) || p.LaunchBox != "" && strings.EqualFold(s, p.LaunchBox) {
+			return p.Code
+		}
+	}
+	return ""
+}
+
+// Scannable returns the sorted codes of platforms the scanner can detect,
+// i.e. those with folder names and extensions
+func Scannable() []string {
+	var codes []string
+	for _, p := range all {
+		if len(p.Folders) > 0 && len(p.Extensions) > 0 {
+			codes = append(codes, p.Code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// DisplayName returns the human-readable name of a platform code, or the
+// code itself if it has none
+func DisplayName(code string) string {
+	if p, ok := byCode[code]; ok {
+		return p.DisplayName
+	}
+	return code
+}
+
+// FromFolder returns the platform code a folder name stands for, or ""
+func FromFolder(name string) string {
+	return byFolder[strings.ToLower(name)]
+}
+
+// FromExtension returns the platform code of the only platform whose ROMs
+// have the given extension, such as ".gb", or "" if none or several do
+func FromExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	code := ""
+	for _, p := range all {
+		if slices.Contains(p.Extensions, ext) {
+			if code != "" {
+				return ""
+			}
+			code = p.Code
+		}
+	}
+	return code
+}
+
+// FromDATHeader returns the platform code for a DAT header name such as
+// "Nintendo - Game Boy Advance", or ""
+func FromDATHeader(name string) string {
+	lower := strings.ToLower(name)
+	for _, p := range all {
+		for _, pattern := range p.DATNames {
+			if strings.Contains(lower, pattern) {
+				return p.Code
+			}
+		}
+	}
+	return ""
+}
+
+// LibretroSystems maps platform codes to libretro-thumbnails repositories
+func LibretroSystems() map[string]string {
+	m := map[string]string{}
+	for _, p := range all {
+		if p.Libretro != "" {
+			m[p.Code] = p.Libretro
+		}
+	}
+	return m
+}
+
+// RetroArchDB returns the name of RetroArch's database for a platform code,
+// such as "Nintendo - Game Boy", or "" if it has none. libretro-thumbnails
+// repositories are named after them with underscores for spaces.
+func RetroArchDB(code string) string {
+	if p, ok := byCode[code]; ok {
+		return strings.ReplaceAll(p.Libretro, "_", " ")
+	}
+	return ""
+}
+
+// FromRetroArchDB returns the platform code for the name of a RetroArch
+// database or playlist, such as "Nintendo - Game Boy.lpl", or ""
+func FromRetroArchDB(name string) string {
+	name = strings.TrimSuffix(name, ".lpl")
+	for _, p := range all {
+		if p.Libretro != "" && strings.EqualFold(RetroArchDB(p.Code), name) {
+			return p.Code
+		}
+	}
+	return ""
+}
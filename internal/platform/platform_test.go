@@ -0,0 +1,209 @@
+package platform
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDisplayName(t *testing.T) {
+	tests := map[string]string{
+		"FC":     "Famicom / NES",
+		"NEOGEO": "Neo Geo",
+		"NGP":    "Neo Geo Pocket",
+		"XYZ":    "XYZ",
+	}
+	for code, want := range tests {
+		if got := DisplayName(code); got != want {
+			t.Errorf("DisplayName(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestPlatformsConsistent(t *testing.T) {
+	codes := map[string]bool{}
+	folders := map[string]string{}
+	repos := map[string]string{}
+	launchBox := map[string]string{}
+	for _, p := range All() {
+		if p.Code == "" || p.Code != strings.ToUpper(p.Code) || codes[p.Code] {
+			t.Errorf("%q: codes must be unique and uppercase", p.Code)
+		}
+		codes[p.Code] = true
+		if p.DisplayName == "" {
+			t.Errorf("%s: no display name", p.Code)
+		}
+		if (len(p.Folders) == 0) != (len(p.Extensions) == 0) {
+			t.Errorf("%s: folders and extensions must be given together", p.Code)
+		}
+		for _, f := range p.Folders {
+			if other, ok := folders[f]; ok {
+				t.Errorf("folder %q claimed by both %s and %s", f, other, p.Code)
+			}
+			folders[f] = p.Code
+			if got := FromFolder(f); got != p.Code {
+				t.Errorf("FromFolder(%q) = %q, want %q", f, got, p.Code)
+			}
+		}
+		for _, ext := range p.Extensions {
+			if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+				t.Errorf("%s: extension %q must be lowercase with a dot", p.Code, ext)
+			}
+		}
+		if p.Libretro != "" {
+			if other, ok := repos[p.Libretro]; ok {
+				t.Errorf("libretro repo %q used by both %s and %s", p.Libretro, other, p.Code)
+			}
+			repos[p.Libretro] = p.Code
+		}
+		if p.LaunchBox != "" {
+			if other, ok := launchBox[p.LaunchBox]; ok {
+				t.Errorf("LaunchBox platform %q used by both %s and %s", p.LaunchBox, other, p.Code)
+			}
+			launchBox[p.LaunchBox] = p.Code
+		}
+		if p.ZipIsROM && !slices.Contains(p.Extensions, ".zip") {
+			t.Errorf("%s: ZIP sets need the .zip extension", p.Code)
+		}
+		// Each DAT name must not be shadowed by a platform listed earlier
+		for _, name := range p.DATNames {
+			if got := FromDATHeader("Vendor - " + name); got != p.Code {
+				t.Errorf("FromDATHeader(%q) = %q, want %q", name, got, p.Code)
+			}
+		}
+	}
+}
+
+// TestPlatformMappings pins down where each source of platform knowledge
+// sends well-known names, so that one platform can't silently take over
+// another's, as NEOGEO once had Neo Geo Pocket's covers
+func TestPlatformMappings(t *testing.T) {
+	tests := []struct {
+		code      string
+		folder    string
+		ext       string // owned by code alone, or "" if shared
+		datHeader string
+		libretro  string
+	}{
+		{"NEOGEO", "neogeo", "", "", "SNK_-_Neo_Geo"},
+		{"NGP", "ngp", ".ngp", "SNK - Neo Geo Pocket", "SNK_-_Neo_Geo_Pocket"},
+		{"SFC", "snes", ".smc", "Nintendo - Super Nintendo Entertainment System", "Nintendo_-_Super_Nintendo_Entertainment_System"},
+		{"FC", "nes", ".nes", "Nintendo - Nintendo Entertainment System", "Nintendo_-_Nintendo_Entertainment_System"},
+		{"GBA", "gba", ".gba", "Nintendo - Game Boy Advance", "Nintendo_-_Game_Boy_Advance"},
+		{"GB", "gb", ".gb", "Nintendo - Game Boy", "Nintendo_-_Game_Boy"},
+		{"MD", "genesis", ".gen", "Sega - Mega Drive - Genesis", "Sega_-_Mega_Drive_-_Genesis"},
+		{"WSC", "wonderswancolor", ".wsc", "Bandai - WonderSwan Color", "Bandai_-_WonderSwan_Color"},
+		{"ARCADE", "arcade", "", "", ""},
+	}
+	repos := LibretroSystems()
+	for _, tt := range tests {
+		if got := FromFolder(tt.folder); got != tt.code {
+			t.Errorf("FromFolder(%q) = %q, want %s", tt.folder, got, tt.code)
+		}
+		if tt.ext != "" {
+			if got := FromExtension(tt.ext); got != tt.code {
+				t.Errorf("FromExtension(%q) = %q, want %s", tt.ext, got, tt.code)
+			}
+		}
+		if tt.datHeader != "" {
+			if got := FromDATHeader(tt.datHeader); got != tt.code {
+				t.Errorf("FromDATHeader(%q) = %q, want %s", tt.datHeader, got, tt.code)
+			}
+		}
+		if got := repos[tt.code]; got != tt.libretro {
+			t.Errorf("%s: libretro repo %q, want %q", tt.code, got, tt.libretro)
+		}
+	}
+	// .zip is an archive of ROMs for most platforms and the ROM itself for
+	// NEOGEO and ARCADE, so it can't identify either
+	if got := FromExtension(".zip"); got != "" {
+		t.Errorf("FromExtension(.zip) = %q, want none", got)
+	}
+}
+
+func TestRetroArchDB(t *testing.T) {
+	tests := map[string]string{
+		"FC":     "Nintendo - Nintendo Entertainment System",
+		"PCE":    "NEC - PC Engine - TurboGrafx 16",
+		"ARCADE": "",
+		"XYZ":    "",
+	}
+	if got := FromRetroArchDB("Favorites.lpl"); got != "" {
+		t.Errorf("FromRetroArchDB(Favorites) = %q", got)
+	}
+	for code, want := range tests {
+		if got := RetroArchDB(code); got != want {
+			t.Errorf("RetroArchDB(%q) = %q, want %q", code, got, want)
+		}
+		if want != "" {
+			if got := FromRetroArchDB(want + ".lpl"); got != code {
+				t.Errorf("FromRetroArchDB(%q) = %q, want %q", want+".lpl", got, code)
+			}
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"MD":       "MD",
+		"genesis":  "MD",
+		"Genesis":  "MD",
+		"snes":     "SFC",
+		"nes":      "FC",
+		"Game Boy": "Game Boy",
+		"XYZ":      "XYZ",
+	}
+	for s, want := range tests {
+		if got := Normalize(s); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	tests := map[string]string{
+		"snes":     "SFC",
+		"sfc":      "SFC",
+		"nes":      "FC",
+		"genesis":  "MD",
+		"Game Boy": "GB",
+		"unknown":  Unknown,
+		"xyz":      "",
+	}
+	for s, want := range tests {
+		if got := Suggest(s); got != want {
+			t.Errorf("Suggest(%q) = %q, want %q", s, got, want)
+		}
+	}
+
+	if err := Check("SFC"); err != nil {
+		t.Errorf("Check(SFC): %v", err)
+	}
+	if err := Check(Unknown); err != nil {
+		t.Errorf("Check(%s): %v", Unknown, err)
+	}
+	if err := Check("snes"); err == nil || !strings.Contains(err.Error(), "did you mean SFC?") {
+		t.Errorf("Check(snes) = %v, want a suggestion", err)
+	}
+	if err := Check("XYZ"); err == nil || !strings.Contains(err.Error(), "FC, ") {
+		t.Errorf("Check(XYZ) = %v, want the known codes", err)
+	}
+}
+
+func TestSave(t *testing.T) {
+	md, _ := Get("MD")
+	restore := Save()
+	Extend("A26", "Atari 2600", []string{"atari2600"}, []string{".a26"}, false)
+	Extend("MD", "", nil, []string{".sgd"}, true)
+	if FromFolder("atari2600") != "A26" || FromExtension(".sgd") != "MD" {
+		t.Fatal("Extend had no effect")
+	}
+
+	restore()
+	if _, ok := Get("A26"); ok || FromFolder("atari2600") != "" {
+		t.Error("A26 is still known")
+	}
+	if got, _ := Get("MD"); !slices.Equal(got.Extensions, md.Extensions) || !slices.Equal(got.Folders, md.Folders) {
+		t.Errorf("MD = %+v, want %+v", got, md)
+	}
+}
@@ -0,0 +1,166 @@
+package scanner
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/retronian/romu/internal/db"
+)
+
+// Hashes holds the CRC32/MD5/SHA1 of some ROM data as uppercase hex
+type Hashes struct {
+	CRC32 string
+	MD5   string
+	SHA1  string
+}
+
+// headerSize returns the length of the copier/emulator header at the start
+// of a ROM for platforms whose dumps come both with and without one, or 0.
+// peek holds the first bytes of the file and size its total length.
+func headerSize(platform string, peek []byte, size int64) int {
+	switch platform {
+	case "FC":
+		// iNES / NES 2.0
+		if bytes.HasPrefix(peek, []byte("NES\x1a")) {
+			return 16
+		}
+	case "SFC":
+		// SMC/SWC copier header: a 512-byte block on top of a 1K-aligned ROM
+		if size%1024 == 512 {
+			return 512
+		}
+	case "LYNX":
+		if bytes.HasPrefix(peek, []byte("LYNX")) {
+			return 64
+		}
+	}
+	return 0
+}
+
+// hashStream hashes r in one pass. If the platform's header is present, nohdr
+// holds the hashes of the data after it; otherwise nohdr is empty.
+func hashStream(r io.Reader, size int64, platform string) (full, nohdr Hashes, err error) {
+	br := bufio.NewReaderSize(r, 4096)
+	peek, _ := br.Peek(16)
+	skip := headerSize(platform, peek, size)
+
+	fullH := newHashSet()
+	writers := []io.Writer{fullH}
+	var nohdrH *hashSet
+	if skip > 0 {
+		nohdrH = newHashSet()
+		writers = append(writers, &skipWriter{w: nohdrH, skip: skip})
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), br); err != nil {
+		return Hashes{}, Hashes{}, err
+	}
+
+	full = fullH.sums()
+	if nohdrH != nil {
+		nohdr = nohdrH.sums()
+	}
+	return full, nohdr, nil
+}
+
+func hashFile(path, platform string) (full, nohdr Hashes, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Hashes{}, Hashes{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Hashes{}, Hashes{}, err
+	}
+	return hashStream(f, info.Size(), platform)
+}
+
+// HashPath re-hashes a stored rom_file path, which is either a file or a
+// ZIP entry ("archive.zip!inner"). The error wraps fs.ErrNotExist if the file
+// or entry is gone.
+func HashPath(path, platform string) (Hashes, error) {
+	zipPath, inner, ok := db.SplitArchivePath(path)
+	if !ok {
+		full, _, err := hashFile(path, platform)
+		return full, err
+	}
+
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return Hashes{}, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name == inner {
+			full, _, err := hashZipEntry(f, platform)
+			return full, err
+		}
+	}
+	return Hashes{}, fmt.Errorf("%s: entry %s: %w", zipPath, inner, fs.ErrNotExist)
+}
+
+func hashZipEntry(f *zip.File, platform string) (full, nohdr Hashes, err error) {
+	rc, err := f.Open()
+	if err != nil {
+		return Hashes{}, Hashes{}, err
+	}
+	defer rc.Close()
+
+	return hashStream(rc, int64(f.UncompressedSize64), platform)
+}
+
+// hashSet computes CRC32, MD5 and SHA1 together
+type hashSet struct {
+	crc, md5, sha1 hash.Hash
+	w              io.Writer
+}
+
+func newHashSet() *hashSet {
+	h := &hashSet{crc: crc32.NewIEEE(), md5: md5.New(), sha1: sha1.New()}
+	h.w = io.MultiWriter(h.crc, h.md5, h.sha1)
+	return h
+}
+
+func (h *hashSet) Write(p []byte) (int, error) {
+	return h.w.Write(p)
+}
+
+func (h *hashSet) sums() Hashes {
+	return Hashes{
+		CRC32: fmt.Sprintf("%08X", h.crc.(hash.Hash32).Sum32()),
+		MD5:   strings.ToUpper(hex.EncodeToString(h.md5.Sum(nil))),
+		SHA1:  strings.ToUpper(hex.EncodeToString(h.sha1.Sum(nil))),
+	}
+}
+
+// skipWriter discards the first skip bytes written to it
+type skipWriter struct {
+	w    io.Writer
+	skip int
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if s.skip >= n {
+		s.skip -= n
+		return n, nil
+	}
+	p = p[s.skip:]
+	s.skip = 0
+	if _, err := s.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -2,93 +2,110 @@ package scanner
 
 import (
 	"archive/zip"
-	"crypto/md5"
-	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
-	"hash/crc32"
-	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/retronian/romu/internal/config"
 	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/platform"
 )
 
-// Platform mapping: folder name -> platform
-var platformFolders = map[string]string{
-	"fc":              "FC",
-	"nes":             "FC",
-	"sfc":             "SFC",
-	"snes":            "SFC",
-	"gb":              "GB",
-	"gbc":             "GBC",
-	"gba":             "GBA",
-	"md":              "MD",
-	"genesis":         "MD",
-	"megadrive":       "MD",
-	"ps1":             "PS1",
-	"psx":             "PS1",
-	"n64":             "N64",
-	"nds":             "NDS",
-	"pce":             "PCE",
-	"pcengine":        "PCE",
-	"pcenginecd":      "PCE",
-	"msx":             "MSX",
-	"gg":              "GG",
-	"sms":             "SMS",
-	"ws":              "WS",
-	"wonderswan":      "WS",
-	"wsc":             "WSC",
-	"wonderswancolor": "WSC",
-	"ngp":             "NGP",
-	"pcfx":            "PCFX",
-	"neogeo":          "NEOGEO",
-	"pico8":           "PICO8",
-	"ps2":             "PS2",
-	"segasaturn":      "SS",
-	"arcade":          "ARCADE",
-}
+// UnknownPlatform is recorded for files outside any platform folder when
+// ScanOptions.KeepUnknown is set, until they are reassigned
+const UnknownPlatform = platform.Unknown
 
-var platformExtensions = map[string][]string{
-	"FC":     {".nes"},
-	"SFC":    {".sfc", ".smc"},
-	"GB":     {".gb"},
-	"GBC":    {".gbc"},
-	"GBA":    {".gba"},
-	"MD":     {".md", ".bin", ".gen"},
-	"PS1":    {".bin", ".cue", ".img", ".iso"},
-	"N64":    {".n64", ".z64", ".v64"},
-	"NDS":    {".nds"},
-	"PCE":    {".pce"},
-	"MSX":    {".rom"},
-	"GG":     {".gg"},
-	"SMS":    {".sms"},
-	"WS":     {".ws"},
-	"WSC":    {".wsc"},
-	"NGP":    {".ngp"},
-	"PCFX":   {".iso", ".bin", ".cue"},
-	"NEOGEO": {".zip"},
-	"PICO8":  {".p8", ".png"},
-	"PS2":    {".iso", ".bin", ".cue"},
-	"SS":     {".iso", ".bin", ".cue"},
-	"ARCADE": {".zip"},
+// zipIsROM reports whether a .zip on the platform is the ROM itself rather
+// than an archive to look inside
+func zipIsROM(code string) bool {
+	if code == UnknownPlatform {
+		return true
+	}
+	p, _ := platform.Get(code)
+	return p.ZipIsROM
 }
 
-// Platforms where .zip file itself IS the ROM (don't look inside)
-var zipIsRomPlatforms = map[string]bool{
-	"NEOGEO": true,
-	"ARCADE": true,
+// nonRomExtensions are never kept as UnknownPlatform ROMs
+var nonRomExtensions = map[string]bool{
+	".txt": true, ".nfo": true, ".diz": true, ".pdf": true, ".htm": true, ".html": true,
+	".xml": true, ".json": true, ".ini": true, ".cfg": true, ".dat": true, ".db": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true, ".webp": true,
+	".mp4": true, ".srm": true, ".sav": true, ".state": true, ".lnk": true, ".url": true,
 }
 
 type Result struct {
-	Scanned int
-	Added   int
-	Skipped int
-	Errors  int
+	Scanned   int `json:"scanned"`
+	Added     int `json:"added"`
+	Unchanged int `json:"unchanged"`
+	Moved     int `json:"moved"`
+	Skipped   int `json:"skipped"`
+	Errors    int `json:"errors"`
+}
+
+// ScanAction describes what happened to a file during a scan
+type ScanAction string
+
+const (
+	ActionAdded     ScanAction = "added"
+	ActionUnchanged ScanAction = "unchanged"
+	ActionMoved     ScanAction = "moved"
+	ActionSkipped   ScanAction = "skipped"
+	ActionError     ScanAction = "error"
+)
+
+// ScanEvent is reported to ScanOptions.Progress for every file the scanner visits
+type ScanEvent struct {
+	Action   ScanAction `json:"action"`
+	Platform string     `json:"platform,omitempty"`
+	Name     string     `json:"name"`
+	Path     string     `json:"path"`
+	CRC32    string     `json:"crc32,omitempty"`
+	OldPath  string     `json:"old_path,omitempty"` // set for ActionMoved
+	Error    string     `json:"error,omitempty"`    // set for ActionError
+	Reason   string     `json:"reason,omitempty"`   // set for ActionSkipped
+}
+
+// ScanOptions controls how Scan treats files already in the database.
+type ScanOptions struct {
+	// Rehash forces every file to be hashed again, even when its size and
+	// modification time match what was recorded by a previous scan.
+	Rehash bool
+	// DetectMoves relinks a rom_file whose path no longer exists on disk to a
+	// newly found file with the same SHA1, keeping its game link.
+	DetectMoves bool
+	// DetectContent identifies files outside any platform folder by their
+	// extension and header (iNES, SNES internal header, "SEGA" at 0x100,
+	// Game Boy logo). ZIP archives still need a platform folder.
+	DetectContent bool
+	// KeepUnknown records files the scanner can't assign a platform to as
+	// UnknownPlatform instead of skipping them, except hidden files and
+	// obvious non-ROMs (images, text, saves).
+	KeepUnknown bool
+	// Progress, if set, is called for every file visited. Errors and, at
+	// debug level, skips are also logged with log/slog.
+	Progress func(ScanEvent)
+}
+
+// Store is the part of *db.DB that scanning reads and writes
+type Store interface {
+	GetRomFileStates() (map[string]db.RomFileState, error)
+	FindRomFilesBySHA1(sha1 string) ([]db.RomFileLocation, error)
+	RelocateRomFile(oldID int64, newPath, newFilename string) error
+	UpsertRomFilesBatch(files []db.RomFileInput) error
+}
+
+func Scan(root string, database Store) (*Result, error) {
+	return ScanWithOptions(root, database, ScanOptions{})
 }
 
-func Scan(root string, database *db.DB) (*Result, error) {
+// ScanWithOptions scans root like Scan. Unless opts.Rehash is set, files whose
+// size and modification time are unchanged since the last scan are counted as
+// Unchanged and not hashed again.
+func ScanWithOptions(root string, database Store, opts ScanOptions) (*Result, error) {
 	root, err := filepath.Abs(root)
 	if err != nil {
 		return nil, err
@@ -102,11 +119,15 @@ func Scan(root string, database *db.DB) (*Result, error) {
 		return nil, fmt.Errorf("%s is not a directory", root)
 	}
 
-	result := &Result{}
+	known, err := database.GetRomFileStates()
+	if err != nil {
+		return nil, fmt.Errorf("load rom_files: %w", err)
+	}
+	s := &scan{database: database, opts: opts, known: known, result: &Result{}}
 
 	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			result.Errors++
+			s.fail(path, "", filepath.Base(path), err)
 			return nil
 		}
 		if info.IsDir() {
@@ -114,8 +135,14 @@ func Scan(root string, database *db.DB) (*Result, error) {
 		}
 
 		platform := detectPlatform(root, path)
+		if platform == "" && s.opts.DetectContent {
+			platform = detectPlatformFromContent(path)
+		}
+		if platform == "" && s.opts.KeepUnknown && keepUnknown(info.Name()) {
+			platform = UnknownPlatform
+		}
 		if platform == "" {
-			result.Skipped++
+			s.skip(path, "", "not in a platform folder")
 			return nil
 		}
 
@@ -123,32 +150,18 @@ func Scan(root string, database *db.DB) (*Result, error) {
 
 		// Handle ZIP files
 		if ext == ".zip" {
-			if zipIsRomPlatforms[platform] {
+			if zipIsROM(platform) {
 				// ZIP itself is the ROM — hash the zip file
 				if !isValidExtension(platform, ".zip") {
-					result.Skipped++
+					s.skip(path, platform, "extension not used by "+platform)
 					return nil
 				}
-				result.Scanned++
-				crc, md5h, sha1h, err := hashFile(path)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "hash error %s: %v\n", path, err)
-					result.Errors++
-					return nil
-				}
-				err = database.UpsertRomFile(path, filepath.Base(path), info.Size(), crc, md5h, sha1h, platform)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "db error %s: %v\n", path, err)
-					result.Errors++
-					return nil
-				}
-				result.Added++
-				fmt.Printf("  [%s] %s (CRC32: %s)\n", platform, filepath.Base(path), crc)
+				s.file(path, platform, info)
 			} else {
 				// Look inside ZIP for ROM files
-				scanned := scanZipContents(path, platform, info.Size(), database, result)
+				scanned := s.zipContents(path, platform, info)
 				if !scanned {
-					result.Skipped++
+					s.skip(path, platform, "no "+platform+" ROMs in archive")
 				}
 			}
 			return nil
@@ -156,45 +169,183 @@ func Scan(root string, database *db.DB) (*Result, error) {
 
 		// Regular file
 		if !isValidExtension(platform, ext) {
-			result.Skipped++
+			s.skip(path, platform, "extension not used by "+platform)
 			return nil
 		}
 
-		result.Scanned++
+		s.file(path, platform, info)
+		return nil
+	})
+	s.flush()
 
-		crc, md5h, sha1h, err := hashFile(path)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "hash error %s: %v\n", path, err)
-			result.Errors++
-			return nil
-		}
+	return s.result, err
+}
 
-		err = database.UpsertRomFile(path, filepath.Base(path), info.Size(), crc, md5h, sha1h, platform)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "db error %s: %v\n", path, err)
-			result.Errors++
-			return nil
+// Hashed files are written to the database in batches of up to batchSize,
+// or whenever flushInterval has passed since the batch was started so that
+// progress keeps flowing on slow, large files.
+const (
+	batchSize     = 500
+	flushInterval = 2 * time.Second
+)
+
+// scan holds the state shared by a single ScanWithOptions run
+type scan struct {
+	database Store
+	opts     ScanOptions
+	known    map[string]db.RomFileState
+	result   *Result
+
+	batch      []db.RomFileInput
+	events     []ScanEvent // reported once the matching batch entry is stored
+	batchStart time.Time
+}
+
+func (s *scan) emit(ev ScanEvent) {
+	if s.opts.Progress != nil {
+		s.opts.Progress(ev)
+	}
+}
+
+func (s *scan) skip(path, platform, reason string) {
+	s.result.Skipped++
+	slog.Debug("skipped", "path", path, "reason", reason)
+	s.emit(ScanEvent{Action: ActionSkipped, Platform: platform, Name: filepath.Base(path), Path: path, Reason: reason})
+}
+
+func (s *scan) fail(path, platform, name string, err error) {
+	s.result.Errors++
+	slog.Warn("scan error", "path", path, "platform", platform, "err", err)
+	s.emit(ScanEvent{Action: ActionError, Platform: platform, Name: name, Path: path, Error: err.Error()})
+}
+
+// unchanged reports whether path was recorded with the same size and modtime
+func (s *scan) unchanged(path string, size, modtime int64) bool {
+	if s.opts.Rehash {
+		return false
+	}
+	st, ok := s.known[path]
+	return ok && st.Size == size && st.ModTime == modtime
+}
+
+// relocate moves an existing rom_file with the same SHA1 whose file is gone
+// from disk to path. It returns the old path, or "" if nothing was relocated.
+func (s *scan) relocate(path, filename, sha1 string) (string, error) {
+	if !s.opts.DetectMoves {
+		return "", nil
+	}
+	if _, ok := s.known[path]; ok {
+		return "", nil
+	}
+	candidates, err := s.database.FindRomFilesBySHA1(sha1)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range candidates {
+		if fileExists(c.Path) {
+			continue
+		}
+		if err := s.database.RelocateRomFile(c.ID, path, filename); err != nil {
+			return "", err
 		}
+		delete(s.known, c.Path)
+		return c.Path, nil
+	}
+	return "", nil
+}
 
-		result.Added++
-		fmt.Printf("  [%s] %s (CRC32: %s)\n", platform, filepath.Base(path), crc)
-		return nil
+// record queues a hashed file for storage, relocating a moved rom_file first
+// if enabled. The outcome is reported when the batch is flushed.
+func (s *scan) record(path, name, platform string, size, modtime int64, full, nohdr Hashes) {
+	oldPath, err := s.relocate(path, name, full.SHA1)
+	if err != nil {
+		s.fail(path, platform, name, fmt.Errorf("db error: %w", err))
+		return
+	}
+
+	ev := ScanEvent{Action: ActionAdded, Platform: platform, Name: name, Path: path, CRC32: full.CRC32}
+	if oldPath != "" {
+		ev.Action = ActionMoved
+		ev.OldPath = oldPath
+	}
+	if len(s.batch) == 0 {
+		s.batchStart = time.Now()
+	}
+	s.batch = append(s.batch, db.RomFileInput{
+		Path: path, Filename: name, Size: size, ModTime: modtime,
+		CRC32: full.CRC32, MD5: full.MD5, SHA1: full.SHA1, Platform: platform,
+		CRC32NoHdr: nohdr.CRC32, MD5NoHdr: nohdr.MD5, SHA1NoHdr: nohdr.SHA1,
 	})
+	s.events = append(s.events, ev)
 
-	return result, err
+	if len(s.batch) >= batchSize || time.Since(s.batchStart) >= flushInterval {
+		s.flush()
+	}
 }
 
-// scanZipContents opens a ZIP and hashes ROM files inside it.
+// flush stores the pending batch. If it fails, every file in the batch is
+// counted as an error.
+func (s *scan) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+	start := time.Now()
+	err := s.database.UpsertRomFilesBatch(s.batch)
+	if err != nil {
+		slog.Error("storing scanned files failed", "count", len(s.batch), "err", err)
+	} else {
+		slog.Debug("stored scanned files", "count", len(s.batch), "elapsed", time.Since(start).Round(time.Millisecond))
+	}
+	for _, ev := range s.events {
+		switch {
+		case err != nil:
+			s.fail(ev.Path, ev.Platform, ev.Name, fmt.Errorf("db error: %w", err))
+			continue
+		case ev.Action == ActionMoved:
+			s.result.Moved++
+		default:
+			s.result.Added++
+		}
+		s.emit(ev)
+	}
+	s.batch = s.batch[:0]
+	s.events = s.events[:0]
+}
+
+// file hashes a single file on disk and records it, unless it is
+// unchanged since the last scan.
+func (s *scan) file(path, platform string, info os.FileInfo) {
+	s.result.Scanned++
+	name := filepath.Base(path)
+
+	modtime := info.ModTime().Unix()
+	if s.unchanged(path, info.Size(), modtime) {
+		s.result.Unchanged++
+		s.emit(ScanEvent{Action: ActionUnchanged, Platform: platform, Name: name, Path: path})
+		return
+	}
+
+	full, nohdr, err := hashFile(path, platform)
+	if err != nil {
+		s.fail(path, platform, name, fmt.Errorf("hash error: %w", err))
+		return
+	}
+
+	s.record(path, name, platform, info.Size(), modtime, full, nohdr)
+}
+
+// zipContents opens a ZIP and hashes ROM files inside it.
 // Returns true if at least one ROM file was found and processed.
-func scanZipContents(zipPath, platform string, zipSize int64, database *db.DB, result *Result) bool {
+// Inner entries inherit the modification time of the ZIP itself.
+func (s *scan) zipContents(zipPath, platform string, zipInfo os.FileInfo) bool {
 	r, err := zip.OpenReader(zipPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "zip open error %s: %v\n", zipPath, err)
-		result.Errors++
+		s.fail(zipPath, platform, filepath.Base(zipPath), fmt.Errorf("zip open error: %w", err))
 		return false
 	}
 	defer r.Close()
 
+	modtime := zipInfo.ModTime().Unix()
 	found := false
 	for _, f := range r.File {
 		if f.FileInfo().IsDir() {
@@ -206,65 +357,57 @@ func scanZipContents(zipPath, platform string, zipSize int64, database *db.DB, r
 		}
 
 		found = true
-		result.Scanned++
-
-		crc, md5h, sha1h, err := hashZipEntry(f)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "hash error %s!%s: %v\n", zipPath, f.Name, err)
-			result.Errors++
-			continue
-		}
+		s.result.Scanned++
 
 		// Store path as zipPath!innerName to make it unique per entry
 		entryPath := zipPath + "!" + f.Name
 		displayName := filepath.Base(zipPath) + "/" + f.Name
-		err = database.UpsertRomFile(entryPath, displayName, int64(f.UncompressedSize64), crc, md5h, sha1h, platform)
+		size := int64(f.UncompressedSize64)
+		if s.unchanged(entryPath, size, modtime) {
+			s.result.Unchanged++
+			s.emit(ScanEvent{Action: ActionUnchanged, Platform: platform, Name: displayName, Path: entryPath})
+			continue
+		}
+
+		full, nohdr, err := hashZipEntry(f, platform)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "db error %s!%s: %v\n", zipPath, f.Name, err)
-			result.Errors++
+			s.fail(entryPath, platform, displayName, fmt.Errorf("hash error: %w", err))
 			continue
 		}
 
-		result.Added++
-		fmt.Printf("  [%s] %s (CRC32: %s)\n", platform, displayName, crc)
+		s.record(entryPath, displayName, platform, size, modtime, full, nohdr)
 	}
 	return found
 }
 
-func hashZipEntry(f *zip.File) (string, string, string, error) {
-	rc, err := f.Open()
-	if err != nil {
-		return "", "", "", err
+// ApplyConfig merges the folder names and extensions of config.toml into
+// the platform tables. Call it once at startup, before scanning.
+func ApplyConfig(cfg *config.Config) {
+	codes := make([]string, 0, len(cfg.Platforms))
+	for code := range cfg.Platforms {
+		codes = append(codes, code)
 	}
-	defer rc.Close()
-
-	crcH := crc32.NewIEEE()
-	md5H := md5.New()
-	sha1H := sha1.New()
-
-	w := io.MultiWriter(crcH, md5H, sha1H)
-	if _, err := io.Copy(w, rc); err != nil {
-		return "", "", "", err
+	// Deterministic if two platforms claim the same folder
+	slices.Sort(codes)
+	for _, code := range codes {
+		p := cfg.Platforms[code]
+		platform.Extend(code, p.Name, p.Folders, p.Extensions, p.Replace)
 	}
+}
 
-	return fmt.Sprintf("%08X", crcH.Sum32()),
-		strings.ToUpper(hex.EncodeToString(md5H.Sum(nil))),
-		strings.ToUpper(hex.EncodeToString(sha1H.Sum(nil))),
-		nil
+// KnownPlatforms returns the platform codes the scanner can detect, sorted
+func KnownPlatforms() []string {
+	return platform.Scannable()
 }
 
 // DetectPlatformFromFolder returns the platform code for a folder name
 func DetectPlatformFromFolder(name string) string {
-	if p, ok := platformFolders[name]; ok {
-		return p
-	}
-	return ""
+	return platform.FromFolder(name)
 }
 
 func detectPlatform(root, path string) string {
 	// First check if root itself is a platform folder
-	rootBase := strings.ToLower(filepath.Base(root))
-	if p, ok := platformFolders[rootBase]; ok {
+	if p := platform.FromFolder(filepath.Base(root)); p != "" {
 		return p
 	}
 
@@ -275,45 +418,30 @@ func detectPlatform(root, path string) string {
 	parts := strings.Split(rel, string(filepath.Separator))
 	// Check each directory component from top
 	for _, part := range parts {
-		lower := strings.ToLower(part)
-		if p, ok := platformFolders[lower]; ok {
+		if p := platform.FromFolder(part); p != "" {
 			return p
 		}
 	}
 	return ""
 }
 
-func isValidExtension(platform, ext string) bool {
-	exts, ok := platformExtensions[platform]
-	if !ok {
-		return true // unknown platform, accept all
-	}
-	for _, e := range exts {
-		if ext == e {
-			return true
-		}
-	}
-	return false
+// fileExists reports whether the file behind a rom_file path is still on
+// disk. ZIP entry paths ("archive.zip!inner") are checked by their archive.
+func fileExists(path string) bool {
+	path, _, _ = db.SplitArchivePath(path)
+	_, err := os.Stat(path)
+	return err == nil
 }
 
-func hashFile(path string) (string, string, string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", "", "", err
-	}
-	defer f.Close()
-
-	crcH := crc32.NewIEEE()
-	md5H := md5.New()
-	sha1H := sha1.New()
+// keepUnknown reports whether a file with no platform may be a ROM
+func keepUnknown(name string) bool {
+	return !strings.HasPrefix(name, ".") && !nonRomExtensions[strings.ToLower(filepath.Ext(name))]
+}
 
-	w := io.MultiWriter(crcH, md5H, sha1H)
-	if _, err := io.Copy(w, f); err != nil {
-		return "", "", "", err
+func isValidExtension(code, ext string) bool {
+	p, ok := platform.Get(code)
+	if !ok {
+		return true // unknown platform, accept all
 	}
-
-	return fmt.Sprintf("%08X", crcH.Sum32()),
-		strings.ToUpper(hex.EncodeToString(md5H.Sum(nil))),
-		strings.ToUpper(hex.EncodeToString(sha1H.Sum(nil))),
-		nil
+	return slices.Contains(p.Extensions, ext)
 }
@@ -2,13 +2,31 @@ package scanner
 
 import (
 	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
+	"github.com/retronian/romu/internal/config"
 	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/platform"
 )
 
+func openTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.OpenMemory()
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
 func TestScan(t *testing.T) {
 	tmp := t.TempDir()
 	fcDir := filepath.Join(tmp, "fc")
@@ -22,12 +40,7 @@ func TestScan(t *testing.T) {
 	// Skip file with wrong extension
 	os.WriteFile(filepath.Join(fcDir, "readme.txt"), []byte("not a rom"), 0644)
 
-	os.Setenv("HOME", tmp)
-	database, err := db.Open()
-	if err != nil {
-		t.Fatalf("db open: %v", err)
-	}
-	defer database.Close()
+	database := openTestDB(t)
 
 	result, err := Scan(tmp, database)
 	if err != nil {
@@ -61,9 +74,7 @@ func TestScanZipContainingRom(t *testing.T) {
 	zw.Close()
 	zf.Close()
 
-	os.Setenv("HOME", tmp)
-	database, _ := db.Open()
-	defer database.Close()
+	database := openTestDB(t)
 
 	result, err := Scan(tmp, database)
 	if err != nil {
@@ -74,6 +85,49 @@ func TestScanZipContainingRom(t *testing.T) {
 	}
 }
 
+func TestScanMultiRomZip(t *testing.T) {
+	tmp := t.TempDir()
+	fcDir := filepath.Join(tmp, "fc")
+	os.MkdirAll(fcDir, 0755)
+
+	zipPath := filepath.Join(fcDir, "pack.zip")
+	zf, _ := os.Create(zipPath)
+	zw := zip.NewWriter(zf)
+	for _, name := range []string{"a.nes", "b.nes", "readme.txt"} {
+		fw, _ := zw.Create(name)
+		fw.Write([]byte("contents of " + name))
+	}
+	zw.Close()
+	zf.Close()
+
+	database := openTestDB(t)
+	result, err := Scan(tmp, database)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if result.Added != 2 {
+		t.Errorf("expected 2 added, got %d", result.Added)
+	}
+
+	// Each entry has a row of its own, keyed by archive!entry
+	files, _ := database.ListRomFiles()
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files in db, got %+v", files)
+	}
+	for i, name := range []string{"a.nes", "b.nes"} {
+		if files[i].Path != zipPath+"!"+name || files[i].Filename != "pack.zip/"+name {
+			t.Errorf("unexpected entry %d: %s as %s", i, files[i].Path, files[i].Filename)
+		}
+	}
+	if files[0].HashCRC32 == files[1].HashCRC32 {
+		t.Error("expected each entry hashed separately")
+	}
+
+	if result, _ := Scan(tmp, database); result.Unchanged != 2 || result.Added != 0 {
+		t.Errorf("expected both entries unchanged on rescan, got %+v", result)
+	}
+}
+
 func TestScanZipIsRom(t *testing.T) {
 	tmp := t.TempDir()
 	neogeoDir := filepath.Join(tmp, "neogeo")
@@ -88,9 +142,7 @@ func TestScanZipIsRom(t *testing.T) {
 	zw.Close()
 	zf.Close()
 
-	os.Setenv("HOME", tmp)
-	database, _ := db.Open()
-	defer database.Close()
+	database := openTestDB(t)
 
 	result, err := Scan(tmp, database)
 	if err != nil {
@@ -108,9 +160,7 @@ func TestScanSubfolderRoms(t *testing.T) {
 	os.MkdirAll(gbDir, 0755)
 	os.WriteFile(filepath.Join(gbDir, "test.gb"), []byte("fake GB ROM data"), 0644)
 
-	os.Setenv("HOME", tmp)
-	database, _ := db.Open()
-	defer database.Close()
+	database := openTestDB(t)
 
 	result, err := Scan(tmp, database)
 	if err != nil {
@@ -146,3 +196,379 @@ func TestDetectPlatform(t *testing.T) {
 		}
 	}
 }
+
+func TestScanSkipsUnchanged(t *testing.T) {
+	tmp := t.TempDir()
+	fcDir := filepath.Join(tmp, "fc")
+	os.MkdirAll(fcDir, 0755)
+	os.WriteFile(filepath.Join(fcDir, "test.nes"), []byte("fake NES ROM data"), 0644)
+
+	database := openTestDB(t)
+
+	if _, err := Scan(tmp, database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	result, err := Scan(tmp, database)
+	if err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if result.Added != 0 || result.Unchanged != 1 {
+		t.Errorf("expected 0 added and 1 unchanged, got %d added and %d unchanged", result.Added, result.Unchanged)
+	}
+
+	result, err = ScanWithOptions(tmp, database, ScanOptions{Rehash: true})
+	if err != nil {
+		t.Fatalf("rehash: %v", err)
+	}
+	if result.Added != 1 || result.Unchanged != 0 {
+		t.Errorf("expected 1 added with rehash, got %d added and %d unchanged", result.Added, result.Unchanged)
+	}
+}
+
+func TestScanDetectMoves(t *testing.T) {
+	tmp := t.TempDir()
+	fcDir := filepath.Join(tmp, "fc")
+	os.MkdirAll(fcDir, 0755)
+	oldPath := filepath.Join(fcDir, "old.nes")
+	os.WriteFile(oldPath, []byte("fake NES ROM data"), 0644)
+
+	database := openTestDB(t)
+
+	if _, err := Scan(tmp, database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	before, _ := database.ListRomFiles()
+	if len(before) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(before))
+	}
+
+	newPath := filepath.Join(fcDir, "new.nes")
+	os.Rename(oldPath, newPath)
+
+	result, err := ScanWithOptions(tmp, database, ScanOptions{DetectMoves: true})
+	if err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if result.Moved != 1 || result.Added != 0 {
+		t.Errorf("expected 1 moved and 0 added, got %d moved and %d added", result.Moved, result.Added)
+	}
+
+	after, _ := database.ListRomFiles()
+	if len(after) != 1 {
+		t.Fatalf("expected 1 file after move, got %d", len(after))
+	}
+	if after[0].ID != before[0].ID || after[0].Path != newPath {
+		t.Errorf("expected row %d at %s, got row %d at %s", before[0].ID, newPath, after[0].ID, after[0].Path)
+	}
+}
+
+func TestScanDetectMovesBang(t *testing.T) {
+	// "!" is common in No-Intro names and only separates ZIP entries after
+	// the archive's extension
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "fc", "Hits!")
+	os.MkdirAll(dir, 0755)
+	data := []byte("fake NES ROM data")
+	romPath := filepath.Join(dir, "Punch-Out!! (USA).nes")
+	os.WriteFile(romPath, data, 0644)
+	zipPath := filepath.Join(dir, "Yes! (Japan).zip")
+	zf, _ := os.Create(zipPath)
+	zw := zip.NewWriter(zf)
+	fw, _ := zw.Create("Yes! (Japan).nes")
+	fw.Write([]byte("other NES ROM data"))
+	zw.Close()
+	zf.Close()
+
+	for _, path := range []string{romPath, zipPath + "!Yes! (Japan).nes"} {
+		if !fileExists(path) {
+			t.Errorf("fileExists(%q) = false", path)
+		}
+	}
+
+	database := openTestDB(t)
+	if _, err := Scan(tmp, database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	// A copy is a new file, not the existing ones moved
+	copyPath := filepath.Join(tmp, "fc", "copy.nes")
+	os.WriteFile(copyPath, data, 0644)
+	result, err := ScanWithOptions(tmp, database, ScanOptions{DetectMoves: true})
+	if err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if result.Moved != 0 || result.Added != 1 {
+		t.Errorf("expected 0 moved and 1 added, got %d moved and %d added", result.Moved, result.Added)
+	}
+	files, _ := database.ListRomFiles()
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	slices.Sort(paths)
+	want := []string{copyPath, zipPath + "!Yes! (Japan).nes", romPath}
+	slices.Sort(want)
+	if !slices.Equal(paths, want) {
+		t.Errorf("paths %q, want %q", paths, want)
+	}
+}
+
+func TestScanProgress(t *testing.T) {
+	tmp := t.TempDir()
+	romsDir := filepath.Join(tmp, "roms")
+	fcDir := filepath.Join(romsDir, "fc")
+	os.MkdirAll(fcDir, 0755)
+	os.WriteFile(filepath.Join(fcDir, "test.nes"), []byte("fake NES ROM data"), 0644)
+	os.WriteFile(filepath.Join(fcDir, "readme.txt"), []byte("not a rom"), 0644)
+
+	database := openTestDB(t)
+
+	counts := map[ScanAction]int{}
+	var added, skipped ScanEvent
+	opts := ScanOptions{Progress: func(ev ScanEvent) {
+		counts[ev.Action]++
+		switch ev.Action {
+		case ActionAdded:
+			added = ev
+		case ActionSkipped:
+			skipped = ev
+		}
+	}}
+	if _, err := ScanWithOptions(romsDir, database, opts); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	if counts[ActionAdded] != 1 || counts[ActionSkipped] != 1 {
+		t.Errorf("expected 1 added and 1 skipped event, got %v", counts)
+	}
+	if added.Platform != "FC" || added.Name != "test.nes" || added.CRC32 == "" {
+		t.Errorf("unexpected added event: %+v", added)
+	}
+	if skipped.Name != "readme.txt" || skipped.Reason != "extension not used by FC" {
+		t.Errorf("unexpected skipped event: %+v", skipped)
+	}
+}
+
+func TestScanHeaderlessMatch(t *testing.T) {
+	tmp := t.TempDir()
+	fcDir := filepath.Join(tmp, "roms", "fc")
+	os.MkdirAll(fcDir, 0755)
+
+	data := []byte("fake NES PRG/CHR data")
+	header := append([]byte("NES\x1a"), make([]byte, 12)...)
+	os.WriteFile(filepath.Join(fcDir, "plain.nes"), data, 0644)
+	os.WriteFile(filepath.Join(fcDir, "headered.nes"), append(header, data...), 0644)
+
+	database := openTestDB(t)
+
+	if _, err := Scan(filepath.Join(tmp, "roms"), database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	full, _, err := hashStream(bytes.NewReader(data), int64(len(data)), "FC")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	matched, err := database.MatchROMs([]db.DATRom{{GameTitle: "Test Game", Platform: "FC", SHA1: full.SHA1}})
+	if err != nil {
+		t.Fatalf("match: %v", err)
+	}
+	if matched != 2 {
+		t.Errorf("expected headered and headerless ROMs to match, got %d", matched)
+	}
+}
+
+func TestGameListMatchesNestedZipEntry(t *testing.T) {
+	tmp := t.TempDir()
+	fcDir := filepath.Join(tmp, "roms", "fc")
+	os.MkdirAll(fcDir, 0755)
+
+	zf, _ := os.Create(filepath.Join(fcDir, "pack.zip"))
+	zw := zip.NewWriter(zf)
+	fw, _ := zw.Create("sub/game.nes")
+	fw.Write([]byte("fake NES ROM in a ZIP subfolder"))
+	zw.Close()
+	zf.Close()
+
+	database := openTestDB(t)
+
+	if _, err := Scan(filepath.Join(tmp, "roms"), database); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	// gamelist.xml paths like "./game.nes" are reduced to their basename
+	_, matched, err := database.MatchByGameList([]db.GameListEntry{{Filename: "./game.nes", Name: "ゲーム"}}, "FC")
+	if err != nil {
+		t.Fatalf("match: %v", err)
+	}
+	if matched != 1 {
+		t.Errorf("expected nested zip entry to match, got %d", matched)
+	}
+}
+
+func TestHashPath(t *testing.T) {
+	tmp := t.TempDir()
+	data := []byte("fake NES ROM")
+	want := fmt.Sprintf("%08X", crc32.ChecksumIEEE(data))
+
+	romPath := filepath.Join(tmp, "game.nes")
+	os.WriteFile(romPath, data, 0644)
+	zipPath := filepath.Join(tmp, "game.zip")
+	zf, _ := os.Create(zipPath)
+	zw := zip.NewWriter(zf)
+	fw, _ := zw.Create("inner/game.nes")
+	fw.Write(data)
+	zw.Close()
+	zf.Close()
+
+	// "!" in a name only separates a ZIP entry after the archive's extension
+	bangPath := filepath.Join(tmp, "Punch-Out!! (USA).nes")
+	os.WriteFile(bangPath, data, 0644)
+
+	for _, path := range []string{romPath, zipPath + "!inner/game.nes", bangPath} {
+		h, err := HashPath(path, "FC")
+		if err != nil || h.CRC32 != want {
+			t.Errorf("HashPath(%s) = %+v (%v), want CRC32 %s", path, h, err, want)
+		}
+	}
+	for _, path := range []string{filepath.Join(tmp, "gone.nes"), zipPath + "!gone.nes", filepath.Join(tmp, "gone.zip") + "!game.nes"} {
+		if _, err := HashPath(path, "FC"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("HashPath(%s): expected fs.ErrNotExist, got %v", path, err)
+		}
+	}
+}
+
+func TestSniffPlatform(t *testing.T) {
+	gb := make([]byte, 0x150)
+	copy(gb[0x104:], nintendoLogo)
+	gbc := append([]byte(nil), gb...)
+	gbc[0x143] = 0xC0
+	md := make([]byte, 0x200)
+	copy(md[0x100:], "SEGA MEGA DRIVE ")
+	sfc := make([]byte, 0x8000)
+	sfc[0x7FD5] = 0x20                    // LoROM map mode
+	sfc[0x7FDC], sfc[0x7FDD] = 0x34, 0x12 // checksum complement
+	sfc[0x7FDE], sfc[0x7FDF] = 0xCB, 0xED // checksum
+
+	tests := []struct {
+		ext  string
+		head []byte
+		want string
+	}{
+		{".nes", []byte("NES\x1a\x02\x01"), "FC"},
+		{".nes", []byte("not a rom"), ""},
+		{".gb", gb, "GB"},
+		{".gb", gbc, "GBC"},
+		{".gbc", gb, "GBC"},
+		{".gb", make([]byte, 0x150), ""},
+		{".bin", md, "MD"},
+		{".bin", make([]byte, 0x200), ""},
+		{".sfc", sfc, "SFC"},
+		{".sfc", make([]byte, 0x8000), ""},
+	}
+	for _, tt := range tests {
+		if got := sniffPlatform(tt.ext, tt.head, int64(len(tt.head))); got != tt.want {
+			t.Errorf("sniffPlatform(%s, %q...) = %q, want %q", tt.ext, tt.head[:4], got, tt.want)
+		}
+	}
+}
+
+func TestScanDetectContent(t *testing.T) {
+	tmp := t.TempDir()
+	flat := filepath.Join(tmp, "downloads")
+	os.MkdirAll(flat, 0755)
+	os.WriteFile(filepath.Join(flat, "game.nes"), []byte("NES\x1a fake"), 0644)
+	os.WriteFile(filepath.Join(flat, "other.nes"), []byte("no header"), 0644)
+
+	database := openTestDB(t)
+
+	result, err := ScanWithOptions(flat, database, ScanOptions{})
+	if err != nil || result.Added != 0 || result.Skipped != 2 {
+		t.Fatalf("expected both skipped without content detection, got %+v (%v)", result, err)
+	}
+	result, err = ScanWithOptions(flat, database, ScanOptions{DetectContent: true})
+	if err != nil || result.Added != 1 || result.Skipped != 1 {
+		t.Fatalf("expected game.nes added, got %+v (%v)", result, err)
+	}
+	files, _ := database.ListRomFiles()
+	if len(files) != 1 || files[0].Filename != "game.nes" || files[0].Platform != "FC" {
+		t.Errorf("unexpected rom_files: %+v", files)
+	}
+}
+
+func TestScanKeepUnknown(t *testing.T) {
+	tmp := t.TempDir()
+	misc := filepath.Join(tmp, "misc")
+	os.MkdirAll(misc, 0755)
+	os.WriteFile(filepath.Join(misc, "game.rom"), []byte("rom"), 0644)
+	os.WriteFile(filepath.Join(misc, "readme.txt"), []byte("text"), 0644)
+	os.WriteFile(filepath.Join(misc, ".DS_Store"), []byte("junk"), 0644)
+
+	database := openTestDB(t)
+
+	result, err := ScanWithOptions(misc, database, ScanOptions{KeepUnknown: true})
+	if err != nil || result.Added != 1 || result.Skipped != 2 {
+		t.Fatalf("expected game.rom kept and 2 skipped, got %+v (%v)", result, err)
+	}
+	files, _ := database.ListRomFiles()
+	if len(files) != 1 || files[0].Platform != UnknownPlatform {
+		t.Fatalf("unexpected rom_files: %+v", files)
+	}
+
+	if err := database.ReassignPlatform(files[0].ID, "MSX"); err != nil {
+		t.Fatalf("reassign: %v", err)
+	}
+	if files, _ := database.ListRomFilesByPlatform("MSX"); len(files) != 1 {
+		t.Errorf("expected the ROM under MSX after reassigning, got %+v", files)
+	}
+
+	// A rescan that rehashes the file keeps the platform set by hand
+	if _, err := ScanWithOptions(misc, database, ScanOptions{KeepUnknown: true, Rehash: true}); err != nil {
+		t.Fatalf("rescan: %v", err)
+	}
+	if files, _ := database.ListRomFilesByPlatform("MSX"); len(files) != 1 {
+		t.Errorf("expected the ROM still under MSX after rescanning, got %+v", files)
+	}
+}
+
+func TestScanConfigPlatform(t *testing.T) {
+	tmp := t.TempDir()
+	cfgPath := filepath.Join(tmp, "config.toml")
+	os.WriteFile(cfgPath, []byte(`
+[platforms.A26]
+folders = ["atari2600"]
+extensions = [".a26"]
+
+[platforms.MD]
+extensions = [".sgd"]
+`), 0644)
+	cfg, err := config.LoadFile(cfgPath)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	t.Cleanup(platform.Save())
+	ApplyConfig(cfg)
+
+	roms := filepath.Join(tmp, "roms")
+	for _, p := range []string{"atari2600/Pitfall! (USA).a26", "md/Sonic (World).sgd", "md/Sonic (World).md"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(roms, p)), 0755)
+		os.WriteFile(filepath.Join(roms, p), []byte(p), 0644)
+	}
+
+	database := openTestDB(t)
+	result, err := Scan(roms, database)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if result.Added != 3 {
+		t.Errorf("expected 3 added, got %+v", result)
+	}
+	files, _ := database.ListRomFilesByPlatform("A26")
+	if len(files) != 1 || files[0].Filename != "Pitfall! (USA).a26" {
+		t.Errorf("expected the .a26 file under A26, got %+v", files)
+	}
+	if !slices.Contains(KnownPlatforms(), "A26") {
+		t.Errorf("expected A26 among known platforms, got %v", KnownPlatforms())
+	}
+}
@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nintendoLogo is the bitmap every Game Boy cartridge carries at 0x104; the
+// boot ROM refuses to start without it
+var nintendoLogo = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// detectPlatformFromContent guesses a file's platform from its extension and
+// header when its folders don't say, or returns "".
+func detectPlatformFromContent(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".nes", ".sfc", ".smc", ".md", ".gen", ".bin", ".gb", ".gbc":
+	default:
+		return ""
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	// Enough for a HiROM SNES header behind a copier header
+	head := make([]byte, 0x10200)
+	n, _ := io.ReadFull(f, head)
+	return sniffPlatform(ext, head[:n], info.Size())
+}
+
+// sniffPlatform identifies a ROM from the start of its data (head) and its
+// total size
+func sniffPlatform(ext string, head []byte, size int64) string {
+	switch ext {
+	case ".nes":
+		if bytes.HasPrefix(head, []byte("NES\x1a")) {
+			return "FC"
+		}
+	case ".sfc", ".smc":
+		if isSNES(head, size) {
+			return "SFC"
+		}
+	case ".md", ".gen", ".bin":
+		// "SEGA MEGA DRIVE", "SEGA GENESIS" or similar; PS1 images have no
+		// such header, so .bin is only claimed when it's there
+		if len(head) >= 0x104 && bytes.Equal(head[0x100:0x104], []byte("SEGA")) {
+			return "MD"
+		}
+	case ".gb", ".gbc":
+		if len(head) > 0x143 && bytes.Equal(head[0x104:0x134], nintendoLogo) {
+			// 0xC0 marks Game Boy Color-only cartridges
+			if ext == ".gbc" || head[0x143] == 0xC0 {
+				return "GBC"
+			}
+			return "GB"
+		}
+	}
+	return ""
+}
+
+// isSNES looks for a LoROM (0x7FC0) or HiROM (0xFFC0) internal header whose
+// checksum and checksum complement add up to 0xFFFF
+func isSNES(head []byte, size int64) bool {
+	base := 0
+	if size%1024 == 512 {
+		base = 512 // copier header
+	}
+	for _, off := range []int{0x7FC0, 0xFFC0} {
+		h := base + off
+		if len(head) < h+0x20 {
+			continue
+		}
+		complement := binary.LittleEndian.Uint16(head[h+0x1C:])
+		checksum := binary.LittleEndian.Uint16(head[h+0x1E:])
+		if complement+checksum == 0xFFFF && head[h+0x15]&0xE0 == 0x20 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,341 @@
+// Package screenscraper looks up game metadata on screenscraper.fr for games
+// missing from the embedded gamedb.
+package screenscraper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/retronian/romu/internal/enrich"
+	"github.com/retronian/romu/internal/gamedb"
+)
+
+const defaultBaseURL = "https://api.screenscraper.fr/api2"
+
+// SystemIDs maps platform codes to ScreenScraper system ids
+var SystemIDs = map[string]int{
+	"FC":     3,
+	"SFC":    4,
+	"GB":     9,
+	"GBC":    10,
+	"GBA":    12,
+	"MD":     1,
+	"PS1":    57,
+	"PS2":    58,
+	"N64":    14,
+	"NDS":    15,
+	"PCE":    31,
+	"MSX":    113,
+	"GG":     21,
+	"SMS":    2,
+	"WS":     45,
+	"WSC":    46,
+	"NGP":    25,
+	"LYNX":   28,
+	"PCFX":   72,
+	"NEOGEO": 142,
+	"SS":     22,
+	"DC":     23,
+	"ARCADE": 75,
+}
+
+var (
+	_ enrich.Enricher     = (*Client)(nil)
+	_ enrich.HashLookuper = (*Client)(nil)
+)
+
+// ErrQuotaExceeded is returned once the account's daily request quota is used up
+var ErrQuotaExceeded = errors.New("screenscraper: daily quota exceeded")
+
+// Client queries the ScreenScraper API. Responses, including "not found",
+// are cached in CacheDir, and requests are spaced at least MinInterval apart.
+type Client struct {
+	BaseURL     string
+	DevID       string
+	DevPassword string
+	User        string // optional; registered users get higher limits
+	Password    string
+	CacheDir    string
+	MinInterval time.Duration
+
+	http      *http.Client
+	mu        sync.Mutex
+	last      time.Time
+	exhausted bool // quota used up; no more requests this run
+}
+
+// NewFromEnv returns a client using SCREENSCRAPER_DEVID and
+// SCREENSCRAPER_DEVPASSWORD, plus SCREENSCRAPER_USER and
+// SCREENSCRAPER_PASSWORD if set. Responses are cached in
+// ~/.romu/cache/screenscraper.
+func NewFromEnv() (*Client, error) {
+	c := &Client{
+		BaseURL:     defaultBaseURL,
+		DevID:       os.Getenv("SCREENSCRAPER_DEVID"),
+		DevPassword: os.Getenv("SCREENSCRAPER_DEVPASSWORD"),
+		User:        os.Getenv("SCREENSCRAPER_USER"),
+		Password:    os.Getenv("SCREENSCRAPER_PASSWORD"),
+		MinInterval: 1200 * time.Millisecond,
+		http:        &http.Client{Timeout: 30 * time.Second},
+	}
+	if c.DevID == "" || c.DevPassword == "" {
+		return nil, errors.New("screenscraper: SCREENSCRAPER_DEVID and SCREENSCRAPER_DEVPASSWORD must be set")
+	}
+	home, _ := os.UserHomeDir()
+	c.CacheDir = filepath.Join(home, ".romu", "cache", "screenscraper")
+	return c, nil
+}
+
+// LookupByHash identifies a ROM by its hashes; any of them may be empty.
+// Returns nil if ScreenScraper doesn't know the ROM.
+func (c *Client) LookupByHash(crc, md5, sha1, platform string) (*gamedb.GameEntry, error) {
+	params := url.Values{}
+	if id, ok := SystemIDs[platform]; ok {
+		params.Set("systemeid", fmt.Sprint(id))
+	}
+	params.Set("romtype", "rom")
+	if crc != "" {
+		params.Set("crc", crc)
+	}
+	if md5 != "" {
+		params.Set("md5", md5)
+	}
+	if sha1 != "" {
+		params.Set("sha1", sha1)
+	}
+
+	var resp struct {
+		Response struct {
+			Jeu *jeu `json:"jeu"`
+		} `json:"response"`
+	}
+	found, err := c.get("jeuInfos.php", params, &resp)
+	if err != nil || !found || resp.Response.Jeu == nil {
+		return nil, err
+	}
+	return resp.Response.Jeu.entry(), nil
+}
+
+// Name identifies the source in `romu enrich --source`
+func (c *Client) Name() string { return "screenscraper" }
+
+// Lookup is LookupByName, for use as an enrich.Enricher
+func (c *Client) Lookup(platform, title string) (*gamedb.GameEntry, error) {
+	return c.LookupByName(platform, title)
+}
+
+// LookupByName searches for a title on the platform and returns the best
+// match, or nil if there is none.
+func (c *Client) LookupByName(platform, title string) (*gamedb.GameEntry, error) {
+	params := url.Values{}
+	if id, ok := SystemIDs[platform]; ok {
+		params.Set("systemeid", fmt.Sprint(id))
+	}
+	params.Set("recherche", title)
+
+	var resp struct {
+		Response struct {
+			Jeux []jeu `json:"jeux"`
+		} `json:"response"`
+	}
+	found, err := c.get("jeuRecherche.php", params, &resp)
+	if err != nil || !found || len(resp.Response.Jeux) == 0 {
+		return nil, err
+	}
+	// An empty search result is returned as a single object without an id
+	if resp.Response.Jeux[0].ID == "" {
+		return nil, nil
+	}
+	return resp.Response.Jeux[0].entry(), nil
+}
+
+// get fetches endpoint with params into v, from the cache if possible.
+// found is false if ScreenScraper has no such game.
+func (c *Client) get(endpoint string, params url.Values, v interface{}) (found bool, err error) {
+	key := cacheKey(endpoint, params)
+	if data, err := os.ReadFile(filepath.Join(c.CacheDir, key)); err == nil {
+		if len(data) == 0 {
+			return false, nil
+		}
+		return true, json.Unmarshal(data, v)
+	}
+
+	if c.exhausted {
+		return false, ErrQuotaExceeded
+	}
+
+	q := url.Values{}
+	for k, vs := range params {
+		q[k] = vs
+	}
+	q.Set("devid", c.DevID)
+	q.Set("devpassword", c.DevPassword)
+	q.Set("softname", "romu")
+	q.Set("output", "json")
+	if c.User != "" {
+		q.Set("ssid", c.User)
+		q.Set("sspassword", c.Password)
+	}
+	reqURL := strings.TrimSuffix(c.BaseURL, "/") + "/" + endpoint + "?" + q.Encode()
+
+	var data []byte
+	for attempt := 0; ; attempt++ {
+		status, body, err := c.fetch(reqURL)
+		if err != nil {
+			return false, fmt.Errorf("screenscraper: %s: %w", endpoint, err)
+		}
+		switch {
+		case status == http.StatusOK:
+			data = body
+		case status == http.StatusNotFound:
+			data = []byte{}
+		case status == 430:
+			c.exhausted = true
+			return false, ErrQuotaExceeded
+		case status == http.StatusTooManyRequests && attempt < 3:
+			// Too many concurrent requests; back off and retry
+			time.Sleep(time.Duration(attempt+1) * 5 * time.Second)
+			continue
+		default:
+			return false, fmt.Errorf("screenscraper: %s: HTTP %d", endpoint, status)
+		}
+		break
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			return false, fmt.Errorf("screenscraper: %s: %w", endpoint, err)
+		}
+	}
+	if err := os.MkdirAll(c.CacheDir, 0755); err == nil {
+		os.WriteFile(filepath.Join(c.CacheDir, key), data, 0644)
+	}
+	return len(data) > 0, nil
+}
+
+// fetch performs one rate-limited GET. Its errors leave out reqURL, whose
+// query holds the passwords.
+func (c *Client) fetch(reqURL string) (int, []byte, error) {
+	c.mu.Lock()
+	if wait := c.MinInterval - time.Since(c.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	c.last = time.Now()
+	c.mu.Unlock()
+
+	client := c.http
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(reqURL)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+func cacheKey(endpoint string, params url.Values) string {
+	sum := sha1.Sum([]byte(endpoint + "?" + params.Encode()))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+// jeu is the subset of a ScreenScraper game record romu uses
+type jeu struct {
+	ID       string     `json:"id"`
+	Noms     []textItem `json:"noms"`
+	Synopsis []struct {
+		Langue string `json:"langue"`
+		Text   string `json:"text"`
+	} `json:"synopsis"`
+	Developpeur textItem   `json:"developpeur"`
+	Editeur     textItem   `json:"editeur"`
+	Joueurs     textItem   `json:"joueurs"`
+	Note        textItem   `json:"note"` // rating out of 20
+	Dates       []textItem `json:"dates"`
+	Genres      []struct {
+		Noms []struct {
+			Langue string `json:"langue"`
+			Text   string `json:"text"`
+		} `json:"noms"`
+	} `json:"genres"`
+}
+
+type textItem struct {
+	Region string `json:"region"`
+	Text   string `json:"text"`
+}
+
+// entry converts j to a gamedb entry, preferring Japanese titles and
+// descriptions and English genre names as the embedded data does.
+func (j *jeu) entry() *gamedb.GameEntry {
+	e := &gamedb.GameEntry{
+		Developer: j.Developpeur.Text,
+		Publisher: j.Editeur.Text,
+		Players:   j.Joueurs.Text,
+	}
+	if n, err := strconv.ParseFloat(j.Note.Text, 64); err == nil {
+		e.Rating = strconv.FormatFloat(n/20, 'f', -1, 64)
+	}
+	for _, n := range j.Noms {
+		if n.Region == "jp" {
+			e.TitleJA = n.Text
+			break
+		}
+	}
+	for _, s := range j.Synopsis {
+		if s.Langue == "ja" {
+			e.DescJA = s.Text
+			break
+		}
+	}
+	if len(j.Genres) > 0 {
+		for _, n := range j.Genres[0].Noms {
+			if n.Langue == "en" {
+				e.Genre = n.Text
+				break
+			}
+		}
+	}
+	// Prefer the Japanese release date, then the earliest listed
+	for _, d := range j.Dates {
+		if d.Region == "jp" {
+			e.ReleaseDate = gamelistDate(d.Text)
+			break
+		}
+	}
+	if e.ReleaseDate == "" && len(j.Dates) > 0 {
+		e.ReleaseDate = gamelistDate(j.Dates[0].Text)
+	}
+	return e
+}
+
+// gamelistDate converts "YYYY-MM-DD" (or "YYYY-MM", "YYYY") to the
+// YYYYMMDDT000000 form used by gamelist.xml and the embedded gamedb
+func gamelistDate(s string) string {
+	parts := strings.Split(s, "-")
+	if len(parts[0]) != 4 {
+		return ""
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "01")
+	}
+	return parts[0] + parts[1] + parts[2] + "T000000"
+}
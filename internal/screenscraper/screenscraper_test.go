@@ -0,0 +1,100 @@
+package screenscraper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const jeuInfosResponse = `{"response":{"jeu":{
+	"id":"1",
+	"noms":[{"region":"wor","text":"Super Mario Bros."},{"region":"jp","text":"スーパーマリオブラザーズ"}],
+	"synopsis":[{"langue":"en","text":"Save the princess."},{"langue":"ja","text":"姫を救え。"}],
+	"developpeur":{"id":"1","text":"Nintendo"},
+	"editeur":{"id":"1","text":"Nintendo"},
+	"joueurs":{"text":"1-2"},
+	"note":{"text":"17"},
+	"dates":[{"region":"us","text":"1985-10-18"},{"region":"jp","text":"1985-09-13"}],
+	"genres":[{"id":"1","noms":[{"langue":"fr","text":"Plateforme"},{"langue":"en","text":"Platform"}]}]
+}}}`
+
+func testClient(t *testing.T, handler http.HandlerFunc) (*Client, *int) {
+	t.Helper()
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("devid") != "dev" {
+			t.Errorf("missing credentials in %s", r.URL)
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return &Client{BaseURL: srv.URL, DevID: "dev", DevPassword: "pw", CacheDir: t.TempDir()}, &requests
+}
+
+func TestLookupByHash(t *testing.T) {
+	c, requests := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("crc") == "3337EC46" && r.URL.Query().Get("systemeid") == "3" {
+			w.Write([]byte(jeuInfosResponse))
+			return
+		}
+		http.NotFound(w, r)
+	})
+
+	e, err := c.LookupByHash("3337EC46", "", "", "FC")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if e == nil || e.TitleJA != "スーパーマリオブラザーズ" || e.DescJA != "姫を救え。" ||
+		e.ReleaseDate != "19850913T000000" || e.Genre != "Platform" || e.Players != "1-2" || e.Developer != "Nintendo" || e.Rating != "0.85" {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+
+	if e, err := c.LookupByHash("00000000", "", "", "FC"); e != nil || err != nil {
+		t.Errorf("expected not found, got %+v (%v)", e, err)
+	}
+
+	// Both answers come from the cache the second time
+	c.LookupByHash("3337EC46", "", "", "FC")
+	c.LookupByHash("00000000", "", "", "FC")
+	if *requests != 2 {
+		t.Errorf("expected 2 requests, got %d", *requests)
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	c, _ := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(430)
+	})
+	if _, err := c.LookupByName("FC", "Tetris"); err != ErrQuotaExceeded {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestFetchErrorHidesPasswords(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	c := &Client{BaseURL: srv.URL, DevID: "dev", DevPassword: "devsecret", User: "me", Password: "mysecret", CacheDir: t.TempDir()}
+	_, err := c.LookupByName("FC", "Tetris")
+	if err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if msg := err.Error(); strings.Contains(msg, "secret") || !strings.Contains(msg, "jeuRecherche.php") {
+		t.Errorf("error should name the endpoint but no password: %v", err)
+	}
+}
+
+func TestGamelistDate(t *testing.T) {
+	tests := map[string]string{
+		"1985-09-13": "19850913T000000",
+		"1991-02":    "19910201T000000",
+		"1994":       "19940101T000000",
+		"":           "",
+	}
+	for in, want := range tests {
+		if got := gamelistDate(in); got != want {
+			t.Errorf("gamelistDate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
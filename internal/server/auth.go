@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Auth protects the server. With neither Token nor BasicAuth set, anyone
+// who can reach the port can use it.
+type Auth struct {
+	// Token is accepted as "Authorization: Bearer <token>" or ?token=, the
+	// latter for links and EventSource, which can't set headers
+	Token string
+	// BasicAuth is "user:pass", accepted as HTTP basic authentication
+	BasicAuth string
+	// ProtectUI also requires auth for the UI and cover images, not just /api/
+	ProtectUI bool
+}
+
+func (a Auth) enabled() bool {
+	return a.Token != "" || a.BasicAuth != ""
+}
+
+// allows reports whether r carries a valid token or basic auth credentials
+func (a Auth) allows(r *http.Request) bool {
+	if a.Token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if token != "" && compare(token, a.Token) == 1 {
+			return true
+		}
+	}
+	if a.BasicAuth != "" {
+		wantUser, wantPass, _ := strings.Cut(a.BasicAuth, ":")
+		user, pass, ok := r.BasicAuth()
+		// Compare both so a wrong user takes as long as a wrong password
+		if ok && compare(user, wantUser)&compare(pass, wantPass) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compares secrets in constant time, returning 1 if they're equal
+func compare(got, want string) int {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want))
+}
+
+// protect wraps h so that /api/ routes, and with ProtectUI everything else,
+// answer 401 to requests without valid credentials
+func (a Auth) protect(h http.Handler) http.Handler {
+	if !a.enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (a.ProtectUI || strings.HasPrefix(r.URL.Path, "/api/")) && !a.allows(r) {
+			if a.BasicAuth != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="romu"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="romu"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// sseEvent is a named Server-Sent Event with a JSON payload
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+// hub fans out events to every subscribed stream. Slow subscribers miss
+// events rather than blocking the publisher, except the "done" and "error"
+// events that end a scan, which a stream waits for: those take the place
+// of the oldest event queued.
+type hub struct {
+	mu   sync.Mutex
+	subs map[chan sseEvent]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[chan sseEvent]struct{})}
+}
+
+func (h *hub) subscribe() chan sseEvent {
+	ch := make(chan sseEvent, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *hub) unsubscribe(ch chan sseEvent) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *hub) publish(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	ev := sseEvent{name: name, data: data}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	final := name == "done" || name == "error"
+	for ch := range h.subs {
+		offer(ch, ev, final)
+	}
+}
+
+// offer sends ev to ch without blocking. If ch is full, ev is dropped
+// unless final, when the oldest queued event is dropped to make room.
+func offer(ch chan sseEvent, ev sseEvent, final bool) {
+	for {
+		select {
+		case ch <- ev:
+			return
+		default:
+		}
+		if !final {
+			return
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// startSSE sets the event-stream headers and returns the flusher, or writes
+// an error if the response writer can't stream.
+func startSSE(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return flusher, true
+}
+
+func writeSSE(w http.ResponseWriter, flusher http.Flusher, ev sseEvent) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data)
+	flusher.Flush()
+}
@@ -1,55 +1,184 @@
 package server
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/retronian/romu/internal/covers"
 	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/enrich"
+	"github.com/retronian/romu/internal/platform"
+	"github.com/retronian/romu/internal/scanner"
 )
 
 //go:embed static
 var staticFiles embed.FS
 
+// Store is the part of *db.DB the server uses: its own queries and those
+// of enriching, scanning and fetching covers
+type Store interface {
+	enrich.Store
+	covers.Store
+	scanner.Store
+	SearchRomsContext(ctx context.Context, f db.SearchFilter, page, perPage int) ([]db.RomFile, int, error)
+	GetStats() (*db.Stats, error)
+	Subscribe() (<-chan struct{}, func())
+	SetUserRating(romID int64, stars int) error
+	SetFavorite(romID int64, fav bool) error
+	ListTags() ([]db.Tag, error)
+	AddTag(romID int64, tag string) error
+	RemoveTag(romID int64, tag string) error
+}
+
 type Server struct {
-	db   *db.DB
+	db   Store
 	port int
+
+	// Host is the address to listen on, by default 127.0.0.1 so only this
+	// machine can reach the server; empty listens on every interface
+	Host string
+
+	scanMu     sync.Mutex
+	scanning   bool
+	scanEvents *hub
+
+	enrichMu  sync.Mutex
+	enriching bool
+
+	stats statsCache
+
+	// StatsRefresh is how often /api/stats/stream re-sends stats even without
+	// a change notification, to pick up writes made by other processes.
+	StatsRefresh time.Duration
+	// StatsCacheTTL is how long stats are reused. Writes through this
+	// server's database clear them sooner; 0 disables the cache.
+	StatsCacheTTL time.Duration
+	// Covers sets where covers are kept (OutputDir, default ~/.romu/covers)
+	// and fetched from (BaseURL) by /api/game/{id}/cover
+	Covers covers.Options
+	// Auth, if set, requires a token or password
+	Auth Auth
+	// Enrich sets the source and options of POST /api/enrich. The default
+	// Source is the gamedb, which also titles Japanese-only games.
+	Enrich enrich.Options
 }
 
-func New(database *db.DB, port int) *Server {
-	return &Server{db: database, port: port}
+func New(database Store, port int) *Server {
+	return &Server{db: database, port: port, Host: "127.0.0.1", scanEvents: newHub(), StatsRefresh: 30 * time.Second, StatsCacheTTL: 5 * time.Second}
 }
 
 func (s *Server) Start() error {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.port))
+	host := s.Host
+	if ip := net.ParseIP(host); host == "" || ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	link := "http://" + net.JoinHostPort(host, strconv.Itoa(s.port))
+	if s.db.ReadOnly() {
+		fmt.Printf("🎮 romu server running at %s (read-only)\n", link)
+	} else {
+		fmt.Printf("🎮 romu server running at %s\n", link)
+	}
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// Handler returns the server's routes, behind Auth and gzip compression
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 
 	// API
 	mux.HandleFunc("/api/roms", s.handleRoms)
 	mux.HandleFunc("/api/stats", s.handleStats)
+	mux.HandleFunc("GET /api/stats/stream", s.handleStatsStream)
 	mux.HandleFunc("/api/platforms", s.handlePlatforms)
+	mux.HandleFunc("PUT /api/roms/{id}/rating", s.writable(s.handleRating))
+	mux.HandleFunc("GET /api/tags", s.handleTags)
+	mux.HandleFunc("POST /api/roms/{id}/tags", s.writable(s.handleAddTag))
+	mux.HandleFunc("DELETE /api/roms/{id}/tags/{tag}", s.writable(s.handleRemoveTag))
+	mux.HandleFunc("POST /api/scan", s.writable(s.handleScan))
+	mux.HandleFunc("GET /api/scan/stream", s.handleScanStream)
+	mux.HandleFunc("POST /api/enrich", s.writable(s.handleEnrich))
+	mux.HandleFunc("GET /api/game/{id}/cover", s.handleGameCover)
 
 	// Cover art files
-	home, _ := os.UserHomeDir()
-	coversDir := filepath.Join(home, ".romu", "covers")
+	coversDir := s.Covers.OutputDir
+	if coversDir == "" {
+		home, _ := os.UserHomeDir()
+		coversDir = filepath.Join(home, ".romu", "covers")
+	}
 	mux.Handle("/covers/", http.StripPrefix("/covers/", http.FileServer(http.Dir(coversDir))))
 
 	// Static files
 	staticFS, _ := fs.Sub(staticFiles, "static")
 	mux.Handle("/", http.FileServer(http.FS(staticFS)))
+	return s.Auth.protect(compress(mux))
+}
 
-	addr := fmt.Sprintf(":%d", s.port)
-	fmt.Printf("🎮 romu server running at http://localhost%s\n", addr)
-	return http.ListenAndServe(addr, mux)
+// writable wraps a handler that writes to the database, refusing it with
+// 405 when the database was opened read-only. As browsers let any page send
+// a form or text/plain POST anywhere, it also refuses requests from other
+// sites with 403, and bodies that aren't JSON with 415: a request with
+// Content-Type application/json from another site needs CORS, which the
+// server doesn't allow.
+func (s *Server) writable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.db.ReadOnly() {
+			http.Error(w, "server is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-site request refused", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			if t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); t != "application/json" {
+				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// sameOrigin reports whether r comes from the server's own pages or from
+// outside a browser, by the Sec-Fetch-Site and Origin headers browsers send
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	return true
 }
 
 func (s *Server) handleRoms(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
 	platform := r.URL.Query().Get("platform")
+	tag := r.URL.Query().Get("tag")
+	favorite, _ := strconv.ParseBool(r.URL.Query().Get("favorite"))
+	missingEN, _ := strconv.ParseBool(r.URL.Query().Get("missing_title_en"))
+	missingJA, _ := strconv.ParseBool(r.URL.Query().Get("missing_title_ja"))
+	var matched *bool
+	if m, err := strconv.ParseBool(r.URL.Query().Get("matched")); err == nil {
+		matched = &m
+	}
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
 	if page == 0 {
@@ -59,13 +188,15 @@ func (s *Server) handleRoms(w http.ResponseWriter, r *http.Request) {
 		perPage = 50
 	}
 
-	files, total, err := s.db.SearchRoms(q, platform, page, perPage)
+	files, total, err := s.db.SearchRomsContext(r.Context(), db.SearchFilter{Query: q, Platform: platform, Tag: tag, Favorite: favorite, Matched: matched,
+		MissingTitleEN: missingEN, MissingTitleJA: missingJA, Sort: r.URL.Query().Get("sort")}, page, perPage)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
 	type romJSON struct {
+		ID          int64   `json:"id"`
 		Platform    string  `json:"platform"`
 		Filename    string  `json:"filename"`
 		Size        int64   `json:"size"`
@@ -80,6 +211,8 @@ func (s *Server) handleRoms(w http.ResponseWriter, r *http.Request) {
 		Genre       *string `json:"genre,omitempty"`
 		Players     *string `json:"players,omitempty"`
 		Rating      *string `json:"rating,omitempty"`
+		UserRating  int     `json:"user_rating"`
+		Favorite    bool    `json:"favorite"`
 	}
 
 	roms := make([]romJSON, 0, len(files))
@@ -91,10 +224,11 @@ func (s *Server) handleRoms(w http.ResponseWriter, r *http.Request) {
 			title = *f.TitleEN
 		}
 		roms = append(roms, romJSON{
-			Platform: f.Platform, Filename: f.Filename, Size: f.Size,
+			ID: f.ID, Platform: f.Platform, Filename: f.Filename, Size: f.Size,
 			CRC32: f.HashCRC32, Title: title, TitleEN: f.TitleEN, TitleJA: f.TitleJA,
 			DescJA: f.DescJA, Developer: f.Developer, Publisher: f.Publisher,
 			ReleaseDate: f.ReleaseDate, Genre: f.Genre, Players: f.Players, Rating: f.Rating,
+			UserRating: f.UserRating, Favorite: f.Favorite,
 		})
 	}
 
@@ -105,7 +239,7 @@ func (s *Server) handleRoms(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
-	stats, err := s.db.GetStats()
+	stats, err := s.stats.get(s.db, s.StatsCacheTTL)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -114,6 +248,171 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+// handleStatsStream pushes the current Stats as a "stats" event on connect,
+// after database writes (at most once per second), and every StatsRefresh.
+func (s *Server) handleStatsStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := startSSE(w)
+	if !ok {
+		return
+	}
+	changes, unsubscribe := s.db.Subscribe()
+	defer unsubscribe()
+
+	send := func() bool {
+		stats, err := s.stats.get(s.db, s.StatsCacheTTL)
+		if err != nil {
+			writeSSE(w, flusher, sseEvent{name: "error", data: []byte(strconv.Quote(err.Error()))})
+			return false
+		}
+		data, _ := json.Marshal(stats)
+		writeSSE(w, flusher, sseEvent{name: "stats", data: data})
+		return true
+	}
+	if !send() {
+		return
+	}
+
+	refresh := time.NewTicker(s.StatsRefresh)
+	defer refresh.Stop()
+	throttle := time.NewTicker(time.Second)
+	defer throttle.Stop()
+	dirty := false
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changes:
+			dirty = true
+		case <-throttle.C:
+			if dirty {
+				dirty = false
+				if !send() {
+					return
+				}
+			}
+		case <-refresh.C:
+			dirty = false
+			if !send() {
+				return
+			}
+		}
+	}
+}
+
+// handleRating updates the personal rating and/or favorite flag of a ROM.
+// Body: {"stars": 0-5, "favorite": bool}, both optional.
+func (s *Server) handleRating(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid rom id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Stars    *int  `json:"stars"`
+		Favorite *bool `json:"favorite"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Stars != nil && (*req.Stars < 0 || *req.Stars > 5) {
+		http.Error(w, "stars must be between 0 and 5", http.StatusBadRequest)
+		return
+	}
+
+	if req.Stars != nil {
+		err = s.db.SetUserRating(id, *req.Stars)
+	}
+	if err == nil && req.Favorite != nil {
+		err = s.db.SetFavorite(id, *req.Favorite)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGameCover serves a game's cover image, fetching it from
+// libretro-thumbnails first if it hasn't been downloaded.
+// Query: type (default "boxart").
+func (s *Server) handleGameCover(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid game id", http.StatusBadRequest)
+		return
+	}
+	imageType := r.URL.Query().Get("type")
+	if imageType == "" {
+		imageType = covers.BoxartType
+	}
+	if imageType != covers.BoxartType {
+		http.Error(w, "unsupported image type", http.StatusBadRequest)
+		return
+	}
+
+	path, err := covers.EnsureGameCover(s.db, id, imageType, s.Covers)
+	if errors.Is(err, db.ErrNotFound) || errors.Is(err, covers.ErrNoCover) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	http.ServeFile(w, r, path)
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.db.ListTags()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (s *Server) handleAddTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid rom id", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Tag string `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tag == "" {
+		http.Error(w, "tag is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.db.AddTag(id, req.Tag); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleRemoveTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid rom id", http.StatusBadRequest)
+		return
+	}
+	if err := s.db.RemoveTag(id, r.PathValue("tag")); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePlatforms lists the platforms in the collection with their display
+// names, or just the codes with ?format=codes
 func (s *Server) handlePlatforms(w http.ResponseWriter, r *http.Request) {
 	platforms, err := s.db.GetPlatforms()
 	if err != nil {
@@ -121,5 +420,124 @@ func (s *Server) handlePlatforms(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(platforms)
+	if r.URL.Query().Get("format") == "codes" {
+		json.NewEncoder(w).Encode(platforms)
+		return
+	}
+
+	type platformJSON struct {
+		Code        string `json:"code"`
+		DisplayName string `json:"display_name"`
+	}
+	out := make([]platformJSON, 0, len(platforms))
+	for _, p := range platforms {
+		out = append(out, platformJSON{Code: p, DisplayName: platform.DisplayName(p)})
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleScan starts a scan of the requested path in the background.
+// Progress is streamed to /api/scan/stream.
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	s.scanMu.Lock()
+	if s.scanning {
+		s.scanMu.Unlock()
+		http.Error(w, "a scan is already running", http.StatusConflict)
+		return
+	}
+	s.scanning = true
+	s.scanMu.Unlock()
+
+	go s.runScan(req.Path)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "started", "path": req.Path})
+}
+
+// handleEnrich fills in game metadata, as romu enrich does, and returns
+// what it did. Body: {"platform": "FC"}, optional.
+func (s *Server) handleEnrich(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Platform string `json:"platform"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	s.enrichMu.Lock()
+	if s.enriching {
+		s.enrichMu.Unlock()
+		http.Error(w, "enrich is already running", http.StatusConflict)
+		return
+	}
+	s.enriching = true
+	s.enrichMu.Unlock()
+	defer func() {
+		s.enrichMu.Lock()
+		s.enriching = false
+		s.enrichMu.Unlock()
+	}()
+
+	opts := s.Enrich
+	if opts.Source == nil {
+		opts.Source = enrich.GameDB{}
+		opts.JapaneseTitles = true
+	}
+	opts.Platform = req.Platform
+	opts.DryRun = false
+	opts.Warn = func(err error) { slog.Warn("enrich error", "err", err) }
+	res, err := enrich.Run(s.db, opts)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) runScan(path string) {
+	opts := scanner.ScanOptions{Progress: func(ev scanner.ScanEvent) {
+		s.scanEvents.publish("progress", ev)
+	}}
+	result, err := scanner.ScanWithOptions(path, s.db, opts)
+
+	s.scanMu.Lock()
+	s.scanning = false
+	s.scanMu.Unlock()
+
+	if err != nil {
+		s.scanEvents.publish("error", map[string]string{"error": err.Error()})
+		return
+	}
+	s.scanEvents.publish("done", result)
+}
+
+// handleScanStream streams scan progress as Server-Sent Events: "progress"
+// per file, then "done" with the Result counts or "error".
+func (s *Server) handleScanStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := startSSE(w)
+	if !ok {
+		return
+	}
+	ch := s.scanEvents.subscribe()
+	defer s.scanEvents.unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			writeSSE(w, flusher, ev)
+		}
+	}
 }
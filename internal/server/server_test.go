@@ -0,0 +1,396 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/retronian/romu/internal/db"
+	"github.com/retronian/romu/internal/gamedb"
+)
+
+// openTestDB creates a database with one ROM, in memory or, to reopen it
+// read-only, in a file
+func openTestDB(t *testing.T, readOnly bool) *db.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "romu.db")
+	open := db.OpenMemory
+	if readOnly {
+		open = func() (*db.DB, error) { return db.OpenPath(path) }
+	}
+	database, err := open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.UpsertRomFile(db.RomFileInput{Path: "/roms/gb/tetris.gb", Filename: "tetris.gb", Size: 32768, CRC32: "46DF91AD", Platform: "GB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readOnly {
+		database.Close()
+		if database, err = db.OpenReadOnlyPath(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestReadOnly(t *testing.T) {
+	for _, readOnly := range []bool{false, true} {
+		h := New(openTestDB(t, readOnly), 0).Handler()
+		for _, tt := range []struct {
+			method, path, body string
+			status, roStatus   int
+		}{
+			{"GET", "/api/stats", "", http.StatusOK, http.StatusOK},
+			{"GET", "/api/roms?q=tetris", "", http.StatusOK, http.StatusOK},
+			{"PUT", "/api/roms/1/rating", `{"stars": 4}`, http.StatusNoContent, http.StatusMethodNotAllowed},
+			{"POST", "/api/roms/1/tags", `{"tag": "puzzle"}`, http.StatusNoContent, http.StatusMethodNotAllowed},
+			{"DELETE", "/api/roms/1/tags/puzzle", "", http.StatusNoContent, http.StatusMethodNotAllowed},
+			{"POST", "/api/scan", `{}`, http.StatusBadRequest, http.StatusMethodNotAllowed},
+			{"POST", "/api/enrich", "", http.StatusOK, http.StatusMethodNotAllowed},
+		} {
+			want := tt.status
+			if readOnly {
+				want = tt.roStatus
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, jsonRequest(tt.method, tt.path, tt.body))
+			if rec.Code != want {
+				t.Errorf("read-only %v: %s %s = %d, want %d (%s)", readOnly, tt.method, tt.path, rec.Code, want, rec.Body)
+			}
+		}
+	}
+}
+
+// jsonRequest is a request with a JSON body, as the UI sends
+func jsonRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestCrossSite(t *testing.T) {
+	h := New(openTestDB(t, false), 0).Handler()
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		header      map[string]string
+		want        int
+	}{
+		{"curl", "application/json", nil, http.StatusBadRequest},
+		{"same origin", "application/json; charset=utf-8", map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}, http.StatusBadRequest},
+		{"text/plain form", "text/plain", nil, http.StatusUnsupportedMediaType},
+		{"no content type", "", nil, http.StatusUnsupportedMediaType},
+		{"other site", "application/json", map[string]string{"Origin": "http://evil.test"}, http.StatusForbidden},
+		{"other site, fetch metadata", "application/json", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("POST", "/api/scan", strings.NewReader(`{}`))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		for k, v := range tt.header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: %d, want %d (%s)", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	database := openTestDB(t, false)
+	for _, tt := range []struct {
+		name       string
+		auth       Auth
+		path       string
+		header     string // Authorization
+		user, pass string // basic auth, if user is set
+		want       int
+	}{
+		{"no auth configured", Auth{}, "/api/stats", "", "", "", http.StatusOK},
+		{"token missing", Auth{Token: "s3cret"}, "/api/stats", "", "", "", http.StatusUnauthorized},
+		{"token header", Auth{Token: "s3cret"}, "/api/stats", "Bearer s3cret", "", "", http.StatusOK},
+		{"token query", Auth{Token: "s3cret"}, "/api/stats?token=s3cret", "", "", "", http.StatusOK},
+		{"wrong token", Auth{Token: "s3cret"}, "/api/stats", "Bearer s3cre", "", "", http.StatusUnauthorized},
+		{"wrong token query", Auth{Token: "s3cret"}, "/api/stats?token=x", "", "", "", http.StatusUnauthorized},
+		{"basic", Auth{BasicAuth: "me:pa:ss"}, "/api/stats", "", "me", "pa:ss", http.StatusOK},
+		{"basic wrong password", Auth{BasicAuth: "me:pa:ss"}, "/api/stats", "", "me", "pa", http.StatusUnauthorized},
+		{"basic wrong user", Auth{BasicAuth: "me:pa:ss"}, "/api/stats", "", "you", "pa:ss", http.StatusUnauthorized},
+		{"token or basic", Auth{Token: "s3cret", BasicAuth: "me:pass"}, "/api/stats", "", "me", "pass", http.StatusOK},
+		{"UI open by default", Auth{Token: "s3cret"}, "/", "", "", "", http.StatusOK},
+		{"UI protected", Auth{Token: "s3cret", ProtectUI: true}, "/", "", "", "", http.StatusUnauthorized},
+		{"UI with token", Auth{Token: "s3cret", ProtectUI: true}, "/?token=s3cret", "", "", "", http.StatusOK},
+		{"covers protected", Auth{BasicAuth: "me:pass", ProtectUI: true}, "/covers/GB/Tetris.png", "", "", "", http.StatusUnauthorized},
+	} {
+		srv := New(database, 0)
+		srv.Auth = tt.auth
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: %s = %d, want %d", tt.name, tt.path, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tt.name)
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	database := openTestDB(t, false)
+	for i := range 50 {
+		name := fmt.Sprintf("game%02d.gb", i)
+		database.UpsertRomFile(db.RomFileInput{Path: "/roms/gb/" + name, Filename: name, Size: 32768, CRC32: fmt.Sprintf("%08X", i), Platform: "GB"})
+	}
+	h := New(database, 0).Handler()
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/roms?per_page=100", "deflate, gzip")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzipped 200, got %d with Content-Encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(zr).Decode(&resp); err != nil || resp.Total != 51 {
+		t.Errorf("expected 51 ROMs in the decompressed JSON, got %d: %v", resp.Total, err)
+	}
+
+	for _, tt := range []struct{ name, path, acceptEncoding string }{
+		{"not accepted", "/api/roms?per_page=100", ""},
+		{"refused", "/api/roms?per_page=100", "gzip;q=0"},
+		{"small", "/api/tags", "gzip"},
+		{"not JSON", "/", "gzip"},
+	} {
+		rec := get(tt.path, tt.acceptEncoding)
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s: Content-Encoding %q", tt.name, enc)
+		}
+		if body, _ := io.ReadAll(rec.Body); rec.Code != http.StatusOK || len(body) == 0 {
+			t.Errorf("%s: %d with %d bytes", tt.name, rec.Code, len(body))
+		}
+	}
+}
+
+// titles is an enrich source that knows a fixed set of titles
+type titles map[string]*gamedb.GameEntry
+
+func (titles) Name() string { return "titles" }
+
+func (t titles) Lookup(platform, titleEN string) (*gamedb.GameEntry, error) {
+	return t[titleEN], nil
+}
+
+func TestEnrich(t *testing.T) {
+	database := openTestDB(t, false)
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/fc/rockman.nes", Filename: "Rockman (Japan).nes", Size: 131072, CRC32: "0FAA0D1D", Platform: "FC"})
+	srv := New(database, 0)
+	srv.Enrich.Source = titles{"Rockman (Japan)": {TitleJA: "ロックマン"}}
+	h := srv.Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, jsonRequest("POST", "/api/enrich", `{"platform": "FC"}`))
+	var res map[string]int
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("%d: %v", rec.Code, err)
+	}
+	if res["filename_enriched"] != 1 || res["enriched"] != 0 || res["skipped"] != 0 {
+		t.Errorf("expected the FC ROM to be enriched by filename, got %v", res)
+	}
+	roms, _, _ := database.SearchRoms(db.SearchFilter{Query: "ロックマン"}, 1, 10)
+	if len(roms) != 1 {
+		t.Errorf("expected the ROM to be found by its Japanese title, got %d", len(roms))
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, jsonRequest("POST", "/api/enrich", `{"platform": 1}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad body: %d", rec.Code)
+	}
+}
+
+// fakeStore answers the few queries a test needs; the embedded nil Store
+// makes any other call panic
+type fakeStore struct {
+	Store
+	roms    []db.RomFile
+	filter  db.SearchFilter
+	page    int
+	perPage int
+	stars   map[int64]int
+	err     error
+}
+
+func (f *fakeStore) ReadOnly() bool { return false }
+
+func (f *fakeStore) SearchRomsContext(ctx context.Context, filter db.SearchFilter, page, perPage int) ([]db.RomFile, int, error) {
+	f.filter, f.page, f.perPage = filter, page, perPage
+	return f.roms, 123, f.err
+}
+
+func (f *fakeStore) GetStats() (*db.Stats, error) {
+	return nil, f.err
+}
+
+func (f *fakeStore) Subscribe() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
+
+func (f *fakeStore) SetUserRating(romID int64, stars int) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.stars[romID] = stars
+	return nil
+}
+
+func TestHandlers(t *testing.T) {
+	title := "Super Mario Bros. (World)"
+	store := &fakeStore{roms: []db.RomFile{{ID: 7, Platform: "FC", Filename: "smb.nes", TitleEN: &title}}, stars: map[int64]int{}}
+	h := New(store, 0).Handler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, jsonRequest(method, path, body))
+		return rec
+	}
+
+	rec := do("GET", "/api/roms?q=mario&platform=FC&favorite=true&page=2&per_page=10", "")
+	var resp struct {
+		Roms []struct {
+			ID    int64  `json:"id"`
+			Title string `json:"title"`
+		} `json:"roms"`
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if store.filter.Query != "mario" || store.filter.Platform != "FC" || !store.filter.Favorite || store.page != 2 || store.perPage != 10 {
+		t.Errorf("unexpected search %+v, page %d of %d", store.filter, store.page, store.perPage)
+	}
+	if resp.Total != 123 || len(resp.Roms) != 1 || resp.Roms[0].ID != 7 || resp.Roms[0].Title != title {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if do("GET", "/api/roms", ""); store.page != 1 || store.perPage != 50 {
+		t.Errorf("default paging: page %d of %d", store.page, store.perPage)
+	}
+
+	if rec := do("PUT", "/api/roms/7/rating", `{"stars": 3}`); rec.Code != http.StatusNoContent || store.stars[7] != 3 {
+		t.Errorf("rating: %d, %v", rec.Code, store.stars)
+	}
+	if rec := do("PUT", "/api/roms/7/rating", `{"stars": 6}`); rec.Code != http.StatusBadRequest || store.stars[7] != 3 {
+		t.Errorf("out of range rating: %d, %v", rec.Code, store.stars)
+	}
+
+	store.err = db.ErrNotFound
+	if rec := do("PUT", "/api/roms/8/rating", `{"stars": 1}`); rec.Code != http.StatusNotFound {
+		t.Errorf("rating an unknown ROM: %d", rec.Code)
+	}
+	store.err = errors.New("disk I/O error")
+	if rec := do("GET", "/api/stats", ""); rec.Code != http.StatusInternalServerError {
+		t.Errorf("stats error: %d", rec.Code)
+	}
+}
+
+// countingStore counts GetStats queries
+type countingStore struct {
+	*db.DB
+	queries int
+}
+
+func (s *countingStore) GetStats() (*db.Stats, error) {
+	s.queries++
+	return s.DB.GetStats()
+}
+
+func TestStatsCache(t *testing.T) {
+	database := openTestDB(t, false)
+	store := &countingStore{DB: database}
+	srv := New(store, 0)
+	h := srv.Handler()
+	stats := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/stats", nil))
+		var resp struct {
+			Total int `json:"total"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Total
+	}
+
+	if n := stats(); n != 1 || stats() != 1 || store.queries != 1 {
+		t.Errorf("expected one query for two requests, got %d (%d ROMs)", store.queries, n)
+	}
+
+	// A write clears the cache
+	database.UpsertRomFile(db.RomFileInput{Path: "/roms/gb/kirby.gb", Filename: "kirby.gb", Size: 1, Platform: "GB"})
+	if n := stats(); n != 2 || store.queries != 2 {
+		t.Errorf("after a write: %d ROMs, %d queries", n, store.queries)
+	}
+
+	// So does age, for writes by other processes
+	srv.StatsCacheTTL = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if stats(); store.queries != 3 {
+		t.Errorf("after the TTL: %d queries", store.queries)
+	}
+
+	srv.StatsCacheTTL = 0
+	stats()
+	stats()
+	if store.queries != 5 {
+		t.Errorf("without the cache: %d queries", store.queries)
+	}
+}
+
+func TestHubKeepsFinalEvents(t *testing.T) {
+	h := newHub()
+	ch := h.subscribe()
+	// A stream too slow to keep up misses progress, but not the end
+	for i := range 100 {
+		h.publish("progress", i)
+	}
+	h.publish("done", "ok")
+	if len(ch) != cap(ch) {
+		t.Fatalf("%d events queued, want %d", len(ch), cap(ch))
+	}
+	var last sseEvent
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	if last.name != "done" || string(last.data) != `"ok"` {
+		t.Errorf("last event %s %s, want done", last.name, last.data)
+	}
+}
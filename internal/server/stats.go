@@ -0,0 +1,47 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/retronian/romu/internal/db"
+)
+
+// statsCache keeps the last GetStats result until it is older than the TTL
+// or the database reports a write. Writes made by other processes aren't
+// reported, so those only show up once the TTL has passed.
+type statsCache struct {
+	mu      sync.Mutex
+	changes <-chan struct{} // subscribed on first use, for the server's lifetime
+	stats   *db.Stats
+	at      time.Time
+}
+
+// get returns cached stats, or fresh ones from store. A ttl of 0 or less
+// disables the cache.
+func (c *statsCache) get(store Store, ttl time.Duration) (*db.Stats, error) {
+	if ttl <= 0 {
+		return store.GetStats()
+	}
+	// Holding the lock while querying also lets concurrent requests share
+	// one query
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changes == nil {
+		c.changes, _ = store.Subscribe()
+	}
+	select {
+	case <-c.changes:
+		c.stats = nil
+	default:
+	}
+	if c.stats != nil && time.Since(c.at) < ttl {
+		return c.stats, nil
+	}
+	stats, err := store.GetStats()
+	if err != nil {
+		return nil, err
+	}
+	c.stats, c.at = stats, time.Now()
+	return stats, nil
+}